@@ -0,0 +1,53 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Tar, gzipped tar and zip archives: writing received files, directories and symlinks into them
+// and reading their contents as a filesystem
+package archive
+
+import (
+	"fmt"
+	"strings"
+)
+
+// What kind of archive is written
+type Format string
+
+const (
+	FormatTar   Format = "tar"
+	FormatTarGz Format = "tar.gz"
+	FormatZip   Format = "zip"
+)
+
+var ErrorUnknownFormat error = fmt.Errorf("unknown archive format")
+
+// Returns the format with such name: "tar", "tar.gz" (or "tgz") or "zip"
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "tar":
+		return FormatTar, nil
+	case "tar.gz", "tgz":
+		return FormatTarGz, nil
+	case "zip":
+		return FormatZip, nil
+	default:
+		return "", fmt.Errorf("%w: \"%s\" (tar, tar.gz or zip)", ErrorUnknownFormat, name)
+	}
+}
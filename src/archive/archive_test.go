@@ -0,0 +1,216 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+// an entry read back from the archive
+type entry struct {
+	mode     fs.FileMode
+	modTime  time.Time
+	contents string // the target of a symlink
+}
+
+func writeTestArchive(t *testing.T, format Format) *bytes.Buffer {
+	archived := new(bytes.Buffer)
+	writer, err := NewWriter(archived, format)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	modTime := time.Date(2022, 3, 4, 5, 6, 8, 0, time.UTC)
+
+	contents, err := writer.File("dir/inner/file.txt", 8, 0600, modTime)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = contents.Write([]byte("contents"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	_, err = writer.File("dir/unknown", 0, 0, time.Time{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = writer.Symlink("dir/link", "inner/file.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = writer.Directory("dir/empty", modTime)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// already in the archive
+	err = writer.Directory("dir/inner", modTime)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for _, name := range []string{"", "/etc", "../outside", "dir/../../outside"} {
+		_, err = writer.File(name, 0, 0, time.Time{})
+		if !errors.Is(err, ErrorInvalidName) {
+			t.Fatalf("expected \"%s\" to be refused; got %v", name, err)
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("expected closing twice to do nothing; got %s", err)
+	}
+
+	return archived
+}
+
+func readTar(t *testing.T, archived io.Reader) map[string]entry {
+	entries := make(map[string]entry)
+
+	reader := tar.NewReader(archived)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		contents, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			contents = []byte(header.Linkname)
+		}
+
+		entries[header.Name] = entry{
+			mode:     header.FileInfo().Mode(),
+			modTime:  header.ModTime,
+			contents: string(contents),
+		}
+	}
+
+	return entries
+}
+
+func readZip(t *testing.T, archived *bytes.Buffer) map[string]entry {
+	entries := make(map[string]entry)
+
+	reader, err := zip.NewReader(bytes.NewReader(archived.Bytes()), int64(archived.Len()))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for _, file := range reader.File {
+		opened, err := file.Open()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		contents, err := io.ReadAll(opened)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		opened.Close()
+
+		entries[file.Name] = entry{
+			mode:     file.Mode(),
+			modTime:  file.Modified,
+			contents: string(contents),
+		}
+	}
+
+	return entries
+}
+
+func Test_Writer(t *testing.T) {
+	for _, format := range []Format{FormatTar, FormatTarGz, FormatZip} {
+		archived := writeTestArchive(t, format)
+
+		var entries map[string]entry
+		switch format {
+		case FormatTar:
+			entries = readTar(t, archived)
+		case FormatTarGz:
+			decompressed, err := gzip.NewReader(archived)
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			entries = readTar(t, decompressed)
+		case FormatZip:
+			entries = readZip(t, archived)
+		}
+
+		if len(entries) != 6 {
+			t.Fatalf("%s: expected 6 entries; got %v", format, entries)
+		}
+
+		for _, dir := range []string{"dir/", "dir/inner/", "dir/empty/"} {
+			if !entries[dir].mode.IsDir() {
+				t.Fatalf("%s: expected \"%s\" to be a directory; got %v", format, dir, entries[dir])
+			}
+		}
+
+		file := entries["dir/inner/file.txt"]
+		if file.contents != "contents" || file.mode != 0600 || !file.modTime.Equal(time.Date(2022, 3, 4, 5, 6, 8, 0, time.UTC)) {
+			t.Fatalf("%s: file has not been archived as it is: %+v", format, file)
+		}
+
+		if entries["dir/unknown"].mode != DefaultFileMode {
+			t.Fatalf("%s: expected a file of unknown mode to get %o; got %o", format, DefaultFileMode, entries["dir/unknown"].mode)
+		}
+
+		link := entries["dir/link"]
+		if link.mode&fs.ModeSymlink == 0 || link.contents != "inner/file.txt" {
+			t.Fatalf("%s: expected a symlink to \"inner/file.txt\"; got %+v", format, link)
+		}
+	}
+}
+
+func Test_ParseFormat(t *testing.T) {
+	for name, expected := range map[string]Format{"tar": FormatTar, "TGZ": FormatTarGz, "tar.gz": FormatTarGz, "zip": FormatZip} {
+		format, err := ParseFormat(name)
+		if err != nil || format != expected {
+			t.Fatalf("expected \"%s\" to be %s; got %s, %v", name, expected, format, err)
+		}
+	}
+
+	_, err := ParseFormat("rar")
+	if !errors.Is(err, ErrorUnknownFormat) {
+		t.Fatalf("expected an unknown format; got %v", err)
+	}
+}
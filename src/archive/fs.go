@@ -0,0 +1,569 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrorNotArchive error = fmt.Errorf("not a tar, tar.gz or zip archive")
+var ErrorInvalidated error = fmt.Errorf("another file of the archive has been opened since")
+var ErrorTooManyLinks error = fmt.Errorf("too many levels of symlinks")
+
+// How many symlinks in a row are followed when opening a file
+const maxLinkDepth int = 40
+
+// A read-only filesystem of the contents of a tar, gzipped tar or zip archive. Regular files,
+// directories and symlinks are in it; directories that are not in the archive themselves are made up
+// from the names of the entries. Nothing is extracted: files are read right from the archive.
+// A compressed tar can only be read from the beginning, so its files are read one after another: opening
+// a file of a tar makes the previously opened one unreadable (see ErrorInvalidated). Files opened in the
+// order of the archive are read in one pass, others make the archive be read from the beginning again
+type FS struct {
+	path    string
+	format  Format
+	modTime time.Time // of the archive itself. Given to the made up directories
+	entries map[string]*fsEntry
+	zip     *zip.ReadCloser
+
+	mutex  sync.Mutex
+	cursor *tarCursor // where the tar is being read. Nil if nowhere
+}
+
+// a file, directory or symlink in the archive
+type fsEntry struct {
+	name     string // full and clean
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	link     string     // target of a symlink
+	index    int        // position in a tar
+	zipFile  *zip.File  // nil if in a tar
+	children []*fsEntry // of a directory, sorted by name
+}
+
+// the tar being read
+type tarCursor struct {
+	file   *os.File
+	reader *tar.Reader
+	index  int // of the entry the reader is at. -1 before the first one
+}
+
+// Opens the archive as a filesystem. The format is recognized by the contents rather than the extension
+func Open(archivePath string) (*FS, error) {
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archiveFile.Close()
+
+	stats, err := archiveFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	filesystem := FS{
+		path:    archivePath,
+		modTime: stats.ModTime(),
+		entries: map[string]*fsEntry{
+			".": {name: ".", mode: fs.ModeDir | DefaultDirMode, modTime: stats.ModTime()},
+		},
+	}
+
+	magic := make([]byte, 4)
+	read, _ := io.ReadFull(archiveFile, magic)
+	magic = magic[:read]
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		filesystem.format = FormatZip
+		err = filesystem.indexZip()
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		filesystem.format = FormatTarGz
+		err = filesystem.indexTar()
+	default:
+		filesystem.format = FormatTar
+		err = filesystem.indexTar()
+	}
+	if err != nil {
+		filesystem.Close()
+		return nil, fmt.Errorf("%w: could not be read as %s: %s", ErrorNotArchive, filesystem.format, err)
+	}
+
+	for _, entry := range filesystem.entries {
+		sort.Slice(entry.children, func(i, j int) bool {
+			return entry.children[i].name < entry.children[j].name
+		})
+	}
+
+	return &filesystem, nil
+}
+
+// Returns the format of the archive
+func (filesystem *FS) Format() Format {
+	return filesystem.format
+}
+
+// Returns the name of the archive without the extension of its format, ie: "release" for "release.tar.gz"
+func Name(archivePath string) string {
+	name := path.Base(strings.ReplaceAll(archivePath, "\\", "/"))
+	for _, extension := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if len(name) > len(extension) && strings.EqualFold(name[len(name)-len(extension):], extension) {
+			return name[:len(name)-len(extension)]
+		}
+	}
+
+	return name
+}
+
+// cleans the name of the entry. Returns "" if it can not be in the filesystem
+func entryName(name string) string {
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if name == "" || !fs.ValidPath(name) {
+		return ""
+	}
+
+	return name
+}
+
+// adds the entry along with the directories it is in, replacing the entry with the same name
+func (filesystem *FS) add(entry *fsEntry) {
+	if existing, ok := filesystem.entries[entry.name]; ok {
+		if existing.mode.IsDir() && entry.mode.IsDir() {
+			entry.children = existing.children
+		} else {
+			filesystem.remove(existing)
+		}
+	}
+	filesystem.entries[entry.name] = entry
+
+	parentName := path.Dir(entry.name)
+	parent, ok := filesystem.entries[parentName]
+	if !ok || !parent.mode.IsDir() {
+		parent = &fsEntry{
+			name:    parentName,
+			mode:    fs.ModeDir | DefaultDirMode,
+			modTime: filesystem.modTime,
+		}
+		filesystem.add(parent)
+	}
+
+	for index, child := range parent.children {
+		if child.name == entry.name {
+			parent.children[index] = entry
+			return
+		}
+	}
+	parent.children = append(parent.children, entry)
+}
+
+// removes the entry from its directory
+func (filesystem *FS) remove(entry *fsEntry) {
+	parent := filesystem.entries[path.Dir(entry.name)]
+	for index, child := range parent.children {
+		if child == entry {
+			parent.children = append(parent.children[:index], parent.children[index+1:]...)
+			return
+		}
+	}
+}
+
+func (filesystem *FS) indexZip() error {
+	var err error
+	filesystem.zip, err = zip.OpenReader(filesystem.path)
+	if err != nil {
+		return err
+	}
+
+	for _, zipFile := range filesystem.zip.File {
+		name := entryName(zipFile.Name)
+		if name == "" {
+			continue
+		}
+
+		info := zipFile.FileInfo()
+		entry := fsEntry{
+			name:    name,
+			mode:    info.Mode(),
+			size:    info.Size(),
+			modTime: zipFile.Modified,
+			zipFile: zipFile,
+		}
+
+		switch {
+		case entry.mode.IsDir():
+			entry.size = 0
+			entry.zipFile = nil
+		case entry.mode&fs.ModeSymlink != 0:
+			// the target is the contents of the link
+			target, err := readZipFile(zipFile)
+			if err != nil {
+				return err
+			}
+			entry.link = string(target)
+		case !entry.mode.IsRegular():
+			continue
+		}
+
+		filesystem.add(&entry)
+	}
+
+	return nil
+}
+
+func readZipFile(zipFile *zip.File) ([]byte, error) {
+	contents, err := zipFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer contents.Close()
+
+	return io.ReadAll(contents)
+}
+
+// opens the tar to be read from the beginning
+func (filesystem *FS) openTar() (*tarCursor, error) {
+	archiveFile, err := os.Open(filesystem.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader = bufio.NewReader(archiveFile)
+	if filesystem.format == FormatTarGz {
+		reader, err = gzip.NewReader(reader)
+		if err != nil {
+			archiveFile.Close()
+			return nil, err
+		}
+	}
+
+	return &tarCursor{
+		file:   archiveFile,
+		reader: tar.NewReader(reader),
+		index:  -1,
+	}, nil
+}
+
+func (filesystem *FS) indexTar() error {
+	cursor, err := filesystem.openTar()
+	if err != nil {
+		return err
+	}
+	defer cursor.file.Close()
+
+	for index := 0; ; index++ {
+		header, err := cursor.reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := entryName(header.Name)
+		if name == "" {
+			continue
+		}
+
+		info := header.FileInfo()
+		entry := fsEntry{
+			name:    name,
+			mode:    info.Mode(),
+			size:    info.Size(),
+			modTime: header.ModTime,
+			index:   index,
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			entry.size = 0
+		case tar.TypeSymlink:
+			entry.size = 0
+			entry.link = header.Linkname
+		case tar.TypeReg, tar.TypeRegA:
+		default:
+			// hard links, devices and others
+			continue
+		}
+
+		filesystem.add(&entry)
+	}
+
+	return nil
+}
+
+// returns the entry with such name, following symlinks to it if asked to
+func (filesystem *FS) lookup(operation string, name string, follow bool) (*fsEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: operation, Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry, ok := filesystem.entries[name]
+	for depth := 0; ok && follow && entry.mode&fs.ModeSymlink != 0; depth++ {
+		if depth == maxLinkDepth {
+			return nil, &fs.PathError{Op: operation, Path: name, Err: ErrorTooManyLinks}
+		}
+		if path.IsAbs(entry.link) {
+			ok = false
+			break
+		}
+		entry, ok = filesystem.entries[path.Join(path.Dir(entry.name), entry.link)]
+	}
+	if !ok {
+		return nil, &fs.PathError{Op: operation, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return entry, nil
+}
+
+// Opens the file or directory. Symlinks are followed, if they point inside of the archive
+func (filesystem *FS) Open(name string) (fs.File, error) {
+	entry, err := filesystem.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &entryInfo{entry: entry, name: path.Base(name)}
+
+	if entry.mode.IsDir() {
+		return &dirFile{filesystem: filesystem, entry: entry, info: info}, nil
+	}
+
+	if entry.zipFile != nil {
+		contents, err := entry.zipFile.Open()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &regularFile{info: info, reader: contents, closer: contents}, nil
+	}
+
+	filesystem.mutex.Lock()
+	defer filesystem.mutex.Unlock()
+
+	cursor := filesystem.cursor
+	if cursor == nil || cursor.index >= entry.index {
+		// start over
+		if cursor != nil {
+			cursor.file.Close()
+		}
+		filesystem.cursor = nil
+
+		cursor, err = filesystem.openTar()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		filesystem.cursor = cursor
+	}
+
+	for cursor.index < entry.index {
+		_, err = cursor.reader.Next()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		cursor.index++
+	}
+
+	return &regularFile{
+		info: info,
+		reader: &tarFileReader{
+			filesystem: filesystem,
+			cursor:     cursor,
+			index:      entry.index,
+		},
+	}, nil
+}
+
+// Returns information about the file or directory, following symlinks
+func (filesystem *FS) Stat(name string) (fs.FileInfo, error) {
+	entry, err := filesystem.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entryInfo{entry: entry, name: path.Base(name)}, nil
+}
+
+// Returns information about the file, directory or symlink itself
+func (filesystem *FS) Lstat(name string) (fs.FileInfo, error) {
+	entry, err := filesystem.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entryInfo{entry: entry, name: path.Base(name)}, nil
+}
+
+// Returns where the symlink points, as it is written in the archive
+func (filesystem *FS) ReadLink(name string) (string, error) {
+	entry, err := filesystem.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+
+	if entry.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return entry.link, nil
+}
+
+// Returns the entries of the directory sorted by name
+func (filesystem *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, err := filesystem.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if !entry.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(entry.children))
+	for _, child := range entry.children {
+		entries = append(entries, &entryInfo{entry: child, name: path.Base(child.name)})
+	}
+
+	return entries, nil
+}
+
+// Closes the archive. Files opened in the filesystem can not be read after that
+func (filesystem *FS) Close() error {
+	filesystem.mutex.Lock()
+	defer filesystem.mutex.Unlock()
+
+	if filesystem.cursor != nil {
+		filesystem.cursor.file.Close()
+		filesystem.cursor = nil
+	}
+
+	if filesystem.zip != nil {
+		return filesystem.zip.Close()
+	}
+
+	return nil
+}
+
+// information about the entry, which is both fs.FileInfo and fs.DirEntry
+type entryInfo struct {
+	entry *fsEntry
+	name  string
+}
+
+func (info *entryInfo) Name() string               { return info.name }
+func (info *entryInfo) Size() int64                { return info.entry.size }
+func (info *entryInfo) Mode() fs.FileMode          { return info.entry.mode }
+func (info *entryInfo) ModTime() time.Time         { return info.entry.modTime }
+func (info *entryInfo) IsDir() bool                { return info.entry.mode.IsDir() }
+func (info *entryInfo) Sys() interface{}           { return nil }
+func (info *entryInfo) Type() fs.FileMode          { return info.entry.mode.Type() }
+func (info *entryInfo) Info() (fs.FileInfo, error) { return info, nil }
+
+// an opened regular file
+type regularFile struct {
+	info   *entryInfo
+	reader io.Reader
+	closer io.Closer // nil if there is nothing to close
+}
+
+func (file *regularFile) Stat() (fs.FileInfo, error) {
+	return file.info, nil
+}
+
+func (file *regularFile) Read(p []byte) (int, error) {
+	return file.reader.Read(p)
+}
+
+func (file *regularFile) Close() error {
+	if file.closer != nil {
+		return file.closer.Close()
+	}
+	return nil
+}
+
+// reads the file of the tar while no other file has been opened
+type tarFileReader struct {
+	filesystem *FS
+	cursor     *tarCursor
+	index      int
+}
+
+func (reader *tarFileReader) Read(p []byte) (int, error) {
+	reader.filesystem.mutex.Lock()
+	defer reader.filesystem.mutex.Unlock()
+
+	if reader.filesystem.cursor != reader.cursor || reader.cursor.index != reader.index {
+		return 0, ErrorInvalidated
+	}
+
+	return reader.cursor.reader.Read(p)
+}
+
+// an opened directory
+type dirFile struct {
+	filesystem *FS
+	entry      *fsEntry
+	info       *entryInfo
+	read       int // how many entries have been read already
+}
+
+func (dir *dirFile) Stat() (fs.FileInfo, error) {
+	return dir.info, nil
+}
+
+func (dir *dirFile) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: dir.entry.name, Err: errors.New("is a directory")}
+}
+
+func (dir *dirFile) Close() error {
+	return nil
+}
+
+func (dir *dirFile) ReadDir(count int) ([]fs.DirEntry, error) {
+	left := dir.entry.children[dir.read:]
+	if count > 0 && len(left) == 0 {
+		return nil, io.EOF
+	}
+	if count > 0 && count < len(left) {
+		left = left[:count]
+	}
+	dir.read += len(left)
+
+	entries := make([]fs.DirEntry, 0, len(left))
+	for _, child := range left {
+		entries = append(entries, &entryInfo{entry: child, name: path.Base(child.name)})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,183 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// writes a small archive of such format with the writer
+func makeArchive(t *testing.T, format Format) string {
+	archivePath := filepath.Join(t.TempDir(), "release."+string(format))
+	archiveFile, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer archiveFile.Close()
+
+	writer, err := NewWriter(archiveFile, format)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	modTime := time.Date(2022, 3, 4, 5, 6, 8, 0, time.UTC)
+	files := []struct {
+		name     string
+		contents []byte
+	}{
+		{"bin/tool", bytes.Repeat([]byte("tool"), 100000)},
+		{"README", []byte("read me")},
+		{"docs/a.txt", []byte("a")},
+	}
+	for _, file := range files {
+		contents, err := writer.File(file.name, uint64(len(file.contents)), 0755, modTime)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		_, err = contents.Write(file.contents)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	err = writer.Symlink("latest", "bin/tool")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = writer.Directory("empty", modTime)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	return archivePath
+}
+
+func Test_FS(t *testing.T) {
+	for _, format := range []Format{FormatTar, FormatTarGz, FormatZip} {
+		filesystem, err := Open(makeArchive(t, format))
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+
+		if filesystem.Format() != format {
+			t.Fatalf("expected the archive to be recognized as %s; got %s", format, filesystem.Format())
+		}
+
+		err = fstest.TestFS(filesystem, "bin/tool", "README", "docs/a.txt", "empty", "latest")
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+
+		info, err := filesystem.Lstat("latest")
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			t.Fatalf("%s: expected \"latest\" to be a symlink; got %v", format, err)
+		}
+		target, err := filesystem.ReadLink("latest")
+		if err != nil || target != "bin/tool" {
+			t.Fatalf("%s: expected \"latest\" to point to \"bin/tool\"; got \"%s\" (%v)", format, target, err)
+		}
+
+		info, err = fs.Stat(filesystem, "bin/tool")
+		if err != nil || info.Mode().Perm() != 0755 || info.Size() != 400000 {
+			t.Fatalf("%s: unexpected information about \"bin/tool\": %v (%v)", format, info, err)
+		}
+
+		err = filesystem.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+	}
+}
+
+func Test_FSNotArchive(t *testing.T) {
+	notArchive := filepath.Join(t.TempDir(), "file.txt")
+	err := os.WriteFile(notArchive, []byte("not an archive"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	_, err = Open(notArchive)
+	if !errors.Is(err, ErrorNotArchive) {
+		t.Fatalf("expected a text file to not be an archive; got %v", err)
+	}
+}
+
+func Test_FSTarReadInOrder(t *testing.T) {
+	filesystem, err := Open(makeArchive(t, FormatTarGz))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer filesystem.Close()
+
+	tool, err := filesystem.Open("bin/tool")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	readme, err := filesystem.Open("README")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// the tar has moved on to the next file
+	_, err = io.ReadAll(tool)
+	if !errors.Is(err, ErrorInvalidated) {
+		t.Fatalf("expected the previously opened file to be unreadable; got %v", err)
+	}
+
+	contents, err := io.ReadAll(readme)
+	if err != nil || string(contents) != "read me" {
+		t.Fatalf("expected \"read me\"; got \"%s\" (%v)", contents, err)
+	}
+
+	// and back to the beginning
+	contents, err = fs.ReadFile(filesystem, "bin/tool")
+	if err != nil || len(contents) != 400000 {
+		t.Fatalf("expected to read all of \"bin/tool\" again; got %d bytes (%v)", len(contents), err)
+	}
+}
+
+func Test_Name(t *testing.T) {
+	for archivePath, expected := range map[string]string{
+		"release.tar.gz":          "release",
+		"/builds/release-1.2.TGZ": "release-1.2",
+		"dir/release.zip":         "release",
+		"release.tar":             "release",
+		"release":                 "release",
+		".zip":                    ".zip",
+	} {
+		if name := Name(archivePath); name != expected {
+			t.Fatalf("expected the name of \"%s\" to be \"%s\"; got \"%s\"", archivePath, expected, name)
+		}
+	}
+}
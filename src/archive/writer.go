@@ -0,0 +1,254 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// Default permissions of the entries which modes are unknown
+const (
+	DefaultFileMode fs.FileMode = 0644
+	DefaultDirMode  fs.FileMode = 0755
+)
+
+var ErrorInvalidName error = fmt.Errorf("invalid entry name")
+
+// Writes entries into an archive one after another. Names of the entries are slash-separated
+// and relative to the root of the archive. Directories an entry is in are written
+// before the entry itself, once, unless they have already been
+type Writer struct {
+	tar     *tar.Writer
+	gzip    *gzip.Writer
+	zip     *zip.Writer
+	dirs    map[string]bool // directories that are already in the archive
+	created time.Time       // modification time of the directories added on their own
+	closed  bool
+}
+
+// Creates a new writer of the archive of such format that is written to the output.
+// The output is not closed by the writer
+func NewWriter(output io.Writer, format Format) (*Writer, error) {
+	writer := Writer{
+		dirs:    make(map[string]bool),
+		created: time.Now(),
+	}
+
+	switch format {
+	case FormatTar:
+		writer.tar = tar.NewWriter(output)
+	case FormatTarGz:
+		writer.gzip = gzip.NewWriter(output)
+		writer.tar = tar.NewWriter(writer.gzip)
+	case FormatZip:
+		writer.zip = zip.NewWriter(output)
+	default:
+		return nil, fmt.Errorf("%w: \"%s\"", ErrorUnknownFormat, format)
+	}
+
+	return &writer, nil
+}
+
+// checks the name and returns it cleaned
+func cleanName(name string) (string, error) {
+	cleaned := path.Clean(name)
+	if name == "" || cleaned == "." || path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: \"%s\"", ErrorInvalidName, name)
+	}
+
+	return cleaned, nil
+}
+
+// writes the directories the entry is in, that are not in the archive yet
+func (writer *Writer) writeParents(name string) error {
+	parent := path.Dir(name)
+	if parent == "." || writer.dirs[parent] {
+		return nil
+	}
+
+	return writer.writeDirectory(parent, writer.created)
+}
+
+func (writer *Writer) writeDirectory(name string, modTime time.Time) error {
+	err := writer.writeParents(name)
+	if err != nil {
+		return err
+	}
+
+	switch writer.zip {
+	case nil:
+		err = writer.tar.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     name + "/",
+			Mode:     int64(DefaultDirMode),
+			ModTime:  modTime,
+		})
+	default:
+		header := zip.FileHeader{
+			Name:     name + "/",
+			Modified: modTime,
+		}
+		header.SetMode(fs.ModeDir | DefaultDirMode)
+		_, err = writer.zip.CreateHeader(&header)
+	}
+	if err != nil {
+		return err
+	}
+
+	writer.dirs[name] = true
+
+	return nil
+}
+
+// Writes a directory. Nothing is written if it is already in the archive.
+// Zero modTime means the time the writer has been created
+func (writer *Writer) Directory(name string, modTime time.Time) error {
+	name, err := cleanName(name)
+	if err != nil {
+		return err
+	}
+
+	if writer.dirs[name] {
+		return nil
+	}
+
+	if modTime.IsZero() {
+		modTime = writer.created
+	}
+
+	return writer.writeDirectory(name, modTime)
+}
+
+// Writes the header of a file and returns where its contents go. Exactly size bytes must be
+// written there before the next entry. Zero mode means DefaultFileMode,
+// zero modTime means the time the writer has been created
+func (writer *Writer) File(name string, size uint64, mode fs.FileMode, modTime time.Time) (io.Writer, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.writeParents(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode.Perm() == 0 {
+		mode = DefaultFileMode
+	}
+	if modTime.IsZero() {
+		modTime = writer.created
+	}
+
+	if writer.zip != nil {
+		header := zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: modTime,
+		}
+		header.SetMode(mode.Perm())
+
+		return writer.zip.CreateHeader(&header)
+	}
+
+	err = writer.tar.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(size),
+		Mode:     int64(mode.Perm()),
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return writer.tar, nil
+}
+
+// Writes a symlink that points to the target. The target is written as is
+func (writer *Writer) Symlink(name string, target string) error {
+	name, err := cleanName(name)
+	if err != nil {
+		return err
+	}
+
+	err = writer.writeParents(name)
+	if err != nil {
+		return err
+	}
+
+	if writer.zip != nil {
+		// zip keeps the target as the contents of the link
+		header := zip.FileHeader{
+			Name:     name,
+			Modified: writer.created,
+		}
+		header.SetMode(fs.ModeSymlink | fs.ModePerm)
+
+		contents, err := writer.zip.CreateHeader(&header)
+		if err != nil {
+			return err
+		}
+		_, err = contents.Write([]byte(target))
+
+		return err
+	}
+
+	return writer.tar.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: target,
+		Mode:     int64(fs.ModePerm),
+		ModTime:  writer.created,
+	})
+}
+
+// Finishes the archive. The writer cannot be used after that.
+// Closing an already closed writer does nothing
+func (writer *Writer) Close() error {
+	if writer.closed {
+		return nil
+	}
+	writer.closed = true
+
+	if writer.zip != nil {
+		return writer.zip.Close()
+	}
+
+	err := writer.tar.Close()
+	if err != nil {
+		return err
+	}
+
+	if writer.gzip != nil {
+		return writer.gzip.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package browse
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/transfer"
+	"unbewohnte/ftu/transport"
+)
+
+// shares a small tree and connects to it
+func startSession(t *testing.T) (*Client, string) {
+	root := t.TempDir()
+
+	files := map[string]string{
+		"a.txt":           "aaaaa",
+		"docs/b.txt":      "bbbbbbbbbb",
+		"docs/inner/c.md": "ccc",
+	}
+	for path, contents := range files {
+		fullPath := filepath.Join(root, path)
+		err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		err = os.WriteFile(fullPath, []byte(contents), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	// leads outside of the root
+	outside := t.TempDir()
+	err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.Symlink(outside, filepath.Join(root, "escape"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	return connect(t, New(Options{Root: root})), outside
+}
+
+// serves with the server and connects to it
+func connect(t *testing.T, server *Server) *Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		stop()
+		select {
+		case <-served:
+		case <-time.After(time.Second * 5):
+			t.Errorf("the server has not stopped")
+		}
+	})
+
+	client, err := Dial(context.Background(), &transport.TCP{Address: listener.Addr().String()})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	return client
+}
+
+func Test_List(t *testing.T) {
+	client, _ := startSession(t)
+
+	entries, err := client.List(context.Background(), "", false)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries; got %+v", entries)
+	}
+	for _, entry := range entries {
+		switch entry.Path {
+		case "a.txt":
+			if entry.Size != 5 || entry.IsDir || entry.ModTime.IsZero() {
+				t.Fatalf("unexpected file entry %+v", entry)
+			}
+		case "docs":
+			if entry.Size != 13 || !entry.IsDir {
+				t.Fatalf("unexpected directory entry %+v", entry)
+			}
+		case "escape":
+			if !entry.IsSymlink {
+				t.Fatalf("unexpected symlink entry %+v", entry)
+			}
+		default:
+			t.Fatalf("unexpected entry %+v", entry)
+		}
+	}
+
+	tree, err := client.List(context.Background(), "docs", true)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(tree) != 3 || tree[0].Path != "b.txt" || tree[1].Path != "inner" || tree[2].Path != "inner/c.md" {
+		t.Fatalf("unexpected tree %+v", tree)
+	}
+
+	_, err = client.List(context.Background(), "nothing", false)
+	if !errors.Is(err, ErrorNotFound) {
+		t.Fatalf("expected a missing directory to be reported; got %v", err)
+	}
+
+	for _, escaping := range []string{"..", "../", "/etc", "escape"} {
+		_, err = client.List(context.Background(), escaping, false)
+		if !errors.Is(err, ErrorDenied) {
+			t.Fatalf("expected listing \"%s\" to be denied; got %v", escaping, err)
+		}
+	}
+}
+
+func Test_Get(t *testing.T) {
+	client, outside := startSession(t)
+	destination := t.TempDir()
+
+	// several transfers and requests in one session
+	for _, path := range []string{"a.txt", "docs"} {
+		result, err := client.Get(context.Background(), path, destination, transfer.ReceiveOptions{})
+		if err != nil {
+			t.Fatalf("getting \"%s\" failed: %s", path, err)
+		}
+		if result.Status != node.StatusSuccess {
+			t.Fatalf("expected \"%s\" to be received successfully; got %s", path, result.Status)
+		}
+
+		_, err = client.List(context.Background(), "", false)
+		if err != nil {
+			t.Fatalf("listing after getting \"%s\" failed: %s", path, err)
+		}
+	}
+
+	for path, expected := range map[string]string{
+		"a.txt":           "aaaaa",
+		"docs/b.txt":      "bbbbbbbbbb",
+		"docs/inner/c.md": "ccc",
+	} {
+		contents, err := os.ReadFile(filepath.Join(destination, path))
+		if err != nil || string(contents) != expected {
+			t.Fatalf("\"%s\" has not been received correctly: %q (%v)", path, contents, err)
+		}
+	}
+
+	for _, escaping := range []string{"../a.txt", "escape", "escape/secret.txt"} {
+		_, err := client.Get(context.Background(), escaping, destination, transfer.ReceiveOptions{})
+		if !errors.Is(err, ErrorDenied) {
+			t.Fatalf("expected getting \"%s\" to be denied; got %v", escaping, err)
+		}
+	}
+	_, err := os.Stat(filepath.Join(destination, "secret.txt"))
+	if err == nil {
+		t.Fatalf("a file from \"%s\" has been received", outside)
+	}
+
+	_, err = client.Get(context.Background(), "nothing.txt", destination, transfer.ReceiveOptions{})
+	if !errors.Is(err, ErrorNotFound) {
+		t.Fatalf("expected a missing file to be reported; got %v", err)
+	}
+}
+
+func Test_GetSwappedForSymlink(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	err := os.WriteFile(filepath.Join(outside, "b.txt"), []byte("secret"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// the checked directory is moved away and becomes a symlink to the outside right before it is sent
+	server := New(Options{Root: root})
+	server.resolved = func(relPath string) {
+		err := os.Rename(filepath.Join(root, "docs"), filepath.Join(t.TempDir(), "docs"))
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		err = os.Symlink(outside, filepath.Join(root, "docs"))
+		if err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+	client := connect(t, server)
+
+	for _, path := range []string{"docs", "docs/b.txt"} {
+		os.Remove(filepath.Join(root, "docs"))
+		err := os.Mkdir(filepath.Join(root, "docs"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		err = os.WriteFile(filepath.Join(root, "docs", "b.txt"), []byte("bbbbbbbbbb"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		destination := t.TempDir()
+		client.Get(context.Background(), path, destination, transfer.ReceiveOptions{})
+
+		for _, received := range []string{"b.txt", filepath.Join("docs", "b.txt")} {
+			contents, err := os.ReadFile(filepath.Join(destination, received))
+			if err == nil && string(contents) == "secret" {
+				t.Fatalf("getting \"%s\" has sent a file from \"%s\"", path, outside)
+			}
+		}
+
+		// the session goes on
+		_, err = client.List(context.Background(), "", false)
+		if err != nil {
+			t.Fatalf("listing after getting \"%s\" failed: %s", path, err)
+		}
+	}
+}
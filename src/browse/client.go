@@ -0,0 +1,158 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package browse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"unbewohnte/ftu/protocol"
+	"unbewohnte/ftu/session"
+	"unbewohnte/ftu/transfer"
+	"unbewohnte/ftu/transport"
+)
+
+// The browsing side of a session. Requests must not be made concurrently
+type Client struct {
+	session *session.Session
+}
+
+// Connects to the sharing side through the transport and starts a session
+func Dial(ctx context.Context, sessionTransport transport.Transport) (*Client, error) {
+	conn, err := sessionTransport.Dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", transfer.ErrorConnection, err)
+	}
+
+	return NewClient(conn)
+}
+
+// Starts a session over the connection to the sharing side
+func NewClient(conn net.Conn) (*Client, error) {
+	sess, err := session.Join(conn, session.KindBrowse)
+	if errors.Is(err, session.ErrorNotSession) {
+		return nil, ErrorNotShared
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", transfer.ErrorConnection, err)
+	}
+
+	return &Client{
+		session: sess,
+	}, nil
+}
+
+// Ends the session
+func (client *Client) Close() error {
+	return client.session.Close()
+}
+
+// Turns the reason of DENIED back into an error that can be checked with errors.Is
+func rejection(reason string) error {
+	for _, known := range []error{ErrorNotFound, ErrorDenied} {
+		if strings.HasPrefix(reason, known.Error()) {
+			return fmt.Errorf("%w%s", known, strings.TrimPrefix(reason, known.Error()))
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrorDenied, reason)
+}
+
+// Waits for the answer to the request. The session is closed if the context is done
+func (client *Client) answer(ctx context.Context) (*protocol.Packet, error) {
+	select {
+	case packet, ok := <-client.session.Packets():
+		if !ok {
+			return nil, fmt.Errorf("%w: the session has ended", transfer.ErrorConnection)
+		}
+
+		if packet.Header == protocol.HeaderDenied {
+			return nil, rejection(string(packet.Body))
+		}
+		return packet, nil
+
+	case <-ctx.Done():
+		client.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Returns entries of the directory at the slash-separated path relative to the shared root.
+// An empty path means the root itself. Entries of inner directories are listed too if recursive
+func (client *Client) List(ctx context.Context, path string, recursive bool) ([]Entry, error) {
+	err := client.session.Send(protocol.CreateListPacket(path, recursive))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for {
+		packet, err := client.answer(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if packet.Header != protocol.HeaderListing {
+			client.Close()
+			return nil, ErrorNotShared
+		}
+
+		listed, last, err := protocol.DecodeListingPacket(packet)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, listed...)
+
+		if last {
+			return entries, nil
+		}
+	}
+}
+
+// Fetches the file|directory at the slash-separated path relative to the shared root into destination
+// directory. The offer is accepted unless options.OfferDecider says otherwise
+func (client *Client) Get(ctx context.Context, path string, destination string, options transfer.ReceiveOptions) (*transfer.Result, error) {
+	// the channel is opened as soon as GRANTED arrives, before anything of the transfer
+	client.session.ExpectGranted()
+
+	err := client.session.Send(protocol.CreateGetPacket(path))
+	if err != nil {
+		return nil, err
+	}
+
+	packet, err := client.answer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := client.session.Channel()
+	if packet.Header != protocol.HeaderGranted || conn == nil {
+		client.Close()
+		return nil, ErrorNotShared
+	}
+
+	options.Reverse = false
+
+	return transfer.ReceiveConn(ctx, conn, destination, options)
+}
@@ -0,0 +1,349 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Sharing a directory read-only, so the other side can browse it and fetch
+// files and directories on demand, all within one session
+package browse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/progress"
+	"unbewohnte/ftu/protocol"
+	"unbewohnte/ftu/session"
+	"unbewohnte/ftu/transfer"
+)
+
+// An entry of the shared directory
+type Entry = protocol.ListingEntry
+
+var (
+	ErrorNotShared error = errors.New("the other side does not share a directory")
+	ErrorDenied    error = errors.New("request denied")
+	ErrorNotFound  error = errors.New("no such file or directory")
+)
+
+type Options struct {
+	Root string               // directory to share
+	Log  io.Writer            // where what happens with sessions is written. Discarded if nil
+	Send transfer.SendOptions // how to send requested files|directories. Output and Observer are set by the server, directories are always sent recursively, symlinks are never followed and those leading outside of the root are left out
+}
+
+// Lets every browsing side that connects list the root and fetch anything inside of it
+type Server struct {
+	options Options
+	log     *progress.Log
+
+	// called after the requested entry has been checked and before it is sent. Lets tests change the tree in between
+	resolved func(relPath string)
+}
+
+func New(options Options) *Server {
+	return &Server{
+		options: options,
+		log:     progress.NewLog(options.Log),
+	}
+}
+
+// Accepts browsing sides from the listener and serves each in its own session until
+// the context is done. Canceling the context ends sessions in progress
+func (server *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var sessions sync.WaitGroup
+	defer sessions.Wait()
+
+	server.log.Printf("Sharing \"%s\" on %s", server.options.Root, listener.Addr())
+
+	var lastID uint64
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		lastID++
+		sessions.Add(1)
+		go func(id uint64) {
+			defer sessions.Done()
+
+			prefix := fmt.Sprintf("[#%d %s]", id, conn.RemoteAddr())
+			err := server.serve(ctx, prefix, conn)
+			if err != nil {
+				server.log.Printf("%s %s", prefix, err)
+			}
+		}(lastID)
+	}
+}
+
+// Serves one browsing side over the connection until it disconnects or the context is done.
+// The connection is closed in the end
+func (server *Server) ServeConn(ctx context.Context, conn net.Conn) error {
+	return server.serve(ctx, fmt.Sprintf("[%s]", conn.RemoteAddr()), conn)
+}
+
+func (server *Server) serve(ctx context.Context, prefix string, conn net.Conn) error {
+	defer conn.Close()
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-finished:
+		}
+	}()
+
+	sandbox, err := fsys.OpenSandbox(server.options.Root)
+	if err != nil {
+		return err
+	}
+	defer sandbox.Close()
+
+	sess, err := session.Open(conn, session.KindBrowse)
+	if err != nil {
+		return err
+	}
+
+	server.log.Printf("%s Connected", prefix)
+	defer server.log.Printf("%s Disconnected", prefix)
+
+	for packet := range sess.Packets() {
+		switch packet.Header {
+		case protocol.HeaderList:
+			err = server.list(sess, sandbox, packet)
+
+		case protocol.HeaderGet:
+			err = server.get(ctx, prefix, sess, sandbox, packet)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Refuses the request telling why
+func deny(sess *session.Session, reason error) error {
+	return sess.Send(&protocol.Packet{
+		Header: protocol.HeaderDenied,
+		Body:   []byte(reason.Error()),
+	})
+}
+
+// Answers LIST
+func (server *Server) list(sess *session.Session, sandbox *fsys.Sandbox, packet *protocol.Packet) error {
+	relPath, recursive, err := protocol.DecodeListPacket(packet)
+	if err != nil {
+		return deny(sess, fmt.Errorf("%w: %s", ErrorDenied, err))
+	}
+
+	var entries []Entry
+	_, err = walk(sandbox, relPath, "", recursive, &entries)
+	if err != nil {
+		return deny(sess, readError(relPath, err))
+	}
+
+	for _, listingPacket := range protocol.CreateListingPackets(entries) {
+		err = sess.Send(listingPacket)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Describes why the directory on the way to relPath could not be read without
+// revealing where the root is
+func readError(relPath string, err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%w: \"%s\"", ErrorNotFound, relPath)
+	case errors.Is(err, fsys.ErrorUnsafePath):
+		return fmt.Errorf("%w: \"%s\" goes through a symlink", ErrorDenied, relPath)
+	default:
+		return fmt.Errorf("%w: \"%s\" is not a directory or can not be read", ErrorDenied, relPath)
+	}
+}
+
+// Appends entries of the directory at relPath to entries (and entries of inner directories too if recursive),
+// with paths starting with prefix. Symlinks are listed, but not followed. Returns the size of the directory
+func walk(sandbox *fsys.Sandbox, relPath string, prefix string, recursive bool, entries *[]Entry) (uint64, error) {
+	dirEntries, err := sandbox.ReadDir(filepath.FromSlash(relPath))
+	if err != nil {
+		return 0, err
+	}
+
+	var size uint64
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil {
+			// has gone already
+			continue
+		}
+
+		entry := Entry{
+			Path:    path.Join(prefix, dirEntry.Name()),
+			ModTime: info.ModTime(),
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			entry.IsSymlink = true
+
+		case info.IsDir():
+			entry.IsDir = true
+
+			index := -1
+			if entries != nil {
+				index = len(*entries)
+				*entries = append(*entries, entry)
+			}
+
+			// inner entries are only needed to know the size unless listing recursively
+			innerEntries := entries
+			if !recursive {
+				innerEntries = nil
+			}
+			innerSize, err := walk(sandbox, path.Join(relPath, dirEntry.Name()), entry.Path, recursive, innerEntries)
+			if err == nil {
+				size += innerSize
+				if index != -1 {
+					(*entries)[index].Size = innerSize
+				}
+			}
+			continue
+
+		default:
+			entry.Size = uint64(info.Size())
+		}
+
+		size += entry.Size
+		if entries != nil {
+			*entries = append(*entries, entry)
+		}
+	}
+
+	return size, nil
+}
+
+// Returns the path of the requested entry in sandbox.FS(), making sure that it is inside of the root
+// and that no symlink is on the way to it
+func resolve(sandbox *fsys.Sandbox, relPath string) (string, error) {
+	if relPath == "" {
+		return ".", nil
+	}
+
+	parent, name := path.Split(relPath)
+	parent = path.Clean(parent)
+	if parent == "." {
+		parent = ""
+	}
+
+	dirEntries, err := sandbox.ReadDir(filepath.FromSlash(parent))
+	if err != nil {
+		return "", readError(relPath, err)
+	}
+
+	for _, dirEntry := range dirEntries {
+		if dirEntry.Name() != name {
+			continue
+		}
+
+		if dirEntry.Type()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: \"%s\" is a symlink", ErrorDenied, relPath)
+		}
+		return relPath, nil
+	}
+
+	return "", fmt.Errorf("%w: \"%s\"", ErrorNotFound, relPath)
+}
+
+// Answers GET and sends the requested file|directory
+func (server *Server) get(ctx context.Context, prefix string, sess *session.Session, sandbox *fsys.Sandbox, packet *protocol.Packet) error {
+	relPath, err := protocol.DecodeGetPacket(packet)
+	if err != nil {
+		return deny(sess, fmt.Errorf("%w: %s", ErrorDenied, err))
+	}
+
+	source, err := resolve(sandbox, relPath)
+	if err != nil {
+		server.log.Printf("%s Refused to send \"%s\": %s", prefix, relPath, err)
+		return deny(sess, err)
+	}
+	if server.resolved != nil {
+		server.resolved(relPath)
+	}
+
+	options := server.options.Send
+	// read through the sandbox, so what has been checked can not be swapped for a symlink on the way
+	options.FS = sandbox.FS()
+	options.FSName = filepath.Base(sandbox.Root())
+	options.Output = nil
+	options.Observer = server.log.Observer(prefix)
+	options.Reverse = false
+	options.Recursive = true
+	options.FollowSymlinks = false
+	options.MoreSources = nil
+	options.Text = ""
+
+	// the transfer starts right after GRANTED
+	conn := sess.OpenChannel()
+	err = sess.Send(&protocol.Packet{
+		Header: protocol.HeaderGranted,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	result, err := transfer.SendConn(ctx, conn, source, options)
+	// says goodbye if the sender has stopped before it could (ie: the entry has been swapped for a symlink)
+	conn.Close()
+	if result == nil {
+		// the transfer has not even started
+		server.log.Printf("%s Could not send \"%s\": %s", prefix, relPath, err)
+	}
+
+	return nil
+}
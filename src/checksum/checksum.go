@@ -24,10 +24,18 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"hash"
 	"io"
 	"os"
 )
 
+// "capturing" chunkSize bytes and then skipping stepSize bytes before the next chunk until the last one
+const (
+	chunksCount uint = 50
+	chunkSize   uint = 50
+	stepSize    uint = 250
+)
+
 // returns a checksum of given file. NOTE, that it creates checksum
 // not of a full file (from all file bytes), but from separate byte blocks.
 // This is done as an optimisation because the file can be very large in size.
@@ -36,11 +44,6 @@ import (
 // checksum := sha256.Sum256(ALLCHUNKS)
 // GetPartialCheckSum is default method used to get a file checksum by sender and receiver
 func GetPartialCheckSum(file *os.File) (string, error) {
-	// "capturing" CHUNKSIZE bytes and then skipping STEP bytes before the next chunk until the last one
-	const CHUNKS uint = 50
-	const CHUNKSIZE uint = 50
-	const STEP uint = 250
-
 	fileStats, err := file.Stat()
 	if err != nil {
 		return "", err
@@ -48,7 +51,7 @@ func GetPartialCheckSum(file *os.File) (string, error) {
 
 	fileSize := fileStats.Size()
 
-	if fileSize < int64(CHUNKS*CHUNKSIZE+STEP*(CHUNKS-1)) {
+	if fileSize < int64(chunksCount*chunkSize+stepSize*(chunksCount-1)) {
 		// file is too small to chop it in chunks, so just get the full checksum
 
 		checksum, err := getFullCheckSum(file)
@@ -66,14 +69,14 @@ func GetPartialCheckSum(file *os.File) (string, error) {
 	// var capturedChunks string
 	var capturedChunks bytes.Buffer
 	var read uint64 = 0
-	for i := 0; uint(i) < CHUNKS; i++ {
-		buffer := make([]byte, CHUNKSIZE)
+	for i := 0; uint(i) < chunksCount; i++ {
+		buffer := make([]byte, chunkSize)
 		r, _ := file.ReadAt(buffer, int64(read))
 
 		capturedChunks.Write(buffer)
 
 		read += uint64(r)
-		read += uint64(STEP)
+		read += uint64(stepSize)
 	}
 
 	checksumBytes := sha256.Sum256(capturedChunks.Bytes())
@@ -99,3 +102,78 @@ func getFullCheckSum(file *os.File) (string, error) {
 
 	return checksum, nil
 }
+
+// Computes the checksum of the contents written into it piece after piece, the same way
+// GetPartialCheckSum does for a file of the given size. Used when the file can not be read
+// back (ie: it is written to stdout) and for streams, whose checksum is always a full one
+type Writer struct {
+	size    int64 // -1 if unknown
+	written uint64
+	full    hash.Hash
+	chunks  bytes.Buffer
+}
+
+// Creates a Writer for the contents of size bytes. -1 means the size is unknown
+// and the full checksum is computed
+func NewWriter(size int64) *Writer {
+	return &Writer{
+		size: size,
+		full: sha256.New(),
+	}
+}
+
+// Returns whether the checksum is taken of separate chunks rather than of all contents
+func (writer *Writer) partial() bool {
+	return writer.size >= int64(chunksCount*chunkSize+stepSize*(chunksCount-1))
+}
+
+func (writer *Writer) Write(p []byte) (int, error) {
+	if !writer.partial() {
+		writer.full.Write(p)
+		writer.written += uint64(len(p))
+		return len(p), nil
+	}
+
+	// keep only the parts of p that fall into the chunks, which are all at the beginning
+	if writer.written >= uint64(chunksCount*(chunkSize+stepSize)) {
+		writer.written += uint64(len(p))
+		return len(p), nil
+	}
+	for index := range p {
+		position := writer.written + uint64(index)
+		if position/uint64(chunkSize+stepSize) < uint64(chunksCount) && position%uint64(chunkSize+stepSize) < uint64(chunkSize) {
+			writer.chunks.WriteByte(p[index])
+		}
+	}
+	writer.written += uint64(len(p))
+
+	return len(p), nil
+}
+
+// Returns the checksum of everything written so far
+func (writer *Writer) CheckSum() string {
+	if !writer.partial() {
+		return hex.EncodeToString(writer.full.Sum(nil))
+	}
+
+	checksumBytes := sha256.Sum256(writer.chunks.Bytes())
+	return hex.EncodeToString(checksumBytes[:])
+}
+
+// Returns the checksum of the contents of size bytes read from the reader, the same way
+// GetPartialCheckSum does for a file. Only the part of the contents the checksum is taken of is read
+func GetReaderCheckSum(reader io.Reader, size int64) (string, error) {
+	writer := NewWriter(size)
+
+	toRead := size
+	if writer.partial() && toRead > int64(chunksCount*(chunkSize+stepSize)) {
+		toRead = int64(chunksCount * (chunkSize + stepSize))
+	}
+
+	_, err := io.CopyN(writer, reader, toRead)
+	if err != nil {
+		return "", err
+	}
+
+	return writer.CheckSum(), nil
+}
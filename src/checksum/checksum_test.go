@@ -21,7 +21,11 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package checksum
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -43,3 +47,56 @@ func Test_GetPartialCheckSum(t *testing.T) {
 		t.Fatalf("GetPartialCheckSum error: hashes of a testfile.txt do not match")
 	}
 }
+
+func Test_Writer(t *testing.T) {
+	for _, size := range []int{0, 86, 14749, 14750, 100000} {
+		contents := make([]byte, size)
+		for index := range contents {
+			contents[index] = byte(index * 7)
+		}
+
+		path := filepath.Join(t.TempDir(), "file")
+		err := os.WriteFile(path, contents, os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		expected, err := GetPartialCheckSum(file)
+		file.Close()
+		if err != nil {
+			t.Fatalf("GetPartialCheckSum error: %s", err)
+		}
+
+		// written in uneven pieces, the way they come from the other side
+		writer := NewWriter(int64(size))
+		for offset := 0; offset < size; offset += 333 {
+			end := offset + 333
+			if end > size {
+				end = len(contents)
+			}
+			writer.Write(contents[offset:end])
+		}
+
+		if writer.CheckSum() != expected {
+			t.Fatalf("Writer error: checksum of %d bytes is %s; expected %s", size, writer.CheckSum(), expected)
+		}
+
+		readerChecksum, err := GetReaderCheckSum(bytes.NewReader(contents), int64(size))
+		if err != nil || readerChecksum != expected {
+			t.Fatalf("GetReaderCheckSum error: checksum of %d bytes is %s; expected %s (%v)", size, readerChecksum, expected, err)
+		}
+	}
+
+	// the size of a stream is unknown, so all of it counts
+	full := sha256.Sum256([]byte("streamed contents"))
+	writer := NewWriter(-1)
+	writer.Write([]byte("streamed "))
+	writer.Write([]byte("contents"))
+	if writer.CheckSum() != hex.EncodeToString(full[:]) {
+		t.Fatalf("Writer error: checksum of a stream is not a full one")
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Returns a path of the numbered backup of the file at relPath.
+// ie: "dir/file.txt", 3 -> "dir/file.txt.~3~"
+func BackupName(relPath string, number uint) string {
+	return fmt.Sprintf("%s.~%d~", relPath, number)
+}
+
+// Extracts the number of the backup with the given name made for the file
+// with the original name. Returns false if it is not a backup of that file
+func backupNumber(name string, original string) (uint, bool) {
+	if !strings.HasPrefix(name, original+".~") || !strings.HasSuffix(name, "~") {
+		return 0, false
+	}
+
+	numberStr := strings.TrimSuffix(strings.TrimPrefix(name, original+".~"), "~")
+	number, err := strconv.ParseUint(numberStr, 10, 64)
+	if err != nil || number == 0 {
+		return 0, false
+	}
+
+	return uint(number), true
+}
+
+// Renames the file at relPath into the next numbered backup next to it and removes
+// the oldest backups so that at most keep of them are left (0 means keep all).
+// Returns a path of the created backup
+func BackupNumbered(sandbox *Sandbox, relPath string, keep uint) (string, error) {
+	parent, name := splitParent(relPath)
+
+	entries, err := sandbox.ReadDir(parent)
+	if err != nil {
+		return "", err
+	}
+
+	var numbers []uint
+	var lastNumber uint = 0
+	for _, entry := range entries {
+		number, ok := backupNumber(entry.Name(), name)
+		if !ok {
+			continue
+		}
+
+		numbers = append(numbers, number)
+		if number > lastNumber {
+			lastNumber = number
+		}
+	}
+
+	backupRelPath := BackupName(relPath, lastNumber+1)
+	err = sandbox.Rename(relPath, backupRelPath)
+	if err != nil {
+		return "", err
+	}
+	numbers = append(numbers, lastNumber+1)
+
+	if keep == 0 || uint(len(numbers)) <= keep {
+		return backupRelPath, nil
+	}
+
+	// remove the oldest ones
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] < numbers[j]
+	})
+	for _, number := range numbers[:uint(len(numbers))-keep] {
+		err = sandbox.Remove(BackupName(relPath, number))
+		if err != nil {
+			return backupRelPath, err
+		}
+	}
+
+	return backupRelPath, nil
+}
+
+// Moves the file at relPath into backupDir keeping its relative path.
+// ie: "dir/file.txt" -> "(backupDir)/dir/file.txt".
+// Returns a path of the created backup
+func BackupToDir(sandbox *Sandbox, relPath string, backupDir string) (string, error) {
+	backupRelPath := filepath.Join(backupDir, relPath)
+
+	err := sandbox.Rename(relPath, backupRelPath)
+	if err != nil {
+		return "", err
+	}
+
+	return backupRelPath, nil
+}
+
+// Removes the oldest directories inside backupsRoot so that at most keep of them are left.
+// Directories are ordered by their names, so they must be named to sort chronologically.
+// keep == 0 means keep all
+func PruneBackupDirs(sandbox *Sandbox, backupsRoot string, keep uint) error {
+	if keep == 0 {
+		return nil
+	}
+
+	entries, err := sandbox.ReadDir(backupsRoot)
+	if err != nil {
+		return err
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+
+	if uint(len(dirs)) <= keep {
+		return nil
+	}
+
+	// entries are already sorted by name
+	for _, dir := range dirs[:uint(len(dirs))-keep] {
+		err = sandbox.RemoveAll(filepath.Join(backupsRoot, dir))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_BackupNumbered(t *testing.T) {
+	root := t.TempDir()
+
+	sandbox, err := OpenSandbox(root)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer sandbox.Close()
+
+	filePath := filepath.Join(root, "file.txt")
+
+	// back up 4 versions, keeping only 2 of them
+	for _, version := range []string{"1", "2", "3", "4"} {
+		err = os.WriteFile(filePath, []byte(version), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		_, err = BackupNumbered(sandbox, "file.txt", 2)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 backups to be left; got %d", len(entries))
+	}
+
+	for number, expected := range map[uint]string{3: "3", 4: "4"} {
+		contents, err := os.ReadFile(filepath.Join(root, BackupName("file.txt", number)))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if string(contents) != expected {
+			t.Fatalf("expected backup %d to contain \"%s\"; got \"%s\"", number, expected, contents)
+		}
+	}
+}
+
+func Test_BackupToDir(t *testing.T) {
+	root := t.TempDir()
+
+	sandbox, err := OpenSandbox(root)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer sandbox.Close()
+
+	for _, backupDir := range []string{"backups/2022-01-01", "backups/2022-01-02", "backups/2022-01-03"} {
+		err = os.MkdirAll(filepath.Join(root, "dir"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		err = os.WriteFile(filepath.Join(root, "dir", "file.txt"), []byte(backupDir), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		backupRelPath, err := BackupToDir(sandbox, "dir/file.txt", backupDir)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if backupRelPath != filepath.Join(backupDir, "dir", "file.txt") {
+			t.Fatalf("unexpected backup path \"%s\"", backupRelPath)
+		}
+	}
+
+	err = PruneBackupDirs(sandbox, "backups", 1)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(root, "backups"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "2022-01-03" {
+		t.Fatalf("expected only the latest backup directory to be left; got %v", entries)
+	}
+}
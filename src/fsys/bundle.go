@@ -0,0 +1,88 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"fmt"
+	"os"
+)
+
+var ErrorDuplicateName error = fmt.Errorf("duplicate name")
+
+// Gets several files and directories as entries of one bundle, so they can be sent together.
+// Directories are walked according to the options. The bundle is a Directory without a name
+// and a path; every entry keeps its own name and is placed at the top level on the receiving side.
+// Entries with the same name cannot be in one bundle
+func GetBundle(paths []string, options WalkOptions) (*Directory, error) {
+	bundle := Directory{
+		Bundle: true,
+	}
+
+	names := make(map[string]string)
+	for _, path := range paths {
+		stats, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var name string
+		if stats.IsDir() {
+			dir, err := GetDirWithOptions(path, options)
+			if err != nil {
+				return nil, err
+			}
+
+			name = dir.Name
+			bundle.Size += dir.Size
+			bundle.Directories = append(bundle.Directories, dir)
+			bundle.Warnings = append(bundle.Warnings, dir.Warnings...)
+		} else {
+			file, err := GetFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			name = file.Name
+			bundle.Size += file.Size
+			bundle.Files = append(bundle.Files, file)
+		}
+
+		if otherPath, taken := names[name]; taken {
+			return nil, fmt.Errorf("%w: \"%s\" and \"%s\" are both called \"%s\"", ErrorDuplicateName, otherPath, path, name)
+		}
+		names[name] = path
+	}
+
+	return &bundle, nil
+}
+
+// Returns names of the upmost entries of the bundle: files first, then directories
+func (dir *Directory) EntryNames() []string {
+	var names []string
+	for _, file := range dir.Files {
+		names = append(names, file.Name)
+	}
+	for _, innerDir := range dir.Directories {
+		names = append(names, innerDir.Name)
+	}
+
+	return names
+}
@@ -36,11 +36,61 @@ type Directory struct {
 	Symlinks           []*Symlink
 	Files              []*File
 	Directories        []*Directory
+	Warnings           []error // Non-fatal problems met during the walk (cycles, depth limit). Set only for the upmost directory
+	Bundle             bool    // Does not exist by itself and only groups its entries together. See GetBundle
 }
 
 var ErrorNotDirectory error = fmt.Errorf("not a directory")
+var ErrorSymlinkCycle error = fmt.Errorf("symlink cycle")
+var ErrorAlreadyWalked error = fmt.Errorf("directory has already been walked")
+var ErrorMaxDepth error = fmt.Errorf("maximum depth reached")
+
+// Options that change the way the directory is walked
+type WalkOptions struct {
+	Recursive      bool // descend into inner directories
+	FollowSymlinks bool // walk symlinks as if they were the files|directories they point to
+	MaxDepth       uint // how many levels of inner directories to descend into. 0 means no limit
+}
+
+// Keeps the state of a single walk so that directories reached through
+// links are not walked forever or more than once
+type walker struct {
+	options   WalkOptions
+	visited   map[fileID]string // every walked directory and the path it was first reached by
+	ancestors map[fileID]bool   // directories on the way from the root to the current one
+	warnings  []error
+}
 
+// Get general information about a directory and its entries.
+// Symlinks are not followed
 func GetDir(path string, recursive bool) (*Directory, error) {
+	return GetDirWithOptions(path, WalkOptions{
+		Recursive: recursive,
+	})
+}
+
+// Get general information about a directory walking it according to the options.
+// Entries are walked in lexical order, so walking the same tree twice produces the same result.
+// When following symlinks, every directory is walked once: links leading to one of the
+// parent directories or to an already walked directory are skipped and reported
+// in the Warnings of the returned directory
+func GetDirWithOptions(path string, options WalkOptions) (*Directory, error) {
+	w := walker{
+		options:   options,
+		visited:   make(map[fileID]string),
+		ancestors: make(map[fileID]bool),
+	}
+
+	directory, err := w.walk(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	directory.Warnings = w.warnings
+
+	return directory, nil
+}
+
+func (w *walker) walk(path string, depth uint) (*Directory, error) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return nil, err
@@ -56,6 +106,14 @@ func GetDir(path string, recursive bool) (*Directory, error) {
 		return nil, ErrorNotDirectory
 	}
 
+	id, err := getFileID(absPath, stats)
+	if err != nil {
+		return nil, err
+	}
+	w.visited[id] = absPath
+	w.ancestors[id] = true
+	defer delete(w.ancestors, id)
+
 	directory := Directory{
 		Name:        stats.Name(),
 		Path:        absPath,
@@ -73,26 +131,57 @@ func GetDir(path string, recursive bool) (*Directory, error) {
 	var innerFiles []*File
 	var innerSymlinks []*Symlink
 	for _, entry := range entries {
+		entryPath := filepath.Join(absPath, entry.Name())
+
 		entryInfo, err := entry.Info()
 		if err != nil {
 			return nil, err
 		}
 
+		if entryInfo.Mode()&os.ModeSymlink != 0 && w.options.FollowSymlinks {
+			targetInfo, err := os.Stat(entryPath)
+			if err == nil {
+				// work with whatever the link points to
+				entryInfo = targetInfo
+			}
+			// a broken link is sent as is
+		}
+
 		if entryInfo.IsDir() {
-			if recursive {
-				// do the recursive magic
-				innerDirPath := filepath.Join(absPath, entry.Name())
+			if !w.options.Recursive {
+				// skip the directory and only work with the files
+				continue
+			}
 
-				innerDir, err := GetDir(innerDirPath, true)
-				if err != nil {
-					return nil, err
-				}
+			if w.options.MaxDepth != 0 && depth+1 > w.options.MaxDepth {
+				w.warnings = append(w.warnings, fmt.Errorf("%w: skipping \"%s\"", ErrorMaxDepth, entryPath))
+				continue
+			}
+
+			innerID, err := getFileID(entryPath, entryInfo)
+			if err != nil {
+				return nil, err
+			}
+
+			if w.ancestors[innerID] {
+				w.warnings = append(w.warnings, fmt.Errorf("%w: \"%s\" leads to its parent \"%s\"", ErrorSymlinkCycle, entryPath, w.visited[innerID]))
+				continue
+			}
 
-				directory.Size += innerDir.Size
+			if firstPath, walked := w.visited[innerID]; walked {
+				w.warnings = append(w.warnings, fmt.Errorf("%w: \"%s\" is the same as \"%s\"", ErrorAlreadyWalked, entryPath, firstPath))
+				continue
+			}
 
-				innerDirs = append(innerDirs, innerDir)
+			// do the recursive magic
+			innerDir, err := w.walk(entryPath, depth+1)
+			if err != nil {
+				return nil, err
 			}
-			// if not - skip the directory and only work with the files
+
+			directory.Size += innerDir.Size
+
+			innerDirs = append(innerDirs, innerDir)
 
 		} else {
 			// not a directory
@@ -100,9 +189,7 @@ func GetDir(path string, recursive bool) (*Directory, error) {
 			switch entryInfo.Mode()&os.ModeSymlink != 0 {
 			case true:
 				// it is a symlink
-				innerSymlinkPath := filepath.Join(absPath, entryInfo.Name())
-
-				symlink, err := GetSymlink(innerSymlinkPath, false)
+				symlink, err := GetSymlink(entryPath, false)
 				if err != nil {
 					// skip this symlink
 					continue
@@ -112,10 +199,7 @@ func GetDir(path string, recursive bool) (*Directory, error) {
 
 			case false:
 				// it is a usual file
-
-				innerFilePath := filepath.Join(absPath, entryInfo.Name())
-
-				innerFile, err := GetFile(innerFilePath)
+				innerFile, err := GetFile(entryPath)
 				if err != nil {
 					// skip this file
 					continue
@@ -139,7 +223,7 @@ func GetDir(path string, recursive bool) (*Directory, error) {
 func (dir *Directory) GetAllFiles(recursive bool) []*File {
 	var files []*File = dir.Files
 
-	if recursive {
+	if recursive || dir.Bundle {
 		if len(dir.Directories) == 0 {
 			return files
 		}
@@ -160,7 +244,7 @@ func (dir *Directory) GetAllFiles(recursive bool) []*File {
 func (dir *Directory) GetAllSymlinks(recursive bool) []*Symlink {
 	var symlinks []*Symlink = dir.Symlinks
 
-	if recursive {
+	if recursive || dir.Bundle {
 		if len(dir.Directories) == 0 {
 			return symlinks
 		}
@@ -176,13 +260,52 @@ func (dir *Directory) GetAllSymlinks(recursive bool) []*Symlink {
 	return symlinks
 }
 
-// Sets `RelativeParentPath` relative to the given base path for files and `Path`, `TargetPath` for symlinks so the
+// Returns every inner directory that has nothing in it: no files, symlinks or directories.
+// Inner directories of inner directories are looked at only if recursive, but the directories
+// of a bundle are always looked at, as they are sent even if not recursive
+func (dir *Directory) GetEmptyDirectories(recursive bool) []*Directory {
+	if !recursive && !dir.Bundle {
+		return nil
+	}
+
+	var emptyDirs []*Directory
+	for _, innerDir := range dir.Directories {
+		if len(innerDir.Files) == 0 && len(innerDir.Symlinks) == 0 && len(innerDir.Directories) == 0 {
+			emptyDirs = append(emptyDirs, innerDir)
+			continue
+		}
+
+		emptyDirs = append(emptyDirs, innerDir.GetEmptyDirectories(recursive)...)
+	}
+
+	return emptyDirs
+}
+
+// Sets `RelativeParentPath` relative to the given base path for files and empty directories and `Path`, `TargetPath` for symlinks so the
 // file with such path:
 // /home/user/directory/somefile.txt
 // had a relative path like that:
 // /directory/somefile.txt
 // (where base path is /home/user/directory)
+// Paths in a bundle are set relative to the parent of each entry, so the base is not used
 func (dir *Directory) SetRelativePaths(base string, recursive bool) error {
+	if dir.Bundle {
+		for _, file := range dir.Files {
+			file.RelativeParentPath = file.Name
+		}
+
+		for _, innerDir := range dir.Directories {
+			innerDir.RelativeParentPath = innerDir.Name
+
+			err := innerDir.SetRelativePaths(filepath.Dir(innerDir.Path), recursive)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
 	for _, file := range dir.GetAllFiles(recursive) {
 		relPath, err := filepath.Rel(base, file.Path)
 		if err != nil {
@@ -193,6 +316,15 @@ func (dir *Directory) SetRelativePaths(base string, recursive bool) error {
 
 	}
 
+	for _, emptyDir := range dir.GetEmptyDirectories(recursive) {
+		relPath, err := filepath.Rel(base, emptyDir.Path)
+		if err != nil {
+			return err
+		}
+
+		emptyDir.RelativeParentPath = relPath
+	}
+
 	for _, symlink := range dir.GetAllSymlinks(recursive) {
 		symRelPath, err := filepath.Rel(base, symlink.Path)
 		if err != nil {
@@ -21,8 +21,10 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package fsys
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -101,3 +103,217 @@ func Test_GetSymlinks(t *testing.T) {
 		t.Fatalf("expected to get %d symlinks; got %d\n", symlinkCount, len(symlinks))
 	}
 }
+
+func Test_GetDirFollowSymlinksCycles(t *testing.T) {
+	root := t.TempDir()
+
+	// root/a/b/file.txt
+	// root/a/b/up -> root/a (cycle)
+	// root/link -> root/a/b (the same directory through another path)
+	err := os.MkdirAll(filepath.Join(root, "a", "b"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.WriteFile(filepath.Join(root, "a", "b", "file.txt"), []byte("contents"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.Symlink(filepath.Join(root, "a"), filepath.Join(root, "a", "b", "up"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.Symlink(filepath.Join(root, "a", "b"), filepath.Join(root, "link"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	dir, err := GetDirWithOptions(root, WalkOptions{
+		Recursive:      true,
+		FollowSymlinks: true,
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	files := dir.GetAllFiles(true)
+	if len(files) != 1 {
+		t.Fatalf("expected to get 1 file; got %d", len(files))
+	}
+
+	var cycles, duplicates int
+	for _, warning := range dir.Warnings {
+		switch {
+		case errors.Is(warning, ErrorSymlinkCycle):
+			cycles++
+		case errors.Is(warning, ErrorAlreadyWalked):
+			duplicates++
+		}
+	}
+	if cycles != 1 || duplicates != 1 {
+		t.Fatalf("expected 1 cycle and 1 duplicate warning; got %d and %d: %v", cycles, duplicates, dir.Warnings)
+	}
+}
+
+func Test_GetDirMaxDepth(t *testing.T) {
+	dirpath := "../testfiles/"
+
+	dir, err := GetDirWithOptions(dirpath, WalkOptions{
+		Recursive: true,
+		MaxDepth:  1,
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for _, innerDir := range dir.Directories {
+		if len(innerDir.Directories) != 0 {
+			t.Fatalf("expected \"%s\" to not be walked deeper", innerDir.Path)
+		}
+	}
+
+	if len(dir.Warnings) == 0 {
+		t.Fatalf("expected to get depth warnings")
+	}
+}
+
+func Test_GetDirDeterministic(t *testing.T) {
+	dirpath := filepath.Join(t.TempDir(), "dir")
+
+	// created out of order, so the order of creation is not what is walked
+	for _, path := range []string{"c/z.txt", "b.txt", "c/y.txt", "B.txt", "a.txt", "a/x.txt"} {
+		fullPath := filepath.Join(dirpath, path)
+		err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		err = os.WriteFile(fullPath, nil, os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	dir, err := GetDir(dirpath, true)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	var walked []string
+	for _, file := range dir.GetAllFiles(true) {
+		relPath, _ := filepath.Rel(dirpath, file.Path)
+		walked = append(walked, filepath.ToSlash(relPath))
+	}
+
+	// files of a directory come before its directories, each in the order of their names
+	expected := []string{"B.txt", "a.txt", "b.txt", "a/x.txt", "c/y.txt", "c/z.txt"}
+	if strings.Join(walked, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected the walk order %v; got %v", expected, walked)
+	}
+}
+
+func Test_GetBundle(t *testing.T) {
+	bundle, err := GetBundle([]string{"../testfiles/testfile.txt", "../testfiles/testdir/testdir2", "../testfiles/testdir3"}, WalkOptions{Recursive: true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if !bundle.Bundle || len(bundle.Files) != 1 || len(bundle.Directories) != 2 {
+		t.Fatalf("expected a bundle of 1 file and 2 directories; got %v", bundle.EntryNames())
+	}
+
+	var size uint64 = bundle.Files[0].Size
+	for _, dir := range bundle.Directories {
+		size += dir.Size
+	}
+	if bundle.Size != size {
+		t.Fatalf("expected the bundle to be %d bytes; got %d", size, bundle.Size)
+	}
+
+	err = bundle.SetRelativePaths("", true)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expected := map[string]bool{
+		"testfile.txt": true,
+		filepath.Join("testdir2", "testfile3.txt"):                       true,
+		filepath.Join("testdir3", "testfile4"):                           true,
+		filepath.Join("testdir3", "nested1", "nested2", "testfilen.txt"): true,
+	}
+	files := bundle.GetAllFiles(true)
+	if len(files) != len(expected) {
+		t.Fatalf("expected %d files; got %d", len(expected), len(files))
+	}
+	for _, file := range files {
+		if !expected[file.RelativeParentPath] {
+			t.Fatalf("unexpected relative path \"%s\"", file.RelativeParentPath)
+		}
+	}
+
+	_, err = GetBundle([]string{"../testfiles/testfile.txt", "../testfiles/testDownload/testfile.txt"}, WalkOptions{})
+	if !errors.Is(err, ErrorDuplicateName) {
+		t.Fatalf("expected entries with the same name to be refused; got %v", err)
+	}
+}
+
+func Test_GetEmptyDirectories(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{
+		filepath.Join(root, "empty"),
+		filepath.Join(root, "full", "inner", "empty"),
+		filepath.Join(root, "full", "inner2"),
+	} {
+		err := os.MkdirAll(path, os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	err := os.WriteFile(filepath.Join(root, "full", "file.txt"), []byte("something"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	dir, err := GetDir(root, true)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = dir.SetRelativePaths(dir.Path, true)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	expected := map[string]bool{
+		"empty":                                 true,
+		filepath.Join("full", "inner", "empty"): true,
+		filepath.Join("full", "inner2"):         true,
+	}
+	emptyDirs := dir.GetEmptyDirectories(true)
+	if len(emptyDirs) != len(expected) {
+		t.Fatalf("expected %d empty directories; got %d", len(expected), len(emptyDirs))
+	}
+	for _, emptyDir := range emptyDirs {
+		if !expected[emptyDir.RelativeParentPath] {
+			t.Fatalf("unexpected empty directory \"%s\"", emptyDir.RelativeParentPath)
+		}
+	}
+
+	if len(dir.GetEmptyDirectories(false)) != 0 {
+		t.Fatalf("expected inner directories to not be looked at when not recursive")
+	}
+
+	// an empty entry of a bundle is sent even if not recursive
+	bundle, err := GetBundle([]string{filepath.Join(root, "empty"), filepath.Join(root, "full")}, WalkOptions{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = bundle.SetRelativePaths("", false)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	emptyDirs = bundle.GetEmptyDirectories(false)
+	if len(emptyDirs) != 1 || emptyDirs[0].RelativeParentPath != "empty" {
+		t.Fatalf("expected only \"empty\" entry of the bundle to be empty; got %v", emptyDirs)
+	}
+}
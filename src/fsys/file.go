@@ -22,8 +22,11 @@ package fsys
 
 import (
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"time"
 
 	"unbewohnte/ftu/checksum"
 )
@@ -35,9 +38,15 @@ type File struct {
 	Path               string
 	RelativeParentPath string // Relative path to the file, where the highest directory in the hierarchy is the upmost parent dir. Set manually
 	Size               uint64
+	ModTime            time.Time   // Zero if unknown
+	Mode               os.FileMode // Permission bits. Zero if unknown
 	Checksum           string
-	Handler            *os.File // Set when .Open() is called
-	SentBytes          uint64   // Set manually during transportation
+	Handler            *os.File  // Set when .Open() is called
+	SentBytes          uint64    // Set manually during transportation
+	Streamed           bool      // Size and Checksum are unknown until the whole file has been sent
+	Stream             io.Reader // Where the contents of a streamed file are read from by the sender instead of Path
+
+	FS fs.FS // The filesystem Path is in, which is read instead of the OS filesystem. Nil for the OS filesystem
 }
 
 var ErrorNotFile error = fmt.Errorf("not a file")
@@ -66,6 +75,8 @@ func GetFile(path string) (*File, error) {
 		Name:    stats.Name(),
 		Path:    absPath,
 		Size:    uint64(stats.Size()),
+		ModTime: stats.ModTime(),
+		Mode:    stats.Mode().Perm(),
 		Handler: nil,
 	}
 
@@ -86,6 +97,16 @@ func GetFile(path string) (*File, error) {
 	return &file, nil
 }
 
+// Returns a file of unknown size that is read from the stream until EOF
+// and sent under the given name
+func GetStream(name string, stream io.Reader) *File {
+	return &File{
+		Name:     name,
+		Streamed: true,
+		Stream:   stream,
+	}
+}
+
 // Opens file for read/write operations
 func (file *File) Open() error {
 	if file.Handler != nil {
@@ -101,8 +122,16 @@ func (file *File) Open() error {
 	return nil
 }
 
-// file.Handler.Close wrapper
+// file.Handler.Close wrapper. Closes the file opened in its FS as well
 func (file *File) Close() error {
+	if file.FS != nil && file.Stream != nil {
+		closer, ok := file.Stream.(io.Closer)
+		file.Stream = nil
+		if ok {
+			return closer.Close()
+		}
+	}
+
 	if file.Handler != nil {
 		err := file.Handler.Close()
 		if err != nil {
@@ -0,0 +1,51 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Identifies a file regardless of the path it was reached by.
+// There are no inodes here, so the fully resolved path is used instead
+type fileID struct {
+	path string
+}
+
+// Returns the fully resolved path of the file
+func getFileID(path string, info os.FileInfo) (fileID, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fileID{}, err
+	}
+
+	absResolved, err := filepath.Abs(resolved)
+	if err != nil {
+		return fileID{}, err
+	}
+
+	return fileID{
+		path: absResolved,
+	}, nil
+}
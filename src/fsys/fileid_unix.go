@@ -0,0 +1,53 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"os"
+	"syscall"
+)
+
+// Identifies a file regardless of the path it was reached by
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+// Returns device/inode pair of the file described by info
+func getFileID(path string, info os.FileInfo) (fileID, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		// should not happen, but stat again just in case
+		var st syscall.Stat_t
+		err := syscall.Stat(path, &st)
+		if err != nil {
+			return fileID{}, err
+		}
+		stat = &st
+	}
+
+	return fileID{
+		device: uint64(stat.Dev),
+		inode:  uint64(stat.Ino),
+	}, nil
+}
@@ -0,0 +1,202 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+
+	"unbewohnte/ftu/checksum"
+)
+
+// A filesystem that can tell where its symlinks point, the same way
+// fs.ReadLinkFS of newer versions of Go does
+type ReadLinkFS interface {
+	fs.FS
+	ReadLink(name string) (string, error)
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// Get general information about a file in the filesystem. Its contents are read from
+// the filesystem as well, see (file *File).OpenFS()
+func GetFileFS(filesystem fs.FS, filePath string) (*File, error) {
+	stats, err := fs.Stat(filesystem, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.IsDir() {
+		return nil, ErrorNotFile
+	}
+
+	file := File{
+		Name:    path.Base(filePath),
+		Path:    filePath,
+		Size:    uint64(stats.Size()),
+		ModTime: stats.ModTime(),
+		Mode:    stats.Mode().Perm(),
+		FS:      filesystem,
+	}
+
+	// get checksum
+	err = file.OpenFS()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	file.Checksum, err = checksum.GetReaderCheckSum(file.Stream, int64(file.Size))
+	if err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// Opens the file in its FS for reading from where it has stopped being sent.
+// The contents are read from Stream until the file is closed
+func (file *File) OpenFS() error {
+	file.Close()
+
+	opened, err := file.FS.Open(file.Path)
+	if err != nil {
+		return err
+	}
+
+	if file.SentBytes != 0 {
+		// skip what has already been sent
+		if seeker, ok := opened.(io.Seeker); ok {
+			_, err = seeker.Seek(int64(file.SentBytes), io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, opened, int64(file.SentBytes))
+		}
+		if err != nil {
+			opened.Close()
+			return err
+		}
+	}
+
+	file.Stream = opened
+
+	return nil
+}
+
+// Get general information about a directory in the filesystem and its entries, walking it the same way
+// GetDirWithOptions walks a directory of the OS filesystem. Paths are paths in the filesystem and the
+// contents of the files are read from it. Symlinks are sent as they are if the filesystem can read them
+// (see ReadLinkFS) and skipped otherwise; they can not be followed
+func GetDirFS(filesystem fs.FS, dirPath string, options WalkOptions) (*Directory, error) {
+	stats, err := fs.Stat(filesystem, dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !stats.IsDir() {
+		return nil, ErrorNotDirectory
+	}
+
+	var warnings []error
+	directory, err := walkFS(filesystem, dirPath, options, 0, &warnings)
+	if err != nil {
+		return nil, err
+	}
+	directory.Warnings = warnings
+
+	return directory, nil
+}
+
+func walkFS(filesystem fs.FS, dirPath string, options WalkOptions, depth uint, warnings *[]error) (*Directory, error) {
+	directory := Directory{
+		Name: path.Base(dirPath),
+		Path: dirPath,
+	}
+
+	// entries are already sorted by name
+	entries, err := fs.ReadDir(filesystem, dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		entryPath := path.Join(dirPath, entry.Name())
+
+		switch {
+		case entry.IsDir():
+			if !options.Recursive {
+				// skip the directory and only work with the files
+				continue
+			}
+
+			if options.MaxDepth != 0 && depth+1 > options.MaxDepth {
+				*warnings = append(*warnings, fmt.Errorf("%w: skipping \"%s\"", ErrorMaxDepth, entryPath))
+				continue
+			}
+
+			innerDir, err := walkFS(filesystem, entryPath, options, depth+1, warnings)
+			if err != nil {
+				return nil, err
+			}
+
+			directory.Size += innerDir.Size
+			directory.Directories = append(directory.Directories, innerDir)
+
+		case entry.Type()&fs.ModeSymlink != 0:
+			linkFS, ok := filesystem.(ReadLinkFS)
+			if !ok {
+				continue
+			}
+
+			target, err := linkFS.ReadLink(entryPath)
+			if err != nil {
+				// skip this symlink
+				continue
+			}
+
+			// only the targets inside of the filesystem can be sent
+			if path.IsAbs(target) {
+				continue
+			}
+			target = path.Join(dirPath, target)
+			if !fs.ValidPath(target) {
+				continue
+			}
+
+			directory.Symlinks = append(directory.Symlinks, &Symlink{
+				Path:       entryPath,
+				TargetPath: target,
+			})
+
+		case entry.Type().IsRegular():
+			innerFile, err := GetFileFS(filesystem, entryPath)
+			if err != nil {
+				// skip this file
+				continue
+			}
+
+			directory.Size += innerFile.Size
+			directory.Files = append(directory.Files, innerFile)
+		}
+	}
+
+	return &directory, nil
+}
@@ -0,0 +1,126 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// reads the targets of symlinks of the MapFS from their contents
+type linkMapFS struct {
+	fstest.MapFS
+}
+
+func (filesystem linkMapFS) ReadLink(name string) (string, error) {
+	return string(filesystem.MapFS[name].Data), nil
+}
+
+func (filesystem linkMapFS) Lstat(name string) (fs.FileInfo, error) {
+	return fs.Stat(filesystem.MapFS, name)
+}
+
+func Test_GetDirFS(t *testing.T) {
+	modTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	filesystem := linkMapFS{fstest.MapFS{
+		"release/bin/tool":     {Data: bytes.Repeat([]byte("tool"), 10000), Mode: 0755, ModTime: modTime},
+		"release/README":       {Data: []byte("read me")},
+		"release/docs/a/b.txt": {Data: []byte("b")},
+		"release/latest":       {Data: []byte("bin/tool"), Mode: fs.ModeSymlink},
+		"release/outside":      {Data: []byte("../../etc/passwd"), Mode: fs.ModeSymlink},
+	}}
+
+	dir, err := GetDirFS(filesystem, "release", WalkOptions{Recursive: true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if dir.Name != "release" || dir.Size != 40000+7+1 {
+		t.Fatalf("expected \"release\" of %d bytes; got \"%s\" of %d", 40000+7+1, dir.Name, dir.Size)
+	}
+
+	err = dir.SetRelativePaths(dir.Path, true)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	files := dir.GetAllFiles(true)
+	expected := map[string]bool{
+		"README":                            true,
+		filepath.Join("bin", "tool"):        true,
+		filepath.Join("docs", "a", "b.txt"): true,
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("expected %d files; got %d", len(expected), len(files))
+	}
+	for _, file := range files {
+		if !expected[file.RelativeParentPath] {
+			t.Fatalf("unexpected relative path \"%s\"", file.RelativeParentPath)
+		}
+		if file.FS == nil {
+			t.Fatalf("expected \"%s\" to be read from the filesystem", file.Path)
+		}
+	}
+
+	// the same checksum as if the file was on disk
+	tool := files[1]
+	path := filepath.Join(t.TempDir(), "tool")
+	err = os.WriteFile(path, filesystem.MapFS["release/bin/tool"].Data, os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	onDisk, err := GetFile(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if tool.Checksum != onDisk.Checksum || tool.Mode != 0755 || !tool.ModTime.Equal(modTime) {
+		t.Fatalf("expected the file to be described as if it was on disk; got %+v", tool)
+	}
+
+	// it is read from where it has stopped
+	tool.SentBytes = 39999
+	err = tool.OpenFS()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	rest, err := io.ReadAll(tool.Stream)
+	tool.Close()
+	if err != nil || string(rest) != "l" {
+		t.Fatalf("expected the rest of the file to be \"l\"; got \"%s\" (%v)", rest, err)
+	}
+
+	// only the symlink pointing inside is kept
+	symlinks := dir.GetAllSymlinks(true)
+	if len(symlinks) != 1 || symlinks[0].Path != "latest" || symlinks[0].TargetPath != filepath.Join("bin", "tool") {
+		t.Fatalf("expected \"latest\" to point to \"bin/tool\"; got %+v", symlinks)
+	}
+
+	_, err = GetDirFS(filesystem, "release/README", WalkOptions{})
+	if err != ErrorNotDirectory {
+		t.Fatalf("expected a file to not be a directory; got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Received data is written into a hidden partial file next to the final one
+// and renamed into place only after it has been fully received and verified.
+// ie: "dir/file.txt" is being written as "dir/.file.txt.ftupart"
+const PartialSuffix string = ".ftupart"
+
+// Returns a path of the partial file for the file at relPath
+func PartialName(relPath string) string {
+	parent, name := splitParent(relPath)
+
+	partialName := "." + name + PartialSuffix
+	if parent == "" {
+		return partialName
+	}
+	return filepath.Join(parent, partialName)
+}
+
+// Tells whether name is a name of a partial file
+func IsPartialName(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, PartialSuffix) && len(name) > len(PartialSuffix)+1
+}
+
+// Removes every leftover partial file in the root directory and its inner directories.
+// Symlinks are not followed. Returns paths of removed files
+func RemovePartialFiles(root string) ([]string, error) {
+	var removed []string
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.Type().IsRegular() || !IsPartialName(entry.Name()) {
+			return nil
+		}
+
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+		removed = append(removed, path)
+
+		return nil
+	})
+
+	return removed, err
+}
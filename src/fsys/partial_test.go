@@ -0,0 +1,83 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_PartialName(t *testing.T) {
+	cases := map[string]string{
+		"file.txt":     ".file.txt.ftupart",
+		"dir/file.txt": filepath.Join("dir", ".file.txt.ftupart"),
+	}
+
+	for path, expected := range cases {
+		partial := PartialName(path)
+		if partial != expected {
+			t.Fatalf("expected partial name of \"%s\" to be \"%s\"; got \"%s\"", path, expected, partial)
+		}
+
+		if !IsPartialName(filepath.Base(partial)) {
+			t.Fatalf("expected \"%s\" to be recognized as a partial file", partial)
+		}
+	}
+
+	if IsPartialName("file.txt") || IsPartialName(".ftupart") {
+		t.Fatalf("not partial files have been recognized as partial")
+	}
+}
+
+func Test_RemovePartialFiles(t *testing.T) {
+	root := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(root, "dir"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	files := []string{
+		filepath.Join(root, PartialName("file.txt")),
+		filepath.Join(root, PartialName("dir/file.txt")),
+		filepath.Join(root, "dir", "file.txt"),
+	}
+	for _, file := range files {
+		err = os.WriteFile(file, []byte("partial"), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	removed, err := RemovePartialFiles(root)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("expected to remove 2 partial files; removed %d", len(removed))
+	}
+
+	_, err = os.Stat(filepath.Join(root, "dir", "file.txt"))
+	if err != nil {
+		t.Fatalf("a complete file has been removed: %s", err)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var ErrorUnsafePath error = fmt.Errorf("unsafe path")
+
+// Splits path into its components accepting both "/" and "\" as separators,
+// so paths sent from any system are checked the same way
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+}
+
+// Checks that the name received from the other side is a single path element
+// that can be safely used as a file or directory name
+func ValidateName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("%w: invalid name \"%s\"", ErrorUnsafePath, name)
+	}
+
+	if strings.ContainsAny(name, "/\\\x00") || filepath.VolumeName(name) != "" {
+		return fmt.Errorf("%w: name \"%s\" contains forbidden characters", ErrorUnsafePath, name)
+	}
+
+	return nil
+}
+
+// Checks that the path received from the other side is relative and clean, so joining it
+// with some directory can never point outside of that directory.
+// ie: "dir/file.txt" is valid, while "/etc/passwd", "../file.txt", "dir//file.txt" and "./file.txt" are not
+func ValidateRelativePath(path string) error {
+	if path == "" {
+		return fmt.Errorf("%w: empty path", ErrorUnsafePath)
+	}
+
+	if filepath.IsAbs(path) || filepath.VolumeName(path) != "" || strings.HasPrefix(path, "/") || strings.HasPrefix(path, "\\") {
+		return fmt.Errorf("%w: \"%s\" is absolute", ErrorUnsafePath, path)
+	}
+
+	if strings.HasSuffix(path, "/") || strings.HasSuffix(path, "\\") || strings.Contains(path, "\x00") {
+		return fmt.Errorf("%w: \"%s\" is not clean", ErrorUnsafePath, path)
+	}
+
+	components := splitPath(path)
+	for _, component := range components {
+		err := ValidateName(component)
+		if err != nil {
+			return fmt.Errorf("%w: \"%s\" is not clean", ErrorUnsafePath, path)
+		}
+	}
+
+	// catches empty components (ie: "dir//file")
+	if len(strings.Join(components, "/")) != len(path) {
+		return fmt.Errorf("%w: \"%s\" is not clean", ErrorUnsafePath, path)
+	}
+
+	return nil
+}
+
+// Returns relPath with a number added to the last element before its extension.
+// ie: "dir/file.txt", 2 -> "dir/file (2).txt"
+func NumberedName(relPath string, number uint) string {
+	parent, name := splitParent(relPath)
+
+	extension := filepath.Ext(name)
+	if extension == name {
+		// hidden files like ".bashrc" have no extension
+		extension = ""
+	}
+	numbered := fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, extension), number, extension)
+
+	if parent == "" {
+		return numbered
+	}
+	return filepath.Join(parent, numbered)
+}
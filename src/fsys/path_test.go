@@ -0,0 +1,100 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ValidateRelativePath(t *testing.T) {
+	valid := []string{
+		"file.txt",
+		"dir/file.txt",
+		"dir/inner/file.txt",
+		"dir\\file.txt",
+		"..file",
+		"dir/.hidden",
+	}
+
+	for _, path := range valid {
+		err := ValidateRelativePath(path)
+		if err != nil {
+			t.Fatalf("expected \"%s\" to be valid; got %s", path, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		".",
+		"..",
+		"../file.txt",
+		"dir/../../file.txt",
+		"dir/..",
+		"..\\..\\file.txt",
+		"/etc/passwd",
+		"\\etc\\passwd",
+		"./file.txt",
+		"dir//file.txt",
+		"dir/",
+		"dir/./file.txt",
+		"file\x00.txt",
+	}
+
+	for _, path := range invalid {
+		err := ValidateRelativePath(path)
+		if !errors.Is(err, ErrorUnsafePath) {
+			t.Fatalf("expected \"%s\" to be unsafe; got %v", path, err)
+		}
+	}
+}
+
+func Test_ValidateName(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "dir/file", "dir\\file", "/"} {
+		err := ValidateName(name)
+		if !errors.Is(err, ErrorUnsafePath) {
+			t.Fatalf("expected \"%s\" to be an unsafe name; got %v", name, err)
+		}
+	}
+
+	err := ValidateName("file.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func Test_NumberedName(t *testing.T) {
+	cases := map[string]string{
+		"file.txt":          "file (2).txt",
+		"archive.tar.gz":    "archive.tar (2).gz",
+		".bashrc":           ".bashrc (2)",
+		"dir":               "dir (2)",
+		"dir/inner/file.go": filepath.Join("dir", "inner", "file (2).go"),
+	}
+
+	for path, expected := range cases {
+		numbered := NumberedName(path, 2)
+		if numbered != expected {
+			t.Fatalf("expected \"%s\" to become \"%s\"; got \"%s\"", path, expected, numbered)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Sandbox is a directory where everything received from the other side is created.
+// All paths given to its methods are relative to its root and must pass ValidateRelativePath.
+// Symlinks are never followed while resolving them, so nothing can be created
+// or written outside of the root, even if the other side has planted a symlink earlier.
+// The implementation is platform-specific (sandbox_linux.go, sandbox_other.go)
+
+package fsys
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returns a full path to the entry inside the sandbox. Used for informational purposes only
+func (sandbox *Sandbox) Path(relPath string) string {
+	if relPath == "" {
+		return sandbox.root
+	}
+	return filepath.Join(sandbox.root, filepath.FromSlash(relPath))
+}
+
+// Returns the path of the sandbox root
+func (sandbox *Sandbox) Root() string {
+	return sandbox.root
+}
+
+// Splits a validated relative path into the parent directory and the last element
+func splitParent(relPath string) (string, string) {
+	components := splitPath(relPath)
+	if len(components) == 0 {
+		return "", ""
+	}
+
+	parent := filepath.Join(components[:len(components)-1]...)
+	return parent, components[len(components)-1]
+}
+
+// Returns the target of the symlink at relPath, making sure that no symlink is on the way to it
+func (sandbox *Sandbox) ReadLink(relPath string) (string, error) {
+	stats, err := sandbox.Lstat(relPath)
+	if err != nil {
+		return "", err
+	}
+	if stats.Mode()&os.ModeSymlink == 0 {
+		return "", ErrorNotSymlink
+	}
+
+	return os.Readlink(sandbox.Path(relPath))
+}
+
+// Returns a read-only view of the sandbox with slash-separated paths ("." for the root).
+// Everything is opened the way the sandbox opens it, so files are never read through a symlink
+// even if one appears on the way after they have been looked at. Stat does not follow symlinks
+func (sandbox *Sandbox) FS() ReadLinkFS {
+	return sandboxFS{sandbox: sandbox}
+}
+
+type sandboxFS struct {
+	sandbox *Sandbox
+}
+
+// Turns a path of the filesystem into a path relative to the sandbox. The root is ""
+func (filesystem sandboxFS) relPath(op string, name string) (string, error) {
+	// the sandbox takes backslashes for separators too
+	if !fs.ValidPath(name) || strings.Contains(name, "\\") {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return "", nil
+	}
+
+	return filepath.FromSlash(name), nil
+}
+
+func (filesystem sandboxFS) Open(name string) (fs.File, error) {
+	relPath, err := filesystem.relPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if relPath == "" {
+		return os.Open(filesystem.sandbox.root)
+	}
+
+	return filesystem.sandbox.OpenFile(relPath, os.O_RDONLY, 0)
+}
+
+func (filesystem sandboxFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	relPath, err := filesystem.relPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return filesystem.sandbox.ReadDir(relPath)
+}
+
+func (filesystem sandboxFS) Stat(name string) (fs.FileInfo, error) {
+	return filesystem.Lstat(name)
+}
+
+func (filesystem sandboxFS) Lstat(name string) (fs.FileInfo, error) {
+	relPath, err := filesystem.relPath("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	if relPath == "" {
+		return os.Stat(filesystem.sandbox.root)
+	}
+
+	return filesystem.sandbox.Lstat(relPath)
+}
+
+func (filesystem sandboxFS) ReadLink(name string) (string, error) {
+	relPath, err := filesystem.relPath("readlink", name)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := filesystem.sandbox.ReadLink(relPath)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.ToSlash(target), nil
+}
@@ -0,0 +1,403 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Tells whether name in the directory dirFd is a symlink itself
+func isSymlinkAt(dirFd int, name string) bool {
+	fd, err := syscall.Openat(dirFd, name, syscall.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err == nil {
+		syscall.Close(fd)
+	}
+
+	return err == syscall.ELOOP
+}
+
+// A directory where everything received from the other side is created.
+// Every path is resolved component by component relative to an opened
+// handle of the root directory (openat), refusing to go through symlinks
+type Sandbox struct {
+	root string
+	fd   int // opened root directory
+}
+
+// Opens a directory at root as a sandbox
+func OpenSandbox(root string) (*Sandbox, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Open(absRoot, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: absRoot, Err: err}
+	}
+
+	return &Sandbox{
+		root: absRoot,
+		fd:   fd,
+	}, nil
+}
+
+// Releases the handle of the root directory
+func (sandbox *Sandbox) Close() error {
+	if sandbox.fd < 0 {
+		return nil
+	}
+
+	err := syscall.Close(sandbox.fd)
+	sandbox.fd = -1
+
+	return err
+}
+
+// Returns a descriptor of a directory at relPath, going through every component with O_NOFOLLOW.
+// If create is true - missing directories are created along the way.
+// The returned descriptor must be closed by the caller
+func (sandbox *Sandbox) openDir(relPath string, create bool) (int, error) {
+	// not a dup, so the descriptor does not share the reading offset with the root
+	fd, err := syscall.Openat(sandbox.fd, ".", syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "openat", Path: sandbox.root, Err: err}
+	}
+
+	walked := ""
+	for _, component := range splitPath(relPath) {
+		walked = filepath.Join(walked, component)
+
+		next, err := syscall.Openat(fd, component, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		if err == syscall.ENOENT && create {
+			err = syscall.Mkdirat(fd, component, uint32(os.ModePerm))
+			if err == nil || err == syscall.EEXIST {
+				next, err = syscall.Openat(fd, component, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+			}
+		}
+		if err == syscall.ENOTDIR && isSymlinkAt(fd, component) {
+			// O_DIRECTORY takes precedence over O_NOFOLLOW
+			err = syscall.ELOOP
+		}
+		syscall.Close(fd)
+
+		if err == syscall.ELOOP {
+			return -1, fmt.Errorf("%w: \"%s\" is a symlink", ErrorUnsafePath, sandbox.Path(walked))
+		}
+		if err != nil {
+			return -1, &os.PathError{Op: "openat", Path: sandbox.Path(walked), Err: err}
+		}
+
+		fd = next
+	}
+
+	return fd, nil
+}
+
+// Creates a directory at relPath along with any missing parents
+func (sandbox *Sandbox) MkdirAll(relPath string) error {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return err
+	}
+
+	fd, err := sandbox.openDir(relPath, true)
+	if err != nil {
+		return err
+	}
+
+	return syscall.Close(fd)
+}
+
+// Returns a new sandbox rooted at an existing directory relPath of this one
+func (sandbox *Sandbox) Sub(relPath string) (*Sandbox, error) {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := sandbox.openDir(relPath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sandbox{
+		root: sandbox.Path(relPath),
+		fd:   fd,
+	}, nil
+}
+
+// Opens a file at relPath. If flag contains os.O_CREATE - missing parent directories are created as well.
+// Fails with ErrorUnsafePath if the file itself or any of its parents is a symlink
+func (sandbox *Sandbox) OpenFile(relPath string, flag int, perm os.FileMode) (*os.File, error) {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, name := splitParent(relPath)
+	parentFd, err := sandbox.openDir(parent, flag&os.O_CREATE != 0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(parentFd)
+
+	fd, err := syscall.Openat(parentFd, name, flag|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, uint32(perm.Perm()))
+	if err == syscall.ELOOP {
+		return nil, fmt.Errorf("%w: \"%s\" is a symlink", ErrorUnsafePath, sandbox.Path(relPath))
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "openat", Path: sandbox.Path(relPath), Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), sandbox.Path(relPath)), nil
+}
+
+// Tells whether anything (including a symlink) exists at relPath
+func (sandbox *Sandbox) Exists(relPath string) (bool, error) {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return false, err
+	}
+
+	parent, name := splitParent(relPath)
+	parentFd, err := sandbox.openDir(parent, false)
+	if errors.Is(err, syscall.ENOENT) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer syscall.Close(parentFd)
+
+	fd, err := syscall.Openat(parentFd, name, syscall.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	switch err {
+	case nil:
+		syscall.Close(fd)
+		return true, nil
+	case syscall.ELOOP:
+		return true, nil
+	case syscall.ENOENT:
+		return false, nil
+	default:
+		return false, &os.PathError{Op: "openat", Path: sandbox.Path(relPath), Err: err}
+	}
+}
+
+// Removes a file (or a symlink itself, not its target) at relPath
+func (sandbox *Sandbox) Remove(relPath string) error {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return err
+	}
+
+	parent, name := splitParent(relPath)
+	parentFd, err := sandbox.openDir(parent, false)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(parentFd)
+
+	err = syscall.Unlinkat(parentFd, name)
+	if err != nil {
+		return &os.PathError{Op: "unlinkat", Path: sandbox.Path(relPath), Err: err}
+	}
+
+	return nil
+}
+
+// Returns entries of the directory at relPath sorted by name. An empty relPath means the root itself
+func (sandbox *Sandbox) ReadDir(relPath string) ([]os.DirEntry, error) {
+	if relPath != "" {
+		err := ValidateRelativePath(relPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fd, err := sandbox.openDir(relPath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := os.NewFile(uintptr(fd), sandbox.Path(relPath))
+	defer dir.Close()
+
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+// Removes relPath and everything it contains. Symlinks inside are removed, not followed
+func (sandbox *Sandbox) RemoveAll(relPath string) error {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return err
+	}
+
+	parent, _ := splitParent(relPath)
+	parentFd, err := sandbox.openDir(parent, false)
+	if err != nil {
+		return err
+	}
+	syscall.Close(parentFd)
+
+	return os.RemoveAll(sandbox.Path(relPath))
+}
+
+// Atomically renames oldRelPath to newRelPath replacing whatever is there.
+// Missing parent directories of newRelPath are created
+func (sandbox *Sandbox) Rename(oldRelPath string, newRelPath string) error {
+	err := ValidateRelativePath(oldRelPath)
+	if err != nil {
+		return err
+	}
+	err = ValidateRelativePath(newRelPath)
+	if err != nil {
+		return err
+	}
+
+	oldParent, oldName := splitParent(oldRelPath)
+	oldParentFd, err := sandbox.openDir(oldParent, false)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(oldParentFd)
+
+	newParent, newName := splitParent(newRelPath)
+	newParentFd, err := sandbox.openDir(newParent, true)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(newParentFd)
+
+	err = syscall.Renameat(oldParentFd, oldName, newParentFd, newName)
+	if err != nil {
+		return &os.LinkError{Op: "renameat", Old: sandbox.Path(oldRelPath), New: sandbox.Path(newRelPath), Err: err}
+	}
+
+	return nil
+}
+
+// Creates a symlink at relPath pointing to target. Missing parent directories are created
+func (sandbox *Sandbox) Symlink(target string, relPath string) error {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return err
+	}
+
+	parent, name := splitParent(relPath)
+	parentFd, err := sandbox.openDir(parent, true)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(parentFd)
+
+	targetPtr, err := syscall.BytePtrFromString(target)
+	if err != nil {
+		return err
+	}
+	namePtr, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_SYMLINKAT, uintptr(unsafe.Pointer(targetPtr)), uintptr(parentFd), uintptr(unsafe.Pointer(namePtr)))
+	if errno != 0 {
+		return &os.LinkError{Op: "symlinkat", Old: target, New: sandbox.Path(relPath), Err: errno}
+	}
+
+	return nil
+}
+
+// Returns information about the entry at relPath itself, not following it if it is a symlink
+func (sandbox *Sandbox) Lstat(relPath string) (os.FileInfo, error) {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, _ := splitParent(relPath)
+	parentFd, err := sandbox.openDir(parent, false)
+	if err != nil {
+		return nil, err
+	}
+	syscall.Close(parentFd)
+
+	return os.Lstat(sandbox.Path(relPath))
+}
+
+// Sets the permission bits and the modification time of the file or directory at relPath.
+// A zero mode or modTime is left as it is. Fails with ErrorUnsafePath if relPath is a symlink
+func (sandbox *Sandbox) SetMetadata(relPath string, mode os.FileMode, modTime time.Time) error {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return err
+	}
+
+	parent, name := splitParent(relPath)
+	parentFd, err := sandbox.openDir(parent, false)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(parentFd)
+
+	fd, err := syscall.Openat(parentFd, name, syscall.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err == syscall.ELOOP {
+		return fmt.Errorf("%w: \"%s\" is a symlink", ErrorUnsafePath, sandbox.Path(relPath))
+	}
+	if err != nil {
+		return &os.PathError{Op: "openat", Path: sandbox.Path(relPath), Err: err}
+	}
+	defer syscall.Close(fd)
+
+	if !modTime.IsZero() {
+		// utimensat with no path sets the times of the descriptor itself, keeping the nanoseconds
+		modTimespec := syscall.NsecToTimespec(modTime.UnixNano())
+		times := [2]syscall.Timespec{modTimespec, modTimespec}
+		_, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, uintptr(fd), 0, uintptr(unsafe.Pointer(&times[0])), 0, 0, 0)
+		if errno != 0 {
+			return &os.PathError{Op: "utimensat", Path: sandbox.Path(relPath), Err: errno}
+		}
+	}
+
+	if mode.Perm() != 0 {
+		err = syscall.Fchmod(fd, uint32(mode.Perm()))
+		if err != nil {
+			return &os.PathError{Op: "fchmod", Path: sandbox.Path(relPath), Err: err}
+		}
+	}
+
+	return nil
+}
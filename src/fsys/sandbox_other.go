@@ -0,0 +1,318 @@
+//go:build !linux
+
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A directory where everything received from the other side is created.
+// There is no openat here, so every component of a path is checked
+// with Lstat before use, refusing to go through symlinks
+type Sandbox struct {
+	root string
+}
+
+// Opens a directory at root as a sandbox
+func OpenSandbox(root string) (*Sandbox, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := os.Stat(absRoot)
+	if err != nil {
+		return nil, err
+	}
+	if !stats.IsDir() {
+		return nil, ErrorNotDirectory
+	}
+
+	return &Sandbox{
+		root: absRoot,
+	}, nil
+}
+
+// Does nothing, present for compatibility with openat-based sandbox
+func (sandbox *Sandbox) Close() error {
+	return nil
+}
+
+// Checks that every component of relPath is a real directory, not a symlink.
+// If create is true - missing directories are created along the way
+func (sandbox *Sandbox) checkDir(relPath string, create bool) error {
+	current := sandbox.root
+	for _, component := range splitPath(relPath) {
+		current = filepath.Join(current, component)
+
+		stats, err := os.Lstat(current)
+		if os.IsNotExist(err) && create {
+			err = os.Mkdir(current, os.ModePerm)
+			if err != nil && !os.IsExist(err) {
+				return err
+			}
+			stats, err = os.Lstat(current)
+		}
+		if err != nil {
+			return err
+		}
+
+		if stats.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: \"%s\" is a symlink", ErrorUnsafePath, current)
+		}
+		if !stats.IsDir() {
+			return &os.PathError{Op: "open", Path: current, Err: ErrorNotDirectory}
+		}
+	}
+
+	return nil
+}
+
+// Checks that the last element of relPath is not a symlink
+func (sandbox *Sandbox) checkNotSymlink(relPath string) error {
+	stats, err := os.Lstat(sandbox.Path(relPath))
+	if err != nil {
+		// does not exist yet or can not be reached, opening will tell
+		return nil
+	}
+
+	if stats.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%w: \"%s\" is a symlink", ErrorUnsafePath, sandbox.Path(relPath))
+	}
+
+	return nil
+}
+
+// Creates a directory at relPath along with any missing parents
+func (sandbox *Sandbox) MkdirAll(relPath string) error {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return err
+	}
+
+	return sandbox.checkDir(relPath, true)
+}
+
+// Returns a new sandbox rooted at an existing directory relPath of this one
+func (sandbox *Sandbox) Sub(relPath string) (*Sandbox, error) {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sandbox.checkDir(relPath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sandbox{
+		root: sandbox.Path(relPath),
+	}, nil
+}
+
+// Opens a file at relPath. If flag contains os.O_CREATE - missing parent directories are created as well.
+// Fails with ErrorUnsafePath if the file itself or any of its parents is a symlink
+func (sandbox *Sandbox) OpenFile(relPath string, flag int, perm os.FileMode) (*os.File, error) {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, _ := splitParent(relPath)
+	err = sandbox.checkDir(parent, flag&os.O_CREATE != 0)
+	if err != nil {
+		return nil, err
+	}
+
+	err = sandbox.checkNotSymlink(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(sandbox.Path(relPath), flag, perm)
+}
+
+// Tells whether anything (including a symlink) exists at relPath
+func (sandbox *Sandbox) Exists(relPath string) (bool, error) {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Lstat(sandbox.Path(relPath))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Removes a file (or a symlink itself, not its target) at relPath
+func (sandbox *Sandbox) Remove(relPath string) error {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return err
+	}
+
+	parent, _ := splitParent(relPath)
+	err = sandbox.checkDir(parent, false)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(sandbox.Path(relPath))
+}
+
+// Returns entries of the directory at relPath sorted by name. An empty relPath means the root itself
+func (sandbox *Sandbox) ReadDir(relPath string) ([]os.DirEntry, error) {
+	if relPath != "" {
+		err := ValidateRelativePath(relPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := sandbox.checkDir(relPath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadDir(sandbox.Path(relPath))
+}
+
+// Removes relPath and everything it contains. Symlinks inside are removed, not followed
+func (sandbox *Sandbox) RemoveAll(relPath string) error {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return err
+	}
+
+	parent, _ := splitParent(relPath)
+	err = sandbox.checkDir(parent, false)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(sandbox.Path(relPath))
+}
+
+// Atomically renames oldRelPath to newRelPath replacing whatever is there.
+// Missing parent directories of newRelPath are created
+func (sandbox *Sandbox) Rename(oldRelPath string, newRelPath string) error {
+	err := ValidateRelativePath(oldRelPath)
+	if err != nil {
+		return err
+	}
+	err = ValidateRelativePath(newRelPath)
+	if err != nil {
+		return err
+	}
+
+	oldParent, _ := splitParent(oldRelPath)
+	err = sandbox.checkDir(oldParent, false)
+	if err != nil {
+		return err
+	}
+
+	newParent, _ := splitParent(newRelPath)
+	err = sandbox.checkDir(newParent, true)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(sandbox.Path(oldRelPath), sandbox.Path(newRelPath))
+}
+
+// Creates a symlink at relPath pointing to target. Missing parent directories are created
+func (sandbox *Sandbox) Symlink(target string, relPath string) error {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return err
+	}
+
+	parent, _ := splitParent(relPath)
+	err = sandbox.checkDir(parent, true)
+	if err != nil {
+		return err
+	}
+
+	return os.Symlink(target, sandbox.Path(relPath))
+}
+
+// Returns information about the entry at relPath itself, not following it if it is a symlink
+func (sandbox *Sandbox) Lstat(relPath string) (os.FileInfo, error) {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, _ := splitParent(relPath)
+	err = sandbox.checkDir(parent, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Lstat(sandbox.Path(relPath))
+}
+
+// Sets the permission bits and the modification time of the file or directory at relPath.
+// A zero mode or modTime is left as it is. Fails with ErrorUnsafePath if relPath is a symlink
+func (sandbox *Sandbox) SetMetadata(relPath string, mode os.FileMode, modTime time.Time) error {
+	err := ValidateRelativePath(relPath)
+	if err != nil {
+		return err
+	}
+
+	parent, _ := splitParent(relPath)
+	err = sandbox.checkDir(parent, false)
+	if err != nil {
+		return err
+	}
+
+	stats, err := os.Lstat(sandbox.Path(relPath))
+	if err != nil {
+		return err
+	}
+	if stats.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%w: \"%s\" is a symlink", ErrorUnsafePath, sandbox.Path(relPath))
+	}
+
+	if !modTime.IsZero() {
+		err = os.Chtimes(sandbox.Path(relPath), modTime, modTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	if mode.Perm() != 0 {
+		return os.Chmod(sandbox.Path(relPath), mode.Perm())
+	}
+
+	return nil
+}
@@ -0,0 +1,305 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func Test_SandboxOpenFile(t *testing.T) {
+	root := t.TempDir()
+
+	sandbox, err := OpenSandbox(root)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer sandbox.Close()
+
+	file, err := sandbox.OpenFile("dir/inner/file.txt", os.O_CREATE|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	file.Write([]byte("contents"))
+	file.Close()
+
+	contents, err := os.ReadFile(filepath.Join(root, "dir", "inner", "file.txt"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if string(contents) != "contents" {
+		t.Fatalf("expected file to contain \"contents\"; got \"%s\"", contents)
+	}
+
+	err = sandbox.Remove("dir/inner/file.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func Test_SandboxRefusesEscapes(t *testing.T) {
+	root := t.TempDir()
+
+	sandbox, err := OpenSandbox(root)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer sandbox.Close()
+
+	for _, path := range []string{"../escaped.txt", "/escaped.txt", "dir/../../escaped.txt"} {
+		_, err = sandbox.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+		if !errors.Is(err, ErrorUnsafePath) {
+			t.Fatalf("expected \"%s\" to be refused; got %v", path, err)
+		}
+	}
+}
+
+func Test_SandboxRefusesPlantedSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	sandbox, err := OpenSandbox(root)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer sandbox.Close()
+
+	// a planted symlink to a directory outside
+	err = sandbox.Symlink(outside, "planted")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	_, err = sandbox.OpenFile("planted/file.txt", os.O_CREATE|os.O_RDWR, os.ModePerm)
+	if !errors.Is(err, ErrorUnsafePath) {
+		t.Fatalf("expected writing through a symlinked directory to be refused; got %v", err)
+	}
+
+	err = sandbox.MkdirAll("planted/inner")
+	if !errors.Is(err, ErrorUnsafePath) {
+		t.Fatalf("expected creating a directory through a symlink to be refused; got %v", err)
+	}
+
+	_, err = sandbox.Sub("planted")
+	if !errors.Is(err, ErrorUnsafePath) {
+		t.Fatalf("expected a symlink to not become a sandbox; got %v", err)
+	}
+
+	// a planted symlink to a file outside
+	outsideFile := filepath.Join(outside, "victim.txt")
+	err = os.WriteFile(outsideFile, []byte("untouched"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = sandbox.Symlink(outsideFile, "victim.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	_, err = sandbox.OpenFile("victim.txt", os.O_CREATE|os.O_RDWR, os.ModePerm)
+	if !errors.Is(err, ErrorUnsafePath) {
+		t.Fatalf("expected writing through a symlinked file to be refused; got %v", err)
+	}
+
+	contents, err := os.ReadFile(outsideFile)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if string(contents) != "untouched" {
+		t.Fatalf("file outside of the sandbox has been modified")
+	}
+
+	entries, err := os.ReadDir(outside)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected nothing to be created outside of the sandbox; got %d entries", len(entries))
+	}
+}
+
+func Test_SandboxFS(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	sandbox, err := OpenSandbox(root)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer sandbox.Close()
+
+	for _, path := range []string{"a.txt", "dir/b.txt", "dir/inner/c.txt"} {
+		file, err := sandbox.OpenFile(path, os.O_CREATE|os.O_RDWR, os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		file.Write([]byte(path))
+		file.Close()
+	}
+
+	filesystem := sandbox.FS()
+	err = fstest.TestFS(filesystem, "a.txt", "dir/b.txt", "dir/inner/c.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// the symlinks are there, but nothing is read through them
+	err = os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = sandbox.Symlink(outside, "planted")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = sandbox.Symlink("dir/b.txt", "link.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for _, path := range []string{"planted/secret.txt", "link.txt"} {
+		_, err = fs.ReadFile(filesystem, path)
+		if !errors.Is(err, ErrorUnsafePath) {
+			t.Fatalf("expected reading \"%s\" to be refused; got %v", path, err)
+		}
+	}
+
+	_, err = fs.ReadDir(filesystem, "planted")
+	if !errors.Is(err, ErrorUnsafePath) {
+		t.Fatalf("expected listing a symlinked directory to be refused; got %v", err)
+	}
+
+	stats, err := fs.Stat(filesystem, "planted")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if stats.Mode()&fs.ModeSymlink == 0 {
+		t.Fatalf("expected the symlink itself to be described; got %s", stats.Mode())
+	}
+
+	target, err := filesystem.ReadLink("link.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if target != "dir/b.txt" {
+		t.Fatalf("expected the symlink to point to \"dir/b.txt\"; got \"%s\"", target)
+	}
+}
+
+func Test_SandboxRename(t *testing.T) {
+	root := t.TempDir()
+
+	sandbox, err := OpenSandbox(root)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer sandbox.Close()
+
+	file, err := sandbox.OpenFile(PartialName("dir/file.txt"), os.O_CREATE|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	file.Write([]byte("new"))
+	file.Close()
+
+	err = os.WriteFile(filepath.Join(root, "dir", "file.txt"), []byte("old"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = sandbox.Rename(PartialName("dir/file.txt"), "dir/file.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(root, "dir", "file.txt"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if string(contents) != "new" {
+		t.Fatalf("expected the file to be replaced; got \"%s\"", contents)
+	}
+
+	exists, err := sandbox.Exists(PartialName("dir/file.txt"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if exists {
+		t.Fatalf("expected partial file to be gone after rename")
+	}
+}
+
+func Test_SandboxSetMetadata(t *testing.T) {
+	root := t.TempDir()
+
+	sandbox, err := OpenSandbox(root)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer sandbox.Close()
+
+	file, err := sandbox.OpenFile("dir/file.txt", os.O_CREATE|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	file.Close()
+
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	err = sandbox.SetMetadata("dir/file.txt", 0600, modTime)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	stats, err := sandbox.Lstat("dir/file.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !stats.ModTime().Equal(modTime) {
+		t.Fatalf("expected modification time %s; got %s", modTime, stats.ModTime())
+	}
+	if runtime.GOOS != "windows" && stats.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode 0600; got %s", stats.Mode().Perm())
+	}
+
+	err = os.Symlink(filepath.Join(root, "dir", "file.txt"), filepath.Join(root, "link"))
+	if err != nil {
+		t.Skipf("can not create symlinks: %s", err)
+	}
+
+	stats, err = sandbox.Lstat("link")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if stats.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("expected Lstat to not follow the symlink")
+	}
+
+	err = sandbox.SetMetadata("link", 0644, time.Time{})
+	if !errors.Is(err, ErrorUnsafePath) {
+		t.Fatalf("expected ErrorUnsafePath for a symlink; got %v", err)
+	}
+}
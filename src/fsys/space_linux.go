@@ -0,0 +1,48 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"os"
+	"syscall"
+)
+
+// FALLOC_FL_KEEP_SIZE. Leaves the size of the file as is,
+// so the partial file still tells how much has been received
+const fallocKeepSize uint32 = 0x01
+
+// Reserves size bytes on disk for the file without changing its size
+func Preallocate(file *os.File, size uint64) error {
+	if size == 0 {
+		return nil
+	}
+
+	err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, 0, int64(size))
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		// the filesystem can not do that, space will be taken while writing
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "fallocate", Path: file.Name(), Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package fsys
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_FreeSpace(t *testing.T) {
+	free, err := FreeSpace(t.TempDir())
+	if err == ErrorNotSupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if free == 0 {
+		t.Fatalf("expected to have some free space in the temporary directory")
+	}
+}
+
+func Test_Preallocate(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "preallocated"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer file.Close()
+
+	err = Preallocate(file, 1024*1024)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	stats, err := file.Stat()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if stats.Size() != 0 {
+		t.Fatalf("expected preallocation to keep the size of the file; got %d bytes", stats.Size())
+	}
+}
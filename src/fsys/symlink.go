@@ -23,6 +23,7 @@ package fsys
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
 type Symlink struct {
@@ -44,7 +45,8 @@ func IsSymlink(path string) (bool, error) {
 var ErrorNotSymlink error = fmt.Errorf("not a symlink")
 
 // get necessary information about a symlink in a filesystem. If check is false -
-// does not check if path REALLY refers to a symlink
+// does not check if path REALLY refers to a symlink. A relative target is
+// turned into a path relative to the same place as the path of the symlink
 func GetSymlink(path string, check bool) (*Symlink, error) {
 	if check {
 		isSymlink, err := IsSymlink(path)
@@ -61,6 +63,11 @@ func GetSymlink(path string, check bool) (*Symlink, error) {
 		return nil, err
 	}
 
+	if !filepath.IsAbs(target) {
+		// relative targets start from the directory of the symlink
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+
 	symlink := Symlink{
 		TargetPath: target,
 		Path:       path,
@@ -40,3 +40,25 @@ func Test_IsSymlink(t *testing.T) {
 		t.Fatalf("%s expected to be a symlink\n", symlinkPath)
 	}
 }
+
+func Test_GetSymlinkRelativeTarget(t *testing.T) {
+	root := t.TempDir()
+	err := os.MkdirAll(filepath.Join(root, "dir"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	symlinkPath := filepath.Join(root, "dir", "link")
+	err = os.Symlink(filepath.Join("..", "file.txt"), symlinkPath)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	symlink, err := GetSymlink(symlinkPath, true)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if symlink.TargetPath != filepath.Join(root, "file.txt") {
+		t.Fatalf("expected the target to be \"%s\"; got \"%s\"", filepath.Join(root, "file.txt"), symlink.TargetPath)
+	}
+}
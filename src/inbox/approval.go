@@ -0,0 +1,155 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package inbox
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/progress"
+)
+
+// An offer waiting for the user to approve it
+type approvalRequest struct {
+	question string
+	answer   chan bool
+}
+
+// Offers of untrusted peers waiting for the user, asked about one at a time
+type ApprovalQueue struct {
+	input    io.Reader
+	output   io.Writer
+	timeout  time.Duration
+	requests chan *approvalRequest
+	done     chan struct{} // closed when the queue has stopped
+}
+
+// Creates a queue asking questions in output and reading answers from input. Offers that
+// are not answered in timeout are rejected. 0 timeout means waiting forever
+func NewApprovalQueue(input io.Reader, output io.Writer, timeout time.Duration) *ApprovalQueue {
+	return &ApprovalQueue{
+		input:    input,
+		output:   output,
+		timeout:  timeout,
+		requests: make(chan *approvalRequest),
+		done:     make(chan struct{}),
+	}
+}
+
+// Asks about queued offers until the context is done. Offers are rejected after that
+// or when there is nothing more to read from the input
+func (queue *ApprovalQueue) Run(ctx context.Context) {
+	defer close(queue.done)
+
+	// reading can not be interrupted, so it is done separately
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(queue.input)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-queue.done:
+				return
+			}
+		}
+		close(lines)
+	}()
+
+	for {
+		var request *approvalRequest
+		select {
+		case <-ctx.Done():
+			return
+		case request = <-queue.requests:
+		}
+
+		if lines == nil {
+			// nobody to ask
+			request.answer <- false
+			continue
+		}
+
+		// on its own line, logs of other transfers can be written meanwhile
+		fmt.Fprintf(queue.output, "%s Accept ? [y/N]\n", request.question)
+
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if queue.timeout != 0 {
+			timer = time.NewTimer(queue.timeout)
+			timeout = timer.C
+		}
+
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				lines = nil
+				fmt.Fprintf(queue.output, "Nothing to read the answer from, rejecting\n")
+			}
+			request.answer <- ok && (strings.EqualFold(line, "y") || strings.EqualFold(line, "yes"))
+
+		case <-timeout:
+			fmt.Fprintf(queue.output, "No answer in %s, rejecting\n", queue.timeout)
+			request.answer <- false
+
+		case <-ctx.Done():
+			request.answer <- false
+			return
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Puts the offer described by the question into the queue and waits for the answer
+func (queue *ApprovalQueue) ask(question string) bool {
+	request := &approvalRequest{
+		question: question,
+		answer:   make(chan bool, 1),
+	}
+
+	select {
+	case queue.requests <- request:
+		return <-request.answer
+	case <-queue.done:
+		return false
+	}
+}
+
+// Returns the decider asking the user about offers of the peer
+func (queue *ApprovalQueue) decider(prefix string) node.OfferDecider {
+	return node.OfferDeciderFunc(func(file *fsys.File, dir *fsys.Directory) bool {
+		if file != nil {
+			return queue.ask(fmt.Sprintf("%s offers file \"%s\" (%s).", prefix, file.Name, progress.FormatSize(file.Size)))
+		}
+		if dir.Bundle {
+			return queue.ask(fmt.Sprintf("%s offers \"%s\" (%s).", prefix, strings.Join(dir.EntryNames(), "\", \""), progress.FormatSize(dir.Size)))
+		}
+		return queue.ask(fmt.Sprintf("%s offers directory \"%s\" (%s).", prefix, dir.Name, progress.FormatSize(dir.Size)))
+	})
+}
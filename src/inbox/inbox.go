@@ -0,0 +1,306 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// A long-running receiver that accepts many pushed transfers at once, each into its own directory
+package inbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/progress"
+	"unbewohnte/ftu/storage"
+	"unbewohnte/ftu/transfer"
+)
+
+// What to do with offers of peers that are not trusted
+type UntrustedPolicy string
+
+const (
+	UntrustedAsk    UntrustedPolicy = "ask"    // queue them for approval
+	UntrustedReject UntrustedPolicy = "reject" // reject them right away
+	UntrustedAccept UntrustedPolicy = "accept" // accept them like the trusted ones
+)
+
+var ErrorInvalidUntrustedPolicy error = errors.New("invalid policy for untrusted peers")
+
+// Parses the policy for untrusted peers. Empty string is UntrustedAsk
+func ParseUntrustedPolicy(policy string) (UntrustedPolicy, error) {
+	switch UntrustedPolicy(policy) {
+	case "":
+		return UntrustedAsk, nil
+	case UntrustedAsk, UntrustedReject, UntrustedAccept:
+		return UntrustedPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("%w \"%s\" (expected ask, reject or accept)", ErrorInvalidUntrustedPolicy, policy)
+	}
+}
+
+// Parses a comma-separated list of IP addresses and CIDR networks, ie: 192.168.1.0/24,10.0.0.5
+func ParseTrusted(list string) ([]*net.IPNet, error) {
+	var trusted []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, err
+			}
+			trusted = append(trusted, network)
+			continue
+		}
+
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address \"%s\"", entry)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+		trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	return trusted, nil
+}
+
+type Options struct {
+	Directory string                  // every transfer gets its own subdirectory in it. A slash-separated directory in Receive.Storage if it is set
+	Trusted   []*net.IPNet            // offers of peers from these networks are accepted without asking
+	Untrusted UntrustedPolicy         // what to do with offers of other peers. UntrustedAsk by default
+	Approval  *ApprovalQueue          // asks about offers of untrusted peers. They are rejected if nil
+	Log       io.Writer               // where what happens with transfers is written. Discarded if nil
+	Receive   transfer.ReceiveOptions // how to receive. Output and OfferDecider are set by the inbox
+}
+
+// Receives pushed transfers until stopped
+type Inbox struct {
+	options Options
+	log     *progress.Log
+	lastID  uint64
+
+	mutex    sync.Mutex
+	reserved map[string]bool // directories in the storage picked for the transfers in progress
+}
+
+func New(options Options) *Inbox {
+	if options.Untrusted == "" {
+		options.Untrusted = UntrustedAsk
+	}
+
+	return &Inbox{
+		options:  options,
+		log:      progress.NewLog(options.Log),
+		reserved: make(map[string]bool),
+	}
+}
+
+// Tells whether the peer on the other side of the connection is trusted
+func (inbox *Inbox) trusted(conn net.Conn) bool {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range inbox.options.Trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the name of the peer suitable for a directory name
+func safeName(peer string) string {
+	return strings.Map(func(char rune) rune {
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char >= '0' && char <= '9', char == '.', char == '-':
+			return char
+		default:
+			return '-'
+		}
+	}, peer)
+}
+
+// Creates a new directory for the transfer from the peer
+func (inbox *Inbox) transferDirectory(peer string) (string, error) {
+	err := os.MkdirAll(inbox.options.Directory, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	name := time.Now().Format("2006-01-02_15-04-05") + "_" + safeName(peer)
+	path := filepath.Join(inbox.options.Directory, name)
+	for number := 2; ; number++ {
+		err = os.Mkdir(path, os.ModePerm)
+		if !os.IsExist(err) {
+			return path, err
+		}
+		path = filepath.Join(inbox.options.Directory, fmt.Sprintf("%s_%d", name, number))
+	}
+}
+
+// Picks a new directory in the storage for the transfer from the peer. Unlike the one on disk, it is not
+// created right away, but only when something is received into it, so it is reserved until the transfer ends
+func (inbox *Inbox) storageTransferDirectory(peer string) (string, error) {
+	inbox.mutex.Lock()
+	defer inbox.mutex.Unlock()
+
+	root := strings.Trim(path.Clean("/"+filepath.ToSlash(inbox.options.Directory)), "/")
+	name := time.Now().Format("2006-01-02_15-04-05") + "_" + safeName(peer)
+	directory := path.Join(root, name)
+	for number := 2; ; number++ {
+		exists, err := storage.Exists(inbox.options.Receive.Storage, directory)
+		if err != nil {
+			return "", err
+		}
+		if !exists && !inbox.reserved[directory] {
+			inbox.reserved[directory] = true
+			return directory, nil
+		}
+		directory = path.Join(root, fmt.Sprintf("%s_%d", name, number))
+	}
+}
+
+// Lets the directory in the storage be picked for other transfers
+func (inbox *Inbox) releaseStorageTransferDirectory(directory string) {
+	inbox.mutex.Lock()
+	delete(inbox.reserved, directory)
+	inbox.mutex.Unlock()
+}
+
+// Receives one transfer over the connection
+func (inbox *Inbox) handle(ctx context.Context, id uint64, conn net.Conn) {
+	peer := conn.RemoteAddr().String()
+	prefix := fmt.Sprintf("[#%d %s]", id, peer)
+
+	host, _, err := net.SplitHostPort(peer)
+	if err != nil || host == "" {
+		// ie: a Unix domain socket
+		host = "local"
+	}
+
+	var directory string
+	if inbox.options.Receive.Storage != nil {
+		directory, err = inbox.storageTransferDirectory(host)
+	} else {
+		directory, err = inbox.transferDirectory(host)
+	}
+	if err != nil {
+		inbox.log.Printf("%s Could not create a directory for the transfer: %s", prefix, err)
+		conn.Close()
+		return
+	}
+	if inbox.options.Receive.Storage != nil {
+		defer inbox.releaseStorageTransferDirectory(directory)
+	} else {
+		// nothing to keep if the transfer has been rejected or failed right away
+		defer os.Remove(directory)
+	}
+
+	options := inbox.options.Receive
+	options.Output = nil
+	options.Observer = inbox.log.Observer(prefix)
+
+	switch {
+	case inbox.trusted(conn) || inbox.options.Untrusted == UntrustedAccept:
+		options.OfferDecider = node.AcceptAll
+	case inbox.options.Untrusted == UntrustedAsk && inbox.options.Approval != nil:
+		options.OfferDecider = inbox.options.Approval.decider(prefix)
+	default:
+		options.OfferDecider = node.OfferDeciderFunc(rejectAll)
+	}
+
+	inbox.log.Printf("%s Connected, receiving into \"%s\"", prefix, directory)
+
+	result, err := transfer.ReceiveConn(ctx, conn, directory, options)
+	if result == nil {
+		inbox.log.Printf("%s Could not receive: %s", prefix, err)
+	}
+}
+
+// Accepts connections from the listener and receives transfers over them until the
+// context is done. Waits for the transfers in progress to stop before returning
+func (inbox *Inbox) Serve(ctx context.Context, listener net.Listener) error {
+	var transfers sync.WaitGroup
+	defer transfers.Wait()
+
+	// stop accepting when the context is done
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			listener.Close()
+		case <-stopped:
+		}
+	}()
+
+	inbox.log.Printf("Waiting for transfers on %s", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		inbox.lastID++
+		transfers.Add(1)
+		go func(id uint64) {
+			defer transfers.Done()
+			inbox.handle(ctx, id, conn)
+		}(inbox.lastID)
+	}
+}
+
+// Rejects any offer
+func rejectAll(file *fsys.File, dir *fsys.Directory) bool {
+	return false
+}
@@ -0,0 +1,258 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package inbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"unbewohnte/ftu/storage"
+	"unbewohnte/ftu/transfer"
+)
+
+func Test_ParseTrusted(t *testing.T) {
+	trusted, err := ParseTrusted("192.168.1.0/24, 10.0.0.5,::1")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	cases := map[string]bool{
+		"192.168.1.77": true,
+		"192.168.2.1":  false,
+		"10.0.0.5":     true,
+		"10.0.0.6":     false,
+		"::1":          true,
+	}
+	for address, expected := range cases {
+		contained := false
+		for _, network := range trusted {
+			if network.Contains(net.ParseIP(address)) {
+				contained = true
+			}
+		}
+		if contained != expected {
+			t.Fatalf("expected %s to be trusted: %v; got %v", address, expected, contained)
+		}
+	}
+
+	for _, invalid := range []string{"192.168.1.0/33", "not an address"} {
+		_, err := ParseTrusted(invalid)
+		if err == nil {
+			t.Fatalf("expected \"%s\" to be invalid", invalid)
+		}
+	}
+}
+
+// starts the inbox on a free port and returns its address
+func startInbox(t *testing.T, options Options) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- New(options).Serve(ctx, listener)
+	}()
+
+	return listener.Addr().String(), func() {
+		cancel()
+		err := <-served
+		if err != nil {
+			t.Fatalf("serving has failed: %s", err)
+		}
+	}
+}
+
+func makeFile(t *testing.T, name string, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(contents), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	return path
+}
+
+func Test_InboxConcurrentPushes(t *testing.T) {
+	trusted, _ := ParseTrusted("127.0.0.1")
+	directory := t.TempDir()
+	address, stop := startInbox(t, Options{
+		Directory: directory,
+		Trusted:   trusted,
+		Untrusted: UntrustedReject,
+	})
+
+	var pushes sync.WaitGroup
+	for _, name := range []string{"first.txt", "second.txt", "third.txt"} {
+		source := makeFile(t, name, name)
+		pushes.Add(1)
+		go func() {
+			defer pushes.Done()
+			_, err := transfer.Send(context.Background(), address, source, transfer.SendOptions{
+				Options: transfer.Options{Reverse: true},
+			})
+			if err != nil {
+				t.Errorf("pushing \"%s\" has failed: %s", source, err)
+			}
+		}()
+	}
+	pushes.Wait()
+	stop()
+
+	transfers, err := os.ReadDir(directory)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(transfers) != 3 {
+		t.Fatalf("expected every transfer to have its own directory; got %d", len(transfers))
+	}
+
+	received := make(map[string]bool)
+	for _, transferDir := range transfers {
+		files, _ := os.ReadDir(filepath.Join(directory, transferDir.Name()))
+		for _, file := range files {
+			received[file.Name()] = true
+		}
+	}
+	if len(received) != 3 {
+		t.Fatalf("expected 3 files to be received; got %v", received)
+	}
+}
+
+func Test_InboxStorage(t *testing.T) {
+	trusted, _ := ParseTrusted("127.0.0.1")
+	memory := storage.NewMemory()
+	address, stop := startInbox(t, Options{
+		Directory: "uploads",
+		Trusted:   trusted,
+		Untrusted: UntrustedReject,
+		Receive:   transfer.ReceiveOptions{Storage: memory},
+	})
+
+	var pushes sync.WaitGroup
+	for _, name := range []string{"first.txt", "second.txt"} {
+		source := makeFile(t, name, name)
+		pushes.Add(1)
+		go func() {
+			defer pushes.Done()
+			_, err := transfer.Send(context.Background(), address, source, transfer.SendOptions{
+				Options: transfer.Options{Reverse: true},
+			})
+			if err != nil {
+				t.Errorf("pushing \"%s\" has failed: %s", source, err)
+			}
+		}()
+	}
+	pushes.Wait()
+	stop()
+
+	// every transfer has its own directory in the storage
+	received := make(map[string]string)
+	for _, name := range memory.Names() {
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		parts := strings.Split(name, "/")
+		if len(parts) != 3 || parts[0] != "uploads" {
+			t.Fatalf("expected files to be in directories of the transfers in \"uploads\"; got \"%s\"", name)
+		}
+		received[parts[1]] = parts[2]
+
+		contents, _ := memory.ReadFile(name)
+		if string(contents) != parts[2] {
+			t.Fatalf("expected \"%s\" to contain its name; got \"%s\"", name, contents)
+		}
+	}
+	if len(received) != 2 {
+		t.Fatalf("expected 2 files in 2 directories; got %v", received)
+	}
+	if _, err := os.Stat("uploads"); err == nil {
+		t.Fatalf("expected nothing to be created on disk")
+	}
+}
+
+func Test_InboxUntrusted(t *testing.T) {
+	directory := t.TempDir()
+	address, stop := startInbox(t, Options{
+		Directory: directory,
+		Untrusted: UntrustedReject,
+	})
+	defer stop()
+
+	_, err := transfer.Send(context.Background(), address, makeFile(t, "a.txt", "a"), transfer.SendOptions{
+		Options: transfer.Options{Reverse: true},
+	})
+	if !errors.Is(err, transfer.ErrorRejected) {
+		t.Fatalf("expected the push of an untrusted peer to be rejected; got %v", err)
+	}
+
+	// the directory of the rejected transfer is removed
+	time.Sleep(time.Millisecond * 100)
+	transfers, _ := os.ReadDir(directory)
+	if len(transfers) != 0 {
+		t.Fatalf("expected no directories to be left; got %d", len(transfers))
+	}
+}
+
+func Test_ApprovalQueue(t *testing.T) {
+	input, answers := io.Pipe()
+	output := new(bytes.Buffer)
+	queue := NewApprovalQueue(input, output, time.Millisecond*200)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go queue.Run(ctx)
+
+	go answers.Write([]byte("y\n"))
+	if !queue.ask("first") {
+		t.Fatalf("expected the first offer to be approved")
+	}
+
+	// nobody answers
+	if queue.ask("second") {
+		t.Fatalf("expected the second offer to be rejected after the timeout")
+	}
+
+	go answers.Write([]byte("n\n"))
+	if queue.ask("third") {
+		t.Fatalf("expected the third offer to be rejected")
+	}
+
+	cancel()
+	time.Sleep(time.Millisecond * 50)
+	if queue.ask("after") {
+		t.Fatalf("expected offers to be rejected after the queue has stopped")
+	}
+
+	if !strings.Contains(output.String(), "first Accept ? [y/N]") {
+		t.Fatalf("the question has not been asked: %q", output.String())
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package limit
+
+import (
+	"context"
+	"net"
+)
+
+// How many bytes are read|written at once, so the traffic flows evenly
+const chunkSize int = 16 * 1024
+
+// A connection with limited bandwidth. Either limiter can be nil
+type Conn struct {
+	net.Conn
+	send    *Limiter
+	receive *Limiter
+
+	// done when the connection is closed, so nothing waits for the limiters anymore
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Wraps the connection so that writes are limited by send and reads are limited by receive.
+// Limiting reads slows the other side down as well, since it can not send more than has been read
+func NewConn(conn net.Conn, send *Limiter, receive *Limiter) *Conn {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Conn{
+		Conn:    conn,
+		send:    send,
+		receive: receive,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+func (conn *Conn) Close() error {
+	conn.cancel()
+	return conn.Conn.Close()
+}
+
+func (conn *Conn) Read(buffer []byte) (int, error) {
+	if conn.receive == nil {
+		return conn.Conn.Read(buffer)
+	}
+
+	if len(buffer) > chunkSize {
+		buffer = buffer[:chunkSize]
+	}
+
+	read, err := conn.Conn.Read(buffer)
+	// what has been read is returned either way; a closed connection fails the next read
+	conn.receive.Wait(conn.ctx, uint64(read))
+
+	return read, err
+}
+
+func (conn *Conn) Write(data []byte) (int, error) {
+	if conn.send == nil {
+		return conn.Conn.Write(data)
+	}
+
+	var written int = 0
+	for written < len(data) {
+		end := written + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		err := conn.send.Wait(conn.ctx, uint64(end-written))
+		if err != nil {
+			return written, net.ErrClosed
+		}
+
+		wrote, err := conn.Conn.Write(data[written:end])
+		written += wrote
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
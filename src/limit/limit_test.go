@@ -0,0 +1,229 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package limit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_ParseRate(t *testing.T) {
+	cases := map[string]Rate{
+		"":          Unlimited,
+		"0":         Unlimited,
+		"unlimited": Unlimited,
+		"100":       100,
+		"100B/s":    100,
+		"10KB/s":    10 * 1000,
+		"10MB/s":    10 * 1000 * 1000,
+		"1GB":       1000 * 1000 * 1000,
+		"512KiB/s":  512 * 1024,
+		"2M":        2 * 1024 * 1024,
+		"1.5MiB/s":  1.5 * 1024 * 1024,
+	}
+
+	for rateStr, expected := range cases {
+		rate, err := ParseRate(rateStr)
+		if err != nil {
+			t.Fatalf("failed to parse \"%s\": %s", rateStr, err)
+		}
+
+		if rate != expected {
+			t.Fatalf("expected \"%s\" to be %d; got %d", rateStr, expected, rate)
+		}
+	}
+
+	for _, invalid := range []string{"fast", "10XB/s", "-5MB", "MB/s", "0.5", "0.5B/s"} {
+		_, err := ParseRate(invalid)
+		if !errors.Is(err, ErrorInvalidRate) {
+			t.Fatalf("expected \"%s\" to be an invalid rate; got %v", invalid, err)
+		}
+	}
+}
+
+func Test_Schedule(t *testing.T) {
+	schedule, err := ParseSchedule("08:00-18:00=2MB/s, 22:00-06:00=unlimited")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	at := func(hour, minute int) time.Time {
+		return time.Date(2022, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+
+	cases := []struct {
+		moment   time.Time
+		rate     Rate
+		isInside bool
+	}{
+		{at(7, 59), 0, false},
+		{at(8, 0), 2 * 1000 * 1000, true},
+		{at(17, 59), 2 * 1000 * 1000, true},
+		{at(18, 0), 0, false},
+		{at(23, 30), Unlimited, true},
+		{at(3, 0), Unlimited, true},
+		{at(6, 0), 0, false},
+	}
+
+	for _, c := range cases {
+		rate, isInside := schedule.RateAt(c.moment)
+		if isInside != c.isInside || (isInside && rate != c.rate) {
+			t.Fatalf("at %s expected (%d, %v); got (%d, %v)", c.moment.Format("15:04"), c.rate, c.isInside, rate, isInside)
+		}
+	}
+
+	for _, invalid := range []string{"", "08:00-18:00", "8-18=1MB", "08:00=1MB", "25:00-26:00=1MB", "08:00-18:00=fast"} {
+		_, err := ParseSchedule(invalid)
+		if err == nil {
+			t.Fatalf("expected \"%s\" to be an invalid schedule", invalid)
+		}
+	}
+}
+
+// a limiter with a fake clock that only moves when the limiter sleeps
+func fakeLimiter(rate Rate, schedule *Schedule, start time.Time) (*Limiter, *time.Time) {
+	clock := start
+	limiter := NewLimiter(rate, schedule)
+	limiter.now = func() time.Time {
+		return clock
+	}
+	limiter.sleep = func(ctx context.Context, duration time.Duration) error {
+		clock = clock.Add(duration)
+		return ctx.Err()
+	}
+
+	return limiter, &clock
+}
+
+func Test_LimiterWait(t *testing.T) {
+	start := time.Date(2022, 1, 1, 12, 0, 0, 0, time.Local)
+	var rate Rate = 1024 * 1024
+
+	limiter, clock := fakeLimiter(rate, nil, start)
+
+	// initial burst + 4 seconds worth of traffic
+	err := limiter.Wait(context.Background(), uint64(burst(rate))+uint64(rate)*4)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	elapsed := clock.Sub(start)
+	if elapsed < 4*time.Second-time.Millisecond || elapsed > 4*time.Second+time.Millisecond {
+		t.Fatalf("expected to wait for 4 seconds; waited for %s", elapsed)
+	}
+
+	// unlimited does not wait
+	limiter.SetRate(Unlimited)
+	before := *clock
+	limiter.Wait(context.Background(), 1024*1024*1024)
+	if clock.Sub(before) != 0 {
+		t.Fatalf("unlimited limiter waited for %s", clock.Sub(before))
+	}
+
+	// a canceled wait returns right away
+	limiter.SetRate(rate)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = limiter.Wait(ctx, uint64(rate)*60)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the wait to be canceled; got %v", err)
+	}
+}
+
+func Test_LimiterWaitClosed(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	// 1 byte per second would take hours to write this
+	conn := NewConn(client, NewLimiter(1, nil), nil)
+	go io.Copy(io.Discard, server)
+
+	written := make(chan error)
+	go func() {
+		_, err := conn.Write(make([]byte, 64*1024))
+		written <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-written:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("expected the write to fail on a closed connection; got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the write is still waiting for the limiter after the connection has been closed")
+	}
+}
+
+func Test_LimiterSchedule(t *testing.T) {
+	schedule, err := ParseSchedule("00:00-12:00=1MiB/s")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	limiter, _ := fakeLimiter(Unlimited, schedule, time.Date(2022, 1, 1, 6, 0, 0, 0, time.Local))
+	if limiter.Rate() != 1024*1024 {
+		t.Fatalf("expected the scheduled rate; got %s", limiter.Rate())
+	}
+
+	limiter, _ = fakeLimiter(5000, schedule, time.Date(2022, 1, 1, 18, 0, 0, 0, time.Local))
+	if limiter.Rate() != 5000 {
+		t.Fatalf("expected the base rate outside of the schedule; got %s", limiter.Rate())
+	}
+}
+
+func Test_ApplyLimitFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limit")
+
+	send := NewLimiter(Unlimited, nil)
+	receive := NewLimiter(Unlimited, nil)
+
+	err := os.WriteFile(path, []byte("# comment\n5MB/s\nreceive=1MB/s\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = ApplyLimitFile(path, send, receive)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if send.Rate() != 5*1000*1000 || receive.Rate() != 1000*1000 {
+		t.Fatalf("limit file has not been applied correctly: send %s, receive %s", send.Rate(), receive.Rate())
+	}
+
+	err = os.WriteFile(path, []byte("upload=1MB/s\n"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if ApplyLimitFile(path, send, receive) == nil {
+		t.Fatalf("expected an error for an unknown direction")
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package limit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A token bucket that lets through at most Rate bytes per second.
+// The rate comes from the schedule if the current time is covered by it,
+// otherwise the base rate is used. Safe for concurrent use
+type Limiter struct {
+	mutex    *sync.Mutex
+	base     Rate
+	schedule *Schedule
+	tokens   float64 // bytes that can be let through right now
+	last     time.Time
+	now      func() time.Time // for testing
+	sleep    func(context.Context, time.Duration) error
+}
+
+// How many seconds worth of traffic can be let through at once
+const burstSeconds float64 = 0.25
+
+// The least burst size, so small rates do not chop packets into tiny pieces
+const minBurst float64 = 16 * 1024
+
+// The longest the limiter sleeps at once, so a changed rate is picked up soon enough
+const maxSleep time.Duration = time.Second
+
+// Creates a new limiter with the given base rate and an optional schedule
+func NewLimiter(rate Rate, schedule *Schedule) *Limiter {
+	return &Limiter{
+		mutex:    &sync.Mutex{},
+		base:     rate,
+		schedule: schedule,
+		now:      time.Now,
+		sleep:    sleep,
+	}
+}
+
+// Sleeps for the duration or until ctx is done
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Changes the base rate. Takes effect immediately
+func (limiter *Limiter) SetRate(rate Rate) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	limiter.base = rate
+}
+
+// Returns the rate in effect right now
+func (limiter *Limiter) Rate() Rate {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	return limiter.currentRate(limiter.now())
+}
+
+func (limiter *Limiter) currentRate(now time.Time) Rate {
+	if limiter.schedule != nil {
+		rate, covered := limiter.schedule.RateAt(now)
+		if covered {
+			return rate
+		}
+	}
+
+	return limiter.base
+}
+
+// Returns how many bytes can be let through at once with the given rate
+func burst(rate Rate) float64 {
+	size := float64(rate) * burstSeconds
+	if size < minBurst {
+		return minBurst
+	}
+	return size
+}
+
+// Blocks until n bytes are allowed to pass. Returns ctx's error if it is done before that
+func (limiter *Limiter) Wait(ctx context.Context, n uint64) error {
+	left := float64(n)
+
+	for left > 0 {
+		limiter.mutex.Lock()
+
+		now := limiter.now()
+		rate := limiter.currentRate(now)
+		if rate == Unlimited {
+			limiter.last = now
+			limiter.mutex.Unlock()
+			return nil
+		}
+
+		// refill
+		maxTokens := burst(rate)
+		if !limiter.last.IsZero() {
+			limiter.tokens += now.Sub(limiter.last).Seconds() * float64(rate)
+		} else {
+			limiter.tokens = maxTokens
+		}
+		if limiter.tokens > maxTokens {
+			limiter.tokens = maxTokens
+		}
+		limiter.last = now
+
+		take := left
+		if take > maxTokens {
+			take = maxTokens
+		}
+
+		if limiter.tokens >= take {
+			limiter.tokens -= take
+			left -= take
+			limiter.mutex.Unlock()
+			continue
+		}
+
+		// not enough yet; wait for the missing tokens to come
+		missing := take - limiter.tokens
+		limiter.mutex.Unlock()
+
+		duration := time.Duration(missing / float64(rate) * float64(time.Second))
+		if duration > maxSleep {
+			duration = maxSleep
+		}
+		err := limiter.sleep(ctx, duration)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Bandwidth limiting: token bucket limiters, time-of-day schedules and
+// connections that pass traffic through them
+package limit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Transfer rate in bytes per second. 0 means unlimited
+type Rate uint64
+
+const Unlimited Rate = 0
+
+var ErrorInvalidRate error = fmt.Errorf("invalid rate")
+
+// Converts a human-readable rate into Rate.
+// KB, MB, GB are powers of 1000; K, M, G, KiB, MiB, GiB are powers of 1024.
+// "/s" suffix is optional. "0", "" and "unlimited" mean no limit.
+// ie: "10MB/s", "512KiB/s", "1.5M", "100000"
+func ParseRate(rate string) (Rate, error) {
+	rate = strings.TrimSpace(rate)
+	if rate == "" || strings.EqualFold(rate, "unlimited") {
+		return Unlimited, nil
+	}
+
+	number := strings.TrimSuffix(rate, "/s")
+
+	// find where the number ends and the unit begins
+	unitStart := len(number)
+	for index, char := range number {
+		if (char < '0' || char > '9') && char != '.' {
+			unitStart = index
+			break
+		}
+	}
+	unit := strings.TrimSpace(number[unitStart:])
+	number = number[:unitStart]
+
+	var multiplier float64
+	switch strings.ToLower(unit) {
+	case "", "b":
+		multiplier = 1
+	case "k", "kib":
+		multiplier = 1024
+	case "m", "mib":
+		multiplier = 1024 * 1024
+	case "g", "gib":
+		multiplier = 1024 * 1024 * 1024
+	case "kb":
+		multiplier = 1000
+	case "mb":
+		multiplier = 1000 * 1000
+	case "gb":
+		multiplier = 1000 * 1000 * 1000
+	default:
+		return 0, fmt.Errorf("%w: unknown unit in \"%s\"", ErrorInvalidRate, rate)
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%w: \"%s\"", ErrorInvalidRate, rate)
+	}
+
+	bytes := uint64(value * multiplier)
+	if bytes == 0 && value != 0 {
+		// would silently turn into 0, which is not what has been asked for
+		return 0, fmt.Errorf("%w: \"%s\" is less than a byte", ErrorInvalidRate, rate)
+	}
+
+	return Rate(bytes), nil
+}
+
+// Returns rate in a human-readable form
+func (rate Rate) String() string {
+	if rate == Unlimited {
+		return "unlimited"
+	}
+
+	switch {
+	case rate >= 1024*1024*1024:
+		return fmt.Sprintf("%.2f GiB/s", float64(rate)/1024/1024/1024)
+	case rate >= 1024*1024:
+		return fmt.Sprintf("%.2f MiB/s", float64(rate)/1024/1024)
+	case rate >= 1024:
+		return fmt.Sprintf("%.2f KiB/s", float64(rate)/1024)
+	default:
+		return fmt.Sprintf("%d B/s", uint64(rate))
+	}
+}
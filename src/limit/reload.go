@@ -0,0 +1,114 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package limit
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Reads rates from a limit file. The file contains either a single rate for both directions
+// or "send=RATE" and|or "receive=RATE" lines. Empty lines and lines starting with # are ignored.
+// Directions that are not mentioned get nil
+func ReadLimitFile(path string) (send *Rate, receive *Rate, err error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		direction, rateStr, found := strings.Cut(line, "=")
+		if !found {
+			rateStr = direction
+			direction = "both"
+		}
+
+		rate, err := ParseRate(rateStr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch strings.TrimSpace(direction) {
+		case "both":
+			send, receive = &rate, &rate
+		case "send":
+			send = &rate
+		case "receive":
+			receive = &rate
+		default:
+			return nil, nil, fmt.Errorf("unknown direction \"%s\" in %s", direction, path)
+		}
+	}
+
+	return send, receive, nil
+}
+
+// Applies rates from the limit file to the limiters. Either limiter can be nil
+func ApplyLimitFile(path string, send *Limiter, receive *Limiter) error {
+	sendRate, receiveRate, err := ReadLimitFile(path)
+	if err != nil {
+		return err
+	}
+
+	if sendRate != nil && send != nil {
+		send.SetRate(*sendRate)
+	}
+	if receiveRate != nil && receive != nil {
+		receive.SetRate(*receiveRate)
+	}
+
+	return nil
+}
+
+// Re-reads the limit file and applies it to the limiters every time the process receives SIGHUP.
+// Errors are passed to onError. Returns a function that stops listening
+func ReloadOnSignal(path string, send *Limiter, receive *Limiter, onError func(error)) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-signals:
+				err := ApplyLimitFile(path, send, receive)
+				if err != nil && onError != nil {
+					onError(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package limit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A time of day window with its own rate
+type window struct {
+	from uint // minutes since midnight, inclusive
+	to   uint // minutes since midnight, exclusive. Can be less than from if the window goes past midnight
+	rate Rate
+}
+
+// Rates that depend on the time of day
+type Schedule struct {
+	windows []window
+}
+
+var ErrorInvalidSchedule error = fmt.Errorf("invalid schedule")
+
+// Converts "HH:MM" into minutes since midnight
+func parseTimeOfDay(timeOfDay string) (uint, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(timeOfDay))
+	if err != nil {
+		return 0, fmt.Errorf("%w: \"%s\" is not a time of day", ErrorInvalidSchedule, timeOfDay)
+	}
+
+	return uint(parsed.Hour()*60 + parsed.Minute()), nil
+}
+
+// Converts comma-separated windows in form of "HH:MM-HH:MM=RATE" into Schedule.
+// ie: "08:00-18:00=2MB/s,22:00-06:00=unlimited".
+// Windows are checked in the given order, the first one that matches wins
+func ParseSchedule(schedule string) (*Schedule, error) {
+	var parsed Schedule
+
+	for _, entry := range strings.Split(schedule, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		span, rateStr, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("%w: \"%s\" has no rate", ErrorInvalidSchedule, entry)
+		}
+
+		fromStr, toStr, found := strings.Cut(span, "-")
+		if !found {
+			return nil, fmt.Errorf("%w: \"%s\" is not a span of time", ErrorInvalidSchedule, span)
+		}
+
+		from, err := parseTimeOfDay(fromStr)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseTimeOfDay(toStr)
+		if err != nil {
+			return nil, err
+		}
+
+		rate, err := ParseRate(rateStr)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed.windows = append(parsed.windows, window{
+			from: from,
+			to:   to,
+			rate: rate,
+		})
+	}
+
+	if len(parsed.windows) == 0 {
+		return nil, fmt.Errorf("%w: no windows", ErrorInvalidSchedule)
+	}
+
+	return &parsed, nil
+}
+
+// Returns the rate for the given moment. The second value is false
+// if the moment is not covered by any window
+func (schedule *Schedule) RateAt(moment time.Time) (Rate, bool) {
+	minute := uint(moment.Hour()*60 + moment.Minute())
+
+	for _, window := range schedule.windows {
+		var inside bool
+		if window.from <= window.to {
+			inside = minute >= window.from && minute < window.to
+		} else {
+			// goes past midnight
+			inside = minute >= window.from || minute < window.to
+		}
+
+		if inside {
+			return window.rate, true
+		}
+	}
+
+	return Unlimited, false
+}
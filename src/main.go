@@ -21,14 +21,60 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"bytes"
+	"context"
 	_ "embed"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"unbewohnte/ftu/archive"
+	"unbewohnte/ftu/browse"
+	"unbewohnte/ftu/inbox"
+	"unbewohnte/ftu/limit"
 	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/offline"
+	"unbewohnte/ftu/protocol"
+	"unbewohnte/ftu/serve"
+	"unbewohnte/ftu/session"
+	"unbewohnte/ftu/storage"
+	"unbewohnte/ftu/transfer"
+	"unbewohnte/ftu/transport"
 )
 
+// Commands of the utility and modes it is run in by them on the other side
+const (
+	modeSend         string = "send"
+	modeReceive      string = "receive"
+	modeInbox        string = "inbox"
+	modeServe        string = "serve"
+	modeShare        string = "share"
+	modeBrowse       string = "browse"
+	modeGet          string = "get"
+	modePeer         string = "peer"
+	modeBundle       string = "bundle"
+	modeStdioSend    string = "stdio-send"
+	modeStdioReceive string = "stdio-receive"
+)
+
+// What bundle command does
+const (
+	bundleCreate  string = "create"
+	bundleExtract string = "extract"
+	bundleVerify  string = "verify"
+)
+
+// exit code for when the transfer could not even be started: invalid flags,
+// a missing source, an output that can't be created and such
+const setupFailed int = 2
+
 var (
 	VERSION string = "v2.3.3"
 
@@ -38,32 +84,360 @@ var (
 	licenseInformation string
 
 	// flags
-	PORT          *uint   = flag.Uint("p", 7270, "Specifies a port to work with")
-	RECUSRIVE     *bool   = flag.Bool("r", false, "Recursively send a directory")
-	ADDRESS       *string = flag.String("a", "", "Specifies an address to connect to")
-	DOWNLOADS_DIR *string = flag.String("d", ".", "Downloads folder")
-	SEND          *string = flag.String("s", "", "Specify a file|directory to send")
-	VERBOSE       *bool   = flag.Bool("?", false, "Turn on/off verbose output")
-	PRINT_VERSION *bool   = flag.Bool("v", false, "Print version information")
-	PRINT_LICENSE *bool   = flag.Bool("l", false, "Print license information")
-
-	isSending bool
+	PORT          *uint          = flag.Uint("p", 7270, "Specifies a port to work with")
+	RECUSRIVE     *bool          = flag.Bool("r", false, "Recursively send a directory")
+	FOLLOW_LINKS  *bool          = flag.Bool("L", false, "Follow symlinks when sending a directory")
+	MAX_DEPTH     *uint          = flag.Uint("depth", 0, "How deep to descend into a directory when sending recursively (0 - no limit)")
+	ADDRESS       *string        = flag.String("a", "", "Specifies an address to connect to (or listen on with -listen) or unix:/path/to/socket")
+	DOWNLOADS_DIR *string        = flag.String("d", ".", "Downloads folder")
+	ON_CONFLICT   *string        = flag.String("on-conflict", "overwrite", "What to do with a received file that differs from the existing one: overwrite|skip|keep-both|overwrite-if-newer|ask")
+	ON_DIR_CONFL  *string        = flag.String("on-dir-conflict", "merge", "What to do when the received directory already exists: merge|keep-both|reject|ask")
+	RESUME        *bool          = flag.Bool("resume", false, "Continue receiving files from leftover partial files of the interrupted transfer")
+	CLEAN_PARTIAL *bool          = flag.Bool("clean-partial", false, "Remove leftover partial files in the downloads folder before receiving")
+	BACKUP        *string        = flag.String("backup", "none", "What to do with files before replacing them: none|numbered|dated")
+	BACKUP_KEEP   *uint          = flag.Uint("backup-keep", 0, "How many backups of a file (or dated backup directories) to keep (0 - keep all)")
+	IGNORE_SPACE  *bool          = flag.Bool("ignore-free-space", false, "Only warn instead of rejecting a transfer that does not fit into the downloads folder")
+	LIMIT         *string        = flag.String("limit", "", "Limit bandwidth in both directions, ie: 10MB/s")
+	LIMIT_SEND    *string        = flag.String("limit-send", "", "Limit outgoing bandwidth (overrides -limit)")
+	LIMIT_RECEIVE *string        = flag.String("limit-receive", "", "Limit incoming bandwidth (overrides -limit)")
+	LIMIT_SCHED   *string        = flag.String("limit-schedule", "", "Limit bandwidth depending on the time of day, ie: 08:00-18:00=2MB/s,22:00-06:00=unlimited")
+	LIMIT_FILE    *string        = flag.String("limit-file", "", "File with limits that is re-read on SIGHUP to adjust them during the transfer")
+	SEND          *string        = flag.String("s", "", "Specify a file|directory to send")
+	AS_DIR        *bool          = flag.Bool("as-dir", false, "Send the contents of the tar, tar.gz or zip archive as a directory instead of the archive itself")
+	TEXT          *string        = flag.String("text", "", "Send the text message instead of a file|directory (- reads it from stdin)")
+	TEXT_FILE     *string        = flag.String("text-file", "", "Append received text messages to the file as well")
+	OUTPUT_FILE   *string        = flag.String("o", "", "Write the received file into this file instead of the downloads folder (- for stdout)")
+	ARCHIVE       *string        = flag.String("archive", "", "Write the received file|directory into -o as an archive: tar|tar.gz|zip")
+	VOLUME_SIZE   *string        = flag.String("volume-size", "", "Split the bundle into volumes of at most this size, ie: 4GB, 650MiB")
+	KEY_FILE      *string        = flag.String("key-file", "", "File with the passphrase|key the bundle is encrypted with (default: $FTU_BUNDLE_PASSPHRASE)")
+	STORAGE       *string        = flag.String("storage", "", "Receive into an S3-compatible object store instead of the OS filesystem: s3://bucket/prefix (-d is a directory in it)")
+	LISTEN        *bool          = flag.Bool("listen", false, "Wait for the other node to connect on -a address (all interfaces if not set) instead of connecting to it")
+	VERBOSE       *bool          = flag.Bool("?", false, "Turn on/off verbose output")
+	JSON          *bool          = flag.Bool("json", false, "Emit newline-delimited JSON events on stdout instead of human-readable output")
+	REMOTE_SHELL  *string        = flag.String("e", "", "Remote shell to run ftu on another machine with for send and receive commands (default: $FTU_RSH or ssh)")
+	REMOTE_FTU    *string        = flag.String("remote-ftu", "ftu", "Path to ftu on the remote machine")
+	STDIO_RECEIVE *string        = flag.String("stdio-receive", "", "Receive into the directory over stdin and stdout (run by the send command)")
+	STDIO_SEND    *string        = flag.String("stdio-send", "", "Send the file|directory over stdin and stdout (run by the receive command)")
+	TRUST         *string        = flag.String("trust", "", "Comma-separated IP addresses and networks whose pushes the inbox accepts without asking, ie: 192.168.1.0/24,10.0.0.5")
+	UNTRUSTED     *string        = flag.String("untrusted", "ask", "What the inbox does with pushes of other peers: ask|reject|accept")
+	MAX_CLIENTS   *uint          = flag.Uint("max-clients", 0, "How many receivers serve command sends to at once (0 - no limit)")
+	SERVE_TIMES   *uint          = flag.Uint("times", 0, "Stop serving after the file|directory has been received this many times (0 - no limit)")
+	EXPIRE        *time.Duration = flag.Duration("expire", 0, "Stop accepting new receivers after serving for this long (0 - never)")
+	APPROVE_TIME  *time.Duration = flag.Duration("approve-timeout", 5*time.Minute, "How long the inbox waits for an answer about a push before rejecting it (0 - forever)")
+	PRINT_VERSION *bool          = flag.Bool("v", false, "Print version information")
+	PRINT_LICENSE *bool          = flag.Bool("l", false, "Print license information")
+
+	// exit codes for each way the transfer can end
+	exitCodes map[node.Status]int = map[node.Status]int{
+		node.StatusSuccess:          0,
+		node.StatusFailed:           1,
+		node.StatusRejected:         3,
+		node.StatusConnectionFailed: 4,
+		node.StatusIntegrityFailed:  5,
+		node.StatusPartial:          6,
+		node.StatusCanceled:         7,
+	}
+
+	isSending      bool
+	reverse        bool   // the receiver listens and the sender connects
+	mode           string // "" for the usual -s|-a, a command or a stdio mode
+	modeArgs       []string
+	sources        []string // what is sent
+	remoteDest     string   // where send command sends to. Empty if not remote
+	text           string   // the text message sent instead of files. Empty if files are sent
+	streaming      bool     // stdin is sent instead of files
+	nodeTransport  transport.Transport
+	sendLimiter    *limit.Limiter
+	receiveLimiter *limit.Limiter
+
+	archiveFormat archive.Format // what the received file|directory is archived as. Empty if not archived
+
+	receiveStorage storage.Storage // where everything is received instead of the OS filesystem. Nil if there
+
+	bundleAction string // create, extract or verify with bundle command
+	volumeSize   uint64 // the size of volumes of the created bundle. 0 if it is not split
+	bundleKey    []byte // the passphrase|key the bundle is encrypted with
 )
 
-func init() {
+// Returns the passphrase|key of the bundle: the contents of -key-file without the line break in the end,
+// or $FTU_BUNDLE_PASSPHRASE. It is not taken as a flag, so it does not show in the list of processes
+func readBundleKey() ([]byte, error) {
+	if *KEY_FILE == "" {
+		return []byte(os.Getenv("FTU_BUNDLE_PASSPHRASE")), nil
+	}
+
+	contents, err := os.ReadFile(*KEY_FILE)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(contents, "\r\n"), nil
+}
+
+// Returns the text message of -text, reading it from stdin if it is "-"
+func readText(arg string) (string, error) {
+	message := arg
+	if arg == "-" {
+		contents, err := io.ReadAll(io.LimitReader(os.Stdin, int64(protocol.MAXTEXTSIZE)+2))
+		if err != nil {
+			return "", err
+		}
+		message = strings.TrimSuffix(string(contents), "\n")
+	}
+
+	if message == "" {
+		return "", fmt.Errorf("the text message is empty")
+	}
+	if uint(len(message)) > protocol.MAXTEXTSIZE {
+		return "", protocol.ErrorTextTooLong
+	}
+
+	return message, nil
+}
+
+// Creates send and receive limiters out of the limit flags. Returns nils if no limits were specified
+func parseLimits() (*limit.Limiter, *limit.Limiter, error) {
+	if *LIMIT == "" && *LIMIT_SEND == "" && *LIMIT_RECEIVE == "" && *LIMIT_SCHED == "" && *LIMIT_FILE == "" {
+		return nil, nil, nil
+	}
+
+	var schedule *limit.Schedule
+	if *LIMIT_SCHED != "" {
+		var err error
+		schedule, err = limit.ParseSchedule(*LIMIT_SCHED)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rate, err := limit.ParseRate(*LIMIT)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sendRate := rate
+	if *LIMIT_SEND != "" {
+		sendRate, err = limit.ParseRate(*LIMIT_SEND)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	receiveRate := rate
+	if *LIMIT_RECEIVE != "" {
+		receiveRate, err = limit.ParseRate(*LIMIT_RECEIVE)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	send := limit.NewLimiter(sendRate, schedule)
+	receive := limit.NewLimiter(receiveRate, schedule)
+
+	if *LIMIT_FILE != "" {
+		err = limit.ApplyLimitFile(*LIMIT_FILE, send, receive)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+	}
+
+	return send, receive, nil
+}
+
+// Creates the storage out of -storage flag. The endpoint and credentials of the object store are taken
+// from the same environment variables AWS tools use
+func parseStorage() (storage.Storage, error) {
+	s3, err := storage.ParseS3(*STORAGE)
+	if err != nil {
+		return nil, err
+	}
+
+	s3.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	s3.Region = os.Getenv("AWS_REGION")
+	if s3.Region == "" {
+		s3.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	s3.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	s3.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	s3.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+
+	return s3, nil
+}
+
+// Creates the transport out of -a and -p flags: a Unix domain socket for "unix:/path" or TCP
+func parseTransport() (transport.Transport, error) {
+	if strings.HasPrefix(*ADDRESS, transport.UnixPrefix) {
+		return transport.Parse(*ADDRESS)
+	}
+
+	if *PORT > 65535 {
+		return nil, fmt.Errorf("invalid port %d", *PORT)
+	}
+
+	return &transport.TCP{Address: net.JoinHostPort(*ADDRESS, strconv.FormatUint(uint64(*PORT), 10))}, nil
+}
+
+// Receives pushed transfers into -d directory until interrupted
+func runInbox(ctx context.Context, receiveOptions transfer.ReceiveOptions) error {
+	trusted, _ := inbox.ParseTrusted(*TRUST)
+	untrusted, _ := inbox.ParseUntrustedPolicy(*UNTRUSTED)
+
+	listener, err := nodeTransport.Listen(ctx)
+	if err != nil {
+		return err
+	}
+
+	approval := inbox.NewApprovalQueue(os.Stdin, os.Stdout, *APPROVE_TIME)
+	go approval.Run(ctx)
+
+	return inbox.New(inbox.Options{
+		Directory: *DOWNLOADS_DIR,
+		Trusted:   trusted,
+		Untrusted: untrusted,
+		Approval:  approval,
+		Log:       os.Stdout,
+		Receive:   receiveOptions,
+	}).Serve(ctx, listener)
+}
+
+// Sends -s file|directory to every receiver until interrupted, expired or received -times times
+func runServe(ctx context.Context, sendOptions transfer.SendOptions) error {
+	listener, err := nodeTransport.Listen(ctx)
+	if err != nil {
+		return err
+	}
+
+	var expire time.Time
+	if *EXPIRE != 0 {
+		expire = time.Now().Add(*EXPIRE)
+	}
+
+	return serve.New(serve.Options{
+		Source:     *SEND,
+		MaxClients: *MAX_CLIENTS,
+		Times:      *SERVE_TIMES,
+		Expire:     expire,
+		Log:        os.Stdout,
+		Send:       sendOptions,
+	}).Serve(ctx, listener)
+}
+
+// Lets browsing sides fetch from -s directory until interrupted
+func runShare(ctx context.Context, sendOptions transfer.SendOptions) error {
+	listener, err := nodeTransport.Listen(ctx)
+	if err != nil {
+		return err
+	}
+
+	return browse.New(browse.Options{
+		Root: *SEND,
+		Log:  os.Stdout,
+		Send: sendOptions,
+	}).Serve(ctx, listener)
+}
+
+// Browses the shared directory interactively
+func runBrowse(ctx context.Context, receiveOptions transfer.ReceiveOptions) error {
+	client, err := browse.Dial(ctx, nodeTransport)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return runShell(ctx, client, os.Stdin, os.Stdout, receiveOptions)
+}
+
+// Parses and validates the command line
+func parseFlags() {
 	flag.Usage = func() {
-		fmt.Printf("ftu -[FLAGs]\n\n")
+		fmt.Printf("ftu -[FLAGs]\n")
+		fmt.Printf("ftu send -[FLAGs] [path_to_file|directory]... [user@]host:[path_to_directory]\n")
+		fmt.Printf("ftu send -[FLAGs] [path_to_file|directory]...\n")
+		fmt.Printf("ftu send -text [text|-] -[FLAGs]\n")
+		fmt.Printf("ftu send -[FLAGs] - [[user@]host:[path_to_directory]]\n")
+		fmt.Printf("ftu receive -[FLAGs] [user@]host:[path_to_file|directory] [path_to_directory]\n")
+		fmt.Printf("ftu receive -[FLAGs]\n")
+		fmt.Printf("ftu inbox -[FLAGs]\n")
+		fmt.Printf("ftu serve -s [path_to_file|directory] -[FLAGs]\n")
+		fmt.Printf("ftu share -s [path_to_directory] -[FLAGs]\n")
+		fmt.Printf("ftu browse -[FLAGs] host\n")
+		fmt.Printf("ftu get -[FLAGs] host:[path_to_file|directory]\n")
+		fmt.Printf("ftu peer -[FLAGs] [host]\n")
+		fmt.Printf("ftu bundle create -[FLAGs] [path_to_bundle] [path_to_file|directory]...\n")
+		fmt.Printf("ftu bundle extract -[FLAGs] [path_to_bundle]\n")
+		fmt.Printf("ftu bundle verify -[FLAGs] [path_to_bundle]\n\n")
+
+		fmt.Printf("[COMMANDs]\n\n")
+		fmt.Printf("| send runs ftu on the host through the remote shell and sends the files|directories to it over the shell. No ports are opened\n")
+		fmt.Printf("| send without a remote destination sends the files|directories the same way as -s. Several paths are sent in one transfer, each under its own name\n")
+		fmt.Printf("| send - sends what comes from stdin as a single file named \"stdin\", without knowing its size in advance\n")
+		fmt.Printf("| receive runs ftu on the host through the remote shell and receives the file|directory from it over the shell\n")
+		fmt.Printf("| receive without a remote source receives the usual way: connects to -a or waits for the sender with -listen\n")
+		fmt.Printf("| inbox keeps waiting for pushes (ftu -s ... -a this_host) into -d directory, receiving each into its own subdirectory. Several pushes are received at once\n")
+		fmt.Printf("| serve keeps sending the file|directory to every receiver that connects, several at once, until interrupted, expired or received -times times\n")
+		fmt.Printf("| share keeps the directory open for browsing: those who connect list it and fetch what they want, never going outside of it. Symlinks are not followed\n")
+		fmt.Printf("| browse connects to the sharing host (-p port, or unix:/path/to/socket) and lets you walk the directory with ls, tree, cd and fetch with get into -d directory\n")
+		fmt.Printf("| get fetches the file|directory from the sharing host into -d directory\n")
+		fmt.Printf("| peer waits for the other side on -p port, or connects to the host, and lets both sides send to each other with send, asking whether to accept every offer. Received files go into -d directory\n")
+		fmt.Printf("| bundle create writes what would be sent to the receiver (checksummed and encrypted with the passphrase of -key-file or $FTU_BUNDLE_PASSPHRASE) into the file instead, to be carried over without a network. -volume-size splits it into \"name.001\", \"name.002\"...\n")
+		fmt.Printf("| bundle extract receives what is in the bundle into -d directory the same way as from the sender: conflict, backup and resume flags, -o, -archive and -storage apply. Split bundles are found by the name of either the bundle or its first volume\n")
+		fmt.Printf("| bundle verify reads the bundle through, checking every file against its checksum without writing anything\n")
+		fmt.Printf("| Flags go before the paths. Conflict and backup flags of send and -r, -L, -depth of receive are passed to the remote ftu\n\n")
 
 		fmt.Printf("[FLAGs]\n\n")
 		fmt.Printf("| -p [integer] for port\n")
 		fmt.Printf("| -r [true|false] send recursively or not\n")
-		fmt.Printf("| -a [ip_address|domain_name] address to connect to (cannot be used with -s)\n")
+		fmt.Printf("| -L [true|false] follow symlinks and send what they point to\n")
+		fmt.Printf("| -depth [integer] how deep to descend into a directory when sending recursively (0 - no limit)\n")
+		fmt.Printf("| -a [ip_address|domain_name|unix:path_to_socket] address to connect to or to listen on with -listen. Together with -s pushes to the listening receiver\n")
 		fmt.Printf("| -d [path_to_directory] where the files will be downloaded to (cannot be used with -s)\n")
-		fmt.Printf("| -s [path_to_file|directory] send it (cannot be used with -a)\n")
+		fmt.Printf("| -on-conflict [overwrite|skip|keep-both|overwrite-if-newer|ask] what to do with a received file that differs from the existing one\n")
+		fmt.Printf("| -on-dir-conflict [merge|keep-both|reject|ask] what to do when the received directory already exists\n")
+		fmt.Printf("| -resume [true|false] continue receiving files from leftover partial files of the interrupted transfer\n")
+		fmt.Printf("| -clean-partial [true|false] remove leftover partial files in the downloads folder before receiving\n")
+		fmt.Printf("| -backup [none|numbered|dated] keep replaced files as \"name.~N~\" or move them into a dated directory inside \"%s\"\n", node.BackupsDirName)
+		fmt.Printf("| -backup-keep [integer] how many backups of a file (or dated backup directories) to keep (0 - keep all)\n")
+		fmt.Printf("| -ignore-free-space [true|false] only warn instead of rejecting a transfer that does not fit into the downloads folder\n")
+		fmt.Printf("| -limit [rate] limit bandwidth in both directions, ie: 10MB/s, 512KiB/s (KB, MB, GB - powers of 1000; K, M, G, KiB, MiB, GiB - powers of 1024)\n")
+		fmt.Printf("| -limit-send [rate] limit outgoing bandwidth (overrides -limit)\n")
+		fmt.Printf("| -limit-receive [rate] limit incoming bandwidth (overrides -limit)\n")
+		fmt.Printf("| -limit-schedule [HH:MM-HH:MM=rate,...] limit bandwidth depending on the time of day. Outside of the windows -limit* flags apply\n")
+		fmt.Printf("| -limit-file [path_to_file] file with a rate or \"send=rate\" and \"receive=rate\" lines that is re-read on SIGHUP to adjust limits during the transfer\n")
+		fmt.Printf("| -s [path_to_file|directory] send it. Waits for the receiver unless -a is given\n")
+		fmt.Printf("| -as-dir [true|false] send the contents of the tar, tar.gz or zip archive as a directory named after it, without extracting it. All of the tree is sent, unless limited with -depth\n")
+		fmt.Printf("| -text [text|-] send the text message instead of a file|directory, the same way as -s. \"-\" reads it from stdin. The receiver just shows it\n")
+		fmt.Printf("| -text-file [path_to_file] append received text messages to the file as well\n")
+		fmt.Printf("| -o [path_to_file|-] write the received file (or stream) into the file or stdout (-) instead of the downloads folder. Directories are rejected\n")
+		fmt.Printf("| -archive [tar|tar.gz|zip] write the received file|directory with its empty directories, symlinks, modes and modification times into -o as an archive. Streams are rejected\n")
+		fmt.Printf("| -key-file [path_to_file] the passphrase|key the bundle is encrypted with and has to be extracted|verified with: the contents of the file without the line break in the end (default: $FTU_BUNDLE_PASSPHRASE). Bundle commands need one of them\n")
+		fmt.Printf("| -volume-size [size] split the created bundle into volumes of at most this size, ie: 4GB, 650MiB (KB, MB, GB - powers of 1000; K, M, G, KiB, MiB, GiB - powers of 1024)\n")
+		fmt.Printf("| -storage [s3://bucket/prefix] receive (or keep receiving with inbox) into an S3-compatible object store instead of the OS filesystem; -d is a directory in it. The endpoint and credentials are taken from AWS_ENDPOINT_URL, AWS_REGION, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN. -resume, -clean-partial and -backup are not possible\n")
+		fmt.Printf("| -listen [true|false] wait for the other node to connect (on -a address if given) instead of connecting to it. The receiver waits for pushes with it\n")
 		fmt.Printf("| -? [true|false] turn on|off verbose output\n")
+		fmt.Printf("| -json [true|false] emit newline-delimited JSON events on stdout; human-readable messages go to stderr\n")
+		fmt.Printf("| -e [command] remote shell for send and receive commands (default: $FTU_RSH or ssh), ie: \"ssh -p 2222\"\n")
+		fmt.Printf("| -remote-ftu [path_to_ftu] ftu on the remote machine (default: ftu)\n")
+		fmt.Printf("| -stdio-receive [path_to_directory] receive into the directory over stdin and stdout, accepting the offer (run by send command)\n")
+		fmt.Printf("| -stdio-send [path_to_file|directory] send it over stdin and stdout (run by receive command)\n")
+		fmt.Printf("| -trust [ip_address|network,...] pushes from these addresses and networks are accepted by the inbox without asking, ie: 192.168.1.0/24,10.0.0.5\n")
+		fmt.Printf("| -untrusted [ask|reject|accept] what the inbox does with pushes of other peers. Asked about one at a time (default: ask)\n")
+		fmt.Printf("| -max-clients [integer] how many receivers serve command sends to at once; others wait for their turn (0 - no limit)\n")
+		fmt.Printf("| -times [integer] stop serving after the file|directory has been received this many times (0 - no limit)\n")
+		fmt.Printf("| -expire [duration] stop accepting new receivers after serving for this long, ie: 1h (0 - never)\n")
+		fmt.Printf("| -approve-timeout [duration] how long the inbox waits for an answer before rejecting the push, ie: 30s, 10m (0 - forever, default: 5m)\n")
 		fmt.Printf("| -l print license information\n")
 		fmt.Printf("| -v print version information\n\n\n")
 
+		fmt.Printf("[Exit codes]\n\n")
+		fmt.Printf("| 0 everything has been transferred\n")
+		fmt.Printf("| 1 the transfer has failed before any file was transferred\n")
+		fmt.Printf("| 2 the transfer could not be started: invalid flags, a missing source, an output or a storage that can't be opened, a wrong bundle key\n")
+		fmt.Printf("| 3 the transfer has been rejected\n")
+		fmt.Printf("| 4 could not connect or the connection has been lost before any file was transferred\n")
+		fmt.Printf("| 5 some received files did not match their checksums\n")
+		fmt.Printf("| 6 some files have been transferred, but not all of them\n")
+		fmt.Printf("| 7 the transfer has been canceled with Ctrl-C\n\n\n")
+
 		fmt.Printf("[Examples]\n\n")
 
 		fmt.Printf("| ftu -p 89898 -s /home/user/Downloads/someVideo.mp4\n")
@@ -78,13 +452,84 @@ func init() {
 		fmt.Printf("| ftu -p 7277 -a 192.168.1.104 -d /home/user/Downloads/\n")
 		fmt.Printf("| creates a node that will connect to 192.168.1.104:7277 and download served file|directory to \"/home/user/Downloads/\"\n\n")
 
+		fmt.Printf("| ftu -a 192.168.1.104 -d . -on-conflict keep-both -on-dir-conflict merge\n")
+		fmt.Printf("| creates a node that will download into an already existing directory, saving files that differ from the existing ones under numbered names\n\n")
+
+		fmt.Printf("| ftu -a 192.168.1.104 -d . -backup numbered -backup-keep 3\n")
+		fmt.Printf("| creates a node that will replace files that differ, keeping up to 3 previous versions of each as \"name.~N~\"\n\n")
+
+		fmt.Printf("| ftu -s /home/user/Videos/movie.mkv -limit 5MB/s -limit-schedule 22:00-07:00=unlimited\n")
+		fmt.Printf("| creates a node that will send \"movie.mkv\" no faster than 5MB/s, except for the night time\n\n")
+
+		fmt.Printf("| ftu -a 192.168.1.104 -d . -limit-file ~/.ftu-limit\n")
+		fmt.Printf("| creates a node that will download with limits from \"~/.ftu-limit\"; edit the file and send SIGHUP to ftu to change them on the fly\n\n")
+
+		fmt.Printf("| ftu -s /home/user/homework -listen -a unix:/tmp/ftu.sock\n")
+		fmt.Printf("| creates a node that will send every file in the directory through the Unix domain socket \"/tmp/ftu.sock\"\n\n")
+
+		fmt.Printf("| ftu -a unix:/tmp/ftu.sock -d .\n")
+		fmt.Printf("| creates a node that will connect to the Unix domain socket \"/tmp/ftu.sock\" and download served file|directory to the working directory\n\n")
+
+		fmt.Printf("| ftu send -r /home/user/homework user@192.168.1.104:/home/user/Downloads\n")
+		fmt.Printf("| sends the directory recursively to \"/home/user/Downloads\" on 192.168.1.104 over ssh\n\n")
+
+		fmt.Printf("| ftu send notes/ a.iso b.txt\n")
+		fmt.Printf("| creates a node that will send the directory and both files in one transfer; the receiver gets \"notes\", \"a.iso\" and \"b.txt\" side by side\n\n")
+
+		fmt.Printf("| tar c /home/user/homework | ftu send -\n")
+		fmt.Printf("| creates a node that will send the archive as it is being made; \"ftu receive -a this_host -o - | tar x\" unpacks it as it comes\n\n")
+
+		fmt.Printf("| ftu -s release.tar.gz -as-dir\n")
+		fmt.Printf("| creates a node that will send the contents of the archive as the directory \"release\" without extracting it\n\n")
+
+		fmt.Printf("| AWS_ENDPOINT_URL=http://127.0.0.1:9000 AWS_ACCESS_KEY_ID=... AWS_SECRET_ACCESS_KEY=... ftu inbox -storage s3://uploads -trust 192.168.1.0/24\n")
+		fmt.Printf("| creates an inbox that will receive every push from the local network into its own directory in the \"uploads\" bucket of the local object store\n\n")
+
+		fmt.Printf("| ftu bundle create -r -volume-size 4GB -key-file ~/bundle.key /media/usb/homework.ftub /home/user/homework\n")
+		fmt.Printf("| writes the directory into the bundle on the USB drive, encrypted with the key of the file and split into volumes that fit on FAT32; \"ftu bundle extract -key-file ~/bundle.key -d . /media/usb/homework.ftub\" extracts it on the other machine with the same key file\n\n")
+
+		fmt.Printf("| ftu -a 192.168.1.104 -archive zip -o homework.zip\n")
+		fmt.Printf("| creates a node that will download the offered file|directory into \"homework.zip\" instead of unpacking it\n\n")
+
+		fmt.Printf("| ftu receive -e \"ssh -p 2222\" user@192.168.1.104:Videos/movie.mkv .\n")
+		fmt.Printf("| downloads \"movie.mkv\" from the home directory of the user on 192.168.1.104 over ssh on port 2222\n\n")
+
+		fmt.Printf("| ftu -d /home/user/Downloads -listen\n")
+		fmt.Printf("| creates a node that will wait for the sender to connect and push a file|directory into \"/home/user/Downloads\"\n\n")
+
+		fmt.Printf("| ftu -s /home/user/homework -a 192.168.1.104\n")
+		fmt.Printf("| creates a node that will connect to the listening receiver on 192.168.1.104:7270 and push the directory to it\n\n")
+
+		fmt.Printf("| ftu inbox -d /home/user/Incoming -trust 192.168.1.0/24\n")
+		fmt.Printf("| keeps receiving pushes into \"/home/user/Incoming\", accepting those from the local network right away and asking about the rest\n\n")
+
+		fmt.Printf("| ftu serve -s /home/user/Videos/movie.mkv -max-clients 3 -times 10 -expire 24h\n")
+		fmt.Printf("| shares \"movie.mkv\" with up to 3 receivers at once until it has been received 10 times or for a day\n\n")
+
 		fmt.Printf("| ftu -s /home/user/homework\n")
 		fmt.Printf("| creates a node that will send every file in the directory\n\n")
 
 		fmt.Printf("| ftu -r -s /home/user/homework/\n")
-		fmt.Printf("| creates a node that will send every file in the directory !RECUSRIVELY!\n\n\n")
+		fmt.Printf("| creates a node that will send every file in the directory !RECUSRIVELY!\n\n")
+
+		fmt.Printf("| ftu -r -L -s /home/user/homework/\n")
+		fmt.Printf("| creates a node that will send every file in the directory !RECUSRIVELY!, sending what symlinks point to instead of symlinks themselves\n\n\n")
+	}
+	// commands go before the flags
+	if len(os.Args) > 1 && (os.Args[1] == modeSend || os.Args[1] == modeReceive || os.Args[1] == modeInbox || os.Args[1] == modeServe ||
+		os.Args[1] == modeShare || os.Args[1] == modeBrowse || os.Args[1] == modeGet || os.Args[1] == modePeer) {
+		mode = os.Args[1]
+		flag.CommandLine.Parse(os.Args[2:])
+	} else if len(os.Args) > 1 && os.Args[1] == modeBundle {
+		// bundle command is followed by what to do
+		mode = modeBundle
+		if len(os.Args) > 2 {
+			bundleAction = os.Args[2]
+			flag.CommandLine.Parse(os.Args[3:])
+		}
+	} else {
+		flag.Parse()
 	}
-	flag.Parse()
 
 	if *PRINT_VERSION {
 		fmt.Println(versionInformation)
@@ -97,46 +542,551 @@ func init() {
 	}
 
 	// validate flags
-	if *SEND == "" && *ADDRESS == "" {
-		fmt.Printf("[ERROR] Neither sending nor receiving flag was specified. Run ftu -h for help\n")
-		os.Exit(-1)
+	switch {
+	case mode == modeInbox:
+		if len(flag.Args()) != 0 {
+			fmt.Fprintf(os.Stderr, "[ERROR] inbox command takes no arguments. Run ftu -h for help\n")
+			os.Exit(setupFailed)
+		}
+
+		// stdin is taken by the approval queue
+		if *ON_CONFLICT == string(node.ConflictAsk) || *ON_DIR_CONFL == string(node.DirConflictAsk) {
+			fmt.Fprintf(os.Stderr, "[ERROR] Can't ask what to do with conflicts in the inbox\n")
+			os.Exit(setupFailed)
+		}
+
+		if _, err := inbox.ParseUntrustedPolicy(*UNTRUSTED); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s. Run ftu -h for help\n", err)
+			os.Exit(setupFailed)
+		}
+
+		if _, err := inbox.ParseTrusted(*TRUST); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s. Run ftu -h for help\n", err)
+			os.Exit(setupFailed)
+		}
+
+	case mode == modeServe:
+		if len(flag.Args()) != 0 || *SEND == "" {
+			fmt.Fprintf(os.Stderr, "[ERROR] serve command needs -s and takes no arguments. Run ftu -h for help\n")
+			os.Exit(setupFailed)
+		}
+
+	case mode == modeShare:
+		if len(flag.Args()) != 0 || *SEND == "" {
+			fmt.Fprintf(os.Stderr, "[ERROR] share command needs -s and takes no arguments. Run ftu -h for help\n")
+			os.Exit(setupFailed)
+		}
+
+		if stats, err := os.Stat(*SEND); err != nil || !stats.IsDir() {
+			fmt.Fprintf(os.Stderr, "[ERROR] \"%s\" is not a directory\n", *SEND)
+			os.Exit(setupFailed)
+		}
+
+	case mode == modeBrowse || mode == modeGet:
+		modeArgs = flag.Args()
+		if len(modeArgs) != 1 {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s command needs one argument. Run ftu -h for help\n", mode)
+			os.Exit(setupFailed)
+		}
+
+		if mode == modeGet {
+			address, path, ok := parseSharedPath(modeArgs[0])
+			if !ok {
+				fmt.Fprintf(os.Stderr, "[ERROR] \"%s\" is not host:path. Run ftu -h for help\n", modeArgs[0])
+				os.Exit(setupFailed)
+			}
+			*ADDRESS = address
+			modeArgs[0] = path
+		} else {
+			*ADDRESS = modeArgs[0]
+		}
+
+		// stdin is taken by the shell
+		if mode == modeBrowse && (*ON_CONFLICT == string(node.ConflictAsk) || *ON_DIR_CONFL == string(node.DirConflictAsk)) {
+			fmt.Fprintf(os.Stderr, "[ERROR] Can't ask what to do with conflicts while browsing\n")
+			os.Exit(setupFailed)
+		}
+
+	case mode == modePeer:
+		modeArgs = flag.Args()
+		if len(modeArgs) > 1 {
+			fmt.Fprintf(os.Stderr, "[ERROR] peer command takes at most one argument. Run ftu -h for help\n")
+			os.Exit(setupFailed)
+		}
+		if len(modeArgs) == 1 {
+			*ADDRESS = modeArgs[0]
+		}
+
+		// stdin is taken by the shell
+		if *ON_CONFLICT == string(node.ConflictAsk) || *ON_DIR_CONFL == string(node.DirConflictAsk) {
+			fmt.Fprintf(os.Stderr, "[ERROR] Can't ask what to do with conflicts in a peer session\n")
+			os.Exit(setupFailed)
+		}
+
+	case mode == modeBundle:
+		modeArgs = flag.Args()
+		switch bundleAction {
+		case bundleCreate:
+			if len(modeArgs) < 2 {
+				fmt.Fprintf(os.Stderr, "[ERROR] bundle create command needs the bundle and at least one path to put into it. Run ftu -h for help\n")
+				os.Exit(setupFailed)
+			}
+			sources = modeArgs[1:]
+
+		case bundleExtract, bundleVerify:
+			if len(modeArgs) != 1 {
+				fmt.Fprintf(os.Stderr, "[ERROR] bundle %s command needs the bundle. Run ftu -h for help\n", bundleAction)
+				os.Exit(setupFailed)
+			}
+
+		default:
+			fmt.Fprintf(os.Stderr, "[ERROR] bundle command is followed by create, extract or verify. Run ftu -h for help\n")
+			os.Exit(setupFailed)
+		}
+
+	case mode == modeSend:
+		modeArgs = flag.Args()
+		if *TEXT != "" {
+			if len(modeArgs) != 0 {
+				fmt.Fprintf(os.Stderr, "[ERROR] send command takes no paths with -text. Run ftu -h for help\n")
+				os.Exit(setupFailed)
+			}
+			break
+		}
+		if len(modeArgs) == 0 {
+			fmt.Fprintf(os.Stderr, "[ERROR] send command needs at least one path to send. Run ftu -h for help\n")
+			os.Exit(setupFailed)
+		}
+
+		// the last argument is where to send if it is remote
+		sources = modeArgs
+		if last := modeArgs[len(modeArgs)-1]; len(modeArgs) > 1 {
+			if _, _, isRemote := parseRemotePath(last); isRemote {
+				sources, remoteDest = modeArgs[:len(modeArgs)-1], last
+			}
+		}
+
+	case mode != "":
+		modeArgs = flag.Args()
+		if mode == modeReceive && len(modeArgs) == 0 {
+			// the usual way
+			if *ADDRESS == "" && !*LISTEN {
+				fmt.Fprintf(os.Stderr, "[ERROR] receive command needs either a source and a destination or -a|-listen. Run ftu -h for help\n")
+				os.Exit(setupFailed)
+			}
+			break
+		}
+		if len(modeArgs) != 2 {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s command needs a source and a destination. Run ftu -h for help\n", mode)
+			os.Exit(setupFailed)
+		}
+
+		remoteArg := modeArgs[1]
+		if mode == modeReceive {
+			remoteArg = modeArgs[0]
+		}
+		if _, _, isRemote := parseRemotePath(remoteArg); !isRemote {
+			fmt.Fprintf(os.Stderr, "[ERROR] \"%s\" is not a remote path ([user@]host:path). Run ftu -h for help\n", remoteArg)
+			os.Exit(setupFailed)
+		}
+
+	case *STDIO_RECEIVE != "" || *STDIO_SEND != "":
+		if *STDIO_RECEIVE != "" && *STDIO_SEND != "" {
+			fmt.Fprintf(os.Stderr, "[ERROR] Can't send and receive at the same time. Specify either -stdio-send or -stdio-receive\n")
+			os.Exit(setupFailed)
+		}
+
+		mode = modeStdioSend
+		if *STDIO_RECEIVE != "" {
+			mode = modeStdioReceive
+		}
+
+		// stdin is taken by the other node
+		if *ON_CONFLICT == string(node.ConflictAsk) || *ON_DIR_CONFL == string(node.DirConflictAsk) {
+			fmt.Fprintf(os.Stderr, "[ERROR] Can't ask what to do with conflicts over stdio\n")
+			os.Exit(setupFailed)
+		}
+
+	case *SEND == "" && *TEXT == "" && *ADDRESS == "" && !*LISTEN:
+		fmt.Fprintf(os.Stderr, "[ERROR] Neither sending nor receiving flag was specified. Run ftu -h for help\n")
+		os.Exit(setupFailed)
 	}
 
-	if *SEND != "" && *ADDRESS != "" {
-		fmt.Printf("[ERROR] Can't send and receive at the same time. Specify either -s or -a\n")
-		os.Exit(-1)
+	if _, err := node.ParseConflictPolicy(*ON_CONFLICT); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %s. Run ftu -h for help\n", err)
+		os.Exit(setupFailed)
+	}
+
+	if _, err := node.ParseDirConflictPolicy(*ON_DIR_CONFL); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %s. Run ftu -h for help\n", err)
+		os.Exit(setupFailed)
+	}
+
+	if _, err := node.ParseBackupPolicy(*BACKUP); err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %s. Run ftu -h for help\n", err)
+		os.Exit(setupFailed)
+	}
+
+	var err error
+	sendLimiter, receiveLimiter, err = parseLimits()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %s. Run ftu -h for help\n", err)
+		os.Exit(setupFailed)
+	}
+
+	nodeTransport, err = parseTransport()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] %s. Run ftu -h for help\n", err)
+		os.Exit(setupFailed)
+	}
+
+	if *TEXT != "" {
+		if (mode != "" && mode != modeSend) || *SEND != "" {
+			fmt.Fprintf(os.Stderr, "[ERROR] -text is sent on its own: either with send command or instead of -s\n")
+			os.Exit(setupFailed)
+		}
+
+		text, err = readText(*TEXT)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s\n", err)
+			os.Exit(setupFailed)
+		}
 	}
 
-	// sending or receiving
 	if *SEND != "" {
-		// sending
+		sources = []string{*SEND}
+	}
+
+	for _, source := range sources {
+		if source == "-" {
+			streaming = true
+		}
+	}
+	creatingBundle := mode == modeBundle && bundleAction == bundleCreate
+	if streaming && (len(sources) != 1 || (mode != "" && mode != modeSend && !creatingBundle)) {
+		fmt.Fprintf(os.Stderr, "[ERROR] stdin can only be sent on its own to one receiver: with send command or -s, or put into a bundle\n")
+		os.Exit(setupFailed)
+	}
+
+	if *AS_DIR && (len(sources) != 1 || streaming || (mode != "" && mode != modeSend && !creatingBundle)) {
+		fmt.Fprintf(os.Stderr, "[ERROR] -as-dir sends the contents of one archive to one receiver: with send command or -s, or puts them into a bundle\n")
+		os.Exit(setupFailed)
+	}
+
+	extractingBundle := mode == modeBundle && bundleAction == bundleExtract
+	if *OUTPUT_FILE != "" {
+		if (mode != "" && mode != modeReceive && mode != modeGet && !extractingBundle) || *SEND != "" || *TEXT != "" {
+			fmt.Fprintf(os.Stderr, "[ERROR] -o is used only when receiving the usual way, with receive or get command, or extracting a bundle\n")
+			os.Exit(setupFailed)
+		}
+
+		if *OUTPUT_FILE == "-" && *JSON {
+			fmt.Fprintf(os.Stderr, "[ERROR] Can't write both the received file and JSON events to stdout\n")
+			os.Exit(setupFailed)
+		}
+	}
+
+	if *ARCHIVE != "" {
+		if *OUTPUT_FILE == "" {
+			fmt.Fprintf(os.Stderr, "[ERROR] -archive needs -o to write the archive into\n")
+			os.Exit(setupFailed)
+		}
+
+		var err error
+		archiveFormat, err = archive.ParseFormat(*ARCHIVE)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s\n", err)
+			os.Exit(setupFailed)
+		}
+	}
+
+	if *STORAGE != "" {
+		receivingUsualWay := mode == "" || mode == modeInbox || (mode == modeReceive && len(modeArgs) == 0) || extractingBundle
+		if !receivingUsualWay || *SEND != "" || *TEXT != "" || *OUTPUT_FILE != "" {
+			fmt.Fprintf(os.Stderr, "[ERROR] -storage is used only when receiving the usual way, with receive command without a remote source, inbox or bundle extract, and not with -o\n")
+			os.Exit(setupFailed)
+		}
+
+		if *RESUME || *CLEAN_PARTIAL || *BACKUP != string(node.BackupNone) {
+			fmt.Fprintf(os.Stderr, "[ERROR] -resume, -clean-partial and -backup work only in the downloads folder, not with -storage\n")
+			os.Exit(setupFailed)
+		}
+
+		var err error
+		receiveStorage, err = parseStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s\n", err)
+			os.Exit(setupFailed)
+		}
+	}
+
+	if *VOLUME_SIZE != "" {
+		if !creatingBundle {
+			fmt.Fprintf(os.Stderr, "[ERROR] -volume-size is used only with bundle create command\n")
+			os.Exit(setupFailed)
+		}
+
+		var err error
+		volumeSize, err = offline.ParseVolumeSize(*VOLUME_SIZE)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s. Run ftu -h for help\n", err)
+			os.Exit(setupFailed)
+		}
+	}
+
+	if *KEY_FILE != "" && mode != modeBundle {
+		fmt.Fprintf(os.Stderr, "[ERROR] -key-file is used only with bundle command\n")
+		os.Exit(setupFailed)
+	}
+	if mode == modeBundle {
+		var err error
+		bundleKey, err = readBundleKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Could not read the key file: %s\n", err)
+			os.Exit(setupFailed)
+		}
+		if len(bundleKey) == 0 {
+			fmt.Fprintf(os.Stderr, "[ERROR] bundle command needs a passphrase: -key-file or $FTU_BUNDLE_PASSPHRASE. Run ftu -h for help\n")
+			os.Exit(setupFailed)
+		}
+	}
+
+	// sending or receiving
+	if (len(sources) != 0 || text != "") && remoteDest == "" {
+		// sending. Pushes to the listening receiver if there is where to connect
 		isSending = true
-	} else if *ADDRESS != "" {
-		// receiving
+		reverse = *ADDRESS != "" && !*LISTEN
+	} else {
+		// receiving (or sending over the remote shell). Waits for the sender to push if told to listen
 		isSending = false
+		reverse = *LISTEN
 	}
 }
 
 func main() {
-	nodeOptions := node.NodeOptions{
-		VerboseOutput: *VERBOSE,
-		IsSending:     isSending,
-		WorkingPort:   *PORT,
-		SenderSide: &node.SenderNodeOptions{
-			ServingPath: *SEND,
-			Recursive:   *RECUSRIVE,
-		},
-		ReceiverSide: &node.ReceiverNodeOptions{
-			ConnectionAddr:      *ADDRESS,
-			DownloadsFolderPath: *DOWNLOADS_DIR,
-		},
-	}
-
-	node, err := node.NewNode(&nodeOptions)
-	if err != nil {
-		fmt.Printf("[ERROR] Error constructing a new node: %s\n", err)
-		os.Exit(-1)
+	parseFlags()
+
+	// Ctrl-C cancels the transfer gracefully
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var output io.Writer = os.Stdout
+	var events io.Writer
+	if *OUTPUT_FILE == "-" {
+		// stdout belongs to the received file
+		output = os.Stderr
+	}
+	if *JSON {
+		// keep stdout clean for the events
+		output = os.Stderr
+		events = os.Stdout
+	}
+	if mode == modeStdioSend || mode == modeStdioReceive {
+		// stdout belongs to the other node, and stderr is shown next to its own output
+		output = io.Discard
+		if *VERBOSE {
+			output = os.Stderr
+		}
+		events = nil
+	}
+
+	options := transfer.Options{
+		Output:         output,
+		Events:         events,
+		Verbose:        *VERBOSE,
+		Reverse:        reverse,
+		SendLimiter:    sendLimiter,
+		ReceiveLimiter: receiveLimiter,
+	}
+
+	stopReloading := func() {}
+	if *LIMIT_FILE != "" {
+		stopReloading = limit.ReloadOnSignal(*LIMIT_FILE, sendLimiter, receiveLimiter, func(err error) {
+			fmt.Fprintf(output, "\n[ERROR] Could not reload limits from \"%s\": %s", *LIMIT_FILE, err)
+		})
+	}
+
+	sendOptions := transfer.SendOptions{
+		Options:        options,
+		Recursive:      *RECUSRIVE,
+		FollowSymlinks: *FOLLOW_LINKS,
+		MaxDepth:       *MAX_DEPTH,
+		Text:           text,
+	}
+	if len(sources) > 1 {
+		sendOptions.MoreSources = sources[1:]
+	}
+
+	source := ""
+	if len(sources) != 0 {
+		source = sources[0]
+	}
+	if streaming {
+		sendOptions.Stream = os.Stdin
+		source = "stdin"
+	}
+	if *AS_DIR {
+		filesystem, err := archive.Open(source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] Could not open the archive: %s\n", err)
+			os.Exit(setupFailed)
+		}
+		defer filesystem.Close()
+
+		// the tree of the archive is sent as a whole
+		sendOptions.FS = filesystem
+		sendOptions.FSName = archive.Name(source)
+		sendOptions.Recursive = true
+		source = "."
+	}
+
+	prompt := &node.Prompt{Output: output}
+	receiveOptions := transfer.ReceiveOptions{
+		Options:         options,
+		OnConflict:      node.ConflictPolicy(*ON_CONFLICT),
+		OnDirConflict:   node.DirConflictPolicy(*ON_DIR_CONFL),
+		Resume:          *RESUME,
+		CleanPartial:    *CLEAN_PARTIAL,
+		Backup:          node.BackupPolicy(*BACKUP),
+		BackupKeep:      *BACKUP_KEEP,
+		IgnoreFreeSpace: *IGNORE_SPACE,
+		OfferDecider:    prompt,
+		ConflictDecider: prompt,
+		TextFile:        *TEXT_FILE,
+		Archive:         archiveFormat,
+		Storage:         receiveStorage,
+	}
+
+	switch *OUTPUT_FILE {
+	case "":
+	case "-":
+		receiveOptions.Writer = os.Stdout
+	default:
+		outputFile, err := os.Create(*OUTPUT_FILE)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s\n", err)
+			os.Exit(setupFailed)
+		}
+		receiveOptions.Writer = outputFile
+	}
+
+	if mode == modeInbox || mode == modeServe || mode == modeShare || mode == modeBrowse || mode == modePeer {
+		var err error
+		switch mode {
+		case modeInbox:
+			err = runInbox(ctx, receiveOptions)
+		case modeServe:
+			err = runServe(ctx, sendOptions)
+		case modeShare:
+			err = runShare(ctx, sendOptions)
+		case modeBrowse:
+			// asking for it is the consent
+			receiveOptions.OfferDecider = node.AcceptAll
+			err = runBrowse(ctx, receiveOptions)
+		case modePeer:
+			err = runPeer(ctx, session.PeerOptions{
+				Destination: *DOWNLOADS_DIR,
+				Send:        sendOptions,
+				Receive:     receiveOptions,
+			})
+		}
+		stopReloading()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR] %s\n", err)
+			os.Exit(setupFailed)
+		}
+		os.Exit(0)
+	}
+
+	var result *transfer.Result
+	var err error
+	switch mode {
+	case modeSend:
+		if remoteDest == "" {
+			// ftu send SOURCE... (or ftu send -text TEXT) the usual way
+			result, err = transfer.SendTransport(ctx, nodeTransport, source, sendOptions)
+			break
+		}
+
+		// ftu send SOURCE... [user@]host:DEST
+		host, path, _ := parseRemotePath(remoteDest)
+		var conn net.Conn
+		conn, err = spawnRemote(ctx, host, append(forwardedFlags(remoteReceiverFlags), "-"+modeStdioReceive, path))
+		if err == nil {
+			result, err = transfer.SendConn(ctx, conn, source, sendOptions)
+		}
+
+	case modeReceive:
+		if len(modeArgs) == 0 {
+			// ftu receive -a host|-listen the usual way
+			result, err = transfer.ReceiveTransport(ctx, nodeTransport, *DOWNLOADS_DIR, receiveOptions)
+			break
+		}
+
+		// ftu receive [user@]host:SOURCE DEST. Asking for it is the consent
+		host, path, _ := parseRemotePath(modeArgs[0])
+		receiveOptions.OfferDecider = node.AcceptAll
+		var conn net.Conn
+		conn, err = spawnRemote(ctx, host, append(forwardedFlags(remoteSenderFlags), "-"+modeStdioSend, path))
+		if err == nil {
+			result, err = transfer.ReceiveConn(ctx, conn, modeArgs[1], receiveOptions)
+		}
+
+	case modeGet:
+		// ftu get host:PATH. Asking for it is the consent
+		receiveOptions.OfferDecider = node.AcceptAll
+		var client *browse.Client
+		client, err = browse.Dial(ctx, nodeTransport)
+		if err == nil {
+			result, err = client.Get(ctx, modeArgs[0], *DOWNLOADS_DIR, receiveOptions)
+			client.Close()
+		}
+
+	case modeBundle:
+		switch bundleAction {
+		case bundleCreate:
+			result, err = offline.Create(ctx, modeArgs[0], source, offline.CreateOptions{
+				SendOptions: sendOptions,
+				VolumeSize:  volumeSize,
+				Key:         bundleKey,
+			})
+		case bundleExtract:
+			// the bundle has been brought here to be extracted
+			receiveOptions.OfferDecider = node.AcceptAll
+			result, err = offline.Extract(ctx, modeArgs[0], *DOWNLOADS_DIR, offline.ExtractOptions{
+				ReceiveOptions: receiveOptions,
+				Key:            bundleKey,
+			})
+		case bundleVerify:
+			result, err = offline.Verify(ctx, modeArgs[0], bundleKey, options)
+		}
+		if result != nil && err != nil {
+			// problems with the bundle itself are not reported by the transfer
+			fmt.Fprintf(os.Stderr, "[ERROR] %s\n", err)
+		}
+
+	case modeStdioSend:
+		result, err = transfer.SendConn(ctx, transport.Stdio(), *STDIO_SEND, sendOptions)
+
+	case modeStdioReceive:
+		// the other side has been told what to send by the user
+		receiveOptions.OfferDecider = node.AcceptAll
+		result, err = transfer.ReceiveConn(ctx, transport.Stdio(), *STDIO_RECEIVE, receiveOptions)
+
+	default:
+		if isSending {
+			result, err = transfer.SendTransport(ctx, nodeTransport, source, sendOptions)
+		} else {
+			result, err = transfer.ReceiveTransport(ctx, nodeTransport, *DOWNLOADS_DIR, receiveOptions)
+		}
+	}
+	stopReloading()
+
+	if result == nil {
+		// could not even start
+		fmt.Fprintf(os.Stderr, "[ERROR] %s\n", err)
+		os.Exit(setupFailed)
 	}
 
-	node.Start()
+	os.Exit(exitCodes[result.Status])
 }
@@ -0,0 +1,199 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// Lets the test binary run as ftu itself, so it can be started through the remote shell
+func TestMain(m *testing.M) {
+	if os.Getenv("FTU_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func Test_ParseRemotePath(t *testing.T) {
+	cases := []struct {
+		arg      string
+		host     string
+		path     string
+		isRemote bool
+	}{
+		{"host:/dest", "host", "/dest", true},
+		{"user@host:dir", "user@host", "dir", true},
+		{"host:", "host", ".", true},
+		{"host:dir with spaces", "host", "dir with spaces", true},
+		{"host:it's \"quoted\"", "host", "it's \"quoted\"", true},
+		{"host:a:b", "host", "a:b", true},
+		{"file.txt", "", "", false},
+		{":dir", "", "", false},
+		{"./dir:name", "", "", false},
+		{"/tmp/dir:name", "", "", false},
+	}
+
+	for _, testCase := range cases {
+		host, path, isRemote := parseRemotePath(testCase.arg)
+		if host != testCase.host || path != testCase.path || isRemote != testCase.isRemote {
+			t.Fatalf("\"%s\": expected (\"%s\", \"%s\", %v); got (\"%s\", \"%s\", %v)",
+				testCase.arg, testCase.host, testCase.path, testCase.isRemote, host, path, isRemote)
+		}
+	}
+}
+
+func Test_ShellQuote(t *testing.T) {
+	cases := []struct {
+		arg    string
+		quoted string
+	}{
+		{"", "''"},
+		{"/home/user/file.txt", "/home/user/file.txt"},
+		{"-on-conflict=keep-both", "-on-conflict=keep-both"},
+		{"dir with spaces", "'dir with spaces'"},
+		{"it's", `'it'\''s'`},
+		{`"double"`, `'"double"'`},
+		{"$HOME;rm -rf *", "'$HOME;rm -rf *'"},
+		{"new\nline", "'new\nline'"},
+	}
+
+	for _, testCase := range cases {
+		quoted := shellQuote(testCase.arg)
+		if quoted != testCase.quoted {
+			t.Fatalf("\"%s\": expected %s; got %s", testCase.arg, testCase.quoted, quoted)
+		}
+	}
+
+	// the shell gets back exactly what has been quoted
+	if runtime.GOOS == "windows" {
+		return
+	}
+	for _, testCase := range cases {
+		output, err := exec.Command("sh", "-c", "printf %s "+shellQuote(testCase.arg)).Output()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if string(output) != testCase.arg {
+			t.Fatalf("expected the shell to get \"%s\"; got \"%s\"", testCase.arg, output)
+		}
+	}
+}
+
+func Test_SetupFailed(t *testing.T) {
+	ftu, err := os.Executable()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	cases := [][]string{
+		{"-json", "-s", filepath.Join(t.TempDir(), "missing")},
+		{"-json", "-limit", "0.5", "-s", "."},
+		{"-json", "send"},
+		{"-json", "-a", "localhost", "-o", filepath.Join(t.TempDir(), "missing", "file")},
+	}
+
+	for _, args := range cases {
+		var stdout, stderr bytes.Buffer
+		command := exec.Command(ftu, args...)
+		command.Env = append(os.Environ(), "FTU_TEST_MAIN=1")
+		command.Stdout = &stdout
+		command.Stderr = &stderr
+		err := command.Run()
+
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok || exitErr.ExitCode() != setupFailed {
+			t.Fatalf("%v: expected exit code %d, got %v\n%s", args, setupFailed, err, stderr.Bytes())
+		}
+		if stdout.Len() != 0 {
+			t.Fatalf("%v: the error ended up among the JSON events: %q", args, stdout.Bytes())
+		}
+		if !bytes.Contains(stderr.Bytes(), []byte("[ERROR]")) {
+			t.Fatalf("%v: no error has been reported: %q", args, stderr.Bytes())
+		}
+	}
+}
+
+func Test_SendRemote(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake remote shell is a shell script")
+	}
+
+	ftu, err := os.Executable()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	source := filepath.Join(t.TempDir(), "dir")
+	files := map[string]string{
+		"a.txt":              "aaaaa",
+		"sub/with space.txt": "bbbbbbbbbb",
+		"sub/it's.txt":       "c",
+	}
+	for path, contents := range files {
+		fullPath := filepath.Join(source, path)
+		err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		err = os.WriteFile(fullPath, []byte(contents), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	// stands for ssh: makes sure the host is not taken for an option and runs the command line "on the host"
+	shell := filepath.Join(t.TempDir(), "rsh")
+	script := "#!/bin/sh\n[ \"$1\" = \"--\" ] && [ \"$2\" = \"host\" ] || exit 100\nshift 2\nexec sh -c \"$*\"\n"
+	err = os.WriteFile(shell, []byte(script), 0700)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	destination := filepath.Join(t.TempDir(), "dest with space")
+	err = os.Mkdir(destination, os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	command := exec.Command(ftu, "send", "-r", "-remote-ftu="+ftu, source, "host:"+destination)
+	command.Env = append(os.Environ(), "FTU_TEST_MAIN=1", "FTU_RSH="+shell)
+	output, err := command.CombinedOutput()
+	if err != nil {
+		t.Fatalf("sending to the remote destination failed: %s\n%s", err, output)
+	}
+
+	for path, contents := range files {
+		received, err := os.ReadFile(filepath.Join(destination, "dir", path))
+		if err != nil {
+			t.Fatalf("\"%s\" has not been received: %s\n%s", path, err, output)
+		}
+		if !bytes.Equal(received, []byte(contents)) {
+			t.Fatalf("\"%s\" differs from the sent one", path)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package node
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/progress"
+	"unbewohnte/ftu/protocol"
+)
+
+var ErrorNoArchiveOutput error = fmt.Errorf("the archive has nowhere to be written: no writer")
+
+// Returns the name of the received entry in the archive. Entries of a directory
+// are placed inside of it, the same way they would be in the downloads folder
+func (receiving *receiving) archivedPath(relPath string) string {
+	return path.Join(receiving.ArchiveRoot, filepath.ToSlash(relPath))
+}
+
+// Starts the archive with the offered directory itself, so it is there even if it is empty
+func (node *Node) archiveDirectory(dir *fsys.Directory) error {
+	if dir.Bundle {
+		// entries of a bundle are at the top level
+		return nil
+	}
+
+	node.transferInfo.Receiving.ArchiveRoot = dir.Name
+
+	return node.transferInfo.Receiving.Archive.Directory(dir.Name, time.Time{})
+}
+
+// Writes the received symlink into the archive. The target is made relative to the
+// directory of the symlink, so the link keeps pointing at the right place wherever the archive is unpacked
+func (node *Node) archiveSymlink(symlink *fsys.Symlink) error {
+	target, err := filepath.Rel(filepath.Dir(symlink.Path), symlink.TargetPath)
+	if err != nil {
+		return err
+	}
+
+	return node.transferInfo.Receiving.Archive.Symlink(
+		node.transferInfo.Receiving.archivedPath(symlink.Path),
+		filepath.ToSlash(target),
+	)
+}
+
+// Creates the received empty directory in the downloads folder, the storage or the archive
+func (node *Node) receiveEmptyDirectory(packet *protocol.Packet) {
+	location, err := protocol.DecodeEmptyDirectoryPacket(packet)
+	if errors.Is(err, fsys.ErrorUnsafePath) {
+		node.abortOnSecurityViolation(err)
+		return
+	}
+	if err != nil {
+		node.fail(err)
+		return
+	}
+
+	if node.transferInfo.Receiving.Archive != nil {
+		location = node.transferInfo.Receiving.archivedPath(location)
+
+		err = node.transferInfo.Receiving.Archive.Directory(location, time.Time{})
+		if err != nil {
+			node.abortOnArchiveError(location, err)
+		}
+		return
+	}
+
+	location = node.transferInfo.Receiving.renamed(location)
+
+	if node.transferInfo.Receiving.Storage != nil {
+		err = node.transferInfo.Receiving.Storage.Mkdir(node.transferInfo.Receiving.storagePath(location))
+	} else {
+		err = node.transferInfo.Receiving.Sandbox.MkdirAll(location)
+	}
+	if errors.Is(err, fsys.ErrorUnsafePath) {
+		node.abortOnSecurityViolation(err)
+		return
+	}
+	if err != nil {
+		node.reporter.Printf("[ERROR] Could not create an empty directory \"%s\": %s", location, err)
+		return
+	}
+
+	if node.verboseOutput {
+		node.reporter.Printf("[Directory] created empty \"%s\"", location)
+	}
+}
+
+// Stops the receiving node because the archive can not be written
+func (node *Node) abortOnArchiveError(entry string, err error) {
+	node.reporter.Printf("[ERROR] Could not write \"%s\" into the archive: %s. Aborting the transfer", entry, err)
+	node.reporter.Error(progress.ErrorWrite, entry, err.Error())
+
+	node.mutex.Lock()
+	if node.outcome.err == nil {
+		node.outcome.err = err
+	}
+	node.outcome.aborted = true
+	node.stopped = true
+	node.mutex.Unlock()
+}
@@ -0,0 +1,96 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package node
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"unbewohnte/ftu/fsys"
+)
+
+// What to do with an existing file before replacing it with the received one
+type BackupPolicy string
+
+const (
+	BackupNone     BackupPolicy = "none"     // replaced files are lost
+	BackupNumbered BackupPolicy = "numbered" // keep replaced files next to the new ones as "name.~N~"
+	BackupDated    BackupPolicy = "dated"    // move replaced files into a dated directory inside BackupsDirName
+)
+
+// A directory in the downloads folder where dated backups are kept
+const BackupsDirName string = ".ftu-backups"
+
+// Converts a string into BackupPolicy. An empty string means BackupNone
+func ParseBackupPolicy(policy string) (BackupPolicy, error) {
+	switch BackupPolicy(policy) {
+	case "":
+		return BackupNone, nil
+	case BackupNone, BackupNumbered, BackupDated:
+		return BackupPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("%w: \"%s\"", ErrorUnknownPolicy, policy)
+	}
+}
+
+// Keeps the existing file at fileRelPath according to the backup policy so that
+// it can be replaced by the received one
+func (node *Node) backupReplacedFile(fileRelPath string) error {
+	receiving := node.transferInfo.Receiving
+
+	var backupPath string
+	switch receiving.Backup {
+	case BackupNumbered:
+		backupRelPath, err := fsys.BackupNumbered(receiving.Sandbox, fileRelPath, receiving.BackupKeep)
+		if err != nil {
+			return err
+		}
+		backupPath = receiving.Sandbox.Path(backupRelPath)
+
+	case BackupDated:
+		// every session gets its own directory
+		if receiving.BackupSessionDir == "" {
+			receiving.BackupSessionDir = filepath.Join(BackupsDirName, time.Now().Format("2006-01-02_15-04-05"))
+		}
+
+		// backups are made in the downloads folder itself, even when receiving into a directory inside of it
+		backupRelPath, err := fsys.BackupToDir(receiving.DownloadsSandbox, filepath.Join(receiving.TransferDir, fileRelPath), receiving.BackupSessionDir)
+		if err != nil {
+			return err
+		}
+		backupPath = receiving.DownloadsSandbox.Path(backupRelPath)
+
+		err = fsys.PruneBackupDirs(receiving.DownloadsSandbox, BackupsDirName, receiving.BackupKeep)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return nil
+	}
+
+	if node.verboseOutput {
+		fmt.Fprintf(node.output, "\n[File] backed up replaced \"%s\" to \"%s\"", receiving.Sandbox.Path(fileRelPath), backupPath)
+	}
+
+	return nil
+}
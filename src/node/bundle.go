@@ -0,0 +1,104 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/progress"
+)
+
+var ErrorFSBundle error = fmt.Errorf("several paths can not be sent together from a filesystem other than the OS one")
+
+// Returns how the offered directory or bundle is called in messages and events
+func offerName(dir *fsys.Directory) string {
+	if dir.Bundle {
+		return strings.Join(dir.EntryNames(), ", ")
+	}
+	return dir.Name
+}
+
+// Prints the manifest of the offered bundle
+func (node *Node) printBundle(bundle *fsys.Directory) {
+	fmt.Fprintf(node.output, "\n| Entries: %d\n", len(bundle.Files)+len(bundle.Directories))
+	for _, file := range bundle.Files {
+		fmt.Fprintf(node.output, "|   %s (%s)\n", file.Name, progress.FormatSize(file.Size))
+	}
+	for _, dir := range bundle.Directories {
+		fmt.Fprintf(node.output, "|   %s/ (%s)\n", dir.Name, progress.FormatSize(dir.Size))
+	}
+	fmt.Fprintf(node.output, "| Size: %s\n", progress.FormatSize(bundle.Size))
+}
+
+// Resolves conflicts of the bundle directories that already exist in the downloads folder (or in the storage)
+// the same way as of an offered directory. Returns false if the transfer must be rejected
+func (node *Node) resolveBundleConflicts(bundle *fsys.Directory) (bool, error) {
+	receiving := node.transferInfo.Receiving
+
+	for _, dir := range bundle.Directories {
+		exists, err := receiving.exists(dir.Name)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			continue
+		}
+
+		switch node.resolveDirConflict(dir) {
+		case DirConflictReject:
+			fmt.Fprintf(node.output, "\nDirectory \"%s\" already exists", dir.Name)
+			return false, nil
+
+		case DirConflictKeepBoth:
+			newName, err := freeNumberedName(receiving.exists, dir.Name)
+			if err != nil {
+				return false, err
+			}
+
+			if receiving.Renamed == nil {
+				receiving.Renamed = make(map[string]string)
+			}
+			receiving.Renamed[dir.Name] = newName
+			fmt.Fprintf(node.output, "\nDirectory \"%s\" already exists. Downloading it into \"%s\"", dir.Name, newName)
+		}
+	}
+
+	return true, nil
+}
+
+// Returns where the entry with the relative path goes, taking renamed bundle directories into account
+func (receiving *receiving) renamed(relPath string) string {
+	if len(receiving.Renamed) == 0 {
+		return relPath
+	}
+
+	entry, rest := relPath, ""
+	if separator := strings.IndexAny(relPath, "/\\"); separator != -1 {
+		entry, rest = relPath[:separator], relPath[separator:]
+	}
+
+	if newName, ok := receiving.Renamed[entry]; ok {
+		return newName + rest
+	}
+	return relPath
+}
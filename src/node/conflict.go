@@ -0,0 +1,206 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package node
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"unbewohnte/ftu/fsys"
+)
+
+// What to do when a received file conflicts with an existing one
+type ConflictPolicy string
+
+const (
+	ConflictOverwrite        ConflictPolicy = "overwrite"          // replace the existing file
+	ConflictSkip             ConflictPolicy = "skip"               // keep the existing file and do not receive the new one
+	ConflictKeepBoth         ConflictPolicy = "keep-both"          // receive the new file under a numbered name
+	ConflictOverwriteIfNewer ConflictPolicy = "overwrite-if-newer" // replace only if the sender`s file has been modified later
+	ConflictAsk              ConflictPolicy = "ask"                // ask for every conflicting file
+)
+
+// What to do when the offered directory already exists in the downloads folder
+type DirConflictPolicy string
+
+const (
+	DirConflictMerge    DirConflictPolicy = "merge"     // receive into the existing directory, resolving each file with ConflictPolicy
+	DirConflictKeepBoth DirConflictPolicy = "keep-both" // receive into a new numbered directory
+	DirConflictReject   DirConflictPolicy = "reject"    // reject the transfer
+	DirConflictAsk      DirConflictPolicy = "ask"       // ask what to do
+)
+
+var ErrorUnknownPolicy error = fmt.Errorf("unknown conflict policy")
+
+// Decides on conflicts when the policy is to ask
+type ConflictDecider interface {
+	// Decides what to do with the offered file that differs from the existing one.
+	// Returns ConflictOverwrite, ConflictSkip or ConflictKeepBoth
+	DecideFile(file *fsys.File, existing os.FileInfo) ConflictPolicy
+
+	// Decides what to do with the offered directory that already exists.
+	// Returns DirConflictMerge, DirConflictKeepBoth or DirConflictReject
+	DecideDir(dir *fsys.Directory) DirConflictPolicy
+}
+
+func (prompt *Prompt) DecideFile(file *fsys.File, existing os.FileInfo) ConflictPolicy {
+	for {
+		var answer string
+		fmt.Fprintf(prompt.Output, "\n| \"%s\" already exists (%d bytes, modified %s)", file.Path, existing.Size(), existing.ModTime().Format("2006-01-02 15:04:05"))
+		if !file.ModTime.IsZero() {
+			fmt.Fprintf(prompt.Output, "\n| Offered: %d bytes, modified %s", file.Size, file.ModTime.Format("2006-01-02 15:04:05"))
+		}
+		fmt.Fprintf(prompt.Output, "\n| [o]verwrite, [s]kip or [k]eep both ? [o/s/k]: ")
+		_, err := fmt.Fscanln(prompt.input(), &answer)
+		if err == io.EOF {
+			// nobody is there to answer
+			return ConflictSkip
+		}
+
+		switch strings.ToLower(answer) {
+		case "o", "":
+			return ConflictOverwrite
+		case "s":
+			return ConflictSkip
+		case "k":
+			return ConflictKeepBoth
+		}
+	}
+}
+
+func (prompt *Prompt) DecideDir(dir *fsys.Directory) DirConflictPolicy {
+	for {
+		var answer string
+		fmt.Fprintf(prompt.Output, "| Directory \"%s\" already exists\n", dir.Name)
+		fmt.Fprintf(prompt.Output, "| [m]erge, [k]eep both or [r]eject ? [m/k/r]: ")
+		_, err := fmt.Fscanln(prompt.input(), &answer)
+		if err == io.EOF {
+			return DirConflictReject
+		}
+
+		switch strings.ToLower(answer) {
+		case "m", "":
+			return DirConflictMerge
+		case "k":
+			return DirConflictKeepBoth
+		case "r":
+			return DirConflictReject
+		}
+	}
+}
+
+// Converts a string into ConflictPolicy. An empty string means ConflictOverwrite
+func ParseConflictPolicy(policy string) (ConflictPolicy, error) {
+	switch ConflictPolicy(policy) {
+	case "":
+		return ConflictOverwrite, nil
+	case ConflictOverwrite, ConflictSkip, ConflictKeepBoth, ConflictOverwriteIfNewer, ConflictAsk:
+		return ConflictPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("%w: \"%s\"", ErrorUnknownPolicy, policy)
+	}
+}
+
+// Converts a string into DirConflictPolicy. An empty string means DirConflictMerge
+func ParseDirConflictPolicy(policy string) (DirConflictPolicy, error) {
+	switch DirConflictPolicy(policy) {
+	case "":
+		return DirConflictMerge, nil
+	case DirConflictMerge, DirConflictKeepBoth, DirConflictReject, DirConflictAsk:
+		return DirConflictPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("%w: \"%s\"", ErrorUnknownPolicy, policy)
+	}
+}
+
+// Decides what to do with the received file that differs from the existing one.
+// Never returns ConflictAsk or ConflictOverwriteIfNewer
+func (node *Node) resolveFileConflict(file *fsys.File, existing os.FileInfo) ConflictPolicy {
+	switch node.transferInfo.Receiving.OnConflict {
+	case ConflictSkip, ConflictKeepBoth:
+		return node.transferInfo.Receiving.OnConflict
+
+	case ConflictOverwriteIfNewer:
+		if file.ModTime.IsZero() || file.ModTime.After(existing.ModTime()) {
+			return ConflictOverwrite
+		}
+		return ConflictSkip
+
+	case ConflictAsk:
+		decider := node.transferInfo.Receiving.ConflictDecider
+		if decider == nil {
+			// nobody to ask, so the existing file stays as it is
+			node.reporter.Printf("[WARNING] Nobody to ask about the conflicting \"%s\". Skipping it", file.Path)
+			return ConflictSkip
+		}
+
+		switch policy := decider.DecideFile(file, existing); policy {
+		case ConflictOverwrite, ConflictKeepBoth:
+			return policy
+		default:
+			return ConflictSkip
+		}
+
+	default:
+		return ConflictOverwrite
+	}
+}
+
+// Decides what to do with the offered directory that already exists.
+// Never returns DirConflictAsk
+func (node *Node) resolveDirConflict(dir *fsys.Directory) DirConflictPolicy {
+	if node.transferInfo.Receiving.OnDirConflict != DirConflictAsk {
+		return node.transferInfo.Receiving.OnDirConflict
+	}
+
+	decider := node.transferInfo.Receiving.ConflictDecider
+	if decider == nil {
+		node.reporter.Printf("[WARNING] Nobody to ask about the existing directory \"%s\". Rejecting it", dir.Name)
+		return DirConflictReject
+	}
+
+	switch policy := decider.DecideDir(dir); policy {
+	case DirConflictMerge, DirConflictKeepBoth:
+		return policy
+	default:
+		return DirConflictReject
+	}
+}
+
+// Returns the first numbered variant of relPath that does not exist according to exists
+func freeNumberedName(exists func(relPath string) (bool, error), relPath string) (string, error) {
+	var number uint = 1
+	for {
+		candidate := fsys.NumberedName(relPath, number)
+
+		exists, err := exists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+
+		number++
+	}
+}
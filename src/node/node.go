@@ -22,53 +22,101 @@ package node
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 
 	"fmt"
+	"io"
+	"io/fs"
 
 	"unbewohnte/ftu/addr"
+	"unbewohnte/ftu/archive"
 	"unbewohnte/ftu/checksum"
 	"unbewohnte/ftu/encryption"
 	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/limit"
+	"unbewohnte/ftu/progress"
 	"unbewohnte/ftu/protocol"
+	"unbewohnte/ftu/storage"
+	"unbewohnte/ftu/transport"
 )
 
 // netInfowork specific settings
 type netInfo struct {
-	ConnAddr      string   // address to connect to. Does not include port
-	Conn          net.Conn // the core TCP connection of the node. Self-explanatory
-	Port          uint     // a port to connect to/listen on
-	EncryptionKey []byte   // if != nil - incoming packets will be decrypted with it and outcoming packets will be encrypted
+	Transport      transport.Transport // how to connect to|wait for the other node
+	Listening      bool                // wait for the other node instead of connecting to it
+	Conn           net.Conn            // the core connection of the node. Self-explanatory
+	EncryptionKey  []byte              // if != nil - incoming packets will be decrypted with it and outcoming packets will be encrypted
+	SendLimiter    *limit.Limiter      // if != nil - outgoing traffic is limited by it
+	ReceiveLimiter *limit.Limiter      // if != nil - incoming traffic is limited by it
 }
 
 // Sending-side node information
 type sending struct {
-	ServingPath         string // path to the thing that will be sent
-	IsDirectory         bool   // is ServingPath a directory
-	Recursive           bool   // recursively send directory
-	CanSendBytes        bool   // is the other node ready to receive another piece
-	AllowedToTransfer   bool   // the way to notify the mainloop of a sending node to start sending pieces of files
-	InTransfer          bool   // already transferring|receiving files
+	ServingPath         string   // path to the thing that will be sent
+	ServingPaths        []string // paths to the entries of the bundle if several things will be sent
+	IsDirectory         bool     // is ServingPath a directory (always true for a bundle)
+	Recursive           bool     // recursively send directory
+	FollowSymlinks      bool     // send what symlinks point to instead of symlinks themselves
+	MaxDepth            uint     // how deep to descend into the directory. 0 means no limit
+	Text                string   // the text message to send instead of files. Empty if files are sent
+	CanSendBytes        bool     // is the other node ready to receive another piece
+	AllowedToTransfer   bool     // the way to notify the mainloop of a sending node to start sending pieces of files
+	InTransfer          bool     // already transferring|receiving files
 	FilesToSend         []*fsys.File
 	SymlinksToSend      []*fsys.Symlink
 	CurrentFileID       uint64 // an id of a file that is currently being transported
 	SentBytes           uint64 // how many bytes sent already
 	TotalTransferSize   uint64 // how many bytes will be sent in total
 	CurrentSymlinkIndex uint64 // current index of a symlink that is
+
+	Stream         io.Reader        // what is sent as a file of unknown size instead of ServingPath. Nil if files are sent
+	StreamChecksum *checksum.Writer // computes the checksum of Stream as it is read
+
+	EmptyDirsToSend []string // locations of the directories with nothing in them, sent right before DONE
+
+	FS     fs.FS  // where ServingPath is instead of the OS filesystem. Nil if there
+	FSName string // the name of the directory all of FS is sent as
 }
 
 // Receiving-side node information
 type receiving struct {
-	AcceptedFiles     []*fsys.File // files that`ve been accepted to be received
-	DownloadsPath     string       // where to download
-	TotalDownloadSize uint64       // how many bytes will be received in total
-	ReceivedBytes     uint64       // how many bytes downloaded so far
+	AcceptedFiles     []*fsys.File      // files that`ve been accepted to be received
+	DownloadsPath     string            // where to download
+	Sandbox           *fsys.Sandbox     // opened DownloadsPath. Everything received is created through it
+	DownloadsSandbox  *fsys.Sandbox     // opened downloads folder itself, even when receiving into a directory inside of it
+	TransferDir       string            // path of Sandbox relative to DownloadsSandbox. Empty if they are the same
+	Backup            BackupPolicy      // what to do with files before replacing them
+	BackupKeep        uint              // how many backups to keep. 0 means keep all
+	BackupSessionDir  string            // where dated backups of this session go. Set when the first backup is made
+	IgnoreFreeSpace   bool              // only warn instead of rejecting a transfer that does not fit
+	OfferDecider      OfferDecider      // decides whether to accept the offer
+	ConflictDecider   ConflictDecider   // decides on conflicts when asked to. Nil if nobody can be asked
+	OnConflict        ConflictPolicy    // what to do with a file that already exists and differs
+	OnDirConflict     DirConflictPolicy // what to do when the offered directory already exists
+	Resume            bool              // continue receiving files from leftover partial files
+	CleanPartial      bool              // remove leftover partial files before receiving
+	Renamed           map[string]string // directories of the bundle that are received under other names
+	TextFile          string            // where to append received text messages as well. Empty if nowhere
+	Writer            io.Writer         // where the received file goes instead of the downloads folder. Nil if there
+	Streamed          bool              // the offered file is a stream, which size and checksum are sent in the end
+	Checksum          *checksum.Writer  // computes the checksum of the file that is written as it comes: a stream or a file written to Writer
+	TotalDownloadSize uint64            // how many bytes will be received in total
+	ReceivedBytes     uint64            // how many bytes downloaded so far
+
+	Archive      *archive.Writer // where everything received goes, written into Writer. Nil if not archived
+	ArchiveRoot  string          // the directory in the archive everything received is placed into. Empty for the top level
+	ArchiveEntry io.Writer       // where the contents of the file that is being received go in the archive
+
+	Storage     storage.Storage // where everything received goes instead of Sandbox. Nil if into the downloads folder
+	StorageRoot string          // the directory in Storage everything is received into
+	StorageFile storage.File    // the file that is being received into Storage
 }
 
 // Both sending-side and receiving-side information
@@ -86,13 +134,50 @@ type Node struct {
 	stopped       bool                  // the way to exit the mainloop in case of an external error or a successful end of a transfer
 	netInfo       *netInfo
 	transferInfo  *transferInfo
+	reporter      *progress.Reporter // shows the progress of the transfer
+	output        io.Writer          // where human-readable messages go
+	outcome       outcome
 }
 
 // Creates a new either a sending or receiving node with specified options
 func NewNode(options *NodeOptions) (*Node, error) {
 	var isDir bool
-	if options.IsSending {
+	if options.IsSending && (options.SenderSide.Text != "" || options.SenderSide.Stream != nil) {
+		// sending node preparation for a text message or a stream. There is nothing to look at
+	} else if options.IsSending && options.SenderSide.FS != nil {
+		// sending node preparation for a file|directory in the filesystem
+		if len(options.SenderSide.ServingPaths) > 1 {
+			return nil, ErrorFSBundle
+		}
+		if len(options.SenderSide.ServingPaths) == 1 {
+			options.SenderSide.ServingPath = options.SenderSide.ServingPaths[0]
+			options.SenderSide.ServingPaths = nil
+		}
+		if options.SenderSide.FSName == "" {
+			options.SenderSide.FSName = "files"
+		}
+
+		stats, err := fs.Stat(options.SenderSide.FS, options.SenderSide.ServingPath)
+		if err != nil {
+			return nil, err
+		}
+		isDir = stats.IsDir()
+	} else if options.IsSending && len(options.SenderSide.ServingPaths) > 1 {
+		// sending node preparation for a bundle
+		for _, path := range options.SenderSide.ServingPaths {
+			_, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+		}
+		isDir = true
+	} else if options.IsSending {
 		// sending node preparation
+		if len(options.SenderSide.ServingPaths) == 1 {
+			options.SenderSide.ServingPath = options.SenderSide.ServingPaths[0]
+			options.SenderSide.ServingPaths = nil
+		}
+
 		sendingPathStats, err := os.Stat(options.SenderSide.ServingPath)
 		if err != nil {
 			return nil, err
@@ -105,6 +190,31 @@ func NewNode(options *NodeOptions) (*Node, error) {
 		case false:
 			isDir = false
 		}
+	} else if options.ReceiverSide.Storage != nil {
+		// receiving node preparation for a storage. The downloads folder is a directory in it
+		if options.ReceiverSide.Writer != nil {
+			return nil, ErrorStorageWriter
+		}
+		if options.ReceiverSide.Resume || options.ReceiverSide.CleanPartial ||
+			(options.ReceiverSide.Backup != "" && options.ReceiverSide.Backup != BackupNone) {
+			return nil, ErrorStorageUnsupported
+		}
+
+		var err error
+		options.ReceiverSide.DownloadsFolderPath, err = storageRoot(options.ReceiverSide.DownloadsFolderPath)
+		if err != nil {
+			return nil, err
+		}
+
+		options.ReceiverSide.OnConflict, err = ParseConflictPolicy(string(options.ReceiverSide.OnConflict))
+		if err != nil {
+			return nil, err
+		}
+
+		options.ReceiverSide.OnDirConflict, err = ParseDirConflictPolicy(string(options.ReceiverSide.OnDirConflict))
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		// receiving node preparation
 		var err error
@@ -118,6 +228,80 @@ func NewNode(options *NodeOptions) (*Node, error) {
 			return nil, err
 		}
 
+		options.ReceiverSide.OnConflict, err = ParseConflictPolicy(string(options.ReceiverSide.OnConflict))
+		if err != nil {
+			return nil, err
+		}
+
+		options.ReceiverSide.OnDirConflict, err = ParseDirConflictPolicy(string(options.ReceiverSide.OnDirConflict))
+		if err != nil {
+			return nil, err
+		}
+
+		options.ReceiverSide.Backup, err = ParseBackupPolicy(string(options.ReceiverSide.Backup))
+		if err != nil {
+			return nil, err
+		}
+
+		if options.ReceiverSide.Archive != "" {
+			if options.ReceiverSide.Writer == nil {
+				return nil, ErrorNoArchiveOutput
+			}
+
+			options.ReceiverSide.Archive, err = archive.ParseFormat(string(options.ReceiverSide.Archive))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var output io.Writer = os.Stdout
+	if options.Output != nil {
+		output = options.Output
+	}
+
+	var reporter *progress.Reporter
+	if options.Events != nil {
+		reporter = progress.NewReporter(options.Events, progress.FormatJSON)
+	} else if outputFile, ok := output.(*os.File); ok && progress.IsTerminal(outputFile) {
+		reporter = progress.NewReporter(output, progress.FormatLive)
+	} else {
+		reporter = progress.NewReporter(output, progress.FormatPlain)
+	}
+
+	if options.Observer != nil {
+		reporter.Observe(options.Observer)
+	}
+
+	// the sender listens unless the roles are reversed
+	listening := options.IsSending != options.Reverse
+
+	nodeTransport := options.Transport
+	if nodeTransport == nil {
+		port := options.WorkingPort
+		if port == 0 {
+			port = 7270
+		}
+
+		if listening {
+			nodeTransport = &transport.TCP{Address: net.JoinHostPort(options.SenderSide.ListenAddr, fmt.Sprint(port))}
+		} else {
+			nodeTransport = &transport.TCP{Address: net.JoinHostPort(options.ReceiverSide.ConnectionAddr, fmt.Sprint(port))}
+		}
+	}
+
+	var offerDecider OfferDecider = options.ReceiverSide.OfferDecider
+	if offerDecider == nil {
+		offerDecider = &Prompt{Output: output}
+	}
+
+	var archiveWriter *archive.Writer
+	if !options.IsSending && options.ReceiverSide.Archive != "" {
+		var err error
+		archiveWriter, err = archive.NewWriter(options.ReceiverSide.Writer, options.ReceiverSide.Archive)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	node := Node{
@@ -126,16 +310,27 @@ func NewNode(options *NodeOptions) (*Node, error) {
 		packetPipe:    make(chan *protocol.Packet, 100),
 		isSending:     options.IsSending,
 		netInfo: &netInfo{
-			Port:          options.WorkingPort,
-			ConnAddr:      options.ReceiverSide.ConnectionAddr,
-			EncryptionKey: nil,
-			Conn:          nil,
+			Transport:      nodeTransport,
+			Listening:      listening,
+			EncryptionKey:  nil,
+			Conn:           options.Conn,
+			SendLimiter:    options.SendLimiter,
+			ReceiveLimiter: options.ReceiveLimiter,
 		},
-		stopped: false,
+		stopped:  false,
+		reporter: reporter,
+		output:   output,
 		transferInfo: &transferInfo{
 			Sending: &sending{
 				ServingPath:       options.SenderSide.ServingPath,
+				ServingPaths:      options.SenderSide.ServingPaths,
 				Recursive:         options.SenderSide.Recursive,
+				FollowSymlinks:    options.SenderSide.FollowSymlinks,
+				MaxDepth:          options.SenderSide.MaxDepth,
+				Text:              options.SenderSide.Text,
+				Stream:            options.SenderSide.Stream,
+				FS:                options.SenderSide.FS,
+				FSName:            options.SenderSide.FSName,
 				IsDirectory:       isDir,
 				TotalTransferSize: 0,
 				SentBytes:         0,
@@ -143,6 +338,20 @@ func NewNode(options *NodeOptions) (*Node, error) {
 			Receiving: &receiving{
 				AcceptedFiles:     nil,
 				DownloadsPath:     options.ReceiverSide.DownloadsFolderPath,
+				OnConflict:        options.ReceiverSide.OnConflict,
+				OnDirConflict:     options.ReceiverSide.OnDirConflict,
+				Resume:            options.ReceiverSide.Resume,
+				CleanPartial:      options.ReceiverSide.CleanPartial,
+				Backup:            options.ReceiverSide.Backup,
+				BackupKeep:        options.ReceiverSide.BackupKeep,
+				IgnoreFreeSpace:   options.ReceiverSide.IgnoreFreeSpace,
+				OfferDecider:      offerDecider,
+				ConflictDecider:   options.ReceiverSide.ConflictDecider,
+				TextFile:          options.ReceiverSide.TextFile,
+				Writer:            options.ReceiverSide.Writer,
+				Archive:           archiveWriter,
+				Storage:           options.ReceiverSide.Storage,
+				StorageRoot:       options.ReceiverSide.DownloadsFolderPath,
 				ReceivedBytes:     0,
 				TotalDownloadSize: 0,
 			},
@@ -151,22 +360,24 @@ func NewNode(options *NodeOptions) (*Node, error) {
 	return &node, nil
 }
 
-// Connect node to another listening one with a pre-defined address&&port
-func (node *Node) connect() error {
-	if node.netInfo.Port == 0 {
-		node.netInfo.Port = 7270
-	}
+// Connect node to another listening one through the transport
+// or use the already established connection
+func (node *Node) connect(ctx context.Context) error {
+	conn := node.netInfo.Conn
+	if conn == nil {
+		fmt.Fprintf(node.output, "\nConnecting to %s...", node.netInfo.Transport)
 
-	fmt.Printf("\nConnecting to %s:%d...", node.netInfo.ConnAddr, node.netInfo.Port)
+		var err error
+		conn, err = node.netInfo.Transport.Dial(ctx)
+		if err != nil {
+			return err
+		}
 
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", node.netInfo.ConnAddr, node.netInfo.Port), time.Second*5)
-	if err != nil {
-		return err
+		fmt.Fprintf(node.output, "\nConnected")
 	}
 
-	fmt.Printf("\nConnected")
-
-	node.netInfo.Conn = conn
+	node.reporter.Connected(node.remoteName(conn))
+	node.setConn(node.limitConn(conn))
 
 	return nil
 }
@@ -178,161 +389,609 @@ func (node *Node) disconnect() error {
 		err := protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
 			Header: protocol.HeaderDisconnecting,
 		})
+
+		// close it anyway, the other node might have gone already
+		closeErr := node.netInfo.Conn.Close()
+		if err == nil {
+			err = closeErr
+		}
+
+		node.mutex.Lock()
+		node.stopped = true
+		node.mutex.Unlock()
+
 		if err != nil {
 			return err
 		}
+	}
 
-		err = node.netInfo.Conn.Close()
+	return nil
+}
+
+// Wait for a connection through the transport or use the already established connection
+func (node *Node) waitForConnection(ctx context.Context) error {
+	connection := node.netInfo.Conn
+	if connection == nil {
+		listener, err := node.netInfo.Transport.Listen(ctx)
 		if err != nil {
 			return err
 		}
+		defer listener.Close()
+
+		// stop waiting when the context is done
+		accepted := make(chan struct{})
+		defer close(accepted)
+		go func() {
+			select {
+			case <-ctx.Done():
+				listener.Close()
+			case <-accepted:
+			}
+		}()
 
-		node.stopped = true
+		// accept only one conneciton
+		connection, err = listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		fmt.Fprintf(node.output, "\nNew connection from %s", node.remoteName(connection))
 	}
 
+	node.reporter.Connected(node.remoteName(connection))
+	node.setConn(node.limitConn(connection))
+
 	return nil
 }
 
-// Wait for a connection on a pre-defined port
-func (node *Node) waitForConnection() error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", node.netInfo.Port))
+// Waits for the other node or connects to it, depending on the role of the node
+func (node *Node) establishConnection(ctx context.Context) error {
+	if node.netInfo.Listening {
+		return node.waitForConnection(ctx)
+	}
+
+	err := node.connect(ctx)
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("could not connect to %s: %w", node.netInfo.Transport, err)
+	}
+
+	return err
+}
+
+// Returns where the listening node can be reached, suitable for printing
+func (node *Node) listenDescription() string {
+	listenAddr := node.netInfo.Transport.String()
+
+	_, isTCP := node.netInfo.Transport.(*transport.TCP)
+	if host, port, err := net.SplitHostPort(listenAddr); isTCP && err == nil && host == "" {
+		localIP, err := addr.GetLocal()
+		if err != nil {
+			// not connected to a network, still can be reached locally
+			localIP = "localhost"
+		}
+
+		return fmt.Sprintf("locally on %s and remotely (if configured)", net.JoinHostPort(localIP, port))
+	}
+
+	return "on " + listenAddr
+}
+
+// Returns the address of the other node. Clients of Unix domain sockets have no address,
+// so the transport is named instead
+func (node *Node) remoteName(conn net.Conn) string {
+	remote := conn.RemoteAddr().String()
+	if (remote == "" || remote == "@") && node.netInfo.Transport != nil {
+		return node.netInfo.Transport.String()
+	}
+
+	return remote
+}
+
+// Sets the connection of the node, closing it right away if the node has been canceled
+func (node *Node) setConn(conn net.Conn) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	node.netInfo.Conn = conn
+	if node.outcome.canceled {
+		conn.Close()
+	}
+}
+
+// Stops the transfer by closing the connection
+func (node *Node) cancel() {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	node.outcome.canceled = true
+	node.stopped = true
+	if node.netInfo.Conn != nil {
+		node.netInfo.Conn.Close()
+	}
+}
+
+// Wraps the connection to limit its bandwidth if the node has limiters
+func (node *Node) limitConn(conn net.Conn) net.Conn {
+	if node.netInfo.SendLimiter == nil && node.netInfo.ReceiveLimiter == nil {
+		return conn
+	}
+
+	return limit.NewConn(conn, node.netInfo.SendLimiter, node.netInfo.ReceiveLimiter)
+}
+
+// Returns a path of the file relative to the transferred directory (or just its name)
+func fileRelPath(file *fsys.File) string {
+	if strings.TrimSpace(file.RelativeParentPath) == "" {
+		// does not have a parent dir
+		return file.Name
+	}
+	return file.RelativeParentPath
+}
+
+// Changes the destination of the received file
+func setReceivedFileRelPath(file *fsys.File, relPath string) {
+	if strings.TrimSpace(file.RelativeParentPath) == "" {
+		file.Name = relPath
+		return
+	}
+	file.RelativeParentPath = relPath
+}
+
+// Opens the partial file of the received file for writing through the sandbox
+func (node *Node) openReceivedFile(file *fsys.File) error {
+	file.Close()
+
+	handler, err := node.transferInfo.Receiving.Sandbox.OpenFile(fsys.PartialName(fileRelPath(file)), os.O_CREATE|os.O_RDWR, os.ModePerm)
 	if err != nil {
 		return err
 	}
+	file.Handler = handler
+
+	return nil
+}
 
-	// accept only one conneciton
-	connection, err := listener.Accept()
+// Flushes the fully received and verified partial file to disk and renames it into place
+func (node *Node) finishReceivedFile(file *fsys.File) error {
+	err := file.Handler.Sync()
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("\nNew connection from %s", connection.RemoteAddr().String())
+	err = file.Close()
+	if err != nil {
+		return err
+	}
 
-	node.netInfo.Conn = connection
+	fileRelPath := fileRelPath(file)
 
-	return nil
+	exists, err := node.transferInfo.Receiving.Sandbox.Exists(fileRelPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		err = node.backupReplacedFile(fileRelPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return node.transferInfo.Receiving.Sandbox.Rename(fsys.PartialName(fileRelPath), fileRelPath)
 }
 
-// Prints information about the transfer after defined delay
-func (node *Node) printTransferInfo(delay time.Duration) error {
-	time.Sleep(delay)
+// Returns how many bytes of the file have already been received in the previous
+// interrupted transfer. Returns 0 if there is no usable partial file
+func (node *Node) receivedPartSize(file *fsys.File) uint64 {
+	partial, err := node.transferInfo.Receiving.Sandbox.OpenFile(fsys.PartialName(fileRelPath(file)), os.O_RDONLY, 0)
+	if err != nil {
+		return 0
+	}
+	defer partial.Close()
 
-	switch node.isSending {
-	case true:
-		if !node.transferInfo.Sending.AllowedToTransfer {
-			// do not print if the transfer has not been accepted yet
-			break
+	partialStats, err := partial.Stat()
+	if err != nil || !partialStats.Mode().IsRegular() {
+		return 0
+	}
+
+	if uint64(partialStats.Size()) > file.Size {
+		// can not be a part of this file
+		return 0
+	}
+
+	return uint64(partialStats.Size())
+}
+
+// Adds the file to the accepted ones and asks the sender for its contents,
+// continuing from the leftover partial file if asked to resume
+func (node *Node) acceptFile(file *fsys.File) {
+	node.reporter.FileStarted(fileRelPath(file), file.Size)
+
+	node.mutex.Lock()
+	node.transferInfo.Receiving.AcceptedFiles = append(node.transferInfo.Receiving.AcceptedFiles, file)
+	node.mutex.Unlock()
+
+	if file.Streamed || node.transferInfo.Receiving.Writer != nil || node.transferInfo.Receiving.Storage != nil {
+		node.acceptSequentialFile(file)
+		return
+	}
+
+	var offset uint64 = 0
+	if node.transferInfo.Receiving.Resume {
+		offset = node.receivedPartSize(file)
+	}
+
+	if offset == 0 {
+		// start from scratch
+		node.transferInfo.Receiving.Sandbox.Remove(fsys.PartialName(fileRelPath(file)))
+	}
+
+	// reserve the space right away, so running out of it is discovered before the data is sent
+	err := node.openReceivedFile(file)
+	if err == nil {
+		err = fsys.Preallocate(file.Handler, file.Size)
+	}
+	if err != nil {
+		node.abortOnWriteError(file, err)
+		return
+	}
+
+	if offset == 0 {
+		err := protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+			Header: protocol.HeaderReady,
+		})
+		if err != nil {
+			node.fail(err)
+			return
 		}
-		fmt.Printf("\r| (%.2f/%.2f MB)",
-			float32(node.transferInfo.Sending.SentBytes)/1024/1024,
-			float32(node.transferInfo.Sending.TotalTransferSize)/1024/1024,
-		)
+		return
+	}
 
-	case false:
-		fmt.Printf("\r| (%.2f/%.2f MB)",
-			float32(node.transferInfo.Receiving.ReceivedBytes)/1024/1024,
-			float32(node.transferInfo.Receiving.TotalDownloadSize)/1024/1024,
-		)
+	file.SentBytes = offset
+	node.transferInfo.Receiving.ReceivedBytes += offset
+	node.reporter.Resumed(offset)
+
+	if node.verboseOutput {
+		node.reporter.Printf("[File] resuming \"%s\" from %d bytes", file.Name, offset)
+	}
+
+	resumePacketBodyBuffer := new(bytes.Buffer)
+	binary.Write(resumePacketBodyBuffer, binary.BigEndian, file.ID)
+	binary.Write(resumePacketBodyBuffer, binary.BigEndian, offset)
+
+	resumePacket := protocol.Packet{
+		Header: protocol.HeaderResume,
+		Body:   resumePacketBodyBuffer.Bytes(),
+	}
+
+	if node.netInfo.EncryptionKey != nil {
+		err := resumePacket.EncryptBody(node.netInfo.EncryptionKey)
+		if err != nil {
+			node.fail(err)
+			return
+		}
+	}
+
+	err = protocol.SendPacket(node.netInfo.Conn, resumePacket)
+	if err != nil {
+		node.fail(err)
+		return
 	}
-	return nil
 }
 
-func (node *Node) send() {
-	// SENDER NODE
+// Starts receiving the file that is written as it comes: a stream, whose size is unknown, or
+// anything written into the Writer or the storage. Nothing is resumed or reserved, and the checksum is computed on the fly
+func (node *Node) acceptSequentialFile(file *fsys.File) {
+	var size int64 = int64(file.Size)
+	if file.Streamed {
+		size = -1
+	}
+	node.transferInfo.Receiving.Checksum = checksum.NewWriter(size)
+
+	if node.transferInfo.Receiving.Archive != nil {
+		entry, err := node.transferInfo.Receiving.Archive.File(file.Path, file.Size, file.Mode, file.ModTime)
+		if err != nil {
+			node.abortOnArchiveError(file.Path, err)
+			return
+		}
+		node.transferInfo.Receiving.ArchiveEntry = entry
+	} else if node.transferInfo.Receiving.Storage != nil {
+		storageFile, err := node.transferInfo.Receiving.Storage.Create(file.Path)
+		if err != nil {
+			node.abortOnWriteError(file, err)
+			return
+		}
+		node.transferInfo.Receiving.StorageFile = storageFile
+	} else if node.transferInfo.Receiving.Writer == nil {
+		node.transferInfo.Receiving.Sandbox.Remove(fsys.PartialName(fileRelPath(file)))
+
+		err := node.openReceivedFile(file)
+		if err != nil {
+			node.abortOnWriteError(file, err)
+			return
+		}
+	}
 
-	localIP, err := addr.GetLocal()
+	err := protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+		Header: protocol.HeaderReady,
+	})
 	if err != nil {
-		panic(err)
+		node.fail(err)
+	}
+}
+
+// Writes the received piece of the file into the archive, the storage, the Writer or into the partial file
+func (node *Node) writeReceivedBytes(file *fsys.File, fileBytes []byte) (int, error) {
+	var wrote int
+	var err error
+	if node.transferInfo.Receiving.Archive != nil {
+		wrote, err = node.transferInfo.Receiving.ArchiveEntry.Write(fileBytes)
+	} else if node.transferInfo.Receiving.Storage != nil {
+		wrote, err = node.transferInfo.Receiving.StorageFile.WriteAt(fileBytes, int64(file.SentBytes))
+	} else if node.transferInfo.Receiving.Writer != nil {
+		wrote, err = node.transferInfo.Receiving.Writer.Write(fileBytes)
+	} else {
+		if file.Handler == nil {
+			err = node.openReceivedFile(file)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		wrote, err = file.Handler.WriteAt(fileBytes, int64(file.SentBytes))
+	}
+
+	if node.transferInfo.Receiving.Checksum != nil {
+		node.transferInfo.Receiving.Checksum.Write(fileBytes[:wrote])
+	}
+
+	return wrote, err
+}
+
+// Returns the checksum of what has been received of the file. The checksum of a file written as
+// it comes is empty, so it matches no other, if not all of it has come
+func (node *Node) receivedChecksum(file *fsys.File) (string, error) {
+	if node.transferInfo.Receiving.Checksum == nil {
+		return checksum.GetPartialCheckSum(file.Handler)
+	}
+
+	if file.SentBytes != file.Size {
+		return "", nil
+	}
+
+	return node.transferInfo.Receiving.Checksum.CheckSum(), nil
+}
+
+// Tells the sender to not send the file
+func (node *Node) skipFile(file *fsys.File) {
+	alreadyHavePacketBodyBuffer := new(bytes.Buffer)
+	binary.Write(alreadyHavePacketBodyBuffer, binary.BigEndian, file.ID)
+
+	alreadyHavePacket := protocol.Packet{
+		Header: protocol.HeaderAlreadyHave,
+		Body:   alreadyHavePacketBodyBuffer.Bytes(),
+	}
+
+	if node.netInfo.EncryptionKey != nil {
+		encryptedBody, err := encryption.Encrypt(node.netInfo.EncryptionKey, alreadyHavePacket.Body)
+		if err != nil {
+			node.fail(err)
+			return
+		}
+		alreadyHavePacket.Body = encryptedBody
+	}
+
+	protocol.SendPacket(node.netInfo.Conn, alreadyHavePacket)
+
+	node.transferInfo.Receiving.ReceivedBytes += file.Size
+	node.reporter.FileSkipped(fileRelPath(file), file.Size)
+}
+
+// Stops the receiving node because the received file can not be written (ie: no space left).
+// Gives back the space reserved for the rest of the file, keeping what has already been received
+func (node *Node) abortOnWriteError(file *fsys.File, err error) {
+	if file.Handler != nil {
+		file.Handler.Truncate(int64(file.SentBytes))
+	}
+	file.Close()
+
+	if node.transferInfo.Receiving.Storage != nil {
+		node.abortStorageFile()
+	} else if file.SentBytes == 0 && node.transferInfo.Receiving.Writer == nil {
+		node.transferInfo.Receiving.Sandbox.Remove(fsys.PartialName(fileRelPath(file)))
+	}
+
+	node.reporter.FileFailed(fileRelPath(file))
+	node.reporter.Printf("[ERROR] Could not write \"%s\": %s. Aborting the transfer", file.Path, err)
+	node.reporter.Error(progress.ErrorWrite, fileRelPath(file), err.Error())
+
+	node.mutex.Lock()
+	if node.outcome.err == nil {
+		node.outcome.err = err
+	}
+	node.outcome.aborted = true
+	node.stopped = true
+	node.mutex.Unlock()
+}
+
+// Tells whether the symlink could not be created only because it is already there, ie: from an earlier transfer
+func symlinkExists(err error, lstat func(name string) (fs.FileInfo, error), name string) bool {
+	if !errors.Is(err, fs.ErrExist) {
+		return false
+	}
+
+	info, statErr := lstat(name)
+	return statErr == nil && info.Mode()&fs.ModeSymlink != 0
+}
+
+// Reports the received symlink that could not be created. The transfer goes on, but is not complete
+func (node *Node) symlinkFailed(symlink *fsys.Symlink, err error) {
+	node.reporter.Printf("[ERROR] Could not create a symlink \"%s\": %s", symlink.Path, err)
+	node.reporter.Error(progress.ErrorWrite, symlink.Path, err.Error())
+
+	node.mutex.Lock()
+	node.outcome.failedSymlinks++
+	node.mutex.Unlock()
+}
+
+// Stops the receiving node because the other side has tried to reach outside of the downloads directory
+func (node *Node) abortOnSecurityViolation(err error) {
+	node.reporter.Printf("[SECURITY] %s. Aborting the transfer", err)
+	node.reporter.Error(progress.ErrorSecurity, "", err.Error())
+
+	node.mutex.Lock()
+	if node.outcome.err == nil {
+		node.outcome.err = err
+	}
+	node.outcome.aborted = true
+	node.stopped = true
+	node.mutex.Unlock()
+}
+
+func (node *Node) send(ctx context.Context) {
+	// SENDER NODE
+
+	if node.transferInfo.Sending.Text != "" {
+		node.sendText(ctx)
+		return
 	}
 
 	// retrieve information about the file|directory
 	var FILETOSEND *fsys.File
 	var DIRTOSEND *fsys.Directory
+	var err error
+	walkOptions := fsys.WalkOptions{
+		Recursive:      node.transferInfo.Sending.Recursive,
+		FollowSymlinks: node.transferInfo.Sending.FollowSymlinks,
+		MaxDepth:       node.transferInfo.Sending.MaxDepth,
+	}
 	switch node.transferInfo.Sending.IsDirectory {
 	case true:
-		DIRTOSEND, err = fsys.GetDir(node.transferInfo.Sending.ServingPath, node.transferInfo.Sending.Recursive)
+		if len(node.transferInfo.Sending.ServingPaths) > 1 {
+			DIRTOSEND, err = fsys.GetBundle(node.transferInfo.Sending.ServingPaths, walkOptions)
+			if err == nil {
+				// make sure the manifest fits before waiting for anyone
+				_, err = protocol.CreateBundlePacket(DIRTOSEND)
+			}
+		} else if node.transferInfo.Sending.FS != nil {
+			DIRTOSEND, err = fsys.GetDirFS(node.transferInfo.Sending.FS, node.transferInfo.Sending.ServingPath, walkOptions)
+			if err == nil && DIRTOSEND.Path == "." {
+				DIRTOSEND.Name = node.transferInfo.Sending.FSName
+			}
+		} else {
+			DIRTOSEND, err = fsys.GetDirWithOptions(node.transferInfo.Sending.ServingPath, walkOptions)
+		}
 		if err != nil {
-			panic(err)
+			node.fail(err)
+			return
+		}
+
+		for _, warning := range DIRTOSEND.Warnings {
+			fmt.Fprintf(node.output, "\n[WARNING] %s", warning)
 		}
 	case false:
-		FILETOSEND, err = fsys.GetFile(node.transferInfo.Sending.ServingPath)
+		if node.transferInfo.Sending.Stream != nil {
+			// its checksum is known only when all of it has been read
+			node.transferInfo.Sending.StreamChecksum = checksum.NewWriter(-1)
+			stream := io.TeeReader(node.transferInfo.Sending.Stream, node.transferInfo.Sending.StreamChecksum)
+			FILETOSEND = fsys.GetStream(filepath.Base(node.transferInfo.Sending.ServingPath), stream)
+			break
+		}
+
+		if node.transferInfo.Sending.FS != nil {
+			FILETOSEND, err = fsys.GetFileFS(node.transferInfo.Sending.FS, node.transferInfo.Sending.ServingPath)
+		} else {
+			FILETOSEND, err = fsys.GetFile(node.transferInfo.Sending.ServingPath)
+		}
 		if err != nil {
-			panic(err)
+			node.fail(err)
+			return
 		}
 	}
 
+	var name string
+	var size uint64
 	if DIRTOSEND != nil {
-		node.transferInfo.Sending.TotalTransferSize = DIRTOSEND.Size
-
-		displaySize := float32(DIRTOSEND.Size) / 1024 / 1024
-		sizeLevel := "MiB"
-		if displaySize >= 1024 {
-			// GiB
-			displaySize = displaySize / 1024
-			sizeLevel = "GiB"
-		}
-
-		fmt.Printf("\nSending \"%s\" (%.3f %s) locally on %s:%d and remotely (if configured)", DIRTOSEND.Name, displaySize, sizeLevel, localIP, node.netInfo.Port)
+		name, size = offerName(DIRTOSEND), DIRTOSEND.Size
 	} else {
-		node.transferInfo.Sending.TotalTransferSize = FILETOSEND.Size
+		name, size = FILETOSEND.Name, FILETOSEND.Size
+	}
+	node.transferInfo.Sending.TotalTransferSize = size
 
-		displaySize := float32(FILETOSEND.Size) / 1024 / 1024
-		sizeLevel := "MiB"
-		if displaySize >= 1024 {
-			// GiB
-			displaySize = displaySize / 1024
-			sizeLevel = "GiB"
-		}
-		fmt.Printf("\nSending \"%s\" (%.3f %s) locally on %s:%d and remotely (if configured)", FILETOSEND.Name, displaySize, sizeLevel, localIP, node.netInfo.Port)
+	sizeDescription := progress.FormatSize(size)
+	if FILETOSEND != nil && FILETOSEND.Streamed {
+		sizeDescription = "size unknown"
+	}
 
+	if node.netInfo.Conn == nil && node.netInfo.Listening {
+		fmt.Fprintf(node.output, "\nSending \"%s\" (%s) %s", name, sizeDescription, node.listenDescription())
+	} else {
+		fmt.Fprintf(node.output, "\nSending \"%s\" (%s)", name, sizeDescription)
 	}
 
-	// wain for another node to connect
-	err = node.waitForConnection()
+	// wait for the receiver or connect to it
+	err = node.establishConnection(ctx)
 	if err != nil {
-		panic(err)
+		if ctx.Err() != nil {
+			node.cancel()
+			return
+		}
+		node.failConnection(err)
+		return
 	}
 
 	// generate and send encryption key
 	encrKey := encryption.Generate32AESkey()
 	node.netInfo.EncryptionKey = encrKey
-	fmt.Printf("\nGenerated encryption key: %s\n", encrKey)
+	fmt.Fprintf(node.output, "\nGenerated encryption key: %s\n", encrKey)
 
 	err = protocol.SendEncryptionKey(node.netInfo.Conn, encrKey)
 	if err != nil {
-		panic(err)
+		node.fail(err)
+		return
 	}
 
 	// listen for incoming packets
 	go protocol.ReceivePackets(node.netInfo.Conn, node.packetPipe)
 
 	// send info about file/directory
+	if DIRTOSEND != nil {
+		node.reporter.Offer(offerName(DIRTOSEND), DIRTOSEND.Size, true)
+	} else {
+		node.reporter.Offer(FILETOSEND.Name, FILETOSEND.Size, false)
+	}
 	go protocol.SendTransferOffer(node.netInfo.Conn, FILETOSEND, DIRTOSEND, node.netInfo.EncryptionKey)
 
 	// mainloop
 	for {
-		if node.stopped {
-			fmt.Printf("\n")
+		node.mutex.Lock()
+		stopped := node.stopped
+		node.mutex.Unlock()
+
+		if stopped {
+			node.reporter.Stop()
+			fmt.Fprintf(node.output, "\n")
 			node.disconnect()
 			break
 		}
 
-		if !node.verboseOutput {
-			go node.printTransferInfo(time.Second)
-		}
-
 		// receive incoming packets and decrypt them if necessary
 		incomingPacket, ok := <-node.packetPipe
 		if !ok {
-			fmt.Printf("\nThe connection has been closed unexpectedly\n")
-			os.Exit(-1)
+			node.failConnection(errors.New("the connection has been closed unexpectedly"))
+			node.mutex.Lock()
+			node.stopped = true
+			node.mutex.Unlock()
+			continue
 		}
 
 		// if encryption key is set - decrypt packet on the spot
 		if node.netInfo.EncryptionKey != nil {
 			err = incomingPacket.DecryptBody(node.netInfo.EncryptionKey)
 			if err != nil {
-				panic(err)
+				node.fail(err)
+				continue
 			}
 		}
 
@@ -354,13 +1013,18 @@ func (node *Node) send() {
 
 				err = DIRTOSEND.SetRelativePaths(DIRTOSEND.Path, node.transferInfo.Sending.Recursive)
 				if err != nil {
-					panic(err)
+					node.fail(err)
+					continue
 				}
 				filesToSend := DIRTOSEND.GetAllFiles(node.transferInfo.Sending.Recursive)
 				symlinksToSend := DIRTOSEND.GetAllSymlinks(node.transferInfo.Sending.Recursive)
 
 				node.transferInfo.Sending.SymlinksToSend = symlinksToSend
 
+				for _, emptyDir := range DIRTOSEND.GetEmptyDirectories(node.transferInfo.Sending.Recursive) {
+					node.transferInfo.Sending.EmptyDirsToSend = append(node.transferInfo.Sending.EmptyDirsToSend, emptyDir.RelativeParentPath)
+				}
+
 				for counter, file := range filesToSend {
 					// assign ID and add it to the node sendlist
 					file.ID = uint64(counter)
@@ -377,15 +1041,47 @@ func (node *Node) send() {
 				// set current file index to the first and only file
 				node.transferInfo.Sending.CurrentFileID = 0
 			}
-			fmt.Printf("\n")
+			fmt.Fprintf(node.output, "\n")
+
+			node.reporter.Accepted()
+			node.reporter.Start(node.transferInfo.Sending.TotalTransferSize, uint64(len(node.transferInfo.Sending.FilesToSend)))
 
 		case protocol.HeaderReject:
+			node.mutex.Lock()
+			node.outcome.rejected = true
 			node.stopped = true
-			fmt.Printf("\nTransfer rejected. Disconnecting...")
+			node.mutex.Unlock()
+			fmt.Fprintf(node.output, "\nTransfer rejected. Disconnecting...")
 
 		case protocol.HeaderDisconnecting:
+			node.mutex.Lock()
 			node.stopped = true
-			fmt.Printf("\n%s disconnected", node.netInfo.Conn.RemoteAddr())
+			node.mutex.Unlock()
+			fmt.Fprintf(node.output, "\n%s disconnected", node.remoteName(node.netInfo.Conn))
+
+		case protocol.HeaderResume:
+			// the other node already has the beginning of the file.
+			// continue from where it has stopped
+
+			resumeReader := bytes.NewReader(incomingPacket.Body)
+			var fileID uint64
+			var offset uint64
+			binary.Read(resumeReader, binary.BigEndian, &fileID)
+			binary.Read(resumeReader, binary.BigEndian, &offset)
+
+			for _, fileToSend := range node.transferInfo.Sending.FilesToSend {
+				if fileToSend.ID == fileID && offset <= fileToSend.Size {
+					fileToSend.SentBytes = offset
+					node.transferInfo.Sending.SentBytes += offset
+					node.reporter.Resumed(offset)
+
+					if node.verboseOutput {
+						node.reporter.Printf("[File] receiver resumes \"%s\" from %d bytes", fileToSend.Name, offset)
+					}
+				}
+			}
+
+			node.transferInfo.Sending.CanSendBytes = true
 
 		case protocol.HeaderAlreadyHave:
 			// the other node already has a file with such ID.
@@ -405,8 +1101,10 @@ func (node *Node) send() {
 
 					node.transferInfo.Sending.InTransfer = false
 
+					node.reporter.FileSkipped(fileRelPath(fileToSend), fileToSend.Size)
+
 					if node.verboseOutput {
-						fmt.Printf("\n[File] receiver already has \"%s\"", fileToSend.Name)
+						node.reporter.Printf("[File] receiver already has \"%s\"", fileToSend.Name)
 					}
 				}
 			}
@@ -422,12 +1120,20 @@ func (node *Node) send() {
 		}
 
 		if len(node.transferInfo.Sending.FilesToSend) == 0 && node.transferInfo.Sending.CurrentSymlinkIndex == uint64(len(node.transferInfo.Sending.SymlinksToSend)) {
+			// empty directories do not need the receiver to be ready
+			for _, emptyDir := range node.transferInfo.Sending.EmptyDirsToSend {
+				protocol.SendEmptyDirectory(emptyDir, node.netInfo.Conn, encrKey)
+			}
+
 			// if there`s nothing else to send - create and send DONE packet
 			protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
 				Header: protocol.HeaderDone,
 			})
 
+			node.mutex.Lock()
+			node.outcome.completed = true
 			node.stopped = true
+			node.mutex.Unlock()
 
 			continue
 		}
@@ -446,21 +1152,26 @@ func (node *Node) send() {
 
 			fpacket, err := protocol.CreateFilePacket(node.transferInfo.Sending.FilesToSend[currentFileIndex])
 			if err != nil {
-				panic(err)
+				node.fail(err)
+				continue
 			}
 
 			if node.netInfo.EncryptionKey != nil {
 				err = fpacket.EncryptBody(node.netInfo.EncryptionKey)
 				if err != nil {
-					panic(err)
+					node.fail(err)
+					continue
 				}
 			}
 
 			err = protocol.SendPacket(node.netInfo.Conn, *fpacket)
 			if err != nil {
-				panic(err)
+				node.fail(err)
+				continue
 			}
 
+			node.reporter.FileStarted(fileRelPath(node.transferInfo.Sending.FilesToSend[currentFileIndex]), node.transferInfo.Sending.FilesToSend[currentFileIndex].Size)
+
 			// initiate the transfer for this file on the next iteration
 			node.transferInfo.Sending.InTransfer = true
 			continue
@@ -482,33 +1193,35 @@ func (node *Node) send() {
 
 			sentBytes, err := protocol.SendPiece(node.transferInfo.Sending.FilesToSend[currentFileIndex], node.netInfo.Conn, node.netInfo.EncryptionKey)
 			node.transferInfo.Sending.SentBytes += sentBytes
+			node.reporter.Transferred(sentBytes)
 			switch err {
 			case protocol.ErrorSentAll:
 				// the file has been sent fully
+				node.reporter.FileDone(fileRelPath(node.transferInfo.Sending.FilesToSend[currentFileIndex]))
 
 				if node.verboseOutput {
-					fmt.Printf("\n[File] fully sent \"%s\" -- %d bytes", node.transferInfo.Sending.FilesToSend[currentFileIndex].Name, node.transferInfo.Sending.FilesToSend[currentFileIndex].Size)
+					node.reporter.Printf("[File] fully sent \"%s\" -- %d bytes", node.transferInfo.Sending.FilesToSend[currentFileIndex].Name, node.transferInfo.Sending.FilesToSend[currentFileIndex].Size)
 				}
 
-				fileIDBuff := new(bytes.Buffer)
-				err = binary.Write(fileIDBuff, binary.BigEndian, node.transferInfo.Sending.FilesToSend[currentFileIndex].ID)
-				if err != nil {
-					panic(err)
+				if node.transferInfo.Sending.FilesToSend[currentFileIndex].Streamed {
+					node.transferInfo.Sending.FilesToSend[currentFileIndex].Checksum = node.transferInfo.Sending.StreamChecksum.CheckSum()
 				}
 
-				endFilePacket := protocol.Packet{
-					Header: protocol.HeaderEndfile,
-					Body:   fileIDBuff.Bytes(),
+				endFilePacket, err := protocol.CreateEndfilePacket(node.transferInfo.Sending.FilesToSend[currentFileIndex])
+				if err != nil {
+					node.fail(err)
+					continue
 				}
 
 				if node.netInfo.EncryptionKey != nil {
 					err = endFilePacket.EncryptBody(node.netInfo.EncryptionKey)
 					if err != nil {
-						panic(err)
+						node.fail(err)
+						continue
 					}
 				}
 
-				protocol.SendPacket(node.netInfo.Conn, endFilePacket)
+				protocol.SendPacket(node.netInfo.Conn, *endFilePacket)
 
 				// remove this file from the queue
 				node.transferInfo.Sending.FilesToSend = append(node.transferInfo.Sending.FilesToSend[:currentFileIndex], node.transferInfo.Sending.FilesToSend[currentFileIndex+1:]...)
@@ -521,25 +1234,65 @@ func (node *Node) send() {
 				node.transferInfo.Sending.CanSendBytes = false
 
 			default:
-				node.stopped = true
-
-				fmt.Printf("\n[ERROR] An error occured while sending a piece of \"%s\": %s", node.transferInfo.Sending.FilesToSend[currentFileIndex].Name, err)
-				panic(err)
+				node.reporter.FileFailed(fileRelPath(node.transferInfo.Sending.FilesToSend[currentFileIndex]))
+				node.fail(fmt.Errorf("could not send a piece of \"%s\": %w", node.transferInfo.Sending.FilesToSend[currentFileIndex].Name, err))
 			}
 		}
 	}
 }
 
-func (node *Node) receive() {
+func (node *Node) receive(ctx context.Context) {
 	// RECEIVER NODE
 
-	// connect to the sending node
-	err := node.connect()
+	if node.netInfo.Conn == nil && node.netInfo.Listening {
+		fmt.Fprintf(node.output, "\nWaiting for the sender %s", node.listenDescription())
+	}
+
+	// connect to the sending node or wait for it
+	err := node.establishConnection(ctx)
 	if err != nil {
-		fmt.Printf("\n[ERROR] Could not connect to %s:%d\n", node.netInfo.ConnAddr, node.netInfo.Port)
-		os.Exit(-1)
+		if ctx.Err() != nil {
+			node.cancel()
+			return
+		}
+		node.failConnection(err)
+		return
+	}
+
+	if node.transferInfo.Receiving.CleanPartial {
+		removed, err := fsys.RemovePartialFiles(node.transferInfo.Receiving.DownloadsPath)
+		if err != nil {
+			fmt.Fprintf(node.output, "\n[ERROR] Could not remove leftover partial files: %s", err)
+		}
+		for _, removedPath := range removed {
+			fmt.Fprintf(node.output, "\nRemoved leftover partial file \"%s\"", removedPath)
+		}
 	}
 
+	if node.transferInfo.Receiving.Storage == nil {
+		// everything will be created relative to the opened downloads directory
+		node.transferInfo.Receiving.DownloadsSandbox, err = fsys.OpenSandbox(node.transferInfo.Receiving.DownloadsPath)
+		if err != nil {
+			node.fail(err)
+			return
+		}
+		node.transferInfo.Receiving.Sandbox = node.transferInfo.Receiving.DownloadsSandbox
+		defer func() {
+			if node.transferInfo.Receiving.Sandbox != node.transferInfo.Receiving.DownloadsSandbox {
+				node.transferInfo.Receiving.Sandbox.Close()
+			}
+			node.transferInfo.Receiving.DownloadsSandbox.Close()
+		}()
+	}
+	defer func() {
+		if node.transferInfo.Receiving.Archive != nil {
+			// finish whatever has been written if the transfer has not got to the end
+			node.transferInfo.Receiving.Archive.Close()
+		}
+		// a file that has not been received completely does not appear in the storage
+		node.abortStorageFile()
+	}()
+
 	// listen for incoming packets
 	go protocol.ReceivePackets(node.netInfo.Conn, node.packetPipe)
 
@@ -550,27 +1303,28 @@ func (node *Node) receive() {
 		node.mutex.Unlock()
 
 		if stopped {
-			fmt.Printf("\n")
+			node.reporter.Stop()
+			fmt.Fprintf(node.output, "\n")
 			node.disconnect()
 			break
 		}
 
-		if !node.verboseOutput && node.transferInfo.Receiving.ReceivedBytes != 0 {
-			go node.printTransferInfo(time.Second)
-		}
-
 		// receive incoming packets and decrypt them if necessary
 		incomingPacket, ok := <-node.packetPipe
 		if !ok {
-			fmt.Printf("\nConnection has been closed unexpectedly\n")
-			os.Exit(-1)
+			node.failConnection(errors.New("the connection has been closed unexpectedly"))
+			node.mutex.Lock()
+			node.stopped = true
+			node.mutex.Unlock()
+			continue
 		}
 
 		// if encryption key is set - decrypt packet on the spot
 		if node.netInfo.EncryptionKey != nil {
 			err = incomingPacket.DecryptBody(node.netInfo.EncryptionKey)
 			if err != nil {
-				panic(err)
+				node.fail(err)
+				continue
 			}
 		}
 
@@ -581,52 +1335,219 @@ func (node *Node) receive() {
 			// accept of reject offer
 			go func() {
 				file, dir, err := protocol.DecodeTransferPacket(incomingPacket)
+				if errors.Is(err, fsys.ErrorUnsafePath) {
+					protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+						Header: protocol.HeaderReject,
+					})
+					node.abortOnSecurityViolation(err)
+					return
+				}
 				if err != nil {
-					panic(err)
+					node.fail(err)
+					return
 				}
 
-				if file != nil {
+				if file != nil && file.Streamed {
+					node.transferInfo.Receiving.Streamed = true
+					node.reporter.Offer(file.Name, 0, false)
+
+					fmt.Fprintf(node.output, "\n| Filename: %s\n| Size: unknown (a stream)\n", file.Name)
+
+				} else if file != nil {
 					node.transferInfo.Receiving.TotalDownloadSize = file.Size
+					node.reporter.Offer(file.Name, file.Size, false)
 
-					size := float32(file.Size) / 1024 / 1024
-					sizeLevel := "MiB"
-					if size >= 1024 {
-						// GiB
-						size = size / 1024
-						sizeLevel = "GiB"
-					}
-					fmt.Printf("\n| Filename: %s\n| Size: %.3f %s\n| Checksum: %s\n", file.Name, size, sizeLevel, file.Checksum)
+					fmt.Fprintf(node.output, "\n| Filename: %s\n| Size: %s\n| Checksum: %s\n", file.Name, progress.FormatSize(file.Size), file.Checksum)
+
+				} else if dir != nil && dir.Bundle {
+					node.transferInfo.Receiving.TotalDownloadSize = dir.Size
+					node.reporter.Offer(offerName(dir), dir.Size, true)
+
+					node.printBundle(dir)
 
 				} else if dir != nil {
 					node.transferInfo.Receiving.TotalDownloadSize = dir.Size
+					node.reporter.Offer(dir.Name, dir.Size, true)
+
+					fmt.Fprintf(node.output, "\n| Directory name: %s\n| Size: %s\n", dir.Name, progress.FormatSize(dir.Size))
+				}
+
+				// only one file can be written into the writer, unless it is archived
+				if dir != nil && node.transferInfo.Receiving.Writer != nil && node.transferInfo.Receiving.Archive == nil {
+					fmt.Fprintf(node.output, "Only a single file can be received into the output. Rejecting the transfer\n")
+
+					err = protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+						Header: protocol.HeaderReject,
+					})
+					if err != nil {
+						node.fail(err)
+						return
+					}
+
+					node.mutex.Lock()
+					node.outcome.rejected = true
+					node.stopped = true
+					node.mutex.Unlock()
+					return
+				}
 
-					size := float32(dir.Size) / 1024 / 1024
-					sizeLevel := "MiB"
-					if size >= 1024 {
-						// GiB
-						size = size / 1024
-						sizeLevel = "GiB"
+				// the size of each archived file must be known in advance
+				if file != nil && file.Streamed && node.transferInfo.Receiving.Archive != nil {
+					fmt.Fprintf(node.output, "The size of a stream is not known in advance, so it can not be archived. Rejecting the transfer\n")
+
+					err = protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+						Header: protocol.HeaderReject,
+					})
+					if err != nil {
+						node.fail(err)
+						return
 					}
-					fmt.Printf("\n| Directory name: %s\n| Size: %.3f %s\n", dir.Name, size, sizeLevel)
+
+					node.mutex.Lock()
+					node.outcome.rejected = true
+					node.stopped = true
+					node.mutex.Unlock()
+					return
 				}
 
-				var answer string
-				fmt.Printf("| Download ? [Y/n]: ")
-				fmt.Scanln(&answer)
-				fmt.Printf("\n\n")
+				// do not even ask if it does not fit
+				if node.transferInfo.Receiving.Writer == nil && node.transferInfo.Receiving.Storage == nil && !node.checkFreeSpace(node.transferInfo.Receiving.TotalDownloadSize) {
+					fmt.Fprintf(node.output, "Rejecting the transfer\n")
+
+					err = protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+						Header: protocol.HeaderReject,
+					})
+					if err != nil {
+						node.fail(err)
+						return
+					}
+
+					node.mutex.Lock()
+					node.outcome.rejected = true
+					node.stopped = true
+					node.mutex.Unlock()
+					return
+				}
 
-				if strings.EqualFold(answer, "y") || answer == "" {
+				if node.transferInfo.Receiving.OfferDecider.Decide(file, dir) {
 					// yes
 
+					// everything goes into the archive, where nothing conflicts
+					if dir != nil && node.transferInfo.Receiving.Archive != nil {
+						err = node.archiveDirectory(dir)
+						if err != nil {
+							protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+								Header: protocol.HeaderReject,
+							})
+							node.abortOnArchiveError(dir.Name, err)
+							return
+						}
+					}
+
+					// entries of a bundle go straight into the downloads folder
+					if dir != nil && dir.Bundle && node.transferInfo.Receiving.Archive == nil {
+						accepted, err := node.resolveBundleConflicts(dir)
+						if err != nil {
+							node.fail(err)
+							return
+						}
+
+						if !accepted {
+							fmt.Fprintf(node.output, "\nRejecting the transfer")
+
+							err = protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+								Header: protocol.HeaderReject,
+							})
+							if err != nil {
+								node.fail(err)
+								return
+							}
+
+							node.mutex.Lock()
+							node.outcome.rejected = true
+							node.stopped = true
+							node.mutex.Unlock()
+							return
+						}
+					}
+
 					// in case it`s a directory - create it now
-					if dir != nil {
-						err = os.MkdirAll(filepath.Join(node.transferInfo.Receiving.DownloadsPath, dir.Name), os.ModePerm)
+					if dir != nil && !dir.Bundle && node.transferInfo.Receiving.Archive == nil {
+						exists, err := node.transferInfo.Receiving.exists(dir.Name)
 						if err != nil {
-							// well, just download all files in the default downloads folder then
-							fmt.Printf("\n[ERROR] could not create a directory, downloading directly to the specified location")
+							node.fail(err)
+							return
+						}
+
+						if exists {
+							switch node.resolveDirConflict(dir) {
+							case DirConflictReject:
+								fmt.Fprintf(node.output, "\nDirectory \"%s\" already exists. Rejecting the transfer", dir.Name)
+
+								err = protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+									Header: protocol.HeaderReject,
+								})
+								if err != nil {
+									node.fail(err)
+									return
+								}
+
+								node.mutex.Lock()
+								node.outcome.rejected = true
+								node.stopped = true
+								node.mutex.Unlock()
+								return
+
+							case DirConflictKeepBoth:
+								dir.Name, err = freeNumberedName(node.transferInfo.Receiving.exists, dir.Name)
+								if err != nil {
+									node.fail(err)
+									return
+								}
+								fmt.Fprintf(node.output, "\nDirectory already exists. Downloading into \"%s\"", dir.Name)
+							}
+						}
+
+						if node.transferInfo.Receiving.Storage != nil {
+							err = node.enterStorageDirectory(dir.Name)
+							if errors.Is(err, fsys.ErrorUnsafePath) {
+								protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+									Header: protocol.HeaderReject,
+								})
+								node.abortOnSecurityViolation(err)
+								return
+							}
+							if err != nil {
+								protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+									Header: protocol.HeaderReject,
+								})
+								node.abortOnStorageError(dir.Name, err)
+								return
+							}
 						} else {
-							// also download everything in a newly created directory
-							node.transferInfo.Receiving.DownloadsPath = filepath.Join(node.transferInfo.Receiving.DownloadsPath, dir.Name)
+							err = node.transferInfo.Receiving.Sandbox.MkdirAll(dir.Name)
+							if errors.Is(err, fsys.ErrorUnsafePath) {
+								protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+									Header: protocol.HeaderReject,
+								})
+								node.abortOnSecurityViolation(err)
+								return
+							}
+
+							var dirSandbox *fsys.Sandbox
+							if err == nil {
+								dirSandbox, err = node.transferInfo.Receiving.Sandbox.Sub(dir.Name)
+							}
+
+							if err != nil {
+								// well, just download all files in the default downloads folder then
+								fmt.Fprintf(node.output, "\n[ERROR] could not create a directory, downloading directly to the specified location")
+							} else {
+								// also download everything in a newly created directory
+								node.transferInfo.Receiving.Sandbox = dirSandbox
+								node.transferInfo.Receiving.TransferDir = dir.Name
+								node.transferInfo.Receiving.DownloadsPath = dirSandbox.Root()
+							}
 						}
 
 					}
@@ -638,9 +1559,14 @@ func (node *Node) receive() {
 
 					err = protocol.SendPacket(node.netInfo.Conn, acceptancePacket)
 					if err != nil {
-						panic(err)
+						node.fail(err)
+						return
 					}
 
+					// the number of files in the directory is not known in advance
+					node.reporter.Accepted()
+					node.reporter.Start(node.transferInfo.Receiving.TotalDownloadSize, 0)
+
 				} else {
 					// no
 
@@ -650,10 +1576,12 @@ func (node *Node) receive() {
 
 					err = protocol.SendPacket(node.netInfo.Conn, rejectionPacket)
 					if err != nil {
-						panic(err)
+						node.fail(err)
+						return
 					}
 
 					node.mutex.Lock()
+					node.outcome.rejected = true
 					node.stopped = true
 					node.mutex.Unlock()
 				}
@@ -662,100 +1590,111 @@ func (node *Node) receive() {
 		case protocol.HeaderFile:
 			// add file to the accepted files;
 			file, err := protocol.DecodeFilePacket(incomingPacket)
+			if errors.Is(err, fsys.ErrorUnsafePath) {
+				node.abortOnSecurityViolation(err)
+				continue
+			}
 			if err != nil {
-				panic(err)
+				node.fail(err)
+				continue
 			}
 
 			if node.verboseOutput {
-				fmt.Printf("\n[File] Received info on \"%s\" - %d bytes", file.Name, file.Size)
+				node.reporter.Printf("[File] Received info on \"%s\" - %d bytes", file.Name, file.Size)
 			}
 
-			if strings.TrimSpace(file.RelativeParentPath) == "" {
-				// does not have a parent dir
-				file.Path = filepath.Join(node.transferInfo.Receiving.DownloadsPath, file.Name)
-			} else {
-				file.Path = filepath.Join(node.transferInfo.Receiving.DownloadsPath, file.RelativeParentPath)
-			}
+			file.Streamed = node.transferInfo.Receiving.Streamed
 
-			// create all underlying directories right ahead
-			err = os.MkdirAll(filepath.Dir(file.Path), os.ModePerm)
-			if err != nil {
-				panic(err)
+			if node.transferInfo.Receiving.Archive != nil {
+				// it goes into the archive, where nothing is there to compare it with either
+				file.Path = node.transferInfo.Receiving.archivedPath(fileRelPath(file))
+				node.acceptFile(file)
+				continue
 			}
 
-			// check if the file already exists
-			_, err = os.Stat(file.Path)
-			if err == nil {
-				// exists
-				// check if it is the exact file
-				existingFileHandler, err := os.Open(file.Path)
-				if err != nil {
-					panic(err)
-				}
-
-				existingFileChecksum, err := checksum.GetPartialCheckSum(existingFileHandler)
-				if err != nil {
-					panic(err)
-				}
+			if node.transferInfo.Receiving.Writer != nil {
+				// it does not go into the downloads folder, so there is nothing to compare it with
+				file.Path = file.Name
+				node.acceptFile(file)
+				continue
+			}
 
-				if existingFileChecksum == file.Checksum {
-					// it`s the exact same file. No need to receive it again
-					// notify the other node
+			if node.transferInfo.Receiving.Storage != nil {
+				node.receiveFileIntoStorage(file)
+				continue
+			}
 
-					alreadyHavePacketBodyBuffer := new(bytes.Buffer)
-					binary.Write(alreadyHavePacketBodyBuffer, binary.BigEndian, file.ID)
+			if file.RelativeParentPath != "" {
+				file.RelativeParentPath = node.transferInfo.Receiving.renamed(file.RelativeParentPath)
+			}
 
-					alreadyHavePacket := protocol.Packet{
-						Header: protocol.HeaderAlreadyHave,
-						Body:   alreadyHavePacketBodyBuffer.Bytes(),
-					}
+			fileRelPath := fileRelPath(file)
+			file.Path = node.transferInfo.Receiving.Sandbox.Path(fileRelPath)
 
-					if node.netInfo.EncryptionKey != nil {
-						encryptedBody, err := encryption.Encrypt(node.netInfo.EncryptionKey, alreadyHavePacket.Body)
-						if err != nil {
-							panic(err)
-						}
-						alreadyHavePacket.Body = encryptedBody
-					}
+			// check if the file already exists
+			existingFileHandler, err := node.transferInfo.Receiving.Sandbox.OpenFile(fileRelPath, os.O_RDONLY, 0)
+			if errors.Is(err, fsys.ErrorUnsafePath) {
+				node.abortOnSecurityViolation(err)
+				continue
+			}
+			if err != nil {
+				// does not exist
+				node.acceptFile(file)
+				continue
+			}
 
-					protocol.SendPacket(node.netInfo.Conn, alreadyHavePacket)
+			// exists
+			// check if it is the exact file
+			existingFileChecksum, err := checksum.GetPartialCheckSum(existingFileHandler)
+			if err != nil {
+				node.fail(err)
+				continue
+			}
+			existingFileStats, err := existingFileHandler.Stat()
+			if err != nil {
+				node.fail(err)
+				continue
+			}
+			existingFileHandler.Close()
 
-					node.transferInfo.Receiving.ReceivedBytes += file.Size
+			if existingFileChecksum == file.Checksum {
+				// it`s the exact same file. No need to receive it again
+				// notify the other node
+				node.skipFile(file)
 
-					if node.verboseOutput {
-						fmt.Printf("\n[File] already have \"%s\"", file.Name)
-					}
+				if node.verboseOutput {
+					node.reporter.Printf("[File] already have \"%s\"", file.Name)
+				}
+				continue
+			}
 
-				} else {
-					// not the same file. Remove it and await new bytes
-					os.Remove(file.Path)
+			// not the same file
+			switch node.resolveFileConflict(file, existingFileStats) {
+			case ConflictSkip:
+				node.skipFile(file)
 
-					node.mutex.Lock()
-					node.transferInfo.Receiving.AcceptedFiles = append(node.transferInfo.Receiving.AcceptedFiles, file)
-					node.mutex.Unlock()
+				if node.verboseOutput {
+					node.reporter.Printf("[File] skipping conflicting \"%s\"", file.Name)
+				}
 
-					err = protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
-						Header: protocol.HeaderReady,
-					})
-					if err != nil {
-						panic(err)
-					}
+			case ConflictKeepBoth:
+				numberedRelPath, err := freeNumberedName(node.transferInfo.Receiving.Sandbox.Exists, fileRelPath)
+				if err != nil {
+					node.fail(err)
+					continue
 				}
+				setReceivedFileRelPath(file, numberedRelPath)
+				file.Path = node.transferInfo.Receiving.Sandbox.Path(numberedRelPath)
 
-				existingFileHandler.Close()
-			} else {
-				// does not exist
+				if node.verboseOutput {
+					node.reporter.Printf("[File] keeping both, receiving into \"%s\"", file.Path)
+				}
 
-				node.mutex.Lock()
-				node.transferInfo.Receiving.AcceptedFiles = append(node.transferInfo.Receiving.AcceptedFiles, file)
-				node.mutex.Unlock()
+				node.acceptFile(file)
 
-				err = protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
-					Header: protocol.HeaderReady,
-				})
-				if err != nil {
-					panic(err)
-				}
+			default:
+				// await new bytes. The existing file will be replaced when the new one is fully received
+				node.acceptFile(file)
 			}
 
 		case protocol.HeaderFileBytes:
@@ -766,7 +1705,8 @@ func (node *Node) receive() {
 			var fileID uint64
 			err := binary.Read(fileBytesBuffer, binary.BigEndian, &fileID)
 			if err != nil {
-				panic(err)
+				node.fail(err)
+				continue
 			}
 
 			for _, acceptedFile := range node.transferInfo.Receiving.AcceptedFiles {
@@ -774,21 +1714,14 @@ func (node *Node) receive() {
 					// accepted
 
 					// append provided bytes to the file
-					if acceptedFile.Handler == nil {
-						err = acceptedFile.Open()
-						if err != nil {
-							panic(err)
-						}
-					}
-
-					fileBytes := fileBytesBuffer.Bytes()
-
-					wrote, err := acceptedFile.Handler.WriteAt(fileBytes, int64(acceptedFile.SentBytes))
+					wrote, err := node.writeReceivedBytes(acceptedFile, fileBytesBuffer.Bytes())
 					if err != nil {
-						panic(err)
+						node.abortOnWriteError(acceptedFile, err)
+						break
 					}
 					acceptedFile.SentBytes += uint64(wrote)
 					node.transferInfo.Receiving.ReceivedBytes += uint64(wrote)
+					node.reporter.Transferred(uint64(wrote))
 				}
 			}
 
@@ -800,25 +1733,31 @@ func (node *Node) receive() {
 		case protocol.HeaderEndfile:
 			// one of the files has been received completely
 
-			fileIDReader := bytes.NewReader(incomingPacket.Body)
-			var fileID uint64
-			err := binary.Read(fileIDReader, binary.BigEndian, &fileID)
+			endedFile, err := protocol.DecodeEndfilePacket(incomingPacket)
 			if err != nil {
-				panic(err)
+				node.fail(err)
+				continue
 			}
 
 			for index, acceptedFile := range node.transferInfo.Receiving.AcceptedFiles {
-				if acceptedFile.ID == fileID {
+				if acceptedFile.ID == endedFile.ID {
 					// accepted
 
+					if acceptedFile.Streamed {
+						// now it is known what has been sent
+						acceptedFile.Size = endedFile.Size
+						acceptedFile.Checksum = endedFile.Checksum
+					}
+
 					if node.verboseOutput {
-						fmt.Printf("\n[File] fully received \"%s\" -- %d bytes", acceptedFile.Name, acceptedFile.Size)
+						node.reporter.Printf("[File] fully received \"%s\" -- %d bytes", acceptedFile.Name, acceptedFile.Size)
 					}
 
-					if acceptedFile.Handler == nil {
-						err = acceptedFile.Open()
+					if acceptedFile.Handler == nil && node.transferInfo.Receiving.Writer == nil && node.transferInfo.Receiving.Storage == nil {
+						err = node.openReceivedFile(acceptedFile)
 						if err != nil {
-							panic(err)
+							node.abortOnWriteError(acceptedFile, err)
+							break
 						}
 					}
 
@@ -826,22 +1765,49 @@ func (node *Node) receive() {
 					node.transferInfo.Receiving.AcceptedFiles = append(node.transferInfo.Receiving.AcceptedFiles[:index], node.transferInfo.Receiving.AcceptedFiles[index+1:]...)
 
 					// compare checksums
-					realChecksum, err := checksum.GetPartialCheckSum(acceptedFile.Handler)
+					realChecksum, err := node.receivedChecksum(acceptedFile)
 					if err != nil {
-						panic(err)
+						node.fail(err)
+						break
 					}
 
 					if realChecksum != acceptedFile.Checksum {
-						if node.verboseOutput {
-							fmt.Printf("\n[ERROR] \"%s\" is corrupted", acceptedFile.Name)
+						node.reporter.FileFailed(fileRelPath(acceptedFile))
+						node.reporter.Printf("[ERROR] \"%s\" is corrupted", acceptedFile.Name)
+						if node.transferInfo.Receiving.Archive != nil {
+							// there is no taking it back
+							node.reporter.Printf("[ERROR] \"%s\" is in the archive anyway", acceptedFile.Path)
 						}
+						node.reporter.Error(progress.ErrorIntegrity, fileRelPath(acceptedFile), "checksum mismatch")
+						node.mutex.Lock()
+						node.outcome.corrupted++
+						node.mutex.Unlock()
 
+						// do not leave corrupted data to be resumed from
 						acceptedFile.Close()
-						break
-					} else {
-						acceptedFile.Close()
+						if node.transferInfo.Receiving.Storage != nil {
+							node.abortStorageFile()
+						} else if node.transferInfo.Receiving.Writer == nil {
+							node.transferInfo.Receiving.Sandbox.Remove(fsys.PartialName(fileRelPath(acceptedFile)))
+						}
 						break
 					}
+
+					if node.transferInfo.Receiving.Storage != nil {
+						err = node.finishStorageFile(acceptedFile)
+						if err != nil {
+							node.abortOnWriteError(acceptedFile, err)
+							break
+						}
+					} else if node.transferInfo.Receiving.Writer == nil {
+						err = node.finishReceivedFile(acceptedFile)
+						if err != nil {
+							node.fail(err)
+							break
+						}
+					}
+					node.reporter.FileDone(fileRelPath(acceptedFile))
+					break
 				}
 			}
 
@@ -849,7 +1815,8 @@ func (node *Node) receive() {
 				Header: protocol.HeaderReady,
 			})
 			if err != nil {
-				panic(err)
+				node.fail(err)
+				continue
 			}
 
 		case protocol.HeaderEncryptionKey:
@@ -864,45 +1831,79 @@ func (node *Node) receive() {
 
 			node.netInfo.EncryptionKey = encrKey
 
-			fmt.Printf("\nGot an encryption key: %s", encrKey)
+			fmt.Fprintf(node.output, "\nGot an encryption key: %s", encrKey)
 
 		case protocol.HeaderSymlink:
-			// SYMLINK~(string size in binary)(location in the filesystem)(string size in binary)(location of a target)
-			packetReader := bytes.NewReader(incomingPacket.Body)
-
-			// extract the location of the symlink
-			var locationSize uint64
-			binary.Read(packetReader, binary.BigEndian, &locationSize)
-
-			symlinkLocationBytes := make([]byte, locationSize)
-			packetReader.Read(symlinkLocationBytes)
-
-			// extract the target of a symlink
-			var targetSize uint64
-			binary.Read(packetReader, binary.BigEndian, &targetSize)
-
-			symlinkTargetLocationBytes := make([]byte, targetSize)
-			packetReader.Read(symlinkTargetLocationBytes)
-
-			symlinkLocation := string(symlinkLocationBytes)
-			symlinkTargetLocation := string(symlinkTargetLocationBytes)
-
-			// create a symlink
-
-			// should be already downloaded
-			symlinkDir := filepath.Join(node.transferInfo.Receiving.DownloadsPath, filepath.Dir(symlinkLocation))
-			os.MkdirAll(symlinkDir, os.ModePerm)
+			symlink, err := protocol.DecodeSymlinkPacket(incomingPacket)
+			if errors.Is(err, fsys.ErrorUnsafePath) {
+				node.abortOnSecurityViolation(err)
+				continue
+			}
+			if err != nil {
+				node.fail(err)
+				continue
+			}
 
-			os.Symlink(
-				filepath.Join(node.transferInfo.Receiving.DownloadsPath, symlinkTargetLocation),
-				filepath.Join(node.transferInfo.Receiving.DownloadsPath, symlinkLocation))
+			if targetErr := fsys.ValidateRelativePath(symlink.TargetPath); targetErr != nil {
+				// it points to something on the side of the sender (ie: an absolute path), which is not here
+				node.reporter.Printf("[WARNING] Skipping symlink \"%s\": it points outside of the transfer (%s)", symlink.Path, targetErr)
+			} else if node.transferInfo.Receiving.Archive != nil {
+				err = node.archiveSymlink(symlink)
+				if err != nil {
+					node.abortOnArchiveError(symlink.Path, err)
+					continue
+				}
+			} else if node.transferInfo.Receiving.Storage != nil {
+				err = node.storageSymlink(symlink)
+				if errors.Is(err, fsys.ErrorUnsafePath) {
+					node.abortOnSecurityViolation(err)
+					continue
+				}
+				if err != nil && !symlinkExists(err, node.transferInfo.Receiving.Storage.Stat, node.transferInfo.Receiving.storagePath(symlink.Path)) {
+					node.symlinkFailed(symlink, err)
+				}
+			} else {
+				symlink.Path = node.transferInfo.Receiving.renamed(symlink.Path)
+				symlink.TargetPath = node.transferInfo.Receiving.renamed(symlink.TargetPath)
+
+				// create a symlink; the target should be already downloaded
+				err = node.transferInfo.Receiving.Sandbox.Symlink(node.transferInfo.Receiving.Sandbox.Path(symlink.TargetPath), symlink.Path)
+				if errors.Is(err, fsys.ErrorUnsafePath) {
+					node.abortOnSecurityViolation(err)
+					continue
+				}
+				if err != nil && !symlinkExists(err, node.transferInfo.Receiving.Sandbox.Lstat, symlink.Path) {
+					node.symlinkFailed(symlink, err)
+				}
+			}
 
 			protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
 				Header: protocol.HeaderReady,
 			})
 
+		case protocol.HeaderEmptyDirectory:
+			node.receiveEmptyDirectory(incomingPacket)
+
+		case protocol.HeaderText:
+			text, err := protocol.DecodeTextPacket(incomingPacket)
+			if err != nil {
+				node.fail(err)
+				continue
+			}
+			node.receiveText(text)
+
 		case protocol.HeaderDone:
+			if node.transferInfo.Receiving.Archive != nil {
+				// the archive is complete only when it is finished
+				err = node.transferInfo.Receiving.Archive.Close()
+				if err != nil {
+					node.abortOnArchiveError("", err)
+					continue
+				}
+			}
+
 			node.mutex.Lock()
+			node.outcome.completed = true
 			node.stopped = true
 			node.mutex.Unlock()
 
@@ -911,17 +1912,43 @@ func (node *Node) receive() {
 			node.stopped = true
 			node.mutex.Unlock()
 
-			fmt.Printf("\n%s disconnected", node.netInfo.Conn.RemoteAddr())
+			fmt.Fprintf(node.output, "\n%s disconnected", node.remoteName(node.netInfo.Conn))
 		}
 	}
 }
 
-// Starts the node in either sending or receiving state and performs the transfer
-func (node *Node) Start() {
+// Starts the node in either sending or receiving state and performs the transfer.
+// Canceling the context stops the transfer. Never panics or exits; returns how the
+// transfer has ended and an error (see Error* variables) if it has not been successful
+func (node *Node) Start(ctx context.Context) (*Result, error) {
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			node.cancel()
+		case <-finished:
+		}
+	}()
+
 	switch node.isSending {
 	case true:
-		node.send()
+		node.send(ctx)
 	case false:
-		node.receive()
+		node.receive(ctx)
 	}
+
+	summary := node.reporter.Stop()
+	status := node.status(summary)
+	node.reporter.Completed(string(status))
+
+	node.mutex.Lock()
+	text := node.outcome.text
+	node.mutex.Unlock()
+
+	return &Result{
+		Status:  status,
+		Summary: summary,
+		Text:    text,
+	}, node.statusError(ctx, status)
 }
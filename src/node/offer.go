@@ -0,0 +1,72 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package node
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"unbewohnte/ftu/fsys"
+)
+
+// Decides whether to accept what the sender offers
+type OfferDecider interface {
+	// Only one of file and dir is not nil. Returns true to accept the offer
+	Decide(file *fsys.File, dir *fsys.Directory) bool
+}
+
+// Lets an ordinary function be used as an OfferDecider
+type OfferDeciderFunc func(file *fsys.File, dir *fsys.Directory) bool
+
+func (decide OfferDeciderFunc) Decide(file *fsys.File, dir *fsys.Directory) bool {
+	return decide(file, dir)
+}
+
+// Accepts every offer
+var AcceptAll OfferDecider = OfferDeciderFunc(func(file *fsys.File, dir *fsys.Directory) bool {
+	return true
+})
+
+// Asks the user whether to accept the offer, printing the question into Output
+// and reading the answer from Input (os.Stdin if nil)
+type Prompt struct {
+	Input  io.Reader
+	Output io.Writer
+}
+
+// Returns where the answers are read from
+func (prompt *Prompt) input() io.Reader {
+	if prompt.Input == nil {
+		return os.Stdin
+	}
+	return prompt.Input
+}
+
+func (prompt *Prompt) Decide(file *fsys.File, dir *fsys.Directory) bool {
+	var answer string
+	fmt.Fprintf(prompt.Output, "| Download ? [Y/n]: ")
+	fmt.Fscanln(prompt.input(), &answer)
+	fmt.Fprintf(prompt.Output, "\n\n")
+
+	return strings.EqualFold(answer, "y") || answer == ""
+}
@@ -20,21 +20,63 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
 package node
 
+import (
+	"io"
+	"io/fs"
+	"net"
+
+	"unbewohnte/ftu/archive"
+	"unbewohnte/ftu/limit"
+	"unbewohnte/ftu/progress"
+	"unbewohnte/ftu/storage"
+	"unbewohnte/ftu/transport"
+)
+
 type SenderNodeOptions struct {
-	ServingPath string
-	Recursive   bool
+	ListenAddr     string // address to listen on (by the receiver too when reversed). All interfaces if empty
+	ServingPath    string
+	ServingPaths   []string // several files|directories sent together, each under its own name. Used instead of ServingPath if there is more than one
+	Recursive      bool
+	FollowSymlinks bool
+	MaxDepth       uint
+	Text           string    // if != "" - the text message is sent instead of ServingPath
+	Stream         io.Reader // if != nil - it is read until EOF and sent as a file named after ServingPath
+	FS             fs.FS     // if != nil - ServingPath is a slash-separated path in it rather than in the OS filesystem ("." for all of it)
+	FSName         string    // the name of the directory all of FS is sent as. "files" if empty
 }
 
 type ReceiverNodeOptions struct {
-	ConnectionAddr      string
+	ConnectionAddr      string // address to connect to (by the sender too when reversed)
 	DownloadsFolderPath string
+	OnConflict          ConflictPolicy    // what to do with a file that already exists and differs. Overwrite by default
+	OnDirConflict       DirConflictPolicy // what to do when the offered directory already exists. Merge by default
+	Resume              bool              // continue receiving files from leftover partial files of the interrupted transfer
+	CleanPartial        bool              // remove leftover partial files in the downloads folder before receiving
+	Backup              BackupPolicy      // what to do with files before replacing them. None by default
+	BackupKeep          uint              // how many backups of a file (or dated backup directories) to keep. 0 means keep all
+	IgnoreFreeSpace     bool              // only warn instead of rejecting a transfer that does not fit into the downloads folder
+	OfferDecider        OfferDecider      // decides whether to accept the offered file or directory. The user is asked on stdin if nil
+	ConflictDecider     ConflictDecider   // decides on conflicts when a conflict policy is "ask". Conflicting files are skipped and directories rejected if nil
+	TextFile            string            // if != "" - received text messages are appended to this file as well
+	Writer              io.Writer         // if != nil - the received file|stream is written into it instead of the downloads folder. Directories are rejected
+	Archive             archive.Format    // if != "" - the received file|directory is written into Writer as an archive of such format. Streams are rejected
+
+	Storage storage.Storage // if != nil - everything is received into it instead of the OS filesystem. DownloadsFolderPath is then a slash-separated directory in it ("" for the root)
 }
 
 // Options to configure the node
 type NodeOptions struct {
-	IsSending     bool
-	WorkingPort   uint
-	VerboseOutput bool
-	SenderSide    *SenderNodeOptions
-	ReceiverSide  *ReceiverNodeOptions
+	IsSending      bool
+	Reverse        bool // the receiver waits for the sender to connect instead of connecting to it
+	WorkingPort    uint
+	VerboseOutput  bool
+	Output         io.Writer           // where human-readable messages and progress go. os.Stdout if nil
+	Events         io.Writer           // if != nil - newline-delimited JSON events are written into it instead of the progress
+	Conn           net.Conn            // if != nil - the already established connection is used instead of listening|dialing
+	Transport      transport.Transport // how to connect. TCP on WorkingPort and ConnectionAddr|ListenAddr if nil
+	Observer       progress.Observer   // if != nil - told about every event of the transfer
+	SendLimiter    *limit.Limiter      // limits outgoing traffic if != nil
+	ReceiveLimiter *limit.Limiter      // limits incoming traffic if != nil
+	SenderSide     *SenderNodeOptions
+	ReceiverSide   *ReceiverNodeOptions
 }
@@ -0,0 +1,61 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package node
+
+import (
+	"fmt"
+
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/progress"
+)
+
+// Checks whether the offered transfer of size bytes fits into the downloads folder.
+// Returns false if it does not and the transfer must be rejected
+func (node *Node) checkFreeSpace(size uint64) bool {
+	free, err := fsys.FreeSpace(node.transferInfo.Receiving.DownloadsPath)
+	if err != nil {
+		// can not tell, hope for the best
+		if node.verboseOutput {
+			fmt.Fprintf(node.output, "| Could not determine free space: %s\n", err)
+		}
+		return true
+	}
+
+	fmt.Fprintf(node.output, "| Free space: %s\n", progress.FormatSize(free))
+
+	if size <= free {
+		return true
+	}
+
+	fmt.Fprintf(node.output, "\n[ERROR] Not enough free space in \"%s\": need %d bytes (%s), only %d bytes (%s) available, %d bytes (%s) short\n",
+		node.transferInfo.Receiving.DownloadsPath,
+		size, progress.FormatSize(size),
+		free, progress.FormatSize(free),
+		size-free, progress.FormatSize(size-free),
+	)
+
+	if node.transferInfo.Receiving.IgnoreFreeSpace {
+		fmt.Fprintf(node.output, "[WARNING] Continuing anyway, files that already exist might not need to be received again\n")
+		return true
+	}
+
+	return false
+}
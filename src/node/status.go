@@ -0,0 +1,174 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"unbewohnte/ftu/progress"
+)
+
+// How the transfer has ended
+type Status string
+
+const (
+	StatusSuccess          Status = "success"           // everything has been transferred (or already was there)
+	StatusPartial          Status = "partial"           // some files have been transferred, but not all of them
+	StatusRejected         Status = "rejected"          // the transfer has been rejected by the receiver
+	StatusConnectionFailed Status = "connection-failed" // could not connect or the connection has been lost before any file was transferred
+	StatusIntegrityFailed  Status = "integrity-failed"  // some received files did not match their checksums
+	StatusFailed           Status = "failed"            // the transfer has been aborted before any file was transferred
+	StatusCanceled         Status = "canceled"          // the context has been canceled
+)
+
+var (
+	ErrorRejected   error = errors.New("the transfer has been rejected")
+	ErrorConnection error = errors.New("connection failure")
+	ErrorIntegrity  error = errors.New("received files do not match their checksums")
+	ErrorPartial    error = errors.New("not everything has been transferred")
+)
+
+// How the transfer has ended and what has been done
+type Result struct {
+	Status Status
+	progress.Summary
+	Text string // the received text message if that is what has been sent
+}
+
+// What has happened during the transfer
+type outcome struct {
+	completed      bool // the sender has sent everything
+	rejected       bool
+	connectionLost bool
+	canceled       bool
+	aborted        bool  // because of a write error or a security violation
+	corrupted      uint  // files that did not match their checksums
+	failedSymlinks uint  // received symlinks that could not be created
+	err            error // the first error that has stopped the transfer
+
+	text string // the received text message
+}
+
+// Stops the node because of an error that does not allow to continue the transfer.
+// Only the first error is kept
+func (node *Node) fail(err error) {
+	node.reporter.Printf("[ERROR] %s", err)
+	node.reporter.Error(progress.ErrorOther, "", err.Error())
+
+	node.mutex.Lock()
+	if node.outcome.err == nil {
+		node.outcome.err = err
+	}
+	node.outcome.aborted = true
+	node.stopped = true
+	node.mutex.Unlock()
+}
+
+// Stops the node because it could not connect or the connection has been lost
+func (node *Node) failConnection(err error) {
+	node.mutex.Lock()
+	canceled := node.outcome.canceled
+	node.mutex.Unlock()
+	if canceled {
+		// closed on purpose
+		return
+	}
+
+	node.reporter.Printf("[ERROR] %s", err)
+	node.reporter.Error(progress.ErrorConnection, "", err.Error())
+
+	node.mutex.Lock()
+	if node.outcome.err == nil {
+		node.outcome.err = err
+	}
+	node.outcome.connectionLost = true
+	node.stopped = true
+	node.mutex.Unlock()
+}
+
+// Determines how the transfer has ended
+func (node *Node) status(summary progress.Summary) Status {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	result := node.outcome
+	if !result.completed && !result.rejected && !result.aborted && !result.canceled {
+		// the other side has gone away in the middle of the transfer
+		result.connectionLost = true
+	}
+
+	switch {
+	case result.rejected:
+		return StatusRejected
+	case result.canceled:
+		return StatusCanceled
+	case result.corrupted != 0:
+		return StatusIntegrityFailed
+	case result.connectionLost || result.aborted:
+		if summary.FilesDone != 0 {
+			return StatusPartial
+		}
+		if result.connectionLost {
+			return StatusConnectionFailed
+		}
+		return StatusFailed
+	case summary.FilesFailed != 0 || result.failedSymlinks != 0:
+		return StatusPartial
+	}
+
+	return StatusSuccess
+}
+
+// Returns the error describing how the transfer has ended. nil on success
+func (node *Node) statusError(ctx context.Context, status Status) error {
+	node.mutex.Lock()
+	cause := node.outcome.err
+	corrupted := node.outcome.corrupted
+	node.mutex.Unlock()
+
+	switch status {
+	case StatusSuccess:
+		return nil
+	case StatusRejected:
+		return ErrorRejected
+	case StatusCanceled:
+		return ctx.Err()
+	case StatusIntegrityFailed:
+		return fmt.Errorf("%w: %d corrupted", ErrorIntegrity, corrupted)
+	case StatusConnectionFailed:
+		if cause == nil {
+			return ErrorConnection
+		}
+		return fmt.Errorf("%w: %s", ErrorConnection, cause)
+	case StatusPartial:
+		if cause == nil {
+			return ErrorPartial
+		}
+		return fmt.Errorf("%w: %s", ErrorPartial, cause)
+	default:
+		if cause == nil {
+			return errors.New("the transfer has failed")
+		}
+		return cause
+	}
+}
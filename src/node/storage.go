@@ -0,0 +1,197 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package node
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/progress"
+	"unbewohnte/ftu/storage"
+)
+
+var ErrorStorageWriter error = fmt.Errorf("received files can not be written both into the writer and into the storage")
+var ErrorStorageUnsupported error = fmt.Errorf("resuming, backups and cleaning partial files are only possible in the downloads folder, not in the storage")
+
+// Returns the directory in the storage the downloads folder is: a clean slash-separated name. "." for the root
+func storageRoot(downloadsPath string) (string, error) {
+	root := strings.Trim(path.Clean("/"+filepath.ToSlash(downloadsPath)), "/")
+	if root == "" {
+		return ".", nil
+	}
+	if !fs.ValidPath(root) {
+		return "", &fs.PathError{Op: "open", Path: downloadsPath, Err: fs.ErrInvalid}
+	}
+
+	return root, nil
+}
+
+// Returns the name of the received entry in the storage
+func (receiving *receiving) storagePath(relPath string) string {
+	return path.Join(receiving.StorageRoot, filepath.ToSlash(relPath))
+}
+
+// Tells whether anything exists at relPath in the storage or in the downloads folder
+func (receiving *receiving) exists(relPath string) (bool, error) {
+	if receiving.Storage != nil {
+		return storage.Exists(receiving.Storage, receiving.storagePath(relPath))
+	}
+
+	return receiving.Sandbox.Exists(relPath)
+}
+
+// Creates the offered directory in the storage and receives everything into it
+func (node *Node) enterStorageDirectory(name string) error {
+	dirPath := node.transferInfo.Receiving.storagePath(name)
+
+	err := node.transferInfo.Receiving.Storage.Mkdir(dirPath)
+	if err != nil {
+		return err
+	}
+
+	node.transferInfo.Receiving.StorageRoot = dirPath
+
+	return nil
+}
+
+// Decides what to do with the offered file that goes into the storage. The existing file can not be
+// read back, so it is the same as the offered one if its size and modification time are the same
+func (node *Node) receiveFileIntoStorage(file *fsys.File) {
+	if file.RelativeParentPath != "" {
+		file.RelativeParentPath = node.transferInfo.Receiving.renamed(file.RelativeParentPath)
+	}
+
+	fileRelPath := fileRelPath(file)
+	file.Path = node.transferInfo.Receiving.storagePath(fileRelPath)
+
+	existing, err := node.transferInfo.Receiving.Storage.Stat(file.Path)
+	if errors.Is(err, fsys.ErrorUnsafePath) {
+		node.abortOnSecurityViolation(err)
+		return
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		node.acceptFile(file)
+		return
+	}
+	if err != nil {
+		node.abortOnStorageError(file.Path, err)
+		return
+	}
+
+	if existing.Mode().IsRegular() && uint64(existing.Size()) == file.Size &&
+		!file.ModTime.IsZero() && existing.ModTime().Equal(file.ModTime) {
+		node.skipFile(file)
+
+		if node.verboseOutput {
+			node.reporter.Printf("[File] already have \"%s\"", file.Name)
+		}
+		return
+	}
+
+	switch node.resolveFileConflict(file, existing) {
+	case ConflictSkip:
+		node.skipFile(file)
+
+		if node.verboseOutput {
+			node.reporter.Printf("[File] skipping conflicting \"%s\"", file.Name)
+		}
+
+	case ConflictKeepBoth:
+		numberedRelPath, err := freeNumberedName(node.transferInfo.Receiving.exists, fileRelPath)
+		if err != nil {
+			node.abortOnStorageError(file.Path, err)
+			return
+		}
+		setReceivedFileRelPath(file, numberedRelPath)
+		file.Path = node.transferInfo.Receiving.storagePath(numberedRelPath)
+
+		if node.verboseOutput {
+			node.reporter.Printf("[File] keeping both, receiving into \"%s\"", file.Path)
+		}
+
+		node.acceptFile(file)
+
+	default:
+		// the existing file is replaced when the new one is finalized
+		node.acceptFile(file)
+	}
+}
+
+// Puts the fully received and verified file into its place in the storage with its permissions and modification time
+func (node *Node) finishStorageFile(file *fsys.File) error {
+	storageFile := node.transferInfo.Receiving.StorageFile
+	node.transferInfo.Receiving.StorageFile = nil
+
+	err := storageFile.Finalize()
+	if err != nil {
+		return err
+	}
+
+	err = node.transferInfo.Receiving.Storage.SetMetadata(file.Path, file.Mode, file.ModTime)
+	if err != nil {
+		// the file itself is there
+		node.reporter.Printf("[ERROR] Could not set the permissions and modification time of \"%s\": %s", file.Path, err)
+	}
+
+	return nil
+}
+
+// Discards the file that is being written into the storage, if any
+func (node *Node) abortStorageFile() {
+	if node.transferInfo.Receiving.StorageFile == nil {
+		return
+	}
+
+	node.transferInfo.Receiving.StorageFile.Abort()
+	node.transferInfo.Receiving.StorageFile = nil
+}
+
+// Creates the received symlink in the storage. The target is made relative to the directory of the symlink
+func (node *Node) storageSymlink(symlink *fsys.Symlink) error {
+	symlink.Path = node.transferInfo.Receiving.renamed(symlink.Path)
+	symlink.TargetPath = node.transferInfo.Receiving.renamed(symlink.TargetPath)
+
+	target, err := filepath.Rel(filepath.Dir(symlink.Path), symlink.TargetPath)
+	if err != nil {
+		return err
+	}
+
+	return node.transferInfo.Receiving.Storage.Symlink(filepath.ToSlash(target), node.transferInfo.Receiving.storagePath(symlink.Path))
+}
+
+// Stops the receiving node because something can not be created in the storage
+func (node *Node) abortOnStorageError(name string, err error) {
+	node.reporter.Printf("[ERROR] Could not create \"%s\" in the storage: %s. Aborting the transfer", name, err)
+	node.reporter.Error(progress.ErrorWrite, name, err.Error())
+
+	node.mutex.Lock()
+	if node.outcome.err == nil {
+		node.outcome.err = err
+	}
+	node.outcome.aborted = true
+	node.stopped = true
+	node.mutex.Unlock()
+}
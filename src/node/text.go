@@ -0,0 +1,130 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"unbewohnte/ftu/encryption"
+	"unbewohnte/ftu/progress"
+	"unbewohnte/ftu/protocol"
+)
+
+// Sends the text message instead of files. There is nothing to accept, so the text
+// goes right after the encryption key, followed by DONE
+func (node *Node) sendText(ctx context.Context) {
+	text := node.transferInfo.Sending.Text
+
+	textPacket, err := protocol.CreateTextPacket(text)
+	if err != nil {
+		node.fail(err)
+		return
+	}
+
+	size := progress.FormatSize(uint64(len(text)))
+	if node.netInfo.Conn == nil && node.netInfo.Listening {
+		fmt.Fprintf(node.output, "\nSending a text message (%s) %s", size, node.listenDescription())
+	} else {
+		fmt.Fprintf(node.output, "\nSending a text message (%s)", size)
+	}
+
+	// wait for the receiver or connect to it
+	err = node.establishConnection(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			node.cancel()
+			return
+		}
+		node.failConnection(err)
+		return
+	}
+
+	encrKey := encryption.Generate32AESkey()
+	node.netInfo.EncryptionKey = encrKey
+
+	err = protocol.SendEncryptionKey(node.netInfo.Conn, encrKey)
+	if err != nil {
+		node.failConnection(err)
+		return
+	}
+
+	err = textPacket.EncryptBody(encrKey)
+	if err != nil {
+		node.fail(err)
+		return
+	}
+	protocol.SendPacket(node.netInfo.Conn, *textPacket)
+
+	protocol.SendPacket(node.netInfo.Conn, protocol.Packet{
+		Header: protocol.HeaderDone,
+	})
+
+	node.mutex.Lock()
+	node.outcome.completed = true
+	node.mutex.Unlock()
+
+	fmt.Fprintf(node.output, "\nSent\n")
+	node.disconnect()
+}
+
+// Shows the received text message and appends it to the text file if there is one
+func (node *Node) receiveText(text string) {
+	node.mutex.Lock()
+	node.outcome.text = text
+	node.mutex.Unlock()
+
+	node.reporter.Printf("\n| Text message:")
+	node.reporter.Text(text)
+
+	textFile := node.transferInfo.Receiving.TextFile
+	if textFile == "" {
+		return
+	}
+
+	err := AppendText(textFile, text)
+	if err != nil {
+		node.reporter.Printf("[ERROR] Could not write the text message into \"%s\": %s", textFile, err)
+		node.reporter.Error(progress.ErrorWrite, "", err.Error())
+	}
+}
+
+// Appends the text message to the file, creating it if necessary. Every message ends with a newline
+func AppendText(path string, text string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+
+	_, err = file.WriteString(text)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
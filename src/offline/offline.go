@@ -0,0 +1,458 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Offline transfers: what the sender would send over the connection is written into a bundle
+// file (split into volumes if needed), carried over and received from it later, the same way
+// as from a live sender.
+//
+// A bundle is a sequence of packets of the protocol as the sender sends them to the receiver that
+// accepts everything: TRANSFEROFFER with the manifest, FILE, FILEBYTES and ENDFILE of every file,
+// SYMLINK, EMPTYDIR and DONE, each as (size)(header)~(body), with files checksummed. The key of
+// the connection is not kept: bodies are stored decrypted, and the whole sequence is encrypted
+// with the key derived from the passphrase or the key file (see seal.go), which has to be given
+// again to extract or verify the bundle. The receiver gets a key of its own when the bundle is
+// extracted, as from the live sender.
+package offline
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"unbewohnte/ftu/encryption"
+	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/protocol"
+	"unbewohnte/ftu/storage"
+	"unbewohnte/ftu/transfer"
+	"unbewohnte/ftu/transport"
+)
+
+var ErrorSenderStopped error = errors.New("the sender has stopped before everything has been written")
+
+type CreateOptions struct {
+	transfer.SendOptions
+	VolumeSize uint64 // split the bundle into volumes of at most this many bytes: path.001, path.002... 0 means one file
+	Key        []byte // the passphrase or the contents of the key file the bundle is encrypted with. Required
+}
+
+type ExtractOptions struct {
+	transfer.ReceiveOptions
+	Key []byte // the passphrase or the contents of the key file the bundle has been created with
+}
+
+// Writes the source file or directory (and options.MoreSources) into the bundle at path, the same way
+// they would be sent to the receiver. Nothing is left at path if the bundle has not been written completely
+func Create(ctx context.Context, path string, source string, options CreateOptions) (*transfer.Result, error) {
+	if len(options.Key) == 0 {
+		return nil, ErrorNoKey
+	}
+
+	volumes, err := newVolumeWriter(path, options.VolumeSize)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := newSealWriter(volumes, options.Key, volumes.id)
+	if err != nil {
+		return nil, err
+	}
+
+	senderConn, recorderConn := net.Pipe()
+
+	recorded := make(chan error, 1)
+	go func() {
+		recorded <- record(recorderConn, bundle)
+	}()
+
+	options.Reverse = false
+	result, err := transfer.SendConn(ctx, transport.NewStreamConn(senderConn, path), source, options.SendOptions)
+	senderConn.Close()
+
+	recordErr := <-recorded
+	if err != nil && errors.Is(recordErr, ErrorSenderStopped) {
+		// nothing has gone wrong with the bundle itself
+		recordErr = nil
+	}
+	sealErr := bundle.Close()
+	closeErr := volumes.Close()
+	if recordErr == nil {
+		recordErr = sealErr
+	}
+	if recordErr == nil {
+		recordErr = closeErr
+	}
+
+	if result != nil && result.Status == node.StatusSuccess && recordErr != nil {
+		// everything has been sent, but not everything is in the bundle
+		result.Status = node.StatusFailed
+	}
+	if result == nil || result.Status != node.StatusSuccess {
+		volumes.Remove()
+	}
+
+	return result, joinErrors(err, recordErr)
+}
+
+// Receives what is in the bundle at path into destination directory with the same options and checks
+// as from the live sender. The whole of the bundle is offered to options.OfferDecider first.
+// Nothing is offered if options.Key is not the one the bundle has been created with
+func Extract(ctx context.Context, path string, destination string, options ExtractOptions) (*transfer.Result, error) {
+	volumes, err := openVolumes(path)
+	if err != nil {
+		return nil, err
+	}
+	defer volumes.Close()
+
+	bundle, err := newOpenReader(volumes, options.Key, volumes.id)
+	if err != nil {
+		return nil, err
+	}
+	// a wrong key shows right away
+	err = bundle.open()
+	if err != nil {
+		return nil, err
+	}
+
+	playerConn, receiverConn := net.Pipe()
+
+	played := make(chan error, 1)
+	go func() {
+		played <- play(bundle, playerConn)
+	}()
+
+	options.Reverse = false
+	result, err := transfer.ReceiveConn(ctx, transport.NewStreamConn(receiverConn, path), destination, options.ReceiveOptions)
+	receiverConn.Close()
+
+	return result, joinErrors(err, <-played)
+}
+
+// Receives the bundle at path without writing anything, checking that it can be read to the end,
+// decrypted with key and that every file matches its checksum. The result is the same as of Extract otherwise
+func Verify(ctx context.Context, path string, key []byte, options transfer.Options) (*transfer.Result, error) {
+	return Extract(ctx, path, "", ExtractOptions{
+		ReceiveOptions: transfer.ReceiveOptions{
+			Options: options,
+			Storage: storage.Discard,
+		},
+		Key: key,
+	})
+}
+
+// Returns the error of the transfer, explained by the error of the bundle if there is one.
+// The error of the bundle is the cause, so that is what is wrapped
+func joinErrors(transferErr error, bundleErr error) error {
+	if bundleErr == nil {
+		return transferErr
+	}
+	if transferErr == nil {
+		return bundleErr
+	}
+
+	return fmt.Errorf("%s: %w", transferErr, bundleErr)
+}
+
+// Returns the key in the ENCRKEY packet
+func decodeKey(packet *protocol.Packet) ([]byte, error) {
+	packetReader := bytes.NewReader(packet.Body)
+
+	var keySize uint64
+	binary.Read(packetReader, binary.BigEndian, &keySize)
+	if keySize != uint64(encryption.KEYLEN) || uint64(packetReader.Len()) < keySize {
+		return nil, protocol.ErrorInvalidPacket
+	}
+
+	key := make([]byte, keySize)
+	packetReader.Read(key)
+
+	return key, nil
+}
+
+// Writes every packet of the sender into the bundle decrypted, answering it as the receiver that accepts everything would
+func record(conn net.Conn, bundle io.Writer) error {
+	defer conn.Close()
+
+	var connectionKey []byte
+
+	for {
+		packetBytes, err := protocol.ReadFromConn(conn)
+		if err != nil {
+			return ErrorSenderStopped
+		}
+
+		packet, err := protocol.BytesToPacket(packetBytes)
+		if err != nil {
+			return err
+		}
+
+		switch packet.Header {
+		case protocol.HeaderDisconnecting:
+			return ErrorSenderStopped
+
+		case protocol.HeaderEncryptionKey:
+			// only for this connection; the bundle is encrypted on its own
+			connectionKey, err = decodeKey(packet)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if connectionKey != nil {
+			err = packet.DecryptBody(connectionKey)
+			if err != nil {
+				return err
+			}
+		}
+		packetBytes, err = packet.ToBytes()
+		if err != nil {
+			return err
+		}
+		_, err = bundle.Write(packetBytes)
+		if err != nil {
+			return err
+		}
+
+		switch packet.Header {
+		case protocol.HeaderTransferOffer:
+			protocol.SendPacket(conn, protocol.Packet{
+				Header: protocol.HeaderAccept,
+			})
+
+		case protocol.HeaderFile, protocol.HeaderFileBytes, protocol.HeaderEndfile, protocol.HeaderSymlink:
+			protocol.SendPacket(conn, protocol.Packet{
+				Header: protocol.HeaderReady,
+			})
+
+		case protocol.HeaderDone:
+			// let the sender say goodbye
+			for {
+				_, err = protocol.ReadFromConn(conn)
+				if err != nil {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// Reads the next packet from the bundle
+func readPacket(bundle io.Reader) (*protocol.Packet, error) {
+	var packetSize uint64
+	err := binary.Read(bundle, binary.BigEndian, &packetSize)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrorTruncated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if packetSize > uint64(protocol.MAXPACKETSIZE) {
+		return nil, protocol.ErrorInvalidPacket
+	}
+
+	packetBytes := make([]byte, packetSize)
+	_, err = io.ReadFull(bundle, packetBytes)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, ErrorTruncated
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return protocol.BytesToPacket(packetBytes)
+}
+
+// Sends the packets of the bundle to the receiver as the sender would: with a key of its own, a file at a time,
+// waiting for the receiver to be ready, skipping the files it already has and continuing from where it asks to resume.
+// Returns an error only if the bundle can not be read; the receiver decides how the transfer has ended otherwise
+func play(bundle io.Reader, conn net.Conn) error {
+	defer conn.Close()
+
+	replies := make(chan *protocol.Packet, 100)
+	go protocol.ReceivePackets(conn, replies)
+
+	encryptionKey := encryption.Generate32AESkey()
+	err := protocol.SendEncryptionKey(conn, encryptionKey)
+	if err != nil {
+		return nil
+	}
+
+	// the file that is being sent
+	var skipping bool   // the receiver does not want it
+	var resumeAt uint64 // where the receiver wants it to continue from
+	var position uint64 // how much of it has been passed
+
+	// encrypts and sends the packet. Tells whether the receiver is still there
+	forward := func(packet *protocol.Packet) bool {
+		if len(packet.Body) != 0 && packet.EncryptBody(encryptionKey) != nil {
+			return false
+		}
+
+		packetBytes, err := packet.ToBytes()
+		if err != nil {
+			return false
+		}
+		_, err = conn.Write(packetBytes)
+
+		return err == nil
+	}
+
+	// waits for the answer of the receiver. Nil if it has gone
+	awaitReply := func() *protocol.Packet {
+		reply, ok := <-replies
+		if !ok || reply.Header == protocol.HeaderDisconnecting {
+			return nil
+		}
+
+		if reply.DecryptBody(encryptionKey) != nil {
+			return nil
+		}
+
+		return reply
+	}
+
+	for {
+		packet, err := readPacket(bundle)
+		if err != nil {
+			return err
+		}
+
+		switch packet.Header {
+		case protocol.HeaderTransferOffer:
+			if !forward(packet) {
+				return nil
+			}
+
+			reply := awaitReply()
+			if reply == nil || reply.Header != protocol.HeaderAccept {
+				// rejected
+				return nil
+			}
+
+		case protocol.HeaderFile:
+			if !forward(packet) {
+				return nil
+			}
+
+			skipping, resumeAt, position = false, 0, 0
+
+			reply := awaitReply()
+			if reply == nil {
+				return nil
+			}
+			switch reply.Header {
+			case protocol.HeaderAlreadyHave:
+				skipping = true
+
+			case protocol.HeaderResume:
+				var fileID uint64
+				resumeReader := bytes.NewReader(reply.Body)
+				binary.Read(resumeReader, binary.BigEndian, &fileID)
+				binary.Read(resumeReader, binary.BigEndian, &resumeAt)
+			}
+
+		case protocol.HeaderFileBytes:
+			if skipping {
+				continue
+			}
+
+			if position < resumeAt {
+				// the receiver already has the beginning of the file
+				var pieceSize uint64
+				packet, pieceSize, err = trimPiece(packet, resumeAt-position)
+				if err != nil {
+					return err
+				}
+				position += pieceSize
+				if packet == nil {
+					continue
+				}
+			}
+
+			if !forward(packet) {
+				return nil
+			}
+			if awaitReply() == nil {
+				return nil
+			}
+
+		case protocol.HeaderEndfile:
+			if skipping {
+				skipping = false
+				continue
+			}
+
+			if !forward(packet) {
+				return nil
+			}
+			if awaitReply() == nil {
+				return nil
+			}
+
+		case protocol.HeaderSymlink:
+			if !forward(packet) {
+				return nil
+			}
+			if awaitReply() == nil {
+				return nil
+			}
+
+		case protocol.HeaderDone:
+			if !forward(packet) {
+				return nil
+			}
+
+			// let the receiver say goodbye
+			for range replies {
+			}
+			return nil
+
+		default:
+			// empty directories and text messages are not answered
+			if !forward(packet) {
+				return nil
+			}
+		}
+	}
+}
+
+// Cuts off the first skip bytes of the piece of the file. Returns the rest of the piece
+// (nil if nothing is left) and how many bytes of the file the piece has had
+func trimPiece(packet *protocol.Packet, skip uint64) (*protocol.Packet, uint64, error) {
+	// the file ID comes first
+	if len(packet.Body) < 8 {
+		return nil, 0, protocol.ErrorInvalidPacket
+	}
+	fileID, data := packet.Body[:8], packet.Body[8:]
+
+	pieceSize := uint64(len(data))
+	if pieceSize <= skip {
+		return nil, pieceSize, nil
+	}
+
+	return &protocol.Packet{
+		Header: protocol.HeaderFileBytes,
+		Body:   append(fileID[:8:8], data[skip:]...),
+	}, pieceSize, nil
+}
@@ -0,0 +1,394 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package offline
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/protocol"
+	"unbewohnte/ftu/storage"
+	"unbewohnte/ftu/transfer"
+)
+
+// the passphrase of the bundles
+var key []byte = []byte("correct horse battery staple")
+
+func init() {
+	// deriving the key the proper way for every bundle takes too long
+	kdfIterations = 1000
+}
+
+// creates a directory with a file bigger than a packet, a symlink and an empty directory
+func makeSource(t *testing.T) string {
+	source := filepath.Join(t.TempDir(), "source")
+
+	big := make([]byte, 300000)
+	rand.New(rand.NewSource(1)).Read(big)
+
+	files := map[string][]byte{
+		"a.txt":           []byte("aaaaa"),
+		"sub/big.bin":     big,
+		"sub/inner/c.txt": {},
+	}
+	for path, contents := range files {
+		fullPath := filepath.Join(source, path)
+		err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		err = os.WriteFile(fullPath, contents, 0640)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	err := os.Mkdir(filepath.Join(source, "empty"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	err = os.Symlink("../a.txt", filepath.Join(source, "sub", "link"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	return source
+}
+
+// writes the source into the bundle
+func create(t *testing.T, source string, volumeSize uint64) string {
+	bundle := filepath.Join(t.TempDir(), "out.ftub")
+
+	result, err := Create(context.Background(), bundle, source, CreateOptions{
+		SendOptions: transfer.SendOptions{Recursive: true},
+		VolumeSize:  volumeSize,
+		Key:         key,
+	})
+	if err != nil {
+		t.Fatalf("could not create the bundle: %s", err)
+	}
+	if result.Status != node.StatusSuccess {
+		t.Fatalf("expected the bundle to be created successfully; got %s", result.Status)
+	}
+
+	return bundle
+}
+
+// checks that the destination has everything of the source
+func compare(t *testing.T, source string, destination string) {
+	for _, path := range []string{"a.txt", "sub/big.bin", "sub/inner/c.txt"} {
+		expected, _ := os.ReadFile(filepath.Join(source, path))
+		received, err := os.ReadFile(filepath.Join(destination, "source", path))
+		if err != nil {
+			t.Fatalf("\"%s\" has not been extracted: %s", path, err)
+		}
+		if !bytes.Equal(expected, received) {
+			t.Fatalf("\"%s\" differs from the original", path)
+		}
+	}
+
+	target, err := os.Readlink(filepath.Join(destination, "source", "sub", "link"))
+	if err != nil {
+		t.Fatalf("the symlink has not been extracted: %s", err)
+	}
+	if received, _ := os.ReadFile(filepath.Join(destination, "source", "sub", "link")); target == "" || string(received) != "aaaaa" {
+		t.Fatalf("expected the symlink to point to \"a.txt\"; it points to \"%s\"", target)
+	}
+
+	if stats, err := os.Stat(filepath.Join(destination, "source", "empty")); err != nil || !stats.IsDir() {
+		t.Fatalf("the empty directory has not been extracted: %v", err)
+	}
+}
+
+func Test_CreateExtract(t *testing.T) {
+	source := makeSource(t)
+
+	for _, volumeSize := range []uint64{0, 100000} {
+		bundle := create(t, source, volumeSize)
+
+		if volumeSize != 0 {
+			// 300 KB do not fit into 3 volumes
+			if _, err := os.Stat(VolumeName(bundle, 4)); err != nil {
+				t.Fatalf("expected the bundle to be split into volumes: %s", err)
+			}
+			if stats, _ := os.Stat(VolumeName(bundle, 1)); stats.Size() != int64(volumeSize) {
+				t.Fatalf("expected the volume to be %d bytes; got %d", volumeSize, stats.Size())
+			}
+		}
+
+		destination := t.TempDir()
+		result, err := Extract(context.Background(), bundle, destination, ExtractOptions{ReceiveOptions: transfer.ReceiveOptions{}, Key: key})
+		if err != nil {
+			t.Fatalf("could not extract the bundle: %s", err)
+		}
+		if result.Status != node.StatusSuccess || result.FilesDone != 3 {
+			t.Fatalf("expected 3 files to be extracted; got %s with %d files", result.Status, result.FilesDone)
+		}
+
+		compare(t, source, destination)
+
+		// the same files are already there
+		result, err = Extract(context.Background(), bundle, destination, ExtractOptions{ReceiveOptions: transfer.ReceiveOptions{}, Key: key})
+		if err != nil {
+			t.Fatalf("could not extract the bundle again: %s", err)
+		}
+		if result.FilesSkipped != 3 || result.TransferredBytes != 0 {
+			t.Fatalf("expected every file to be skipped; got %d skipped and %d bytes transferred", result.FilesSkipped, result.TransferredBytes)
+		}
+	}
+}
+
+func Test_ExtractConflict(t *testing.T) {
+	source := makeSource(t)
+	bundle := create(t, source, 0)
+	destination := t.TempDir()
+
+	existing := filepath.Join(destination, "source", "a.txt")
+	os.MkdirAll(filepath.Dir(existing), os.ModePerm)
+	os.WriteFile(existing, []byte("mine"), os.ModePerm)
+
+	result, err := Extract(context.Background(), bundle, destination, ExtractOptions{ReceiveOptions: transfer.ReceiveOptions{OnConflict: node.ConflictKeepBoth}, Key: key})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if result.Status != node.StatusSuccess {
+		t.Fatalf("expected the bundle to be extracted; got %s", result.Status)
+	}
+
+	if contents, _ := os.ReadFile(existing); string(contents) != "mine" {
+		t.Fatalf("expected the existing file to be kept; got \"%s\"", contents)
+	}
+	if contents, _ := os.ReadFile(filepath.Join(destination, "source", "a (1).txt")); string(contents) != "aaaaa" {
+		t.Fatalf("expected the extracted file to be kept next to the existing one; got \"%s\"", contents)
+	}
+}
+
+func Test_ExtractResume(t *testing.T) {
+	source := makeSource(t)
+	bundle := create(t, source, 0)
+	destination := t.TempDir()
+
+	// the interrupted extraction has left a part of the big file, which does not end on a piece boundary
+	big, _ := os.ReadFile(filepath.Join(source, "sub", "big.bin"))
+	partial := filepath.Join(destination, "source", fsys.PartialName(filepath.Join("sub", "big.bin")))
+	os.MkdirAll(filepath.Dir(partial), os.ModePerm)
+	os.WriteFile(partial, big[:200001], os.ModePerm)
+
+	result, err := Extract(context.Background(), bundle, destination, ExtractOptions{ReceiveOptions: transfer.ReceiveOptions{Resume: true}, Key: key})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if result.Status != node.StatusSuccess {
+		t.Fatalf("expected the bundle to be extracted; got %s", result.Status)
+	}
+	if result.TransferredBytes != 5+300000-200001 {
+		t.Fatalf("expected only the rest of the big file to be extracted; got %d bytes", result.TransferredBytes)
+	}
+
+	compare(t, source, destination)
+}
+
+func Test_ExtractIntoStorage(t *testing.T) {
+	source := makeSource(t)
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(filepath.Join(source, "a.txt"), modTime, modTime)
+
+	bundle := create(t, source, 0)
+
+	memory := storage.NewMemory()
+	result, err := Extract(context.Background(), bundle, "", ExtractOptions{ReceiveOptions: transfer.ReceiveOptions{Storage: memory}, Key: key})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if result.Status != node.StatusSuccess {
+		t.Fatalf("expected the bundle to be extracted; got %s", result.Status)
+	}
+
+	// the bundle carries the metadata
+	stats, err := memory.Stat("source/a.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !stats.ModTime().Equal(modTime) || stats.Mode().Perm() != 0640 {
+		t.Fatalf("expected the file to keep its mode and modification time; got %s and %s", stats.Mode(), stats.ModTime())
+	}
+}
+
+func Test_Verify(t *testing.T) {
+	source := makeSource(t)
+	bundle := create(t, source, 0)
+
+	result, err := Verify(context.Background(), bundle, key, transfer.Options{})
+	if err != nil {
+		t.Fatalf("expected the bundle to be valid: %s", err)
+	}
+	if result.Status != node.StatusSuccess || result.FilesDone != 3 {
+		t.Fatalf("expected 3 files to be verified; got %s with %d files", result.Status, result.FilesDone)
+	}
+
+	// spoil a byte in the middle of the big file
+	contents, _ := os.ReadFile(bundle)
+	contents[len(contents)/2] ^= 0xff
+	os.WriteFile(bundle, contents, os.ModePerm)
+
+	result, err = Verify(context.Background(), bundle, key, transfer.Options{})
+	if !errors.Is(err, ErrorDamaged) || result.Status == node.StatusSuccess {
+		t.Fatalf("expected the spoiled bundle to fail verification; got %v", err)
+	}
+}
+
+func Test_BrokenBundle(t *testing.T) {
+	source := makeSource(t)
+	bundle := create(t, source, 100000)
+
+	os.Remove(VolumeName(bundle, 2))
+	_, err := Extract(context.Background(), bundle, t.TempDir(), ExtractOptions{ReceiveOptions: transfer.ReceiveOptions{}, Key: key})
+	if !errors.Is(err, ErrorMissingVolume) {
+		t.Fatalf("expected the missing volume to be reported; got %v", err)
+	}
+
+	os.Rename(VolumeName(bundle, 3), VolumeName(bundle, 2))
+	_, err = Extract(context.Background(), bundle, t.TempDir(), ExtractOptions{ReceiveOptions: transfer.ReceiveOptions{}, Key: key})
+	if !errors.Is(err, ErrorForeignVolume) {
+		t.Fatalf("expected the volume out of order to be reported; got %v", err)
+	}
+
+	notBundle := filepath.Join(t.TempDir(), "file.txt")
+	os.WriteFile(notBundle, []byte("just a file"), os.ModePerm)
+	_, err = Extract(context.Background(), notBundle, t.TempDir(), ExtractOptions{ReceiveOptions: transfer.ReceiveOptions{}, Key: key})
+	if !errors.Is(err, ErrorNotBundle) {
+		t.Fatalf("expected a file that is not a bundle to be reported; got %v", err)
+	}
+
+	_, err = Create(context.Background(), filepath.Join(t.TempDir(), "out.ftub"), source, CreateOptions{VolumeSize: 10, Key: key})
+	if !errors.Is(err, ErrorVolumeTooSmall) {
+		t.Fatalf("expected too small volumes to be rejected; got %v", err)
+	}
+}
+
+func Test_CreateFailure(t *testing.T) {
+	bundle := filepath.Join(t.TempDir(), "out.ftub")
+
+	result, err := Create(context.Background(), bundle, filepath.Join(t.TempDir(), "missing"), CreateOptions{Key: key})
+	if err == nil || (result != nil && result.Status == node.StatusSuccess) {
+		t.Fatalf("expected a missing source to fail")
+	}
+	if _, err := os.Stat(bundle); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be left of the bundle; got %v", err)
+	}
+}
+
+func Test_ExtractWithoutKey(t *testing.T) {
+	source := makeSource(t)
+	bundle := create(t, source, 0)
+
+	// neither the key nor what has been sent is there to read
+	contents, _ := os.ReadFile(bundle)
+	big, _ := os.ReadFile(filepath.Join(source, "sub", "big.bin"))
+	for _, secret := range [][]byte{[]byte("big.bin"), []byte(protocol.HeaderEncryptionKey), big[:64]} {
+		if bytes.Contains(contents, secret) {
+			t.Fatalf("expected \"%.20s\" not to be readable in the bundle", secret)
+		}
+	}
+
+	for _, wrongKey := range [][]byte{nil, []byte("wrong passphrase")} {
+		destination := t.TempDir()
+		_, err := Extract(context.Background(), bundle, destination, ExtractOptions{Key: wrongKey})
+		if !errors.Is(err, ErrorNoKey) && !errors.Is(err, ErrorWrongKey) {
+			t.Fatalf("expected extracting with the key \"%s\" to fail; got %v", wrongKey, err)
+		}
+		if entries, _ := os.ReadDir(destination); len(entries) != 0 {
+			t.Fatalf("expected nothing to be extracted without the key")
+		}
+	}
+
+	_, err := Verify(context.Background(), bundle, []byte("wrong passphrase"), transfer.Options{})
+	if !errors.Is(err, ErrorWrongKey) {
+		t.Fatalf("expected verifying with a wrong key to fail; got %v", err)
+	}
+
+	_, err = Create(context.Background(), filepath.Join(t.TempDir(), "out.ftub"), source, CreateOptions{})
+	if !errors.Is(err, ErrorNoKey) {
+		t.Fatalf("expected a bundle without a key to be refused; got %v", err)
+	}
+}
+
+func Test_ParseVolumeSize(t *testing.T) {
+	cases := map[string]uint64{
+		"100":    100,
+		"4GB":    4 * 1000 * 1000 * 1000,
+		"650MiB": 650 * 1024 * 1024,
+		"1.5K":   1.5 * 1024,
+	}
+
+	for sizeStr, expected := range cases {
+		size, err := ParseVolumeSize(sizeStr)
+		if err != nil {
+			t.Fatalf("failed to parse \"%s\": %s", sizeStr, err)
+		}
+
+		if size != expected {
+			t.Fatalf("expected \"%s\" to be %d; got %d", sizeStr, expected, size)
+		}
+	}
+
+	for _, invalid := range []string{"", "big", "10XB", "-5MB", "10MB/s", "0.1", "0", "20B"} {
+		_, err := ParseVolumeSize(invalid)
+		if !errors.Is(err, ErrorInvalidVolumeSize) {
+			t.Fatalf("expected \"%s\" to be an invalid volume size; got %v", invalid, err)
+		}
+	}
+}
+
+func Test_DeriveKey(t *testing.T) {
+	defer func(iterations int) {
+		kdfIterations = iterations
+	}(kdfIterations)
+
+	// PBKDF2-HMAC-SHA256 test vectors of RFC 7914
+	cases := []struct {
+		secret     string
+		salt       string
+		iterations int
+		key        string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"},
+	}
+
+	for _, testCase := range cases {
+		kdfIterations = testCase.iterations
+		key := hex.EncodeToString(deriveKey([]byte(testCase.secret), []byte(testCase.salt)))
+		if key != testCase.key {
+			t.Fatalf("expected %s for \"%s\"; got %s", testCase.key, testCase.secret, key)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package offline
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// The bundle is encrypted at rest with AES-256-GCM, in chunks of at most chunkSize bytes:
+// (size of the sealed chunk as big-endian uint32)(sealed chunk). The nonce of a chunk is its number,
+// with the highest bit set for the last one, so chunks can not be reordered, and a bundle that has
+// lost its end is told apart from a complete one. The key is derived from the secret (a passphrase
+// or the contents of a key file) with PBKDF2-HMAC-SHA256, salted with the id of the bundle
+const chunkSize int = 64 * 1024
+
+// Iterations of PBKDF2. Makes guessing the passphrase slow
+var kdfIterations int = 600000
+
+var (
+	ErrorNoKey    error = errors.New("the bundle needs a passphrase or a key file")
+	ErrorWrongKey error = errors.New("wrong passphrase or key file (or the bundle is damaged at the beginning)")
+	ErrorDamaged  error = errors.New("the bundle is damaged")
+)
+
+// Derives the key of the bundle from the secret with PBKDF2-HMAC-SHA256. The key is a single block of it
+func deriveKey(secret []byte, salt []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+
+	// U1 = HMAC(secret, salt || INT(1))
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	block := mac.Sum(nil)
+
+	key := make([]byte, len(block))
+	copy(key, block)
+	for iteration := 1; iteration < kdfIterations; iteration++ {
+		mac.Reset()
+		mac.Write(block)
+		block = mac.Sum(block[:0])
+		for index := range key {
+			key[index] ^= block[index]
+		}
+	}
+
+	return key
+}
+
+// Returns the cipher of the bundle with such id
+func newBundleCipher(secret []byte, id []byte) (cipher.AEAD, error) {
+	if len(secret) == 0 {
+		return nil, ErrorNoKey
+	}
+
+	block, err := aes.NewCipher(deriveKey(secret, id))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Returns the nonce of the chunk with such number
+func chunkNonce(aead cipher.AEAD, number uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], number)
+	if last {
+		nonce[0] = 0x80
+	}
+
+	return nonce
+}
+
+// Encrypts what is written into it chunk by chunk. Close seals the last chunk
+type sealWriter struct {
+	aead   cipher.AEAD
+	out    io.Writer
+	buffer []byte
+	number uint64
+}
+
+func newSealWriter(out io.Writer, secret []byte, id []byte) (*sealWriter, error) {
+	aead, err := newBundleCipher(secret, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealWriter{
+		aead: aead,
+		out:  out,
+	}, nil
+}
+
+// Encrypts and writes the chunk
+func (writer *sealWriter) seal(chunk []byte, last bool) error {
+	sealed := writer.aead.Seal(nil, chunkNonce(writer.aead, writer.number, last), chunk, nil)
+	writer.number++
+
+	err := binary.Write(writer.out, binary.BigEndian, uint32(len(sealed)))
+	if err != nil {
+		return err
+	}
+	_, err = writer.out.Write(sealed)
+
+	return err
+}
+
+func (writer *sealWriter) Write(data []byte) (int, error) {
+	writer.buffer = append(writer.buffer, data...)
+
+	for len(writer.buffer) >= chunkSize {
+		err := writer.seal(writer.buffer[:chunkSize], false)
+		if err != nil {
+			return 0, err
+		}
+		writer.buffer = append(writer.buffer[:0], writer.buffer[chunkSize:]...)
+	}
+
+	return len(data), nil
+}
+
+// Seals what is left as the last chunk. Nothing can be written after that
+func (writer *sealWriter) Close() error {
+	err := writer.seal(writer.buffer, true)
+	writer.buffer = nil
+
+	return err
+}
+
+// Decrypts the bundle chunk by chunk
+type openReader struct {
+	aead   cipher.AEAD
+	in     io.Reader
+	chunk  []byte // decrypted, but not read yet
+	number uint64
+	last   bool // the last chunk has been decrypted
+}
+
+func newOpenReader(in io.Reader, secret []byte, id []byte) (*openReader, error) {
+	aead, err := newBundleCipher(secret, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openReader{
+		aead: aead,
+		in:   in,
+	}, nil
+}
+
+// Reads and decrypts the next chunk
+func (reader *openReader) open() error {
+	var size uint32
+	err := binary.Read(reader.in, binary.BigEndian, &size)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrorTruncated
+	}
+	if err != nil {
+		return err
+	}
+	if int(size) > chunkSize+reader.aead.Overhead() {
+		return ErrorDamaged
+	}
+
+	sealed := make([]byte, size)
+	_, err = io.ReadFull(reader.in, sealed)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrorTruncated
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, last := range []bool{false, true} {
+		chunk, err := reader.aead.Open(nil, chunkNonce(reader.aead, reader.number, last), sealed, nil)
+		if err == nil {
+			reader.chunk = chunk
+			reader.last = last
+			reader.number++
+			return nil
+		}
+	}
+
+	if reader.number == 0 {
+		// the very first chunk can not be decrypted
+		return ErrorWrongKey
+	}
+	return ErrorDamaged
+}
+
+func (reader *openReader) Read(data []byte) (int, error) {
+	for len(reader.chunk) == 0 {
+		if reader.last {
+			return 0, io.EOF
+		}
+
+		err := reader.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(data, reader.chunk)
+	reader.chunk = reader.chunk[n:]
+
+	return n, nil
+}
@@ -0,0 +1,352 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package offline
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Every volume starts with the header:
+// (magic)(version)(bundle id)(volume number as big-endian uint32)
+// Volumes of one bundle share the id and are numbered from 1
+const (
+	magic      string = "FTUBUNDLE"
+	version    uint8  = 1
+	idSize     int    = 16
+	headerSize int    = len(magic) + 1 + idSize + 4
+)
+
+var (
+	ErrorNotBundle          error = errors.New("not a bundle")
+	ErrorUnsupportedVersion error = errors.New("the bundle has been made by a newer version of ftu")
+	ErrorForeignVolume      error = errors.New("the volume belongs to another bundle")
+	ErrorMissingVolume      error = errors.New("a volume of the bundle is missing")
+	ErrorTruncated          error = errors.New("the bundle ends unexpectedly")
+	ErrorVolumeTooSmall     error = fmt.Errorf("a volume must be bigger than %d bytes", headerSize)
+	ErrorInvalidVolumeSize  error = errors.New("invalid volume size")
+)
+
+// Returns the name of the volume of the bundle split into several files: path.001, path.002...
+func VolumeName(path string, number uint32) string {
+	return fmt.Sprintf("%s.%03d", path, number)
+}
+
+// Converts a human-readable volume size into bytes.
+// KB, MB, GB are powers of 1000; K, M, G, KiB, MiB, GiB are powers of 1024.
+// ie: "4GB", "650MiB", "1.5M", "100000"
+func ParseVolumeSize(size string) (uint64, error) {
+	size = strings.TrimSpace(size)
+
+	// find where the number ends and the unit begins
+	unitStart := len(size)
+	for index, char := range size {
+		if (char < '0' || char > '9') && char != '.' {
+			unitStart = index
+			break
+		}
+	}
+	unit := strings.TrimSpace(size[unitStart:])
+
+	var multiplier float64
+	switch strings.ToLower(unit) {
+	case "", "b":
+		multiplier = 1
+	case "k", "kib":
+		multiplier = 1024
+	case "m", "mib":
+		multiplier = 1024 * 1024
+	case "g", "gib":
+		multiplier = 1024 * 1024 * 1024
+	case "kb":
+		multiplier = 1000
+	case "mb":
+		multiplier = 1000 * 1000
+	case "gb":
+		multiplier = 1000 * 1000 * 1000
+	default:
+		return 0, fmt.Errorf("%w: unknown unit in \"%s\"", ErrorInvalidVolumeSize, size)
+	}
+
+	value, err := strconv.ParseFloat(size[:unitStart], 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%w: \"%s\"", ErrorInvalidVolumeSize, size)
+	}
+
+	bytes := uint64(value * multiplier)
+	if bytes <= uint64(headerSize) {
+		return 0, fmt.Errorf("%w: \"%s\": %s", ErrorInvalidVolumeSize, size, ErrorVolumeTooSmall)
+	}
+
+	return bytes, nil
+}
+
+// matches the suffix of a volume name
+var volumeSuffix *regexp.Regexp = regexp.MustCompile(`\.([0-9]{3,})$`)
+
+// Writes the bundle into one file or splits it into volumes of the given size
+type volumeWriter struct {
+	path    string
+	size    uint64 // of every volume. 0 means one file
+	id      []byte
+	number  uint32
+	current *os.File
+	left    uint64   // how many bytes the current volume can take yet
+	written []string // names of the volumes written so far
+}
+
+func newVolumeWriter(path string, size uint64) (*volumeWriter, error) {
+	if size != 0 && size <= uint64(headerSize) {
+		return nil, ErrorVolumeTooSmall
+	}
+
+	id := make([]byte, idSize)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &volumeWriter{
+		path: path,
+		size: size,
+		id:   id,
+	}, nil
+}
+
+// Flushes the current volume to the disk (it is most likely a removable one) and closes it
+func (writer *volumeWriter) closeCurrent() error {
+	if writer.current == nil {
+		return nil
+	}
+
+	err := writer.current.Sync()
+	closeErr := writer.current.Close()
+	writer.current = nil
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Closes the current volume and starts the next one
+func (writer *volumeWriter) nextVolume() error {
+	err := writer.closeCurrent()
+	if err != nil {
+		return err
+	}
+
+	writer.number++
+	name := writer.path
+	if writer.size != 0 {
+		name = VolumeName(writer.path, writer.number)
+	}
+
+	volume, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	writer.current = volume
+	writer.written = append(writer.written, name)
+
+	header := new(bytes.Buffer)
+	header.WriteString(magic)
+	header.WriteByte(version)
+	header.Write(writer.id)
+	binary.Write(header, binary.BigEndian, writer.number)
+
+	_, err = volume.Write(header.Bytes())
+	if err != nil {
+		return err
+	}
+	writer.left = writer.size - uint64(headerSize)
+
+	return nil
+}
+
+func (writer *volumeWriter) Write(data []byte) (int, error) {
+	var wrote int
+	for len(data) != 0 {
+		if writer.current == nil || (writer.size != 0 && writer.left == 0) {
+			err := writer.nextVolume()
+			if err != nil {
+				return wrote, err
+			}
+		}
+
+		chunk := data
+		if writer.size != 0 && uint64(len(chunk)) > writer.left {
+			chunk = chunk[:writer.left]
+		}
+
+		n, err := writer.current.Write(chunk)
+		wrote += n
+		writer.left -= uint64(n)
+		data = data[n:]
+		if err != nil {
+			return wrote, err
+		}
+	}
+
+	return wrote, nil
+}
+
+func (writer *volumeWriter) Close() error {
+	return writer.closeCurrent()
+}
+
+// Removes every volume written so far
+func (writer *volumeWriter) Remove() {
+	writer.closeCurrent()
+	for _, name := range writer.written {
+		os.Remove(name)
+	}
+}
+
+// Reads the volumes of the bundle one after another as one stream, checking that they belong together
+type volumeReader struct {
+	path    string // without the volume suffix if split
+	split   bool
+	id      []byte
+	number  uint32
+	current *os.File
+}
+
+// Opens the bundle at path: a single file or the first volume of a split one.
+// The first volume can also be found by the name of the bundle without the suffix
+func openVolumes(path string) (*volumeReader, error) {
+	reader := &volumeReader{path: path}
+
+	volume, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// split, but named without a suffix
+		var firstErr error
+		volume, firstErr = os.Open(VolumeName(path, 1))
+		if firstErr != nil {
+			return nil, err
+		}
+		reader.split = true
+	} else if err != nil {
+		return nil, err
+	} else if match := volumeSuffix.FindStringSubmatch(path); match != nil {
+		number, _ := strconv.ParseUint(match[1], 10, 32)
+		if number != 1 {
+			volume.Close()
+			return nil, fmt.Errorf("\"%s\" is not the first volume of the bundle", path)
+		}
+		reader.path = path[:len(path)-len(match[0])]
+		reader.split = true
+	}
+
+	reader.id, reader.number, err = readHeader(volume)
+	if err != nil {
+		volume.Close()
+		return nil, fmt.Errorf("\"%s\": %w", volume.Name(), err)
+	}
+	if reader.number != 1 {
+		volume.Close()
+		return nil, fmt.Errorf("\"%s\" is not the first volume of the bundle", volume.Name())
+	}
+	reader.current = volume
+
+	return reader, nil
+}
+
+// Reads and checks the header of the volume. Returns the id of its bundle and its number
+func readHeader(volume io.Reader) ([]byte, uint32, error) {
+	header := make([]byte, headerSize)
+	_, err := io.ReadFull(volume, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && string(header[:len(magic)]) != magic) {
+		return nil, 0, ErrorNotBundle
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if header[len(magic)] > version {
+		return nil, 0, ErrorUnsupportedVersion
+	}
+
+	id := header[len(magic)+1 : len(magic)+1+idSize]
+	number := binary.BigEndian.Uint32(header[len(magic)+1+idSize:])
+
+	return id, number, nil
+}
+
+// Opens the volume that follows the current one
+func (reader *volumeReader) nextVolume() error {
+	if !reader.split {
+		return ErrorTruncated
+	}
+
+	name := VolumeName(reader.path, reader.number+1)
+	volume, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: \"%s\"", ErrorMissingVolume, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	id, number, err := readHeader(volume)
+	if err == nil && (!bytes.Equal(id, reader.id) || number != reader.number+1) {
+		err = ErrorForeignVolume
+	}
+	if err != nil {
+		volume.Close()
+		return fmt.Errorf("\"%s\": %w", name, err)
+	}
+
+	reader.current.Close()
+	reader.current = volume
+	reader.number = number
+
+	return nil
+}
+
+func (reader *volumeReader) Read(data []byte) (int, error) {
+	for {
+		n, err := reader.current.Read(data)
+		if n != 0 || err != io.EOF {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+
+		err = reader.nextVolume()
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (reader *volumeReader) Close() error {
+	return reader.current.Close()
+}
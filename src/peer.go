@@ -0,0 +1,237 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/session"
+	"unbewohnte/ftu/transfer"
+)
+
+// Waits for the other side on the port, or connects to it if there is the address,
+// and lets both send to each other until one of them leaves
+func runPeer(ctx context.Context, options session.PeerOptions) error {
+	var conn net.Conn
+	var err error
+	if *ADDRESS == "" {
+		var listener net.Listener
+		listener, err = nodeTransport.Listen(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Waiting for the other side on %s\n", listener.Addr())
+
+		go func() {
+			<-ctx.Done()
+			listener.Close()
+		}()
+		conn, err = listener.Accept()
+		listener.Close()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	} else {
+		conn, err = nodeTransport.Dial(ctx)
+		if err != nil {
+			return fmt.Errorf("%w: %s", transfer.ErrorConnection, err)
+		}
+	}
+
+	var peerSession *session.Session
+	if *ADDRESS == "" {
+		peerSession, err = session.Open(conn, session.KindPeer)
+	} else {
+		peerSession, err = session.Join(conn, session.KindPeer)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	return runPeerShell(ctx, peerSession, os.Stdin, os.Stdout, options)
+}
+
+// Lets the user send to the other side, reading commands from input, while offers of the other side
+// are asked about in between
+func runPeerShell(ctx context.Context, peerSession *session.Session, input io.Reader, output io.Writer, options session.PeerOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lines := readLines(ctx, input)
+
+	// offers of the other side wait for the next line to be the answer
+	questions := make(chan chan string)
+	options.Receive.OfferDecider = node.OfferDeciderFunc(func(file *fsys.File, dir *fsys.Directory) bool {
+		answer := make(chan string, 1)
+		select {
+		case questions <- answer:
+		case <-ctx.Done():
+			return false
+		}
+
+		select {
+		case line := <-answer:
+			line = strings.TrimSpace(line)
+			return strings.EqualFold(line, "y") || line == ""
+		case <-ctx.Done():
+			return false
+		}
+	})
+	options.Text = func(text string) {
+		fmt.Fprintf(output, "\n[%s] %s\n", peerSession.RemoteAddr(), text)
+
+		if options.Receive.TextFile != "" {
+			err := node.AppendText(options.Receive.TextFile, text)
+			if err != nil {
+				fmt.Fprintf(output, "[ERROR] Could not write the text message into \"%s\": %s\n", options.Receive.TextFile, err)
+			}
+		}
+	}
+	options.Received = func(result *transfer.Result, err error) {
+		// rejected offers have been rejected here
+		if err != nil && !errors.Is(err, transfer.ErrorRejected) {
+			fmt.Fprintf(output, "\n[ERROR] %s\n", err)
+		}
+	}
+
+	peer := session.NewPeer(peerSession, options)
+	ran := make(chan error, 1)
+	go func() {
+		ran <- peer.Run(ctx)
+	}()
+
+	fmt.Fprintf(output, "Connected. Type \"help\" to see the commands\n")
+	var answer chan string
+	chatting := false // every line is a text message
+	for {
+		switch {
+		case answer != nil:
+		case chatting:
+			fmt.Fprintf(output, "chat> ")
+		default:
+			fmt.Fprintf(output, "ftu> ")
+		}
+
+		var line string
+		var ok bool
+		select {
+		case line, ok = <-lines:
+		case answer = <-questions:
+			fmt.Fprintf(output, "| Download ? [Y/n]: ")
+			continue
+		case err := <-ran:
+			fmt.Fprintf(output, "\nThe session has ended\n")
+			return err
+		case <-ctx.Done():
+			fmt.Fprintf(output, "\n")
+			return ctx.Err()
+		}
+		if !ok {
+			fmt.Fprintf(output, "\n")
+			return nil
+		}
+
+		if answer != nil {
+			answer <- line
+			answer = nil
+			fmt.Fprintf(output, "\n")
+			continue
+		}
+
+		if chatting {
+			switch {
+			case strings.TrimSpace(line) == "/exit":
+				chatting = false
+			case strings.TrimSpace(line) != "":
+				err := peer.Say(line)
+				if err != nil {
+					fmt.Fprintf(output, "[ERROR] %s\n", err)
+				}
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		command, args := fields[0], fields[1:]
+
+		// paths can contain spaces
+		arg := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), command))
+
+		var err error
+		switch command {
+		case "help", "?":
+			fmt.Fprintf(output, "| send path send the file|directory to the other side as soon as no other transfer is in progress\n")
+			fmt.Fprintf(output, "| say text send the text message to the other side right away\n")
+			fmt.Fprintf(output, "| chat send every following line as a text message until \"/exit\"\n")
+			fmt.Fprintf(output, "| exit end the session\n")
+
+		case "send":
+			if len(args) == 0 {
+				err = errors.New("send needs a path")
+				break
+			}
+
+			// the shell keeps answering offers of the other side meanwhile
+			go func(path string) {
+				_, err := peer.Send(ctx, path)
+				if err != nil && ctx.Err() == nil {
+					fmt.Fprintf(output, "\n[ERROR] Could not send \"%s\": %s\n", path, err)
+				}
+			}(arg)
+
+		case "say":
+			if len(args) == 0 {
+				err = errors.New("say needs a text")
+				break
+			}
+			err = peer.Say(arg)
+
+		case "chat":
+			chatting = true
+			fmt.Fprintf(output, "| Every line is sent to the other side now. \"/exit\" to stop chatting\n")
+
+		case "exit", "quit", "bye":
+			return nil
+
+		default:
+			err = fmt.Errorf("unknown command \"%s\". Type \"help\" to see the commands", command)
+		}
+
+		if err != nil {
+			fmt.Fprintf(output, "[ERROR] %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package progress
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Names of JSON events. Every event has "event" and "time" (RFC 3339) fields
+const (
+	jsonConnected   string = "connected"    // remote
+	jsonOffer       string = "offer"        // name, size, is_directory
+	jsonAccepted    string = "accepted"     //
+	jsonFileStarted string = "file_started" // file, size
+	jsonProgress    string = "progress"     // done_bytes, total_bytes, transferred_bytes, files_done, files_total, speed, eta_seconds
+	jsonFileDone    string = "file_done"    // file
+	jsonFileSkipped string = "file_skipped" // file, size
+	jsonError       string = "error"        // kind, file, message
+	jsonText        string = "text"         // text
+	jsonCompleted   string = "completed"    // status, elapsed_seconds, total_bytes, transferred_bytes, average_speed, files_done, files_skipped, files_failed
+)
+
+// Kinds of errors
+const (
+	ErrorConnection string = "connection" // could not connect or the connection has been lost
+	ErrorIntegrity  string = "integrity"  // the received file does not match its checksum
+	ErrorWrite      string = "write"      // the received file could not be written
+	ErrorSecurity   string = "security"   // the other side has tried to reach outside of the downloads directory
+	ErrorOther      string = "other"
+)
+
+// Writes a JSON event on its own line. Does nothing in other formats
+func (reporter *Reporter) emit(name string, fields map[string]interface{}) {
+	if reporter.format != FormatJSON {
+		return
+	}
+
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["event"] = name
+	fields["time"] = time.Now().Format(time.RFC3339Nano)
+
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return
+	}
+
+	reporter.output.Write(append(encoded, '\n'))
+}
+
+func (reporter *Reporter) emitProgress() {
+	snapshot := reporter.snapshot()
+
+	var eta float64 = -1
+	if snapshot.ETA >= 0 {
+		eta = snapshot.ETA.Seconds()
+	}
+
+	reporter.emit(jsonProgress, map[string]interface{}{
+		"done_bytes":        snapshot.DoneBytes,
+		"total_bytes":       snapshot.TotalBytes,
+		"transferred_bytes": snapshot.TransferredBytes,
+		"files_done":        snapshot.FilesDone,
+		"files_total":       snapshot.FilesTotal,
+		"speed":             snapshot.Speed,
+		"eta_seconds":       eta,
+	})
+}
+
+// Stops the reporter if it is still running and emits the final "completed" event with the status of the transfer.
+// Observers are told about it as well
+func (reporter *Reporter) Completed(status string) {
+	summary := reporter.Stop()
+
+	// the reporting goroutine is done, nobody else touches the observers
+	reporter.notify(func(observer Observer) {
+		observer.Completed(status, summary)
+	})
+
+	reporter.emit(jsonCompleted, map[string]interface{}{
+		"status":            status,
+		"elapsed_seconds":   summary.Elapsed.Seconds(),
+		"total_bytes":       summary.TotalBytes,
+		"transferred_bytes": summary.TransferredBytes,
+		"average_speed":     summary.AverageSpeed,
+		"files_done":        summary.FilesDone,
+		"files_skipped":     summary.FilesSkipped,
+		"files_failed":      summary.FilesFailed,
+	})
+}
@@ -0,0 +1,97 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Writes a timestamped line for every noteworthy event of many transfers running at once.
+// Safe for concurrent use
+type Log struct {
+	output io.Writer
+	mutex  sync.Mutex
+}
+
+// Creates a log writing into output. Nothing is written if output is nil
+func NewLog(output io.Writer) *Log {
+	if output == nil {
+		output = io.Discard
+	}
+
+	return &Log{
+		output: output,
+	}
+}
+
+// Writes a line
+func (log *Log) Printf(format string, a ...interface{}) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	fmt.Fprintf(log.output, "%s %s\n", time.Now().Format("2006-01-02 15:04:05"), fmt.Sprintf(format, a...))
+}
+
+// Returns an observer writing events of one transfer into the log, each line starting with prefix
+func (log *Log) Observer(prefix string) Observer {
+	return &logObserver{
+		log:    log,
+		prefix: prefix,
+	}
+}
+
+type logObserver struct {
+	NopObserver
+	log    *Log
+	prefix string
+}
+
+func (observer *logObserver) Offered(name string, size uint64, isDirectory bool) {
+	kind := "file"
+	if isDirectory {
+		kind = "directory"
+	}
+	observer.log.Printf("%s Offered %s \"%s\" (%s)", observer.prefix, kind, name, FormatSize(size))
+}
+
+func (observer *logObserver) Accepted() {
+	observer.log.Printf("%s Accepted", observer.prefix)
+}
+
+func (observer *logObserver) FileFailed(name string) {
+	observer.log.Printf("%s Failed to transfer \"%s\"", observer.prefix, name)
+}
+
+func (observer *logObserver) Error(kind string, file string, message string) {
+	observer.log.Printf("%s [ERROR] %s", observer.prefix, message)
+}
+
+func (observer *logObserver) TextReceived(text string) {
+	observer.log.Printf("%s Text message:\n%s", observer.prefix, text)
+}
+
+func (observer *logObserver) Completed(status string, summary Summary) {
+	observer.log.Printf("%s Finished: %s. %s", observer.prefix, status, strings.TrimPrefix(summary.String(), "| "))
+}
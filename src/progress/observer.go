@@ -0,0 +1,104 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package progress
+
+import "time"
+
+// How often observers are told about the progress
+const ObserveInterval time.Duration = time.Millisecond * 500
+
+// The state of the transfer at some moment
+type Snapshot struct {
+	CurrentFile      string // "" if no file is being transferred
+	CurrentDone      uint64
+	CurrentSize      uint64
+	DoneBytes        uint64 // transferred, resumed and skipped bytes
+	TotalBytes       uint64
+	TransferredBytes uint64
+	FilesDone        uint64        // done, skipped and failed files
+	FilesTotal       uint64        // 0 if unknown
+	Speed            float64       // smoothed, bytes per second
+	ETA              time.Duration // -1 if unknown
+}
+
+// Receives the events of the transfer. The methods are called one at a time from the
+// reporting goroutine, so they should return quickly
+type Observer interface {
+	Connected(remote string)
+	Offered(name string, size uint64, isDirectory bool)
+	Accepted()
+	FileStarted(name string, size uint64)
+	Progress(snapshot Snapshot)
+	FileDone(name string)
+	FileSkipped(name string, size uint64)
+	FileFailed(name string)
+	Error(kind string, file string, message string) // kind is one of the Error* constants
+	TextReceived(text string)
+	Completed(status string, summary Summary)
+}
+
+// Implements Observer doing nothing. Embed it to handle only the events you need
+type NopObserver struct{}
+
+func (NopObserver) Connected(remote string)                            {}
+func (NopObserver) Offered(name string, size uint64, isDirectory bool) {}
+func (NopObserver) Accepted()                                          {}
+func (NopObserver) FileStarted(name string, size uint64)               {}
+func (NopObserver) Progress(snapshot Snapshot)                         {}
+func (NopObserver) FileDone(name string)                               {}
+func (NopObserver) FileSkipped(name string, size uint64)               {}
+func (NopObserver) FileFailed(name string)                             {}
+func (NopObserver) Error(kind string, file string, message string)     {}
+func (NopObserver) TextReceived(text string)                           {}
+func (NopObserver) Completed(status string, summary Summary)           {}
+
+// Adds an observer that will be told about every following event
+func (reporter *Reporter) Observe(observer Observer) {
+	reporter.send(event{kind: eventObserve, observer: observer})
+}
+
+// Returns the current state of the transfer
+func (reporter *Reporter) snapshot() Snapshot {
+	eta := time.Duration(-1)
+	if left, ok := reporter.eta(); ok {
+		eta = left
+	}
+
+	return Snapshot{
+		CurrentFile:      reporter.currentName,
+		CurrentDone:      reporter.currentDone,
+		CurrentSize:      reporter.currentSize,
+		DoneBytes:        reporter.doneBytes,
+		TotalBytes:       reporter.totalBytes,
+		TransferredBytes: reporter.transferred,
+		FilesDone:        reporter.filesDone + reporter.filesSkipped + reporter.filesFailed,
+		FilesTotal:       reporter.totalFiles,
+		Speed:            reporter.speed,
+		ETA:              eta,
+	}
+}
+
+// Calls notify for every observer
+func (reporter *Reporter) notify(notify func(observer Observer)) {
+	for _, observer := range reporter.observers {
+		notify(observer)
+	}
+}
@@ -0,0 +1,580 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Transfer progress reporting: a reporter fed by events that shows the current file,
+// done and total amounts, smoothed throughput and ETA, and prints a summary in the end
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the progress is redrawn on a terminal
+const LiveInterval time.Duration = time.Millisecond * 500
+
+// How often the progress line is printed when the output is not a terminal
+const PlainInterval time.Duration = time.Second * 5
+
+// How often the progress event is emitted in JSON
+const JSONInterval time.Duration = time.Second
+
+// How the progress is shown
+type Format uint8
+
+const (
+	FormatPlain Format = iota // a progress line from time to time
+	FormatLive                // a multi-line display redrawn in place
+	FormatJSON                // newline-delimited JSON events
+)
+
+// How much the latest speed sample weighs in the smoothed throughput
+const smoothing float64 = 0.3
+
+type eventKind uint8
+
+const (
+	eventStart eventKind = iota
+	eventFileStarted
+	eventTransferred
+	eventResumed
+	eventFileDone
+	eventFileSkipped
+	eventFileFailed
+	eventMessage
+	eventConnected
+	eventOffer
+	eventAccepted
+	eventError
+	eventText
+	eventObserve
+)
+
+type event struct {
+	kind    eventKind
+	name    string
+	size    uint64
+	count   uint64
+	message string
+	kindOf  string // kind of an error
+	flag    bool   // whether the offer is a directory
+
+	observer Observer
+}
+
+// What has been done in the end of the transfer
+type Summary struct {
+	Elapsed          time.Duration
+	TotalBytes       uint64 // size of everything offered
+	TransferredBytes uint64 // bytes that actually went through the connection
+	AverageSpeed     float64
+	FilesDone        uint64
+	FilesSkipped     uint64
+	FilesFailed      uint64
+}
+
+// Reports the progress of a single transfer. All the state is owned by one goroutine
+// that receives events, so the methods can be called from anywhere
+type Reporter struct {
+	output   io.Writer
+	format   Format
+	interval time.Duration
+	events   chan event
+	stop     chan struct{}
+	stopOnce *sync.Once
+	summary  chan Summary
+	result   Summary
+
+	// owned by the reporting goroutine
+	started         bool
+	startTime       time.Time
+	totalBytes      uint64
+	totalFiles      uint64
+	doneBytes       uint64 // transferred, resumed and skipped bytes
+	transferred     uint64
+	filesDone       uint64
+	filesSkipped    uint64
+	filesFailed     uint64
+	currentName     string
+	currentSize     uint64
+	currentDone     uint64
+	speed           float64 // smoothed, bytes per second
+	lastSampleBytes uint64
+	lastSampleTime  time.Time
+	drawnLines      int
+	observers       []Observer
+}
+
+// Tells whether the file is a terminal, so the progress can be redrawn in place
+func IsTerminal(file *os.File) bool {
+	stats, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return stats.Mode()&os.ModeCharDevice != 0
+}
+
+// Creates a new reporter writing into output in the given format
+func NewReporter(output io.Writer, format Format) *Reporter {
+	var interval time.Duration
+	switch format {
+	case FormatLive:
+		interval = LiveInterval
+	case FormatJSON:
+		interval = JSONInterval
+	default:
+		interval = PlainInterval
+	}
+
+	reporter := &Reporter{
+		output:   output,
+		format:   format,
+		interval: interval,
+		events:   make(chan event, 256),
+		stop:     make(chan struct{}),
+		stopOnce: &sync.Once{},
+		summary:  make(chan Summary),
+	}
+
+	go reporter.run()
+
+	return reporter
+}
+
+// Passes the event to the reporting goroutine. Events after Stop are dropped
+func (reporter *Reporter) send(e event) {
+	select {
+	case reporter.events <- e:
+	case <-reporter.stop:
+	}
+}
+
+// The transfer of totalBytes in totalFiles has begun. totalFiles can be 0 if unknown
+func (reporter *Reporter) Start(totalBytes uint64, totalFiles uint64) {
+	reporter.send(event{kind: eventStart, size: totalBytes, count: totalFiles})
+}
+
+// A new file of size bytes is being transferred
+func (reporter *Reporter) FileStarted(name string, size uint64) {
+	reporter.send(event{kind: eventFileStarted, name: name, size: size})
+}
+
+// Bytes of the current file went through the connection
+func (reporter *Reporter) Transferred(bytes uint64) {
+	reporter.send(event{kind: eventTransferred, size: bytes})
+}
+
+// Bytes of the current file were already there and will not be transferred
+func (reporter *Reporter) Resumed(bytes uint64) {
+	reporter.send(event{kind: eventResumed, size: bytes})
+}
+
+// The current file has been transferred successfully
+func (reporter *Reporter) FileDone(name string) {
+	reporter.send(event{kind: eventFileDone, name: name})
+}
+
+// The file of size bytes will not be transferred
+func (reporter *Reporter) FileSkipped(name string, size uint64) {
+	reporter.send(event{kind: eventFileSkipped, name: name, size: size})
+}
+
+// The file could not be transferred
+func (reporter *Reporter) FileFailed(name string) {
+	reporter.send(event{kind: eventFileFailed, name: name})
+}
+
+// Prints a message without breaking the progress display. Messages are not printed in JSON
+func (reporter *Reporter) Printf(format string, a ...interface{}) {
+	reporter.send(event{kind: eventMessage, message: fmt.Sprintf(format, a...)})
+}
+
+// The connection with remote has been established. Only reported in JSON
+func (reporter *Reporter) Connected(remote string) {
+	reporter.send(event{kind: eventConnected, message: remote})
+}
+
+// The file or directory of size bytes is offered. Only reported in JSON
+func (reporter *Reporter) Offer(name string, size uint64, isDirectory bool) {
+	reporter.send(event{kind: eventOffer, name: name, size: size, flag: isDirectory})
+}
+
+// The offer has been accepted. Only reported in JSON
+func (reporter *Reporter) Accepted() {
+	reporter.send(event{kind: eventAccepted})
+}
+
+// Something went wrong, possibly with the file (can be ""). Only reported in JSON,
+// kind is one of the Error* constants
+func (reporter *Reporter) Error(kind string, file string, message string) {
+	reporter.send(event{kind: eventError, kindOf: kind, name: file, message: message})
+}
+
+// A text message has been received. Printed as is, or reported in JSON
+func (reporter *Reporter) Text(text string) {
+	reporter.send(event{kind: eventText, message: text})
+}
+
+// Stops reporting, prints the summary if the transfer has been started and returns it.
+// Calling it again returns the same summary
+func (reporter *Reporter) Stop() Summary {
+	reporter.stopOnce.Do(func() {
+		close(reporter.stop)
+		reporter.result = <-reporter.summary
+	})
+
+	return reporter.result
+}
+
+func (reporter *Reporter) run() {
+	ticker := time.NewTicker(reporter.interval)
+	defer ticker.Stop()
+
+	// ticks only when there are observers
+	var observeTicks <-chan time.Time
+
+	for {
+		select {
+		case e := <-reporter.events:
+			reporter.handle(e)
+			if observeTicks == nil && len(reporter.observers) > 0 {
+				observeTicker := time.NewTicker(ObserveInterval)
+				defer observeTicker.Stop()
+				observeTicks = observeTicker.C
+			}
+
+		case now := <-ticker.C:
+			if !reporter.started {
+				continue
+			}
+			if observeTicks == nil {
+				// otherwise sampled more often for the observers
+				reporter.sample(now)
+			}
+			if reporter.format == FormatJSON {
+				reporter.emitProgress()
+			} else {
+				reporter.draw()
+			}
+
+		case now := <-observeTicks:
+			if !reporter.started {
+				continue
+			}
+			reporter.sample(now)
+			snapshot := reporter.snapshot()
+			reporter.notify(func(observer Observer) {
+				observer.Progress(snapshot)
+			})
+
+		case <-reporter.stop:
+			// handle what is left
+			for len(reporter.events) > 0 {
+				reporter.handle(<-reporter.events)
+			}
+
+			summary := reporter.finish()
+			reporter.summary <- summary
+			return
+		}
+	}
+}
+
+func (reporter *Reporter) handle(e event) {
+	switch e.kind {
+	case eventStart:
+		reporter.started = true
+		reporter.startTime = time.Now()
+		reporter.lastSampleTime = reporter.startTime
+		reporter.totalBytes = e.size
+		reporter.totalFiles = e.count
+		if reporter.format == FormatLive {
+			reporter.draw()
+		}
+
+	case eventFileStarted:
+		reporter.currentName = e.name
+		reporter.currentSize = e.size
+		reporter.currentDone = 0
+		reporter.emit(jsonFileStarted, map[string]interface{}{
+			"file": e.name,
+			"size": e.size,
+		})
+		reporter.notify(func(observer Observer) {
+			observer.FileStarted(e.name, e.size)
+		})
+
+	case eventTransferred:
+		reporter.transferred += e.size
+		reporter.doneBytes += e.size
+		reporter.currentDone += e.size
+
+	case eventResumed:
+		reporter.doneBytes += e.size
+		reporter.currentDone += e.size
+
+	case eventFileDone:
+		reporter.filesDone++
+		if reporter.currentName == e.name {
+			reporter.currentName = ""
+		}
+		reporter.emit(jsonFileDone, map[string]interface{}{
+			"file": e.name,
+		})
+		reporter.notify(func(observer Observer) {
+			observer.FileDone(e.name)
+		})
+
+	case eventFileSkipped:
+		reporter.filesSkipped++
+		reporter.doneBytes += e.size
+		reporter.emit(jsonFileSkipped, map[string]interface{}{
+			"file": e.name,
+			"size": e.size,
+		})
+		reporter.notify(func(observer Observer) {
+			observer.FileSkipped(e.name, e.size)
+		})
+
+	case eventFileFailed:
+		reporter.filesFailed++
+		if reporter.currentName == e.name {
+			reporter.currentName = ""
+		}
+		reporter.notify(func(observer Observer) {
+			observer.FileFailed(e.name)
+		})
+
+	case eventMessage:
+		if reporter.format == FormatJSON {
+			break
+		}
+		reporter.clear()
+		fmt.Fprintf(reporter.output, "%s\n", e.message)
+		if reporter.format == FormatLive && reporter.started {
+			reporter.draw()
+		}
+
+	case eventConnected:
+		reporter.emit(jsonConnected, map[string]interface{}{
+			"remote": e.message,
+		})
+		reporter.notify(func(observer Observer) {
+			observer.Connected(e.message)
+		})
+
+	case eventOffer:
+		reporter.emit(jsonOffer, map[string]interface{}{
+			"name":         e.name,
+			"size":         e.size,
+			"is_directory": e.flag,
+		})
+		reporter.notify(func(observer Observer) {
+			observer.Offered(e.name, e.size, e.flag)
+		})
+
+	case eventAccepted:
+		reporter.emit(jsonAccepted, nil)
+		reporter.notify(func(observer Observer) {
+			observer.Accepted()
+		})
+
+	case eventError:
+		reporter.emit(jsonError, map[string]interface{}{
+			"kind":    e.kindOf,
+			"file":    e.name,
+			"message": e.message,
+		})
+		reporter.notify(func(observer Observer) {
+			observer.Error(e.kindOf, e.name, e.message)
+		})
+
+	case eventText:
+		reporter.emit(jsonText, map[string]interface{}{
+			"text": e.message,
+		})
+		reporter.notify(func(observer Observer) {
+			observer.TextReceived(e.message)
+		})
+		if reporter.format == FormatJSON {
+			break
+		}
+		reporter.clear()
+		fmt.Fprintf(reporter.output, "%s\n", e.message)
+		if reporter.format == FormatLive && reporter.started {
+			reporter.draw()
+		}
+
+	case eventObserve:
+		reporter.observers = append(reporter.observers, e.observer)
+	}
+}
+
+// Updates the smoothed throughput
+func (reporter *Reporter) sample(now time.Time) {
+	elapsed := now.Sub(reporter.lastSampleTime).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	instant := float64(reporter.transferred-reporter.lastSampleBytes) / elapsed
+	if reporter.lastSampleBytes == 0 && reporter.speed == 0 {
+		reporter.speed = instant
+	} else {
+		reporter.speed = smoothing*instant + (1-smoothing)*reporter.speed
+	}
+
+	reporter.lastSampleBytes = reporter.transferred
+	reporter.lastSampleTime = now
+}
+
+// Returns the estimated time left. The second value is false if it can not be estimated
+func (reporter *Reporter) eta() (time.Duration, bool) {
+	if reporter.speed <= 0 || reporter.doneBytes >= reporter.totalBytes {
+		return 0, false
+	}
+
+	left := float64(reporter.totalBytes-reporter.doneBytes) / reporter.speed
+	return time.Duration(left * float64(time.Second)), true
+}
+
+// Returns the lines describing the current progress
+func (reporter *Reporter) lines() []string {
+	var lines []string
+
+	// sizes of streams are not known until they end
+	if reporter.currentName != "" && reporter.currentSize == 0 && reporter.currentDone != 0 {
+		lines = append(lines, fmt.Sprintf("| File: %s (%s)", reporter.currentName, FormatSize(reporter.currentDone)))
+	} else if reporter.currentName != "" {
+		lines = append(lines, fmt.Sprintf("| File: %s (%s/%s)",
+			reporter.currentName, FormatSize(reporter.currentDone), FormatSize(reporter.currentSize)))
+	}
+
+	var percent float64 = 100
+	if reporter.totalBytes != 0 {
+		percent = float64(reporter.doneBytes) / float64(reporter.totalBytes) * 100
+	}
+
+	files := fmt.Sprint(reporter.filesDone + reporter.filesSkipped + reporter.filesFailed)
+	if reporter.totalFiles != 0 {
+		files += fmt.Sprintf("/%d", reporter.totalFiles)
+	}
+
+	if reporter.totalBytes == 0 && reporter.doneBytes != 0 {
+		lines = append(lines, fmt.Sprintf("| Done: %s, files: %s", FormatSize(reporter.doneBytes), files))
+	} else {
+		lines = append(lines, fmt.Sprintf("| Done: %s/%s (%.1f%%), files: %s",
+			FormatSize(reporter.doneBytes), FormatSize(reporter.totalBytes), percent, files))
+	}
+
+	eta := "unknown"
+	if left, ok := reporter.eta(); ok {
+		eta = FormatDuration(left)
+	}
+	lines = append(lines, fmt.Sprintf("| Speed: %s/s, ETA: %s", FormatSize(uint64(reporter.speed)), eta))
+
+	return lines
+}
+
+// Erases the drawn progress display
+func (reporter *Reporter) clear() {
+	if reporter.format != FormatLive || reporter.drawnLines == 0 {
+		return
+	}
+
+	// move to the beginning of the first drawn line and clear everything below
+	fmt.Fprintf(reporter.output, "\033[%dA\r\033[J", reporter.drawnLines)
+	reporter.drawnLines = 0
+}
+
+func (reporter *Reporter) draw() {
+	lines := reporter.lines()
+
+	if reporter.format != FormatLive {
+		fmt.Fprintf(reporter.output, "%s\n", strings.Join(lines, " "))
+		return
+	}
+
+	reporter.clear()
+	for _, line := range lines {
+		fmt.Fprintf(reporter.output, "\033[2K%s\n", line)
+	}
+	reporter.drawnLines = len(lines)
+}
+
+func (reporter *Reporter) finish() Summary {
+	summary := Summary{
+		TotalBytes:       reporter.totalBytes,
+		TransferredBytes: reporter.transferred,
+		FilesDone:        reporter.filesDone,
+		FilesSkipped:     reporter.filesSkipped,
+		FilesFailed:      reporter.filesFailed,
+	}
+
+	if !reporter.started {
+		return summary
+	}
+
+	summary.Elapsed = time.Since(reporter.startTime)
+	if summary.Elapsed > 0 {
+		summary.AverageSpeed = float64(summary.TransferredBytes) / summary.Elapsed.Seconds()
+	}
+
+	if reporter.format != FormatJSON {
+		reporter.clear()
+		fmt.Fprintf(reporter.output, "%s\n", summary)
+	}
+
+	return summary
+}
+
+// Returns the summary suitable for printing
+func (summary Summary) String() string {
+	return fmt.Sprintf("| Transferred %s in %s (%s/s on average). Files: %d done, %d skipped, %d failed",
+		FormatSize(summary.TransferredBytes),
+		FormatDuration(summary.Elapsed),
+		FormatSize(uint64(summary.AverageSpeed)),
+		summary.FilesDone, summary.FilesSkipped, summary.FilesFailed,
+	)
+}
+
+// Returns size in MiB or GiB suitable for printing
+func FormatSize(size uint64) string {
+	displaySize := float32(size) / 1024 / 1024
+	sizeLevel := "MiB"
+	if displaySize >= 1024 {
+		// GiB
+		displaySize = displaySize / 1024
+		sizeLevel = "GiB"
+	}
+
+	return fmt.Sprintf("%.3f %s", displaySize, sizeLevel)
+}
+
+// Returns duration as HH:MM:SS
+func FormatDuration(duration time.Duration) string {
+	seconds := int64(duration.Round(time.Second).Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
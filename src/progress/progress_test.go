@@ -0,0 +1,266 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ReporterSummary(t *testing.T) {
+	output := new(bytes.Buffer)
+	reporter := NewReporter(output, FormatPlain)
+
+	reporter.Start(300, 3)
+
+	reporter.FileStarted("a.txt", 100)
+	reporter.Transferred(60)
+	reporter.Transferred(40)
+	reporter.FileDone("a.txt")
+
+	reporter.FileSkipped("b.txt", 100)
+
+	reporter.FileStarted("c.txt", 100)
+	reporter.Resumed(50)
+	reporter.Transferred(10)
+	reporter.FileFailed("c.txt")
+	reporter.Printf("[ERROR] \"%s\" is corrupted", "c.txt")
+
+	summary := reporter.Stop()
+
+	if summary.TotalBytes != 300 || summary.TransferredBytes != 110 {
+		t.Fatalf("expected 110 of 300 bytes to be transferred; got %d of %d", summary.TransferredBytes, summary.TotalBytes)
+	}
+
+	if summary.FilesDone != 1 || summary.FilesSkipped != 1 || summary.FilesFailed != 1 {
+		t.Fatalf("expected 1 done, 1 skipped and 1 failed file; got %d, %d, %d", summary.FilesDone, summary.FilesSkipped, summary.FilesFailed)
+	}
+
+	if !strings.Contains(output.String(), "[ERROR] \"c.txt\" is corrupted\n") {
+		t.Fatalf("message has not been printed: %q", output.String())
+	}
+
+	if !strings.Contains(output.String(), summary.String()) {
+		t.Fatalf("summary has not been printed: %q", output.String())
+	}
+
+	// stopping again is harmless and events are dropped
+	reporter.Transferred(1)
+	if reporter.Stop() != summary {
+		t.Fatalf("second stop returned a different summary")
+	}
+}
+
+func Test_ReporterNotStarted(t *testing.T) {
+	output := new(bytes.Buffer)
+	reporter := NewReporter(output, FormatLive)
+	reporter.Stop()
+
+	if output.Len() != 0 {
+		t.Fatalf("expected nothing to be printed for a transfer that has not started; got %q", output.String())
+	}
+}
+
+func Test_ReporterLines(t *testing.T) {
+	reporter := &Reporter{
+		totalBytes:  4 * 1024 * 1024,
+		totalFiles:  2,
+		doneBytes:   1024 * 1024,
+		filesDone:   1,
+		currentName: "b.bin",
+		currentSize: 3 * 1024 * 1024,
+		speed:       1024 * 1024,
+	}
+
+	lines := reporter.lines()
+	expected := []string{
+		"| File: b.bin (0.000 MiB/3.000 MiB)",
+		"| Done: 1.000 MiB/4.000 MiB (25.0%), files: 1/2",
+		"| Speed: 1.000 MiB/s, ETA: 00:00:03",
+	}
+
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+
+	reporter.speed = 0
+	if _, ok := reporter.eta(); ok {
+		t.Fatalf("ETA should not be known without speed")
+	}
+}
+
+func Test_Smoothing(t *testing.T) {
+	start := time.Now()
+	reporter := &Reporter{lastSampleTime: start}
+
+	reporter.transferred = 1000
+	reporter.sample(start.Add(time.Second))
+	if reporter.speed != 1000 {
+		t.Fatalf("expected the first sample to be taken as is; got %f", reporter.speed)
+	}
+
+	reporter.transferred = 1000
+	reporter.sample(start.Add(2 * time.Second))
+	if reporter.speed <= 0 || reporter.speed >= 1000 {
+		t.Fatalf("expected the speed to be smoothed after a stall; got %f", reporter.speed)
+	}
+}
+
+func Test_FormatDuration(t *testing.T) {
+	cases := map[time.Duration]string{
+		0:                                   "00:00:00",
+		time.Second * 59:                    "00:00:59",
+		time.Minute*61 + time.Second*5:      "01:01:05",
+		time.Hour*25 + time.Millisecond*600: "25:00:01",
+	}
+
+	for duration, expected := range cases {
+		if FormatDuration(duration) != expected {
+			t.Fatalf("expected %s to be formatted as %s; got %s", duration, expected, FormatDuration(duration))
+		}
+	}
+}
+
+func Test_ReporterJSON(t *testing.T) {
+	output := new(bytes.Buffer)
+	reporter := NewReporter(output, FormatJSON)
+
+	reporter.Connected("127.0.0.1:7270")
+	reporter.Offer("dir", 10, true)
+	reporter.Accepted()
+	reporter.Start(10, 2)
+	reporter.FileStarted("dir/a", 5)
+	reporter.Transferred(5)
+	reporter.FileDone("dir/a")
+	reporter.FileSkipped("dir/b", 5)
+	reporter.Printf("not a JSON event")
+	reporter.Error(ErrorIntegrity, "dir/c", "checksum mismatch")
+	reporter.Completed("integrity-failed")
+
+	expected := []string{"connected", "offer", "accepted", "file_started", "file_done", "file_skipped", "error", "completed"}
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d events; got %d:\n%s", len(expected), len(lines), output.String())
+	}
+
+	for index, line := range lines {
+		var fields map[string]interface{}
+		err := json.Unmarshal([]byte(line), &fields)
+		if err != nil {
+			t.Fatalf("\"%s\" is not a valid JSON: %s", line, err)
+		}
+
+		if fields["event"] != expected[index] {
+			t.Fatalf("expected event #%d to be \"%s\"; got %v", index, expected[index], fields["event"])
+		}
+
+		if _, ok := fields["time"]; !ok {
+			t.Fatalf("event \"%s\" has no time", line)
+		}
+
+		switch fields["event"] {
+		case "file_skipped":
+			if fields["file"] != "dir/b" || fields["size"] != float64(5) {
+				t.Fatalf("unexpected file_skipped event: %s", line)
+			}
+		case "error":
+			if fields["kind"] != ErrorIntegrity || fields["file"] != "dir/c" {
+				t.Fatalf("unexpected error event: %s", line)
+			}
+		case "completed":
+			if fields["status"] != "integrity-failed" || fields["files_done"] != float64(1) || fields["files_skipped"] != float64(1) {
+				t.Fatalf("unexpected completed event: %s", line)
+			}
+		}
+	}
+}
+
+// remembers the names of received events
+type recordingObserver struct {
+	NopObserver
+	events    []string
+	snapshots []Snapshot
+	status    string
+	summary   Summary
+}
+
+func (observer *recordingObserver) Offered(name string, size uint64, isDirectory bool) {
+	observer.events = append(observer.events, "offered "+name)
+}
+
+func (observer *recordingObserver) FileStarted(name string, size uint64) {
+	observer.events = append(observer.events, "started "+name)
+}
+
+func (observer *recordingObserver) FileDone(name string) {
+	observer.events = append(observer.events, "done "+name)
+}
+
+func (observer *recordingObserver) FileFailed(name string) {
+	observer.events = append(observer.events, "failed "+name)
+}
+
+func (observer *recordingObserver) Progress(snapshot Snapshot) {
+	observer.snapshots = append(observer.snapshots, snapshot)
+}
+
+func (observer *recordingObserver) Completed(status string, summary Summary) {
+	observer.status = status
+	observer.summary = summary
+}
+
+func Test_ReporterObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	reporter := NewReporter(new(bytes.Buffer), FormatPlain)
+	reporter.Observe(observer)
+
+	reporter.Offer("dir", 20, true)
+	reporter.Start(20, 2)
+	reporter.FileStarted("dir/a", 10)
+	reporter.Transferred(5)
+	time.Sleep(ObserveInterval + ObserveInterval/2)
+	reporter.Transferred(5)
+	reporter.FileDone("dir/a")
+	reporter.FileStarted("dir/b", 10)
+	reporter.FileFailed("dir/b")
+	reporter.Completed("partial")
+
+	expected := []string{"offered dir", "started dir/a", "done dir/a", "started dir/b", "failed dir/b"}
+	if strings.Join(observer.events, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v events; got %v", expected, observer.events)
+	}
+
+	if len(observer.snapshots) == 0 {
+		t.Fatalf("no progress has been observed")
+	}
+	snapshot := observer.snapshots[0]
+	if snapshot.CurrentFile != "dir/a" || snapshot.CurrentDone != 5 || snapshot.DoneBytes != 5 || snapshot.TotalBytes != 20 {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+
+	if observer.status != "partial" || observer.summary.FilesDone != 1 || observer.summary.FilesFailed != 1 {
+		t.Fatalf("unexpected completion: %s %+v", observer.status, observer.summary)
+	}
+}
@@ -26,6 +26,10 @@ package protocol
 // (packets with size bigger than MAXPACKETSIZE are invalid and will not be sent)
 const MAXPACKETSIZE uint = 131072 // 128 KiB
 
+// MAXTEXTSIZE.
+// How many bytes a text message can contain at maximum
+const MAXTEXTSIZE uint = 65536 // 64 KiB
+
 // HEADERDELIMETER.
 // Character that delimits header of the packet from the body of the packet.
 // ie: (packet header)~(packet body)
@@ -36,3 +40,12 @@ const FILECODE string = "f"
 
 // DIRCODE.
 const DIRCODE string = "d"
+
+// BUNDLECODE.
+const BUNDLECODE string = "b"
+
+// STREAMCODE.
+const STREAMCODE string = "s"
+
+// SYMLINKCODE.
+const SYMLINKCODE string = "l"
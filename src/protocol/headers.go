@@ -78,16 +78,23 @@ const HeaderDisconnecting Header = "BYE!"
 // a file or a directory will be sent in case of acceptance. The rest must be identical either to the FILE or DIRECTORY packet.
 // e for directory: TRANSFER~(dircode)(dirname size in binary)(dirname)(dirsize)
 // e for a single file: TRANSFER~(filecode)(id in binary)(filename length in binary)(filename)(filesize)(checksum length in binary)(checksum)
-// dircode and filecode are pre-declared in the constants of the protocol (d) and (f).
+// e for a bundle: TRANSFER~(bundlecode)(the rest of the BUNDLE packet)
+// e for a stream: TRANSFER~(streamcode)(the rest of the FILE packet, with 0 filesize and an empty checksum)
+// dircode, filecode, bundlecode and streamcode are pre-declared in the constants of the protocol (d), (f), (b) and (s).
+// A stream is a single file of unknown size (ie: read from a pipe), sent the same way as a file.
 // The actual transfer must start only after the other node has accepted the dir/file with ACCEPT packet.
 const HeaderTransferOffer Header = "TRANSFEROFFER"
 
 // FILE.
 // Sent by sender, indicating that the file is going to be sent.
 // The body structure must follow such structure:
-// FILE~(id in binary)(filename length in binary)(filename)(filesize)(checksum length in binary)(checksum)(relative path to the upper directory size in binary if present)(relative path)
+// FILE~(id in binary)(filename length in binary)(filename)(filesize)(checksum length in binary)(checksum)(relative path to the upper directory size in binary if present)(relative path)(modification time in binary)(mode in binary)
 // relative path is not needed when the file is already in the root of the initial directory, but must be included when
 // the whole directory is being sent recursively
+// modification time is an int64 of unix nanoseconds (0 if unknown) and may be absent in packets from older senders.
+// mode is an uint32 of the permission bits of the file (0 if unknown) and may be absent as well.
+// filename must be a single path element and relative path must be relative and clean (no "..", "." or empty elements),
+// otherwise the receiver aborts the transfer
 const HeaderFile Header = "FILE"
 
 // FILEBYTES.
@@ -98,8 +105,10 @@ const HeaderFileBytes Header = "FILEBYTES"
 
 // ENDFILE
 // Sent by sender when the file`s contents fully has been sent.
-// The body must contain a file ID.
+// The body must contain a file ID. When a stream ends, its size and checksum, which were not
+// known in advance, follow. The checksum of a stream is taken of all its contents.
 // ie: ENDFILE~(file ID in binary)
+// ie: ENDFILE~(file ID in binary)(filesize)(checksum length in binary)(checksum)
 const HeaderEndfile Header = "ENDFILE"
 
 // DIRECTORY
@@ -108,8 +117,18 @@ const HeaderEndfile Header = "ENDFILE"
 // ie: DIRECTORY~(dirname size in binary)(dirname)(dirsize)
 const HeaderDirectory Header = "DIRECTORY"
 
+// BUNDLE
+// Sent by sender. Used in TRANSFEROFFER packet when several files and directories are sent
+// together. Every entry is placed at the top level of the downloads directory under its own name.
+// The body contains the total size and the manifest of the upmost entries, where the entry code is
+// either filecode or dircode
+// ie: BUNDLE~(total size)(entries count)[(entry code)(name size in binary)(name)(entry size)]...
+// Entry names must be single path elements, the same way as in FILE packet, and must not repeat
+const HeaderBundle Header = "BUNDLE"
+
 // ALREADYHAVE
-// Sent by receiver in case there is the same file that already exists.
+// Sent by receiver in case there is the same file that already exists or
+// the receiver has decided to skip the conflicting file.
 // Sender upon receiving such packet with specified file ID must not send it.
 // Body must contain a file ID.
 // ie: ALREADYHAVE~(file ID in binary)
@@ -120,4 +139,80 @@ const HeaderAlreadyHave Header = "ALREADYHAVE"
 // is a symlink in some place that points to some other already received file.
 // Body must contain information where the symlink is and the target file.
 // ie: SYMLINK~(string size in binary)(location in the filesystem)(string size in binary)(location of a target)
+// Both locations are relative to the root of the transfer and must be clean, the same way as in FILE packet
 const HeaderSymlink Header = "SYMLINK"
+
+// EMPTYDIR
+// Sent by sender AFTER ALL FILES and symlinks, right before DONE, once for every directory
+// that has nothing in it, so it is not lost. The receiver does not answer with READY.
+// The location is relative to the root of the transfer and must be clean, the same way as in FILE packet
+// ie: EMPTYDIR~(string size in binary)(location in the filesystem)
+const HeaderEmptyDirectory Header = "EMPTYDIR"
+
+// RESUME
+// Sent by receiver instead of READY after FILE packet when it already has
+// the beginning of that file from the previous interrupted transfer. Sender upon receiving
+// such packet must continue sending the file from the given offset.
+// Sent only if the receiver has been asked to resume interrupted transfers.
+// ie: RESUME~(file ID in binary)(offset in binary)
+const HeaderResume Header = "RESUME"
+
+// TEXT
+// Sent by sender after ENCRKEY instead of the transfer offer when all there is to send is a text
+// message, followed by DONE. The receiver shows the text as is, without asking anything.
+// In peer sessions sent by either side at any time, even in the middle of a transfer.
+// The text must be no longer than MAXTEXTSIZE bytes.
+// ie: TEXT~(text)
+const HeaderText Header = "TEXT"
+
+//// Sessions. The side that starts the session sends ENCRKEY followed by SESSION, and then both
+//// sides exchange the packets below, encrypted with that key. Transfers started in the session are
+//// ordinary transfers with their own ENCRKEY that end with both sides sending BYE!, after which
+//// the session continues. Packets with headers other than those below belong to the transfer in progress
+
+// SESSION
+// Sent by the side that has started the session right after ENCRKEY. Tells what kind of session
+// it is: "browse" (the starting side shares a directory) or "peer" (both sides can send).
+// ie: SESSION~(kind)
+const HeaderSession Header = "SESSION"
+
+// GRANTED
+// Sent in response to GET or PUSH when the request is fulfilled. The transfer starts right after it.
+// ie: GRANTED~
+const HeaderGranted Header = "GRANTED"
+
+// DENIED
+// Sent instead of LISTING or GRANTED when the request cannot be fulfilled, with the reason in the body.
+// ie: DENIED~(reason)
+const HeaderDenied Header = "DENIED"
+
+// LIST
+// Sent by the browsing side. Asks for entries of the directory at the path, which is
+// slash-separated and relative to the shared root. If recursive is 1 - entries of every
+// inner directory are listed as well.
+// The sharing side answers with LISTING packets or DENIED.
+// ie: LIST~(path size in binary)(path)(recursive)
+const HeaderList Header = "LIST"
+
+// LISTING
+// Sent by the sharing side in response to LIST. Entries may be split between several packets;
+// last is 1 in the last one. The entry code is filecode, dircode or symlinkcode, the path is
+// slash-separated and relative to the listed directory, the size of a directory is the size of
+// everything inside of it and the modification time is in unix nanoseconds.
+// ie: LISTING~(last)(entries count)[(entry code)(path size in binary)(path)(size)(modification time)]...
+const HeaderListing Header = "LISTING"
+
+// GET
+// Sent by the browsing side. Asks to send the file|directory at the path, which is
+// slash-separated and relative to the shared root. The sharing side answers with DENIED
+// or GRANTED, after which it offers the file|directory the usual way.
+// ie: GET~(path size in binary)(path)
+const HeaderGet Header = "GET"
+
+// PUSH
+// Sent by either side of a peer session that wants to send something. The other side answers with
+// GRANTED as soon as it is not busy with another transfer, after which the requesting side offers
+// the file|directory the usual way, and the offer is accepted or rejected as always. If both sides
+// send PUSH at the same time - the request of the side that has started the session goes first.
+// ie: PUSH~
+const HeaderPush Header = "PUSH"
@@ -0,0 +1,277 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Packets of browse sessions: LIST, LISTING and GET
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"unbewohnte/ftu/fsys"
+)
+
+// An entry of the shared directory as seen by the browsing side
+type ListingEntry struct {
+	Path      string    // slash-separated, relative to the listed directory
+	Size      uint64    // for directories - the size of everything inside
+	ModTime   time.Time // zero if unknown
+	IsDir     bool
+	IsSymlink bool
+}
+
+// Writes a string preceded by its size
+func writeString(buffer *bytes.Buffer, str string) {
+	binary.Write(buffer, binary.BigEndian, uint64(len(str)))
+	buffer.Write([]byte(str))
+}
+
+// Reads a string preceded by its size
+func readString(reader *bytes.Reader) (string, error) {
+	var size uint64
+	err := binary.Read(reader, binary.BigEndian, &size)
+	if err != nil {
+		return "", err
+	}
+	if size > uint64(reader.Len()) {
+		return "", ErrorInvalidPacket
+	}
+
+	str := make([]byte, size)
+	_, err = reader.Read(str)
+	if err != nil {
+		return "", err
+	}
+
+	return string(str), nil
+}
+
+// Checks the path of a request. An empty path means the shared root itself
+func validateRequestPath(path string) error {
+	if path == "" {
+		return nil
+	}
+	return fsys.ValidateRelativePath(path)
+}
+
+// constructs a ready to send LIST packet
+func CreateListPacket(path string, recursive bool) *Packet {
+	// LIST~(path size in binary)(path)(recursive)
+
+	buffer := new(bytes.Buffer)
+	writeString(buffer, path)
+	if recursive {
+		buffer.WriteByte(1)
+	} else {
+		buffer.WriteByte(0)
+	}
+
+	return &Packet{
+		Header: HeaderList,
+		Body:   buffer.Bytes(),
+	}
+}
+
+// decodes LIST packet into the path and whether to list it recursively
+func DecodeListPacket(listPacket *Packet) (string, bool, error) {
+	if listPacket.Header != HeaderList {
+		return "", false, ErrorWrongPacket
+	}
+
+	reader := bytes.NewReader(listPacket.Body)
+
+	path, err := readString(reader)
+	if err != nil {
+		return "", false, err
+	}
+
+	recursive, err := reader.ReadByte()
+	if err != nil {
+		return "", false, err
+	}
+
+	err = validateRequestPath(path)
+	if err != nil {
+		return "", false, err
+	}
+
+	return path, recursive == 1, nil
+}
+
+// constructs ready to send LISTING packets, as many as needed to fit all entries.
+// There is always at least one
+func CreateListingPackets(entries []ListingEntry) []*Packet {
+	// LISTING~(last)(entries count)[(entry code)(path size in binary)(path)(size)(modification time)]...
+
+	// leave room for the header, last flag, count and encryption
+	maxBodySize := int(MAXPACKETSIZE) - len(HeaderListing) - len(HEADERDELIMETER) - 1 - 8 - int(encryptionOverhead)
+
+	var packets []*Packet
+	var current []byte
+	var count uint64
+	flush := func(last bool) {
+		body := new(bytes.Buffer)
+		if last {
+			body.WriteByte(1)
+		} else {
+			body.WriteByte(0)
+		}
+		binary.Write(body, binary.BigEndian, count)
+		body.Write(current)
+
+		packets = append(packets, &Packet{
+			Header: HeaderListing,
+			Body:   body.Bytes(),
+		})
+		current = nil
+		count = 0
+	}
+
+	for _, entry := range entries {
+		entryBuffer := new(bytes.Buffer)
+		switch {
+		case entry.IsDir:
+			entryBuffer.Write([]byte(DIRCODE))
+		case entry.IsSymlink:
+			entryBuffer.Write([]byte(SYMLINKCODE))
+		default:
+			entryBuffer.Write([]byte(FILECODE))
+		}
+		writeString(entryBuffer, entry.Path)
+		binary.Write(entryBuffer, binary.BigEndian, entry.Size)
+		var modTime int64 = 0
+		if !entry.ModTime.IsZero() {
+			modTime = entry.ModTime.UnixNano()
+		}
+		binary.Write(entryBuffer, binary.BigEndian, modTime)
+
+		if len(current)+entryBuffer.Len() > maxBodySize && count != 0 {
+			flush(false)
+		}
+		current = append(current, entryBuffer.Bytes()...)
+		count++
+	}
+	flush(true)
+
+	return packets
+}
+
+// decodes LISTING packet into entries and whether it is the last one
+func DecodeListingPacket(listingPacket *Packet) ([]ListingEntry, bool, error) {
+	if listingPacket.Header != HeaderListing {
+		return nil, false, ErrorWrongPacket
+	}
+
+	reader := bytes.NewReader(listingPacket.Body)
+
+	last, err := reader.ReadByte()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var count uint64
+	err = binary.Read(reader, binary.BigEndian, &count)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var entries []ListingEntry
+	for i := uint64(0); i < count; i++ {
+		code, err := reader.ReadByte()
+		if err != nil {
+			return nil, false, err
+		}
+
+		path, err := readString(reader)
+		if err != nil {
+			return nil, false, err
+		}
+
+		entry := ListingEntry{
+			Path: path,
+		}
+
+		err = binary.Read(reader, binary.BigEndian, &entry.Size)
+		if err != nil {
+			return nil, false, err
+		}
+
+		var modTime int64
+		err = binary.Read(reader, binary.BigEndian, &modTime)
+		if err != nil {
+			return nil, false, err
+		}
+		if modTime != 0 {
+			entry.ModTime = time.Unix(0, modTime)
+		}
+
+		switch string(code) {
+		case DIRCODE:
+			entry.IsDir = true
+		case SYMLINKCODE:
+			entry.IsSymlink = true
+		case FILECODE:
+		default:
+			return nil, false, ErrorInvalidPacket
+		}
+
+		err = fsys.ValidateRelativePath(path)
+		if err != nil {
+			return nil, false, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, last == 1, nil
+}
+
+// constructs a ready to send GET packet
+func CreateGetPacket(path string) *Packet {
+	// GET~(path size in binary)(path)
+
+	buffer := new(bytes.Buffer)
+	writeString(buffer, path)
+
+	return &Packet{
+		Header: HeaderGet,
+		Body:   buffer.Bytes(),
+	}
+}
+
+// decodes GET packet into the requested path
+func DecodeGetPacket(getPacket *Packet) (string, error) {
+	if getPacket.Header != HeaderGet {
+		return "", ErrorWrongPacket
+	}
+
+	path, err := readString(bytes.NewReader(getPacket.Body))
+	if err != nil {
+		return "", err
+	}
+
+	err = validateRequestPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
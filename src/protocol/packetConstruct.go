@@ -24,19 +24,22 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 
 	"unbewohnte/ftu/fsys"
 )
 
 // constructs a ready to send FILE packet
 func CreateFilePacket(file *fsys.File) (*Packet, error) {
-	err := file.Open()
-	if err != nil {
-		return nil, err
+	if !file.Streamed && file.FS == nil {
+		err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
 	}
-	defer file.Close()
 
-	//(id in binary)(filename length in binary)(filename)(filesize)(checksum length in binary)(checksum)(relative path to the upper directory size in binary if present)(relative path)
+	//(id in binary)(filename length in binary)(filename)(filesize)(checksum length in binary)(checksum)(relative path to the upper directory size in binary if present)(relative path)(modification time in binary)(mode in binary)
 
 	filePacket := Packet{
 		Header: HeaderFile,
@@ -64,6 +67,17 @@ func CreateFilePacket(file *fsys.File) (*Packet, error) {
 	binary.Write(fPacketBodyBuff, binary.BigEndian, &relPathLen)
 	fPacketBodyBuff.Write([]byte(file.RelativeParentPath))
 
+	// modification time
+	var modTime int64 = 0
+	if !file.ModTime.IsZero() {
+		modTime = file.ModTime.UnixNano()
+	}
+	binary.Write(fPacketBodyBuff, binary.BigEndian, &modTime)
+
+	// permission bits
+	mode := uint32(file.Mode.Perm())
+	binary.Write(fPacketBodyBuff, binary.BigEndian, &mode)
+
 	filePacket.Body = fPacketBodyBuff.Bytes()
 
 	// we do not check for packet size because there is no way that it`ll exceed current
@@ -71,6 +85,31 @@ func CreateFilePacket(file *fsys.File) (*Packet, error) {
 	return &filePacket, nil
 }
 
+// constructs a ready to send ENDFILE packet. The size and checksum of a streamed file
+// are included as well, so they must be set by now
+func CreateEndfilePacket(file *fsys.File) (*Packet, error) {
+	endfilePacketBodyBuff := new(bytes.Buffer)
+
+	// (file ID in binary)(filesize)(checksum length in binary)(checksum)
+	err := binary.Write(endfilePacketBodyBuff, binary.BigEndian, file.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Streamed {
+		binary.Write(endfilePacketBodyBuff, binary.BigEndian, file.Size)
+
+		checksumLen := uint64(len(file.Checksum))
+		binary.Write(endfilePacketBodyBuff, binary.BigEndian, checksumLen)
+		endfilePacketBodyBuff.Write([]byte(file.Checksum))
+	}
+
+	return &Packet{
+		Header: HeaderEndfile,
+		Body:   endfilePacketBodyBuff.Bytes(),
+	}, nil
+}
+
 // constructs a ready to send DIRECTORY packet
 func CreateDirectoryPacket(dir *fsys.Directory) (*Packet, error) {
 	dirPacket := Packet{
@@ -101,3 +140,62 @@ func CreateDirectoryPacket(dir *fsys.Directory) (*Packet, error) {
 	// maximum of 128 KiB
 	return &dirPacket, nil
 }
+
+var ErrorTextTooLong error = fmt.Errorf("the text is longer than %d bytes", MAXTEXTSIZE)
+
+// constructs a ready to send TEXT packet. Returns ErrorTextTooLong if the text is longer than MAXTEXTSIZE bytes
+func CreateTextPacket(text string) (*Packet, error) {
+	if uint(len(text)) > MAXTEXTSIZE {
+		return nil, ErrorTextTooLong
+	}
+
+	return &Packet{
+		Header: HeaderText,
+		Body:   []byte(text),
+	}, nil
+}
+
+// How much bigger the encrypted body of a packet can get
+const encryptionOverhead uint64 = 48
+
+// constructs a ready to send BUNDLE packet. Returns ErrorExceededMaxPacketsize if the manifest
+// of the bundle does not fit into the transfer offer
+func CreateBundlePacket(bundle *fsys.Directory) (*Packet, error) {
+	bundlePacket := Packet{
+		Header: HeaderBundle,
+	}
+
+	// BUNDLE~(total size)(entries count)[(entry code)(name size in binary)(name)(entry size)]...
+
+	bundlePacketBuffer := new(bytes.Buffer)
+
+	binary.Write(bundlePacketBuffer, binary.BigEndian, bundle.Size)
+
+	entriesCount := uint64(len(bundle.Files) + len(bundle.Directories))
+	binary.Write(bundlePacketBuffer, binary.BigEndian, entriesCount)
+
+	writeEntry := func(code string, name string, size uint64) {
+		bundlePacketBuffer.Write([]byte(code))
+		nameLength := uint64(len(name))
+		binary.Write(bundlePacketBuffer, binary.BigEndian, nameLength)
+		bundlePacketBuffer.Write([]byte(name))
+		binary.Write(bundlePacketBuffer, binary.BigEndian, size)
+	}
+
+	for _, file := range bundle.Files {
+		writeEntry(FILECODE, file.Name, file.Size)
+	}
+	for _, dir := range bundle.Directories {
+		writeEntry(DIRCODE, dir.Name, dir.Size)
+	}
+
+	bundlePacket.Body = bundlePacketBuffer.Bytes()
+
+	// unlike other packets, the manifest can be arbitrarily long
+	offerSize := uint64(len(HeaderTransferOffer)) + uint64(len(HEADERDELIMETER)) + uint64(len(BUNDLECODE)) + uint64(len(bundlePacket.Body))
+	if offerSize+encryptionOverhead > uint64(MAXPACKETSIZE) {
+		return nil, ErrorExceededMaxPacketsize
+	}
+
+	return &bundlePacket, nil
+}
@@ -25,6 +25,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"unbewohnte/ftu/fsys"
 )
@@ -37,7 +42,7 @@ func DecodeFilePacket(filePacket *Packet) (*fsys.File, error) {
 		return nil, ErrorWrongPacket
 	}
 
-	//(id in binary)(filename length in binary)(filename)(filesize)(checksum length in binary)(checksum)(relative path to the upper directory size in binary if present)(relative path)
+	//(id in binary)(filename length in binary)(filename)(filesize)(checksum length in binary)(checksum)(relative path to the upper directory size in binary if present)(relative path)(modification time in binary)(mode in binary)
 
 	// retrieve data from packet body
 
@@ -57,6 +62,9 @@ func DecodeFilePacket(filePacket *Packet) (*fsys.File, error) {
 		return nil, err
 	}
 
+	if filenameLength > uint64(packetReader.Len()) {
+		return nil, ErrorInvalidPacket
+	}
 	filenameBytes := make([]byte, filenameLength)
 	_, err = packetReader.Read(filenameBytes)
 	if err != nil {
@@ -78,6 +86,9 @@ func DecodeFilePacket(filePacket *Packet) (*fsys.File, error) {
 	if err != nil {
 		return nil, err
 	}
+	if checksumLength > uint64(packetReader.Len()) {
+		return nil, ErrorInvalidPacket
+	}
 	checksumBytes := make([]byte, checksumLength)
 	_, err = packetReader.Read(checksumBytes)
 	if err != nil {
@@ -91,6 +102,9 @@ func DecodeFilePacket(filePacket *Packet) (*fsys.File, error) {
 	if err != nil {
 		return nil, err
 	}
+	if relPathLength > uint64(packetReader.Len()) {
+		return nil, ErrorInvalidPacket
+	}
 	relPathBytes := make([]byte, relPathLength)
 	_, err = packetReader.Read(relPathBytes)
 	if err != nil {
@@ -98,16 +112,95 @@ func DecodeFilePacket(filePacket *Packet) (*fsys.File, error) {
 	}
 	relPath := string(relPathBytes)
 
+	// modification time. Older senders do not include it
+	var modTime time.Time
+	if packetReader.Len() >= 8 {
+		var modTimeNano int64
+		err = binary.Read(packetReader, binary.BigEndian, &modTimeNano)
+		if err != nil {
+			return nil, err
+		}
+		if modTimeNano != 0 {
+			modTime = time.Unix(0, modTimeNano)
+		}
+	}
+
+	// permission bits. Older senders do not include them either
+	var mode uint32
+	if packetReader.Len() >= 4 {
+		err = binary.Read(packetReader, binary.BigEndian, &mode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the other side must not be able to point outside of the downloads directory
+	err = fsys.ValidateName(filename)
+	if err != nil {
+		return nil, err
+	}
+	if relPath != "" {
+		err = fsys.ValidateRelativePath(relPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &fsys.File{
 		ID:                 fileID,
 		Name:               filename,
 		Size:               filesize,
+		ModTime:            modTime,
+		Mode:               os.FileMode(mode).Perm(),
 		Checksum:           checksum,
 		RelativeParentPath: relPath,
 		Handler:            nil,
 	}, nil
 }
 
+// decodes ENDFILE packet into fsys.File struct with only ID set, or ID, Size and Checksum
+// if it ends a stream (then Streamed is true as well)
+func DecodeEndfilePacket(endfilePacket *Packet) (*fsys.File, error) {
+	if endfilePacket.Header != HeaderEndfile {
+		return nil, ErrorWrongPacket
+	}
+
+	packetReader := bytes.NewReader(endfilePacket.Body)
+
+	var file fsys.File
+	err := binary.Read(packetReader, binary.BigEndian, &file.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if packetReader.Len() == 0 {
+		// an ordinary file
+		return &file, nil
+	}
+
+	// the end of a stream
+	file.Streamed = true
+
+	err = binary.Read(packetReader, binary.BigEndian, &file.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	var checksumLength uint64
+	err = binary.Read(packetReader, binary.BigEndian, &checksumLength)
+	if err != nil {
+		return nil, err
+	}
+	if checksumLength != uint64(packetReader.Len()) {
+		return nil, ErrorInvalidPacket
+	}
+	checksumBytes := make([]byte, checksumLength)
+	packetReader.Read(checksumBytes)
+	file.Checksum = string(checksumBytes)
+
+	return &file, nil
+}
+
 // decodes DIRECTORY packet into fsys.Directory struct
 func DecodeDirectoryPacket(dirPacket *Packet) (*fsys.Directory, error) {
 	if dirPacket.Header != HeaderDirectory {
@@ -124,6 +217,9 @@ func DecodeDirectoryPacket(dirPacket *Packet) (*fsys.Directory, error) {
 	if err != nil {
 		return nil, err
 	}
+	if dirNameSize > uint64(packetReader.Len()) {
+		return nil, ErrorInvalidPacket
+	}
 	dirName := make([]byte, dirNameSize)
 	_, err = packetReader.Read(dirName)
 	if err != nil {
@@ -137,6 +233,11 @@ func DecodeDirectoryPacket(dirPacket *Packet) (*fsys.Directory, error) {
 		return nil, err
 	}
 
+	err = fsys.ValidateName(string(dirName))
+	if err != nil {
+		return nil, err
+	}
+
 	dir := fsys.Directory{
 		Name: string(dirName),
 		Size: dirSize,
@@ -145,6 +246,204 @@ func DecodeDirectoryPacket(dirPacket *Packet) (*fsys.Directory, error) {
 	return &dir, nil
 }
 
+// decodes BUNDLE packet into fsys.Directory struct with Bundle set. Its Files and Directories
+// contain only names and sizes of the upmost entries
+func DecodeBundlePacket(bundlePacket *Packet) (*fsys.Directory, error) {
+	if bundlePacket.Header != HeaderBundle {
+		return nil, ErrorWrongPacket
+	}
+
+	// BUNDLE~(total size)(entries count)[(entry code)(name size in binary)(name)(entry size)]...
+
+	packetReader := bytes.NewReader(bundlePacket.Body)
+
+	bundle := fsys.Directory{
+		Bundle: true,
+	}
+
+	err := binary.Read(packetReader, binary.BigEndian, &bundle.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	var entriesCount uint64
+	err = binary.Read(packetReader, binary.BigEndian, &entriesCount)
+	if err != nil {
+		return nil, err
+	}
+
+	var entriesSize uint64
+	names := make(map[string]bool)
+	for i := uint64(0); i < entriesCount; i++ {
+		code, err := packetReader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		var nameSize uint64
+		err = binary.Read(packetReader, binary.BigEndian, &nameSize)
+		if err != nil {
+			return nil, err
+		}
+		if nameSize > uint64(packetReader.Len()) {
+			return nil, ErrorInvalidPacket
+		}
+		name := make([]byte, nameSize)
+		_, err = packetReader.Read(name)
+		if err != nil {
+			return nil, err
+		}
+
+		var size uint64
+		err = binary.Read(packetReader, binary.BigEndian, &size)
+		if err != nil {
+			return nil, err
+		}
+
+		err = fsys.ValidateName(string(name))
+		if err != nil {
+			return nil, err
+		}
+		if names[string(name)] {
+			return nil, fmt.Errorf("%w: \"%s\"", fsys.ErrorDuplicateName, name)
+		}
+		names[string(name)] = true
+		entriesSize += size
+
+		switch string(code) {
+		case FILECODE:
+			bundle.Files = append(bundle.Files, &fsys.File{
+				Name: string(name),
+				Size: size,
+			})
+		case DIRCODE:
+			bundle.Directories = append(bundle.Directories, &fsys.Directory{
+				Name: string(name),
+				Size: size,
+			})
+		default:
+			return nil, ErrorInvalidPacket
+		}
+	}
+
+	if entriesSize != bundle.Size {
+		return nil, ErrorInvalidPacket
+	}
+
+	return &bundle, nil
+}
+
+// decodes SYMLINK packet into fsys.Symlink struct. Both the location of a symlink and its target
+// must be relative to the root of the transfer
+func DecodeSymlinkPacket(symlinkPacket *Packet) (*fsys.Symlink, error) {
+	if symlinkPacket.Header != HeaderSymlink {
+		return nil, ErrorWrongPacket
+	}
+
+	// SYMLINK~(string size in binary)(location in the filesystem)(string size in binary)(location of a target)
+
+	packetReader := bytes.NewReader(symlinkPacket.Body)
+
+	// location
+	var locationSize uint64
+	err := binary.Read(packetReader, binary.BigEndian, &locationSize)
+	if err != nil {
+		return nil, err
+	}
+	if locationSize > uint64(packetReader.Len()) {
+		return nil, ErrorInvalidPacket
+	}
+	locationBytes := make([]byte, locationSize)
+	_, err = packetReader.Read(locationBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// target
+	var targetSize uint64
+	err = binary.Read(packetReader, binary.BigEndian, &targetSize)
+	if err != nil {
+		return nil, err
+	}
+	if targetSize > uint64(packetReader.Len()) {
+		return nil, ErrorInvalidPacket
+	}
+	targetBytes := make([]byte, targetSize)
+	_, err = packetReader.Read(targetBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	symlink := fsys.Symlink{
+		Path:       string(locationBytes),
+		TargetPath: string(targetBytes),
+	}
+
+	// the target is only pointed to, so it is up to the receiver whether to create
+	// a symlink that points outside of the transfer
+	err = fsys.ValidateRelativePath(symlink.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &symlink, nil
+}
+
+// decodes EMPTYDIR packet into the location of an empty directory, relative to the root of the transfer
+func DecodeEmptyDirectoryPacket(emptyDirPacket *Packet) (string, error) {
+	if emptyDirPacket.Header != HeaderEmptyDirectory {
+		return "", ErrorWrongPacket
+	}
+
+	// EMPTYDIR~(string size in binary)(location in the filesystem)
+
+	packetReader := bytes.NewReader(emptyDirPacket.Body)
+
+	var locationSize uint64
+	err := binary.Read(packetReader, binary.BigEndian, &locationSize)
+	if err != nil {
+		return "", err
+	}
+	if locationSize > uint64(packetReader.Len()) {
+		return "", ErrorInvalidPacket
+	}
+	locationBytes := make([]byte, locationSize)
+	_, err = packetReader.Read(locationBytes)
+	if err != nil {
+		return "", err
+	}
+	location := string(locationBytes)
+
+	err = fsys.ValidateRelativePath(location)
+	if err != nil {
+		return "", err
+	}
+
+	return location, nil
+}
+
+// decodes TEXT packet into the text that is safe to print: invalid UTF-8 is replaced and
+// control characters (terminal escape sequences included) are removed, except for newlines and tabs
+func DecodeTextPacket(textPacket *Packet) (string, error) {
+	if textPacket.Header != HeaderText {
+		return "", ErrorWrongPacket
+	}
+
+	if uint(len(textPacket.Body)) > MAXTEXTSIZE {
+		return "", ErrorTextTooLong
+	}
+
+	text := strings.ToValidUTF8(string(textPacket.Body), string(utf8.RuneError))
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, text)
+
+	return text, nil
+}
+
 // decodes TRANSFERINFO packet into either fsys.File or fsys.Directory struct.
 // decodeTransferPacket cannot return 2 nils or both non-nils as 2 first return values in case
 // of a successfull decoding
@@ -157,6 +456,10 @@ func DecodeTransferPacket(transferPacket *Packet) (*fsys.File, *fsys.Directory,
 	var dir *fsys.Directory = nil
 	var err error
 
+	if len(transferPacket.Body) == 0 {
+		return nil, nil, ErrorInvalidPacket
+	}
+
 	// determine if it`s a file or a directory
 	switch string(transferPacket.Body[0]) {
 	case FILECODE:
@@ -170,6 +473,18 @@ func DecodeTransferPacket(transferPacket *Packet) (*fsys.File, *fsys.Directory,
 			return nil, nil, err
 		}
 
+	case STREAMCODE:
+		filePacket := Packet{
+			Header: HeaderFile,
+			Body:   transferPacket.Body[1:],
+		}
+
+		file, err = DecodeFilePacket(&filePacket)
+		if err != nil {
+			return nil, nil, err
+		}
+		file.Streamed = true
+
 	case DIRCODE:
 		dirPacket := Packet{
 			Header: HeaderDirectory,
@@ -181,6 +496,17 @@ func DecodeTransferPacket(transferPacket *Packet) (*fsys.File, *fsys.Directory,
 			return nil, nil, err
 		}
 
+	case BUNDLECODE:
+		bundlePacket := Packet{
+			Header: HeaderBundle,
+			Body:   transferPacket.Body[1:],
+		}
+
+		dir, err = DecodeBundlePacket(&bundlePacket)
+		if err != nil {
+			return nil, nil, err
+		}
+
 	default:
 		return nil, nil, ErrorInvalidPacket
 	}
@@ -22,8 +22,13 @@ package protocol
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"net"
+	"strings"
 	"testing"
+
+	"unbewohnte/ftu/fsys"
 )
 
 func Test_WriteRead(t *testing.T) {
@@ -99,3 +104,390 @@ func Test_BytesToPacket(t *testing.T) {
 		t.Fatalf("BytesToPacket error: header or body of converted packet does not match with the original")
 	}
 }
+
+// writes a string the way the protocol does: (string size in binary)(string)
+func writeProtocolString(buffer *bytes.Buffer, str string) {
+	binary.Write(buffer, binary.BigEndian, uint64(len(str)))
+	buffer.Write([]byte(str))
+}
+
+// constructs a FILE packet with arbitrary name and relative path
+func craftFilePacket(name string, relPath string) *Packet {
+	body := new(bytes.Buffer)
+	binary.Write(body, binary.BigEndian, uint64(0))
+	writeProtocolString(body, name)
+	binary.Write(body, binary.BigEndian, uint64(8))
+	writeProtocolString(body, "checksum")
+	writeProtocolString(body, relPath)
+
+	return &Packet{
+		Header: HeaderFile,
+		Body:   body.Bytes(),
+	}
+}
+
+func Test_DecodeMaliciousManifests(t *testing.T) {
+	maliciousFiles := []struct {
+		name    string
+		relPath string
+	}{
+		{"../.bashrc", ""},
+		{"..", ""},
+		{"/etc/passwd", ""},
+		{"passwd", "/etc/passwd"},
+		{".bashrc", "../../.bashrc"},
+		{".bashrc", "dir/../../.bashrc"},
+		{".bashrc", "..\\..\\.bashrc"},
+		{"file", "dir//file"},
+		{"file", "./file"},
+	}
+
+	for _, malicious := range maliciousFiles {
+		_, err := DecodeFilePacket(craftFilePacket(malicious.name, malicious.relPath))
+		if !errors.Is(err, fsys.ErrorUnsafePath) {
+			t.Fatalf("expected FILE with name \"%s\" and path \"%s\" to be rejected; got %v", malicious.name, malicious.relPath, err)
+		}
+
+		offer := craftFilePacket(malicious.name, malicious.relPath)
+		offer.Header = HeaderTransferOffer
+		offer.Body = append([]byte(FILECODE), offer.Body...)
+		_, _, err = DecodeTransferPacket(offer)
+		if !errors.Is(err, fsys.ErrorUnsafePath) {
+			t.Fatalf("expected TRANSFEROFFER with name \"%s\" and path \"%s\" to be rejected; got %v", malicious.name, malicious.relPath, err)
+		}
+	}
+
+	for _, dirname := range []string{"..", "../Downloads", "/home/user"} {
+		body := new(bytes.Buffer)
+		body.Write([]byte(DIRCODE))
+		writeProtocolString(body, dirname)
+		binary.Write(body, binary.BigEndian, uint64(0))
+
+		_, _, err := DecodeTransferPacket(&Packet{
+			Header: HeaderTransferOffer,
+			Body:   body.Bytes(),
+		})
+		if !errors.Is(err, fsys.ErrorUnsafePath) {
+			t.Fatalf("expected directory \"%s\" to be rejected; got %v", dirname, err)
+		}
+	}
+
+	maliciousSymlinks := []struct {
+		path   string
+		target string
+	}{
+		{"../link", "file"},
+		{"/tmp/link", "file"},
+		{"sub/../../link", "file"},
+	}
+
+	for _, malicious := range maliciousSymlinks {
+		body := new(bytes.Buffer)
+		writeProtocolString(body, malicious.path)
+		writeProtocolString(body, malicious.target)
+
+		_, err := DecodeSymlinkPacket(&Packet{
+			Header: HeaderSymlink,
+			Body:   body.Bytes(),
+		})
+		if !errors.Is(err, fsys.ErrorUnsafePath) {
+			t.Fatalf("expected symlink \"%s\" -> \"%s\" to be rejected; got %v", malicious.path, malicious.target, err)
+		}
+	}
+
+	// targets outside of the transfer are left to the receiver to deal with
+	for _, target := range []string{"../../.ssh/authorized_keys", "/etc/passwd"} {
+		body := new(bytes.Buffer)
+		writeProtocolString(body, "link")
+		writeProtocolString(body, target)
+
+		symlink, err := DecodeSymlinkPacket(&Packet{
+			Header: HeaderSymlink,
+			Body:   body.Bytes(),
+		})
+		if err != nil || symlink.TargetPath != target {
+			t.Fatalf("expected symlink to \"%s\" to be decoded; got %v", target, err)
+		}
+	}
+
+	// lengths that exceed the packet itself
+	body := new(bytes.Buffer)
+	binary.Write(body, binary.BigEndian, uint64(0))
+	binary.Write(body, binary.BigEndian, uint64(1<<62))
+	_, err := DecodeFilePacket(&Packet{
+		Header: HeaderFile,
+		Body:   body.Bytes(),
+	})
+	if !errors.Is(err, ErrorInvalidPacket) {
+		t.Fatalf("expected oversized filename length to be rejected; got %v", err)
+	}
+}
+
+func Test_DecodeValidManifest(t *testing.T) {
+	file, err := DecodeFilePacket(craftFilePacket("file.txt", "dir/inner/file.txt"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if file.Name != "file.txt" || file.RelativeParentPath != "dir/inner/file.txt" {
+		t.Fatalf("decoded file does not match: %+v", file)
+	}
+}
+
+func Test_FilePacketModTime(t *testing.T) {
+	file, err := fsys.GetFile("../testfiles/testfile.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	filePacket, err := CreateFilePacket(file)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	decodedFile, err := DecodeFilePacket(filePacket)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if !decodedFile.ModTime.Equal(file.ModTime) {
+		t.Fatalf("modification times do not match: expected %s; got %s", file.ModTime, decodedFile.ModTime)
+	}
+
+	// packets from older senders do not carry modification time
+	oldFile, err := DecodeFilePacket(craftFilePacket("file.txt", ""))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !oldFile.ModTime.IsZero() {
+		t.Fatalf("expected unknown modification time; got %s", oldFile.ModTime)
+	}
+}
+
+func Test_FilePacketMode(t *testing.T) {
+	file, err := fsys.GetFile("../testfiles/testfile.txt")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	file.Mode = 0750
+
+	filePacket, err := CreateFilePacket(file)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	decodedFile, err := DecodeFilePacket(filePacket)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if decodedFile.Mode != 0750 {
+		t.Fatalf("expected mode %o; got %o", 0750, decodedFile.Mode)
+	}
+
+	// neither do packets from older senders carry mode
+	oldFile, err := DecodeFilePacket(craftFilePacket("file.txt", ""))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if oldFile.Mode != 0 {
+		t.Fatalf("expected unknown mode; got %o", oldFile.Mode)
+	}
+}
+
+func Test_EmptyDirectoryPacket(t *testing.T) {
+	for _, location := range []string{"empty", "dir/inner/empty"} {
+		body := new(bytes.Buffer)
+		writeProtocolString(body, location)
+
+		decoded, err := DecodeEmptyDirectoryPacket(&Packet{
+			Header: HeaderEmptyDirectory,
+			Body:   body.Bytes(),
+		})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if decoded != location {
+			t.Fatalf("expected \"%s\"; got \"%s\"", location, decoded)
+		}
+	}
+
+	for _, location := range []string{"", "../outside", "/etc", "dir/../../outside"} {
+		body := new(bytes.Buffer)
+		writeProtocolString(body, location)
+
+		_, err := DecodeEmptyDirectoryPacket(&Packet{
+			Header: HeaderEmptyDirectory,
+			Body:   body.Bytes(),
+		})
+		if err == nil {
+			t.Fatalf("expected \"%s\" to be refused", location)
+		}
+	}
+}
+
+func Test_BundlePacket(t *testing.T) {
+	bundle, err := fsys.GetBundle([]string{"../testfiles/testfile.txt", "../testfiles/testdir"}, fsys.WalkOptions{Recursive: true})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	bundlePacket, err := CreateBundlePacket(bundle)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	_, decoded, err := DecodeTransferPacket(&Packet{
+		Header: HeaderTransferOffer,
+		Body:   append([]byte(BUNDLECODE), bundlePacket.Body...),
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if !decoded.Bundle || decoded.Size != bundle.Size {
+		t.Fatalf("expected a bundle of %d bytes; got %+v", bundle.Size, decoded)
+	}
+	if len(decoded.Files) != 1 || decoded.Files[0].Name != "testfile.txt" || len(decoded.Directories) != 1 || decoded.Directories[0].Name != "testdir" {
+		t.Fatalf("decoded entries do not match: %v", decoded.EntryNames())
+	}
+
+	// names are validated and must not repeat
+	for _, names := range [][]string{{"../file"}, {"file", "file"}} {
+		body := new(bytes.Buffer)
+		binary.Write(body, binary.BigEndian, uint64(0))
+		binary.Write(body, binary.BigEndian, uint64(len(names)))
+		for _, name := range names {
+			body.Write([]byte(FILECODE))
+			writeProtocolString(body, name)
+			binary.Write(body, binary.BigEndian, uint64(0))
+		}
+
+		_, err := DecodeBundlePacket(&Packet{
+			Header: HeaderBundle,
+			Body:   body.Bytes(),
+		})
+		if err == nil {
+			t.Fatalf("expected bundle with entries %v to be rejected", names)
+		}
+	}
+}
+
+func Test_TextPacket(t *testing.T) {
+	text := "https://example.com/?token=abc\n\tsecond line ✓"
+	textPacket, err := CreateTextPacket(text)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	decoded, err := DecodeTextPacket(textPacket)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if decoded != text {
+		t.Fatalf("expected %q; got %q", text, decoded)
+	}
+
+	// nothing can mess with the terminal
+	decoded, err = DecodeTextPacket(&Packet{
+		Header: HeaderText,
+		Body:   []byte("red\x1b[31m\rbell\a \xff"),
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if decoded != "red[31mbell �" {
+		t.Fatalf("control characters have not been removed: %q", decoded)
+	}
+
+	_, err = CreateTextPacket(strings.Repeat("a", int(MAXTEXTSIZE)+1))
+	if !errors.Is(err, ErrorTextTooLong) {
+		t.Fatalf("expected a too long text to be refused; got %v", err)
+	}
+}
+
+func Test_StreamPackets(t *testing.T) {
+	contents := bytes.Repeat([]byte("stream"), 50000)
+	stream := fsys.GetStream("stdin", bytes.NewReader(contents))
+
+	filePacket, err := CreateFilePacket(stream)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	decoded, _, err := DecodeTransferPacket(&Packet{
+		Header: HeaderTransferOffer,
+		Body:   append([]byte(STREAMCODE), filePacket.Body...),
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if decoded == nil || !decoded.Streamed || decoded.Name != "stdin" {
+		t.Fatalf("expected a stream named \"stdin\"; got %+v", decoded)
+	}
+
+	// the stream is read piece by piece until it ends
+	sender, receiver := net.Pipe()
+	defer sender.Close()
+	defer receiver.Close()
+
+	received := make(chan []byte)
+	go func() {
+		var data []byte
+		for {
+			packetBytes, err := ReadFromConn(receiver)
+			if err != nil {
+				break
+			}
+			packet, err := BytesToPacket(packetBytes)
+			if err != nil {
+				break
+			}
+			data = append(data, packet.Body[8:]...)
+		}
+		received <- data
+	}()
+
+	for {
+		_, err = SendPiece(stream, sender, nil)
+		if err == ErrorSentAll {
+			break
+		}
+		if err != nil {
+			t.Fatalf("SendPiece error: %s", err)
+		}
+	}
+	sender.Close()
+
+	if data := <-received; !bytes.Equal(data, contents) {
+		t.Fatalf("received %d bytes of the stream; expected %d", len(data), len(contents))
+	}
+	if stream.Size != uint64(len(contents)) {
+		t.Fatalf("expected the size of the stream to be %d; got %d", len(contents), stream.Size)
+	}
+
+	// its size and checksum come at the end
+	stream.Checksum = "checksum"
+	endfilePacket, err := CreateEndfilePacket(stream)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	ending, err := DecodeEndfilePacket(endfilePacket)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !ending.Streamed || ending.Size != stream.Size || ending.Checksum != "checksum" {
+		t.Fatalf("expected the stream to end with its size and checksum; got %+v", ending)
+	}
+
+	// ordinary files end with just the ID
+	ending, err = DecodeEndfilePacket(&Packet{
+		Header: HeaderEndfile,
+		Body:   []byte{0, 0, 0, 0, 0, 0, 0, 5},
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if ending.Streamed || ending.ID != 5 {
+		t.Fatalf("expected an ordinary file with ID 5; got %+v", ending)
+	}
+}
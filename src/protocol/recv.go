@@ -52,10 +52,15 @@ func ReadFromConn(connection net.Conn) ([]byte, error) {
 			buff = make([]byte, left)
 		}
 
-		read, _ := connection.Read(buff)
+		read, err := connection.Read(buff)
 		left -= uint64(read)
 
 		packetBuffer.Write(buff[:read])
+
+		if err != nil && left != 0 {
+			// the connection has been closed in the middle of a packet
+			return nil, err
+		}
 	}
 
 	// fmt.Printf("[RECV] read from connection: %s; length: %d\n", packetBuffer.Bytes()[:30], packetBuffer.Len())
@@ -94,7 +94,12 @@ func SendTransferOffer(connection net.Conn, file *fsys.File, dir *fsys.Directory
 			return err
 		}
 
-		transferOfferBody := append([]byte(FILECODE), filePacket.Body...)
+		code := FILECODE
+		if file.Streamed {
+			code = STREAMCODE
+		}
+
+		transferOfferBody := append([]byte(code), filePacket.Body...)
 
 		// if encrKey is present - encrypt
 		if encrKey != nil {
@@ -107,6 +112,24 @@ func SendTransferOffer(connection net.Conn, file *fsys.File, dir *fsys.Directory
 
 		transferOfferPacket.Body = transferOfferBody
 
+	} else if dir != nil && dir.Bundle {
+		bundlePacket, err := CreateBundlePacket(dir)
+		if err != nil {
+			return err
+		}
+
+		transferOfferBody := append([]byte(BUNDLECODE), bundlePacket.Body...)
+		// if encrKey is present - encrypt
+		if encrKey != nil {
+			encryptedBody, err := encryption.Encrypt(encrKey, transferOfferBody)
+			if err != nil {
+				return err
+			}
+			transferOfferBody = encryptedBody
+		}
+
+		transferOfferPacket.Body = transferOfferBody
+
 	} else if dir != nil {
 		dirPacket, err := CreateDirectoryPacket(dir)
 		if err != nil {
@@ -141,6 +164,13 @@ var ErrorSentAll error = fmt.Errorf("sent the whole file")
 // another piece util the file has been fully sent. If encrKey is not nil - encrypts each packet with
 // this key. Returns amount of filebytes written to the connection
 func SendPiece(file *fsys.File, connection net.Conn, encrKey []byte) (uint64, error) {
+	if file.Streamed {
+		return sendStreamPiece(file, connection, encrKey)
+	}
+	if file.FS != nil {
+		return sendFSPiece(file, connection, encrKey)
+	}
+
 	var sentBytes uint64 = 0
 
 	err := file.Open()
@@ -210,6 +240,97 @@ func SendPiece(file *fsys.File, connection net.Conn, encrKey []byte) (uint64, er
 	return sentBytes, nil
 }
 
+// Sends a piece of the streamed file, reading it from file.Stream. When the stream has ended,
+// sets the size of the file and returns ErrorSentAll
+func sendStreamPiece(file *fsys.File, connection net.Conn, encrKey []byte) (uint64, error) {
+	sentBytes, err := sendPieceFrom(file.Stream, file, connection, encrKey)
+	if err == io.EOF {
+		file.Size = file.SentBytes
+		return 0, ErrorSentAll
+	}
+
+	return sentBytes, err
+}
+
+// Sends a piece of the file in its FS. Not every filesystem can read the file from the middle
+// (ie: a compressed archive), so the file is read from beginning to end and kept open until all of it has been sent
+func sendFSPiece(file *fsys.File, connection net.Conn, encrKey []byte) (uint64, error) {
+	if file.Size == file.SentBytes {
+		file.Close()
+		return 0, ErrorSentAll
+	}
+
+	if file.Stream == nil {
+		err := file.OpenFS()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	sentBytes, err := sendPieceFrom(io.LimitReader(file.Stream, int64(file.Size-file.SentBytes)), file, connection, encrKey)
+	if err == io.EOF {
+		// the file has turned out to be smaller than it should be
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		file.Close()
+	}
+
+	return sentBytes, err
+}
+
+// Sends as much of what is read from the reader as fits into one packet. Returns io.EOF if nothing is left
+func sendPieceFrom(reader io.Reader, file *fsys.File, connection net.Conn, encrKey []byte) (uint64, error) {
+	fileBytesPacket := Packet{
+		Header: HeaderFileBytes,
+	}
+
+	packetBodyBuff := new(bytes.Buffer)
+
+	// write file ID first
+	err := binary.Write(packetBodyBuff, binary.BigEndian, file.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	// fill the remaining space of packet with what comes from the reader
+	canSendBytes := uint64(MAXPACKETSIZE) - fileBytesPacket.Size() - uint64(packetBodyBuff.Len())
+
+	if encrKey != nil {
+		// account for padding
+		canSendBytes -= 48
+	}
+
+	fileBytes := make([]byte, canSendBytes)
+
+	read, err := io.ReadFull(reader, fileBytes)
+	if err == io.EOF {
+		return 0, io.EOF
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	file.SentBytes += uint64(read)
+
+	packetBodyBuff.Write(fileBytes[:read])
+
+	fileBytesPacket.Body = packetBodyBuff.Bytes()
+
+	if encrKey != nil {
+		err = fileBytesPacket.EncryptBody(encrKey)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = SendPacket(connection, fileBytesPacket)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(read), nil
+}
+
 // Sends a symlink to the other side. If encrKey is not nil - encrypts the packet with this key
 func SendSymlink(symlink *fsys.Symlink, connection net.Conn, encrKey []byte) error {
 	symlinkPacket := Packet{
@@ -242,3 +363,33 @@ func SendSymlink(symlink *fsys.Symlink, connection net.Conn, encrKey []byte) err
 
 	return nil
 }
+
+// Sends the location of an empty directory to the other side. If encrKey is not nil - encrypts the packet with this key
+func SendEmptyDirectory(location string, connection net.Conn, encrKey []byte) error {
+	emptyDirPacket := Packet{
+		Header: HeaderEmptyDirectory,
+	}
+
+	emptyDirPacketBodyBuff := new(bytes.Buffer)
+
+	// EMPTYDIR~(string size in binary)(location in the filesystem)
+
+	binary.Write(emptyDirPacketBodyBuff, binary.BigEndian, uint64(len(location)))
+	emptyDirPacketBodyBuff.Write([]byte(location))
+
+	emptyDirPacket.Body = emptyDirPacketBodyBuff.Bytes()
+
+	if encrKey != nil {
+		err := emptyDirPacket.EncryptBody(encrKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := SendPacket(connection, emptyDirPacket)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"unbewohnte/ftu/transport"
+)
+
+// Remote shell used if neither -e nor $FTU_RSH is set
+const defaultRemoteShell string = "ssh"
+
+// Flags of the local node that are passed to the remote one
+var (
+	remoteReceiverFlags []string = []string{"on-conflict", "on-dir-conflict", "resume", "clean-partial", "backup", "backup-keep", "ignore-free-space"}
+	remoteSenderFlags   []string = []string{"r", "L", "depth"}
+)
+
+// Splits "[user@]host:path" into "[user@]host" and path. Returns false if arg is a local path
+func parseRemotePath(arg string) (string, string, bool) {
+	if filepath.VolumeName(arg) != "" {
+		return "", "", false
+	}
+
+	colon := strings.Index(arg, ":")
+	if colon <= 0 || strings.Contains(arg[:colon], "/") {
+		return "", "", false
+	}
+
+	path := arg[colon+1:]
+	if path == "" {
+		// home directory of the remote user
+		path = "."
+	}
+
+	return arg[:colon], path, true
+}
+
+// Quotes arg for a POSIX shell on the other side
+func shellQuote(arg string) string {
+	safe := arg != ""
+	for _, char := range arg {
+		if !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+./,:@%", char) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// Returns the flags out of names that have been set explicitly as "-name=value"
+func forwardedFlags(names []string) []string {
+	var forwarded []string
+	flag.Visit(func(set *flag.Flag) {
+		for _, name := range names {
+			if set.Name == name {
+				forwarded = append(forwarded, fmt.Sprintf("-%s=%s", name, set.Value))
+			}
+		}
+	})
+
+	return forwarded
+}
+
+// Returns the command line of the remote shell
+func remoteShell() []string {
+	shell := *REMOTE_SHELL
+	if shell == "" {
+		shell = os.Getenv("FTU_RSH")
+	}
+	if shell == "" {
+		shell = defaultRemoteShell
+	}
+
+	return strings.Fields(shell)
+}
+
+// Runs ftu with args on host through the remote shell and returns the connection
+// to it over the stdin and stdout of the shell
+func spawnRemote(ctx context.Context, host string, args []string) (net.Conn, error) {
+	shell := remoteShell()
+	if len(shell) == 0 {
+		return nil, fmt.Errorf("no remote shell")
+	}
+
+	// the remote shell joins its arguments into a command line. A host starting with "-" is not taken for an option
+	commandLine := []string{"--", host, shellQuote(*REMOTE_FTU)}
+	for _, arg := range args {
+		commandLine = append(commandLine, shellQuote(arg))
+	}
+
+	command := exec.CommandContext(ctx, shell[0], append(shell[1:], commandLine...)...)
+	command.Stderr = os.Stderr
+
+	conn, err := transport.Command(command, host)
+	if err != nil {
+		return nil, fmt.Errorf("could not run the remote shell \"%s\": %w", shell[0], err)
+	}
+
+	return conn, nil
+}
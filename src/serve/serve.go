@@ -0,0 +1,193 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// A persistent share server that sends the same file or directory to many receivers,
+// each in its own session
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/progress"
+	"unbewohnte/ftu/transfer"
+)
+
+type Options struct {
+	Source     string               // file or directory to share
+	MaxClients uint                 // how many receivers are served at once. Others wait for their turn. 0 means no limit
+	Times      uint                 // stop after the source has been received successfully this many times. 0 means no limit
+	Expire     time.Time            // stop accepting new receivers after that moment. Zero means never
+	Log        io.Writer            // where what happens with sessions is written. Discarded if nil
+	Send       transfer.SendOptions // how to send. Output and Observer are set by the server
+}
+
+// Sends the source to every receiver that connects until stopped
+type Server struct {
+	options Options
+	log     *progress.Log
+}
+
+func New(options Options) *Server {
+	return &Server{
+		options: options,
+		log:     progress.NewLog(options.Log),
+	}
+}
+
+// Sends the source over the connection. Returns whether it has been received successfully
+func (server *Server) session(ctx context.Context, id uint64, conn net.Conn) bool {
+	prefix := fmt.Sprintf("[#%d %s]", id, conn.RemoteAddr())
+	server.log.Printf("%s Connected", prefix)
+
+	options := server.options.Send
+	options.Output = nil
+	options.Observer = server.log.Observer(prefix)
+
+	result, err := transfer.SendConn(ctx, conn, server.options.Source, options)
+	if result == nil {
+		server.log.Printf("%s Could not send: %s", prefix, err)
+		return false
+	}
+
+	return result.Status == node.StatusSuccess
+}
+
+// Accepts receivers from the listener and runs a session for each of them until the
+// context is done, the source has been received Times times or the server has expired.
+// Canceling the context cancels sessions in progress; otherwise they are waited for
+func (server *Server) Serve(ctx context.Context, listener net.Listener) error {
+	// stops accepting, but does not touch sessions
+	acceptCtx, stopAccepting := context.WithCancel(ctx)
+	defer stopAccepting()
+	if !server.options.Expire.IsZero() {
+		var cancelExpiry context.CancelFunc
+		acceptCtx, cancelExpiry = context.WithDeadline(acceptCtx, server.options.Expire)
+		defer cancelExpiry()
+	}
+
+	go func() {
+		<-acceptCtx.Done()
+		listener.Close()
+	}()
+
+	var sessions sync.WaitGroup
+	defer sessions.Wait()
+
+	// a slot is taken by every session in progress
+	var slots chan struct{}
+	if server.options.MaxClients != 0 {
+		slots = make(chan struct{}, server.options.MaxClients)
+	}
+
+	// a ticket is spent by every successful session and returned by a failed one
+	var tickets chan struct{}
+	var served uint
+	var servedLock sync.Mutex
+	if server.options.Times != 0 {
+		tickets = make(chan struct{}, server.options.Times)
+		for i := uint(0); i < server.options.Times; i++ {
+			tickets <- struct{}{}
+		}
+	}
+
+	server.log.Printf("Sharing \"%s\" on %s", server.options.Source, listener.Addr())
+
+	var lastID uint64
+	for {
+		if tickets != nil {
+			select {
+			case <-tickets:
+			case <-acceptCtx.Done():
+				return server.stopped(ctx, acceptCtx)
+			}
+		}
+
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-acceptCtx.Done():
+				return server.stopped(ctx, acceptCtx)
+			}
+		}
+
+		conn, err := listener.Accept()
+		if err != nil {
+			if acceptCtx.Err() != nil {
+				return server.stopped(ctx, acceptCtx)
+			}
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if tickets != nil {
+					tickets <- struct{}{}
+				}
+				if slots != nil {
+					<-slots
+				}
+				continue
+			}
+			return err
+		}
+
+		lastID++
+		sessions.Add(1)
+		go func(id uint64) {
+			defer sessions.Done()
+
+			success := server.session(ctx, id, conn)
+
+			if slots != nil {
+				<-slots
+			}
+
+			if tickets == nil {
+				return
+			}
+			if !success {
+				tickets <- struct{}{}
+				return
+			}
+
+			servedLock.Lock()
+			served++
+			if served == server.options.Times {
+				server.log.Printf("Has been received %d times, stopping", served)
+				stopAccepting()
+			}
+			servedLock.Unlock()
+		}(lastID)
+	}
+}
+
+// Logs why the server has stopped accepting receivers
+func (server *Server) stopped(ctx context.Context, acceptCtx context.Context) error {
+	if ctx.Err() == nil && errors.Is(acceptCtx.Err(), context.DeadlineExceeded) {
+		server.log.Printf("Has expired, waiting for sessions in progress")
+	}
+
+	return nil
+}
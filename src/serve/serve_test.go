@@ -0,0 +1,124 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package serve
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/transfer"
+)
+
+// starts sharing a small file on a free port
+func startServer(t *testing.T, options Options) (string, chan error) {
+	source := filepath.Join(t.TempDir(), "shared.txt")
+	err := os.WriteFile(source, []byte("shared contents"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	options.Source = source
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- New(options).Serve(context.Background(), listener)
+	}()
+
+	return listener.Addr().String(), served
+}
+
+func receive(t *testing.T, address string, accept bool) error {
+	_, err := transfer.Receive(context.Background(), address, t.TempDir(), transfer.ReceiveOptions{
+		OfferDecider: node.OfferDeciderFunc(func(file *fsys.File, dir *fsys.Directory) bool {
+			return accept
+		}),
+	})
+
+	return err
+}
+
+func Test_ServeTimes(t *testing.T) {
+	address, served := startServer(t, Options{MaxClients: 2, Times: 3})
+
+	// a rejection does not count
+	err := receive(t, address, false)
+	if !errors.Is(err, transfer.ErrorRejected) {
+		t.Fatalf("expected the offer to be rejected; got %v", err)
+	}
+
+	var receivers sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		receivers.Add(1)
+		go func() {
+			defer receivers.Done()
+			err := receive(t, address, true)
+			if err != nil {
+				t.Errorf("receiving has failed: %s", err)
+			}
+		}()
+	}
+	receivers.Wait()
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("serving has failed: %s", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("the server has not stopped after being received 3 times")
+	}
+
+	// nobody listens anymore
+	err = receive(t, address, true)
+	if !errors.Is(err, transfer.ErrorConnection) {
+		t.Fatalf("expected the server to be gone; got %v", err)
+	}
+}
+
+func Test_ServeExpire(t *testing.T) {
+	address, served := startServer(t, Options{Expire: time.Now().Add(time.Millisecond * 300)})
+
+	err := receive(t, address, true)
+	if err != nil {
+		t.Fatalf("receiving has failed: %s", err)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("serving has failed: %s", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("the server has not expired")
+	}
+}
@@ -0,0 +1,261 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"unbewohnte/ftu/protocol"
+	"unbewohnte/ftu/transfer"
+)
+
+var ErrorSessionEnded error = fmt.Errorf("%w: the session has ended", transfer.ErrorConnection)
+
+type PeerOptions struct {
+	Destination string                                   // directory to receive files|directories of the other side into
+	Send        transfer.SendOptions                     // how to send. MoreSources and Reverse are ignored
+	Receive     transfer.ReceiveOptions                  // how to receive. OfferDecider decides on every offer of the other side, everything is accepted if nil
+	Received    func(result *transfer.Result, err error) // if != nil - called after every transfer from the other side
+	Text        func(text string)                        // if != nil - called with every text message of the other side as soon as it arrives
+}
+
+// One side of a peer session. Both sides can send files|directories to each other
+// at any time, while transfers themselves go one at a time
+type Peer struct {
+	session  *Session
+	options  PeerOptions
+	requests chan *pushRequest
+	incoming chan *protocol.Packet // packets of the session other than text messages
+	ended    chan struct{}
+}
+
+// What has been asked to send
+type pushRequest struct {
+	ctx     context.Context
+	sources []string
+	done    chan pushOutcome
+}
+
+type pushOutcome struct {
+	result *transfer.Result
+	err    error
+}
+
+func (request *pushRequest) finish(result *transfer.Result, err error) {
+	request.done <- pushOutcome{
+		result: result,
+		err:    err,
+	}
+}
+
+// Makes one side of a peer session out of the session. Nothing happens until Run is called
+func NewPeer(session *Session, options PeerOptions) *Peer {
+	return &Peer{
+		session:  session,
+		options:  options,
+		requests: make(chan *pushRequest),
+		incoming: make(chan *protocol.Packet, 100),
+		ended:    make(chan struct{}),
+	}
+}
+
+// Sends the files|directories to the other side in one transfer as soon as no other transfer
+// is in progress. Can be called from any goroutine while Run is running
+func (peer *Peer) Send(ctx context.Context, sources ...string) (*transfer.Result, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("nothing to send")
+	}
+
+	request := &pushRequest{
+		ctx:     ctx,
+		sources: sources,
+		done:    make(chan pushOutcome, 1),
+	}
+
+	select {
+	case peer.requests <- request:
+	case <-peer.ended:
+		return nil, ErrorSessionEnded
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case outcome := <-request.done:
+		return outcome.result, outcome.err
+	case <-ctx.Done():
+		// the transfer, if it starts at all, is canceled too
+		return nil, ctx.Err()
+	}
+}
+
+// Sends the text message to the other side right away, even in the middle of a transfer.
+// Can be called from any goroutine while Run is running
+func (peer *Peer) Say(text string) error {
+	textPacket, err := protocol.CreateTextPacket(text)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-peer.ended:
+		return ErrorSessionEnded
+	default:
+	}
+
+	err = peer.session.Send(textPacket)
+	if err != nil {
+		return ErrorSessionEnded
+	}
+
+	return nil
+}
+
+// Shows text messages of the other side as soon as they arrive and passes the rest of the packets on
+func (peer *Peer) dispatch() {
+	defer close(peer.incoming)
+
+	for packet := range peer.session.Packets() {
+		if packet.Header != protocol.HeaderText {
+			peer.incoming <- packet
+			continue
+		}
+
+		text, err := protocol.DecodeTextPacket(packet)
+		if err == nil && peer.options.Text != nil {
+			peer.options.Text(text)
+		}
+	}
+}
+
+// Takes part in the session until the other side ends it (nil is returned then) or the context is done,
+// sending what has been asked with Send and receiving what the other side sends. The session is closed in the end
+func (peer *Peer) Run(ctx context.Context) error {
+	defer close(peer.ended)
+	defer peer.session.Close()
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			peer.session.Close()
+		case <-finished:
+		}
+	}()
+
+	go peer.dispatch()
+
+	for {
+		select {
+		case packet, ok := <-peer.incoming:
+			if !ok {
+				return ctx.Err()
+			}
+			if packet.Header == protocol.HeaderPush {
+				peer.receive(ctx)
+			}
+
+		case request := <-peer.requests:
+			peer.push(ctx, request)
+		}
+	}
+}
+
+// Asks the other side to let this side send and sends when it does
+func (peer *Peer) push(ctx context.Context, request *pushRequest) {
+	// the push of the other side that has to wait until this one is done
+	deferred := false
+	defer func() {
+		if deferred {
+			peer.receive(ctx)
+		}
+	}()
+
+	peer.session.ExpectGranted()
+	err := peer.session.Send(&protocol.Packet{
+		Header: protocol.HeaderPush,
+	})
+	if err != nil {
+		request.finish(nil, ErrorSessionEnded)
+		return
+	}
+
+	for packet := range peer.incoming {
+		switch packet.Header {
+		case protocol.HeaderPush:
+			// both sides want to send at once. The one that has started the session goes first
+			if peer.session.Opened() {
+				deferred = true
+				continue
+			}
+			peer.receive(ctx)
+
+		case protocol.HeaderGranted:
+			conn := peer.session.Channel()
+			if conn == nil {
+				request.finish(nil, ErrorSessionEnded)
+				return
+			}
+
+			options := peer.options.Send
+			options.Reverse = false
+			options.MoreSources = request.sources[1:]
+			options.Text = ""
+
+			result, err := transfer.SendConn(request.ctx, conn, request.sources[0], options)
+			if result == nil {
+				// has not even started
+				conn.Close()
+			}
+			request.finish(result, err)
+			return
+		}
+	}
+
+	request.finish(nil, ErrorSessionEnded)
+}
+
+// Lets the other side send and receives what it offers
+func (peer *Peer) receive(ctx context.Context) {
+	// the transfer starts right after GRANTED
+	conn := peer.session.OpenChannel()
+	err := peer.session.Send(&protocol.Packet{
+		Header: protocol.HeaderGranted,
+	})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	options := peer.options.Receive
+	options.Reverse = false
+
+	result, err := transfer.ReceiveConn(ctx, conn, peer.options.Destination, options)
+	if result == nil {
+		conn.Close()
+	}
+	if peer.options.Received != nil {
+		peer.options.Received(result, err)
+	}
+}
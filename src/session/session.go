@@ -0,0 +1,433 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+// Sessions: one long-lived encrypted connection that carries requests of its own
+// and, one at a time, ordinary transfers going through channels inside of it
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"unbewohnte/ftu/encryption"
+	"unbewohnte/ftu/protocol"
+	"unbewohnte/ftu/transport"
+)
+
+// Kinds of sessions
+const (
+	KindBrowse string = "browse"
+	KindPeer   string = "peer"
+)
+
+var ErrorNotSession error = errors.New("the other side has not started a session of this kind")
+
+// Headers of packets that belong to the session itself. Everything else belongs to the transfer in progress
+var sessionHeaders = map[protocol.Header]bool{
+	protocol.HeaderSession: true,
+	protocol.HeaderGranted: true,
+	protocol.HeaderDenied:  true,
+	protocol.HeaderList:    true,
+	protocol.HeaderListing: true,
+	protocol.HeaderGet:     true,
+	protocol.HeaderPush:    true,
+	protocol.HeaderText:    true,
+}
+
+// The connection of a session. Requests and answers go through it directly, while
+// each transfer goes through a channel: a connection of its own carried over
+// the session connection until the transfer is over
+type Session struct {
+	conn       net.Conn
+	key        []byte
+	opened     bool                  // this side has started the session
+	packets    chan *protocol.Packet // decrypted packets of the session itself
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	channel    *channel // the transfer in progress or the one the other side has not finished yet. nil if there is none
+	ended      chan struct{}
+	grantOpens bool // the next GRANTED starts the transfer, so a channel must be opened for it
+}
+
+func newSession(conn net.Conn, key []byte, opened bool) *Session {
+	session := &Session{
+		conn:    conn,
+		key:     key,
+		opened:  opened,
+		packets: make(chan *protocol.Packet, 100),
+		ended:   make(chan struct{}),
+	}
+	go session.run()
+
+	return session
+}
+
+// Starts a session of the kind over the connection by sending the encryption key and
+// telling the other side what kind of session it is
+func Open(conn net.Conn, kind string) (*Session, error) {
+	key := encryption.Generate32AESkey()
+	err := protocol.SendEncryptionKey(conn, key)
+	if err != nil {
+		return nil, err
+	}
+
+	session := newSession(conn, key, true)
+	err = session.Send(&protocol.Packet{
+		Header: protocol.HeaderSession,
+		Body:   []byte(kind),
+	})
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Joins the session of the kind the other side has started over the connection.
+// Returns ErrorNotSession and closes the connection if the other side has started something else
+func Join(conn net.Conn, kind string) (*Session, error) {
+	packetBytes, err := protocol.ReadFromConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	packet, err := protocol.BytesToPacket(packetBytes)
+	if err != nil || packet.Header != protocol.HeaderEncryptionKey {
+		conn.Close()
+		return nil, ErrorNotSession
+	}
+
+	// ENCRKEY~(size)(encryption key)
+	packetReader := bytes.NewReader(packet.Body)
+	var keySize uint64
+	binary.Read(packetReader, binary.BigEndian, &keySize)
+	if keySize > uint64(packetReader.Len()) {
+		conn.Close()
+		return nil, protocol.ErrorInvalidPacket
+	}
+	key := make([]byte, keySize)
+	packetReader.Read(key)
+
+	session := newSession(conn, key, false)
+
+	packet, ok := <-session.packets
+	if !ok {
+		session.Close()
+		return nil, fmt.Errorf("%w: the connection has been closed", ErrorNotSession)
+	}
+	if packet.Header != protocol.HeaderSession || string(packet.Body) != kind {
+		session.Close()
+		return nil, ErrorNotSession
+	}
+
+	return session, nil
+}
+
+// Tells whether this side has started the session
+func (session *Session) Opened() bool {
+	return session.opened
+}
+
+// Address of the other side
+func (session *Session) RemoteAddr() net.Addr {
+	return session.conn.RemoteAddr()
+}
+
+// Decrypted packets of the session itself. Closed when the session ends
+func (session *Session) Packets() <-chan *protocol.Packet {
+	return session.packets
+}
+
+// Ends the session, along with the transfer in progress
+func (session *Session) Close() error {
+	return session.conn.Close()
+}
+
+// Encrypts and sends a packet of the session
+func (session *Session) Send(packet *protocol.Packet) error {
+	err := packet.EncryptBody(session.key)
+	if err != nil {
+		return err
+	}
+
+	session.writeMutex.Lock()
+	defer session.writeMutex.Unlock()
+
+	return protocol.SendPacket(session.conn, *packet)
+}
+
+// Writes raw bytes of whole packets of the transfer in progress
+func (session *Session) write(data []byte) (int, error) {
+	session.writeMutex.Lock()
+	defer session.writeMutex.Unlock()
+
+	return session.conn.Write(data)
+}
+
+// Opens a channel for the transfer that is about to start and returns its connection.
+// Must be called before the other side can send anything of the transfer. Waits until both
+// sides are done with the previous transfer, so nothing left from it ends up in the new one
+func (session *Session) OpenChannel() net.Conn {
+	previous := session.currentChannel()
+	if previous != nil {
+		select {
+		case <-previous.finished:
+		case <-session.ended:
+		}
+	}
+
+	return session.openChannel()
+}
+
+func (session *Session) openChannel() net.Conn {
+	reader, writer := io.Pipe()
+	opened := &channel{
+		session:     session,
+		reader:      reader,
+		writer:      writer,
+		closed:      make(chan struct{}),
+		byeReceived: make(chan struct{}),
+		finished:    make(chan struct{}),
+	}
+	opened.conn = transport.NewStreamConn(opened, session.conn.RemoteAddr().String())
+
+	session.mutex.Lock()
+	session.channel = opened
+	session.mutex.Unlock()
+
+	return opened.conn
+}
+
+// Makes the next GRANTED open a channel before anything of the transfer that follows it arrives.
+// Must be called before sending the request that is answered with GRANTED
+func (session *Session) ExpectGranted() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	session.grantOpens = true
+}
+
+// Returns the connection of the transfer in progress. nil if there is none
+func (session *Session) Channel() net.Conn {
+	current := session.currentChannel()
+	if current == nil {
+		return nil
+	}
+
+	select {
+	case <-current.closed:
+		return nil
+	default:
+		return current.conn
+	}
+}
+
+func (session *Session) currentChannel() *channel {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return session.channel
+}
+
+// Reads packets from the connection until it is closed, passing those of the transfer in progress
+// to its channel and the rest, decrypted, to the packets
+func (session *Session) run() {
+	defer close(session.packets)
+	defer close(session.ended)
+
+	for {
+		packetBytes, err := protocol.ReadFromConn(session.conn)
+		if err != nil {
+			break
+		}
+
+		packet, err := protocol.BytesToPacket(packetBytes)
+		if err != nil {
+			break
+		}
+
+		if !sessionHeaders[packet.Header] {
+			current := session.currentChannel()
+			if current == nil {
+				// left from the finished transfer
+				continue
+			}
+
+			current.deliver(packet)
+			if packet.Header == protocol.HeaderDisconnecting {
+				// the other side is done with the transfer
+				current.byeOnce.Do(func() {
+					close(current.byeReceived)
+				})
+				current.finish()
+			}
+			continue
+		}
+
+		if packet.Header == protocol.HeaderGranted {
+			session.mutex.Lock()
+			grantOpens := session.grantOpens
+			session.grantOpens = false
+			previous := session.channel
+			session.mutex.Unlock()
+
+			if grantOpens {
+				if previous != nil {
+					// the other side has finished the previous transfer already, this side is about to
+					<-previous.closed
+				}
+				session.openChannel()
+			}
+		}
+
+		err = packet.DecryptBody(session.key)
+		if err != nil {
+			break
+		}
+
+		session.packets <- packet
+	}
+
+	session.conn.Close()
+	if current := session.currentChannel(); current != nil {
+		current.writer.CloseWithError(io.EOF)
+	}
+}
+
+// A connection of one transfer inside of the session
+type channel struct {
+	session     *Session
+	conn        net.Conn
+	reader      *io.PipeReader // what the other side has sent
+	writer      *io.PipeWriter
+	mutex       sync.Mutex
+	pending     []byte // the beginning of the packet that has not been written whole yet
+	saidBye     bool   // the transfer has told the other side that it is over
+	closeOnce   sync.Once
+	closed      chan struct{} // closed when this side is done with the transfer
+	byeOnce     sync.Once
+	byeReceived chan struct{} // closed when the other side is done with the transfer
+	finishOnce  sync.Once
+	finished    chan struct{} // closed when both sides are done with the transfer
+}
+
+// Forgets the channel once both sides are done with it
+func (channel *channel) finish() {
+	select {
+	case <-channel.closed:
+	default:
+		return
+	}
+	select {
+	case <-channel.byeReceived:
+	default:
+		return
+	}
+
+	channel.finishOnce.Do(func() {
+		channel.session.mutex.Lock()
+		if channel.session.channel == channel {
+			channel.session.channel = nil
+		}
+		channel.session.mutex.Unlock()
+
+		close(channel.finished)
+	})
+}
+
+// Passes the packet of the transfer to whoever reads the channel. Returns false if the channel has been closed
+func (channel *channel) deliver(packet *protocol.Packet) bool {
+	packetBytes, err := packet.ToBytes()
+	if err != nil {
+		return false
+	}
+
+	_, err = channel.writer.Write(packetBytes)
+	return err == nil
+}
+
+func (channel *channel) Read(p []byte) (int, error) {
+	return channel.reader.Read(p)
+}
+
+// Writes only whole packets into the session connection, so that packets of the session
+// sent meanwhile never end up in the middle of one
+func (channel *channel) Write(p []byte) (int, error) {
+	select {
+	case <-channel.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+
+	channel.pending = append(channel.pending, p...)
+	for len(channel.pending) >= 8 {
+		packetSize := binary.BigEndian.Uint64(channel.pending[:8])
+		if packetSize > uint64(protocol.MAXPACKETSIZE) {
+			return 0, protocol.ErrorExceededMaxPacketsize
+		}
+		if uint64(len(channel.pending)-8) < packetSize {
+			break
+		}
+
+		packet := channel.pending[:8+packetSize]
+		if bytes.HasPrefix(packet[8:], []byte(string(protocol.HeaderDisconnecting)+protocol.HEADERDELIMETER)) {
+			channel.saidBye = true
+		}
+
+		_, err := channel.session.write(packet)
+		if err != nil {
+			return 0, err
+		}
+		channel.pending = channel.pending[8+packetSize:]
+	}
+
+	return len(p), nil
+}
+
+// Ends the transfer. The session connection stays open. If the transfer has not said
+// goodbye to the other side (ie: it has not even started) - the channel does it instead
+func (channel *channel) Close() error {
+	channel.closeOnce.Do(func() {
+		channel.mutex.Lock()
+		if !channel.saidBye {
+			channel.saidBye = true
+			byePacket, err := (&protocol.Packet{Header: protocol.HeaderDisconnecting}).ToBytes()
+			if err == nil {
+				channel.session.write(byePacket)
+			}
+		}
+		channel.mutex.Unlock()
+
+		channel.reader.Close()
+		close(channel.closed)
+		channel.finish()
+	})
+
+	return nil
+}
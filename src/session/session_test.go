@@ -0,0 +1,291 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/protocol"
+	"unbewohnte/ftu/transfer"
+)
+
+// connects two sides with a session of the kind. The first one has started it
+func connect(t *testing.T, openKind string, joinKind string) (*Session, *Session, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer listener.Close()
+
+	opened := make(chan *Session, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			opened <- nil
+			return
+		}
+		session, err := Open(conn, openKind)
+		if err != nil {
+			conn.Close()
+		}
+		opened <- session
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	joined, err := Join(conn, joinKind)
+
+	opener := <-opened
+	if opener == nil {
+		t.Fatalf("could not open a session")
+	}
+	t.Cleanup(func() {
+		opener.Close()
+	})
+
+	return opener, joined, err
+}
+
+// starts both sides of a peer session, each sending recursively and receiving into its own directory
+func startPeers(t *testing.T, optionsA PeerOptions, optionsB PeerOptions) (*Peer, *Peer, string, string) {
+	openerSession, joinerSession, err := connect(t, KindPeer, KindPeer)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	dirA, dirB := t.TempDir(), t.TempDir()
+	optionsA.Destination, optionsB.Destination = dirA, dirB
+	optionsA.Send.Recursive, optionsB.Send.Recursive = true, true
+	peerA := NewPeer(openerSession, optionsA)
+	peerB := NewPeer(joinerSession, optionsB)
+
+	ctx, stop := context.WithCancel(context.Background())
+	ran := make(chan error, 2)
+	go func() {
+		ran <- peerA.Run(ctx)
+	}()
+	go func() {
+		ran <- peerB.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		stop()
+		for i := 0; i < 2; i++ {
+			select {
+			case <-ran:
+			case <-time.After(time.Second * 5):
+				t.Errorf("the peer has not stopped")
+			}
+		}
+	})
+
+	return peerA, peerB, dirA, dirB
+}
+
+func writeFile(t *testing.T, path string, contents string) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.WriteFile(path, []byte(contents), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func expectFile(t *testing.T, path string, expected string) {
+	contents, err := os.ReadFile(path)
+	if err != nil || string(contents) != expected {
+		t.Fatalf("\"%s\" has not been received correctly: %q (%v)", path, contents, err)
+	}
+}
+
+func Test_Join(t *testing.T) {
+	_, _, err := connect(t, KindBrowse, KindPeer)
+	if !errors.Is(err, ErrorNotSession) {
+		t.Fatalf("expected joining a session of another kind to fail; got %v", err)
+	}
+
+	opener, joiner, err := connect(t, KindPeer, KindPeer)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer joiner.Close()
+	if !opener.Opened() || joiner.Opened() {
+		t.Fatalf("expected only the first side to have started the session")
+	}
+}
+
+func Test_PeerExchange(t *testing.T) {
+	peerA, peerB, dirA, dirB := startPeers(t, PeerOptions{}, PeerOptions{})
+
+	sources := t.TempDir()
+	writeFile(t, filepath.Join(sources, "a.txt"), "from a")
+	writeFile(t, filepath.Join(sources, "b", "inner", "b.txt"), "from b")
+
+	// back and forth in one session
+	result, err := peerA.Send(context.Background(), filepath.Join(sources, "a.txt"))
+	if err != nil || result.Status != node.StatusSuccess {
+		t.Fatalf("sending from the first side failed: %v", err)
+	}
+	expectFile(t, filepath.Join(dirB, "a.txt"), "from a")
+
+	result, err = peerB.Send(context.Background(), filepath.Join(sources, "b"))
+	if err != nil || result.Status != node.StatusSuccess {
+		t.Fatalf("sending from the second side failed: %v", err)
+	}
+	expectFile(t, filepath.Join(dirA, "b", "inner", "b.txt"), "from b")
+
+	// both at once
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("%d.txt", i)
+		writeFile(t, filepath.Join(sources, "A", name), "a"+name)
+		writeFile(t, filepath.Join(sources, "B", name), "b"+name)
+
+		sent := make(chan error, 2)
+		go func() {
+			_, err := peerA.Send(context.Background(), filepath.Join(sources, "A", name))
+			sent <- err
+		}()
+		go func() {
+			_, err := peerB.Send(context.Background(), filepath.Join(sources, "B", name))
+			sent <- err
+		}()
+		for j := 0; j < 2; j++ {
+			err := <-sent
+			if err != nil {
+				t.Fatalf("sending at the same time failed: %s", err)
+			}
+		}
+
+		expectFile(t, filepath.Join(dirB, name), "a"+name)
+		expectFile(t, filepath.Join(dirA, name), "b"+name)
+	}
+}
+
+func Test_PeerReject(t *testing.T) {
+	rejectAll := node.OfferDeciderFunc(func(file *fsys.File, dir *fsys.Directory) bool {
+		return false
+	})
+	peerA, peerB, dirA, dirB := startPeers(t, PeerOptions{}, PeerOptions{
+		Receive: transfer.ReceiveOptions{OfferDecider: rejectAll},
+	})
+
+	sources := t.TempDir()
+	writeFile(t, filepath.Join(sources, "a.txt"), "from a")
+	writeFile(t, filepath.Join(sources, "b.txt"), "from b")
+
+	_, err := peerA.Send(context.Background(), filepath.Join(sources, "a.txt"))
+	if !errors.Is(err, transfer.ErrorRejected) {
+		t.Fatalf("expected the offer to be rejected; got %v", err)
+	}
+	_, err = os.Stat(filepath.Join(dirB, "a.txt"))
+	if err == nil {
+		t.Fatalf("a rejected file has been received")
+	}
+
+	// the session goes on
+	_, err = peerB.Send(context.Background(), filepath.Join(sources, "b.txt"))
+	if err != nil {
+		t.Fatalf("sending after the rejection failed: %s", err)
+	}
+	expectFile(t, filepath.Join(dirA, "b.txt"), "from b")
+
+	_, err = peerA.Send(context.Background(), filepath.Join(sources, "nothing.txt"))
+	if err == nil {
+		t.Fatalf("expected sending a missing file to fail")
+	}
+	_, err = peerB.Send(context.Background(), filepath.Join(sources, "b.txt"))
+	if err != nil {
+		t.Fatalf("sending after a failed transfer failed: %s", err)
+	}
+}
+
+func Test_PeerText(t *testing.T) {
+	textsA, textsB := make(chan string, 10), make(chan string, 10)
+	peerA, peerB, _, dirB := startPeers(t, PeerOptions{
+		Text: func(text string) {
+			textsA <- text
+		},
+	}, PeerOptions{
+		Text: func(text string) {
+			textsB <- text
+		},
+	})
+
+	expectText := func(texts chan string, expected string) {
+		select {
+		case text := <-texts:
+			if text != expected {
+				t.Fatalf("expected %q; got %q", expected, text)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%q has not arrived", expected)
+		}
+	}
+
+	err := peerA.Say("https://example.com")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expectText(textsB, "https://example.com")
+
+	err = peerB.Say("thanks\x1b[2J")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	expectText(textsA, "thanks[2J")
+
+	// messages and transfers go together
+	sources := t.TempDir()
+	writeFile(t, filepath.Join(sources, "a.txt"), "from a")
+	sent := make(chan error, 1)
+	go func() {
+		_, err := peerA.Send(context.Background(), filepath.Join(sources, "a.txt"))
+		sent <- err
+	}()
+	for i := 0; i < 10; i++ {
+		peerB.Say(fmt.Sprint(i))
+	}
+	for i := 0; i < 10; i++ {
+		expectText(textsA, fmt.Sprint(i))
+	}
+	err = <-sent
+	if err != nil {
+		t.Fatalf("sending failed: %s", err)
+	}
+	expectFile(t, filepath.Join(dirB, "a.txt"), "from a")
+
+	err = peerA.Say(string(make([]byte, protocol.MAXTEXTSIZE+1)))
+	if !errors.Is(err, protocol.ErrorTextTooLong) {
+		t.Fatalf("expected a too long text to be refused; got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"unbewohnte/ftu/browse"
+	"unbewohnte/ftu/progress"
+	"unbewohnte/ftu/transfer"
+	"unbewohnte/ftu/transport"
+)
+
+// Splits "host:path" of the get command into the address of the sharing side and the path
+// inside of the shared directory. The address can be "[ipv6]" or "unix:/path/to/socket".
+// Returns false if there is no address
+func parseSharedPath(arg string) (string, string, bool) {
+	var address, rest string
+	switch {
+	case strings.HasPrefix(arg, transport.UnixPrefix):
+		separator := strings.Index(arg[len(transport.UnixPrefix):], ":")
+		if separator == -1 {
+			return "", "", false
+		}
+		address, rest = arg[:len(transport.UnixPrefix)+separator], arg[len(transport.UnixPrefix)+separator+1:]
+
+	case strings.HasPrefix(arg, "["):
+		end := strings.Index(arg, "]:")
+		if end == -1 {
+			return "", "", false
+		}
+		address, rest = arg[1:end], arg[end+2:]
+
+	default:
+		separator := strings.Index(arg, ":")
+		if separator == -1 {
+			return "", "", false
+		}
+		address, rest = arg[:separator], arg[separator+1:]
+	}
+
+	if address == "" || address == transport.UnixPrefix {
+		return "", "", false
+	}
+
+	return address, sharedPath("", rest), true
+}
+
+// Returns the path inside of the shared directory that arg leads to from the current directory.
+// Both are slash-separated; the result never goes above the shared root, which is ""
+func sharedPath(current string, arg string) string {
+	if !strings.HasPrefix(arg, "/") {
+		arg = path.Join("/", current, arg)
+	}
+
+	return strings.TrimPrefix(path.Clean("/"+arg), "/")
+}
+
+// Prints entries of the shared directory, indenting inner entries of the tree
+func printEntries(output io.Writer, entries []browse.Entry, tree bool) {
+	for _, entry := range entries {
+		name := entry.Path
+		indent := ""
+		if tree {
+			depth := strings.Count(entry.Path, "/")
+			indent = strings.Repeat("    ", depth)
+			name = path.Base(entry.Path)
+		}
+
+		size := progress.FormatSize(entry.Size)
+		switch {
+		case entry.IsDir:
+			name += "/"
+		case entry.IsSymlink:
+			name += "@"
+			size = "-"
+		}
+
+		modTime := "-"
+		if !entry.ModTime.IsZero() {
+			modTime = entry.ModTime.Format("2006-01-02 15:04")
+		}
+
+		fmt.Fprintf(output, "%16s  %s  %s%s\n", size, modTime, indent, name)
+	}
+}
+
+// Reads lines of the input in the background so Ctrl-C is not stuck waiting for it
+func readLines(ctx context.Context, input io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(input)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return lines
+}
+
+// Lets the user browse the shared directory and fetch from it, reading commands from input
+func runShell(ctx context.Context, client *browse.Client, input io.Reader, output io.Writer, receiveOptions transfer.ReceiveOptions) error {
+	current := ""
+
+	lines := readLines(ctx, input)
+
+	fmt.Fprintf(output, "Connected. Type \"help\" to see the commands\n")
+	for {
+		fmt.Fprintf(output, "ftu:/%s> ", current)
+
+		var line string
+		var ok bool
+		select {
+		case line, ok = <-lines:
+		case <-ctx.Done():
+			fmt.Fprintf(output, "\n")
+			return ctx.Err()
+		}
+		if !ok {
+			fmt.Fprintf(output, "\n")
+			return nil
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		command, args := fields[0], fields[1:]
+
+		// paths can contain spaces
+		arg := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), command))
+
+		var err error
+		switch command {
+		case "help", "?":
+			fmt.Fprintf(output, "| ls [path] list the directory\n")
+			fmt.Fprintf(output, "| tree [path] list the directory with everything inside of it\n")
+			fmt.Fprintf(output, "| cd [path] go to the directory (the shared root if no path is given)\n")
+			fmt.Fprintf(output, "| pwd print the current directory\n")
+			fmt.Fprintf(output, "| get path download the file|directory into the downloads folder\n")
+			fmt.Fprintf(output, "| exit end the session\n")
+
+		case "ls", "tree":
+			var entries []browse.Entry
+			entries, err = client.List(ctx, sharedPath(current, arg), command == "tree")
+			if err == nil {
+				printEntries(output, entries, command == "tree")
+			}
+
+		case "cd":
+			target := sharedPath(current, arg)
+			_, err = client.List(ctx, target, false)
+			if err == nil {
+				current = target
+			}
+
+		case "pwd":
+			fmt.Fprintf(output, "/%s\n", current)
+
+		case "get":
+			if len(args) == 0 {
+				err = errors.New("get needs a path")
+				break
+			}
+
+			_, err = client.Get(ctx, sharedPath(current, arg), *DOWNLOADS_DIR, receiveOptions)
+			fmt.Fprintf(output, "\n")
+
+		case "exit", "quit", "bye":
+			return nil
+
+		default:
+			err = fmt.Errorf("unknown command \"%s\". Type \"help\" to see the commands", command)
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, transfer.ErrorConnection) {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(output, "[ERROR] %s\n", err)
+		}
+	}
+}
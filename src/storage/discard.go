@@ -0,0 +1,94 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Keeps nothing, but checks names the same way as other storages. Receiving into it
+// verifies what is received without writing it anywhere
+var Discard Storage = discard{}
+
+type discard struct{}
+
+// A file that is written nowhere
+type discardFile struct {
+	finished bool
+}
+
+func (discard) Create(name string) (File, error) {
+	err := checkName("create", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &discardFile{}, nil
+}
+
+func (discard) Stat(name string) (fs.FileInfo, error) {
+	err := checkName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (discard) Mkdir(name string) error {
+	return checkName("mkdir", name)
+}
+
+func (discard) Symlink(target string, name string) error {
+	return checkSymlink(target, name)
+}
+
+func (discard) SetMetadata(name string, mode os.FileMode, modTime time.Time) error {
+	return checkName("setmetadata", name)
+}
+
+func (file *discardFile) WriteAt(data []byte, offset int64) (int, error) {
+	if file.finished {
+		return 0, ErrorFinished
+	}
+
+	return len(data), nil
+}
+
+func (file *discardFile) Finalize() error {
+	if file.finished {
+		return ErrorFinished
+	}
+	file.finished = true
+
+	return nil
+}
+
+func (file *discardFile) Abort() error {
+	if file.finished {
+		return ErrorFinished
+	}
+	file.finished = true
+
+	return nil
+}
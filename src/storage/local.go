@@ -0,0 +1,186 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"unbewohnte/ftu/fsys"
+)
+
+// The local filesystem. Everything is created inside of the root directory through fsys.Sandbox,
+// so nothing can end up outside of it. Files are written into partial files next to
+// their names and renamed into place when finalized, the same as in the downloads folder
+type Local struct {
+	sandbox *fsys.Sandbox
+}
+
+// Opens an existing directory at root as the storage
+func OpenLocal(root string) (*Local, error) {
+	sandbox, err := fsys.OpenSandbox(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{
+		sandbox: sandbox,
+	}, nil
+}
+
+// Returns the path of the root directory
+func (local *Local) Root() string {
+	return local.sandbox.Root()
+}
+
+// Releases the root directory
+func (local *Local) Close() error {
+	return local.sandbox.Close()
+}
+
+func (local *Local) Create(name string) (File, error) {
+	err := checkName("create", name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+
+	partialName := fsys.PartialName(filepath.FromSlash(name))
+
+	handler, err := local.sandbox.OpenFile(partialName, os.O_CREATE|os.O_TRUNC|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localFile{
+		local:       local,
+		name:        filepath.FromSlash(name),
+		partialName: partialName,
+		handler:     handler,
+	}, nil
+}
+
+func (local *Local) Stat(name string) (fs.FileInfo, error) {
+	err := checkName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "." {
+		return os.Stat(local.sandbox.Root())
+	}
+
+	return local.sandbox.Lstat(filepath.FromSlash(name))
+}
+
+func (local *Local) Mkdir(name string) error {
+	err := checkName("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return nil
+	}
+
+	return local.sandbox.MkdirAll(filepath.FromSlash(name))
+}
+
+func (local *Local) Symlink(target string, name string) error {
+	err := checkSymlink(target, name)
+	if err != nil {
+		return err
+	}
+
+	return local.sandbox.Symlink(filepath.FromSlash(target), filepath.FromSlash(name))
+}
+
+func (local *Local) SetMetadata(name string, mode os.FileMode, modTime time.Time) error {
+	err := checkName("setmetadata", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "setmetadata", Path: name, Err: fs.ErrPermission}
+	}
+
+	return local.sandbox.SetMetadata(filepath.FromSlash(name), mode, modTime)
+}
+
+// A partial file in the local storage
+type localFile struct {
+	mutex       sync.Mutex
+	local       *Local
+	name        string
+	partialName string
+	handler     *os.File // nil when finalized or aborted
+}
+
+func (file *localFile) WriteAt(data []byte, offset int64) (int, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.handler == nil {
+		return 0, ErrorFinished
+	}
+
+	return file.handler.WriteAt(data, offset)
+}
+
+func (file *localFile) Finalize() error {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.handler == nil {
+		return ErrorFinished
+	}
+
+	err := file.handler.Sync()
+	if err == nil {
+		err = file.handler.Close()
+	} else {
+		file.handler.Close()
+	}
+	file.handler = nil
+	if err != nil {
+		file.local.sandbox.Remove(file.partialName)
+		return err
+	}
+
+	return file.local.sandbox.Rename(file.partialName, file.name)
+}
+
+func (file *localFile) Abort() error {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.handler == nil {
+		return ErrorFinished
+	}
+
+	file.handler.Close()
+	file.handler = nil
+
+	return file.local.sandbox.Remove(file.partialName)
+}
@@ -0,0 +1,324 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An entry in the memory storage
+type memoryEntry struct {
+	mode    fs.FileMode
+	modTime time.Time
+	data    []byte // contents of a file
+	target  string // where a symlink points
+}
+
+// Keeps everything in the memory. Useful for tests
+type Memory struct {
+	mutex   sync.Mutex
+	entries map[string]*memoryEntry // by name. The root is not there
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		entries: make(map[string]*memoryEntry),
+	}
+}
+
+// Returns the entry at name. The root is a directory
+func (memory *Memory) entry(name string) (*memoryEntry, bool) {
+	if name == "." {
+		return &memoryEntry{mode: fs.ModeDir | 0755}, true
+	}
+
+	entry, ok := memory.entries[name]
+	return entry, ok
+}
+
+// Creates the missing directories the entry at name is in
+func (memory *Memory) mkdirParents(op string, name string) error {
+	parent := path.Dir(name)
+	if parent == "." {
+		return nil
+	}
+
+	err := memory.mkdirParents(op, parent)
+	if err != nil {
+		return err
+	}
+
+	entry, ok := memory.entries[parent]
+	if !ok {
+		memory.entries[parent] = &memoryEntry{mode: fs.ModeDir | 0755, modTime: time.Now()}
+		return nil
+	}
+	if !entry.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: ErrorNotDirectory}
+	}
+
+	return nil
+}
+
+func (memory *Memory) Create(name string) (File, error) {
+	err := checkName("create", name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+
+	return &memoryFile{
+		memory: memory,
+		name:   name,
+	}, nil
+}
+
+func (memory *Memory) Stat(name string) (fs.FileInfo, error) {
+	err := checkName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	entry, ok := memory.entry(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &memoryInfo{
+		name:  path.Base(name),
+		entry: *entry,
+	}, nil
+}
+
+func (memory *Memory) Mkdir(name string) error {
+	err := checkName("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	entry, ok := memory.entry(name)
+	if ok && entry.mode.IsDir() {
+		return nil
+	}
+	if ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+
+	err = memory.mkdirParents("mkdir", name)
+	if err != nil {
+		return err
+	}
+	memory.entries[name] = &memoryEntry{mode: fs.ModeDir | 0755, modTime: time.Now()}
+
+	return nil
+}
+
+func (memory *Memory) Symlink(target string, name string) error {
+	err := checkSymlink(target, name)
+	if err != nil {
+		return err
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	if _, ok := memory.entry(name); ok {
+		return &fs.PathError{Op: "symlink", Path: name, Err: fs.ErrExist}
+	}
+
+	err = memory.mkdirParents("symlink", name)
+	if err != nil {
+		return err
+	}
+	memory.entries[name] = &memoryEntry{mode: fs.ModeSymlink | 0777, modTime: time.Now(), target: target}
+
+	return nil
+}
+
+func (memory *Memory) SetMetadata(name string, mode os.FileMode, modTime time.Time) error {
+	err := checkName("setmetadata", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "setmetadata", Path: name, Err: fs.ErrPermission}
+	}
+
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	entry, ok := memory.entries[name]
+	if !ok {
+		return &fs.PathError{Op: "setmetadata", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if mode.Perm() != 0 {
+		entry.mode = entry.mode.Type() | mode.Perm()
+	}
+	if !modTime.IsZero() {
+		entry.modTime = modTime
+	}
+
+	return nil
+}
+
+// Returns the contents of the file at name
+func (memory *Memory) ReadFile(name string) ([]byte, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	entry, ok := memory.entry(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	if !entry.mode.IsRegular() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return append([]byte(nil), entry.data...), nil
+}
+
+// Returns where the symlink at name points
+func (memory *Memory) ReadLink(name string) (string, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	entry, ok := memory.entry(name)
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return entry.target, nil
+}
+
+// Returns the names of all entries sorted. Directories end with "/"
+func (memory *Memory) Names() []string {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	var names []string
+	for name, entry := range memory.entries {
+		if entry.mode.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// A file that is written into the memory. Its contents are kept aside until finalized
+type memoryFile struct {
+	mutex    sync.Mutex
+	memory   *Memory
+	name     string
+	data     []byte
+	finished bool
+}
+
+func (file *memoryFile) WriteAt(data []byte, offset int64) (int, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.finished {
+		return 0, ErrorFinished
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "writeat", Path: file.name, Err: fs.ErrInvalid}
+	}
+
+	end := offset + int64(len(data))
+	if end > int64(len(file.data)) {
+		file.data = append(file.data, make([]byte, end-int64(len(file.data)))...)
+	}
+	copy(file.data[offset:], data)
+
+	return len(data), nil
+}
+
+func (file *memoryFile) Finalize() error {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.finished {
+		return ErrorFinished
+	}
+	file.finished = true
+
+	file.memory.mutex.Lock()
+	defer file.memory.mutex.Unlock()
+
+	existing, ok := file.memory.entries[file.name]
+	if ok && existing.mode.IsDir() {
+		return &fs.PathError{Op: "finalize", Path: file.name, Err: fs.ErrExist}
+	}
+
+	err := file.memory.mkdirParents("finalize", file.name)
+	if err != nil {
+		return err
+	}
+	file.memory.entries[file.name] = &memoryEntry{mode: 0644, modTime: time.Now(), data: file.data}
+
+	return nil
+}
+
+func (file *memoryFile) Abort() error {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.finished {
+		return ErrorFinished
+	}
+	file.finished = true
+	file.data = nil
+
+	return nil
+}
+
+// Information about an entry in the memory
+type memoryInfo struct {
+	name  string
+	entry memoryEntry
+}
+
+func (info *memoryInfo) Name() string       { return info.name }
+func (info *memoryInfo) Size() int64        { return int64(len(info.entry.data)) }
+func (info *memoryInfo) Mode() fs.FileMode  { return info.entry.mode }
+func (info *memoryInfo) ModTime() time.Time { return info.entry.modTime }
+func (info *memoryInfo) IsDir() bool        { return info.entry.mode.IsDir() }
+func (info *memoryInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,903 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metadata of the objects
+const (
+	s3MetaMode    string = "X-Amz-Meta-Mode"    // permission bits in octal
+	s3MetaMtime   string = "X-Amz-Meta-Mtime"   // modification time in RFC 3339 with nanoseconds
+	s3MetaSymlink string = "X-Amz-Meta-Symlink" // where the symlink points
+)
+
+// SHA-256 of nothing, the payload of requests without a body
+const emptyPayloadHash string = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Bytes in the parts of a multipart upload unless told otherwise. S3 wants at least 5 MiB in every part but the last
+const defaultPartSize int = 8 * 1024 * 1024
+
+// The biggest object that can be copied with a single request. Bigger ones are copied part by part
+var maxCopySize int64 = 5 * 1024 * 1024 * 1024
+
+// Used when S3 is not given a client. Requests give up on a silent or unreachable object store
+// instead of hanging; the whole request is limited loosely, as a part can take a while on a slow link
+var defaultS3Client *http.Client = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   4,
+	},
+	Timeout: 30 * time.Minute,
+}
+
+var ErrorInvalidS3Location error = errors.New("invalid S3 location")
+
+var ErrorWriteOrder error = errors.New("the file is written out of order: the part has already been uploaded or is too far ahead")
+
+// An error response of the object store
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (err *S3Error) Error() string {
+	if err.Code == "" {
+		return fmt.Sprintf("S3 responded with %d %s", err.StatusCode, http.StatusText(err.StatusCode))
+	}
+	return fmt.Sprintf("S3 responded with %d %s: %s", err.StatusCode, err.Code, err.Message)
+}
+
+// An S3-compatible object store (AWS S3, MinIO, ...). Every entry is an object named Prefix/name:
+// files are objects with their contents, directories are empty objects with names ending with "/"
+// and symlinks are empty objects with the target in their metadata. Permission bits and modification
+// times are kept in the metadata too. A file is uploaded as it is written: once there is more than a part
+// of it, part by part with a multipart upload, so only a couple of parts are kept in memory. Such a file
+// has to be written in order. A smaller file is uploaded with a single request when finalized. Parts grow
+// every 1000 of them, so with the default PartSize a file can be up to about 430 GiB. Requests are signed
+// with AWS Signature Version 4 and addressed path-style (Endpoint/Bucket/key), which every S3-compatible
+// store understands
+type S3 struct {
+	Endpoint  string       // ie: "http://127.0.0.1:9000". "https://s3.REGION.amazonaws.com" if empty
+	Region    string       // "us-east-1" if empty
+	Bucket    string       // must exist
+	Prefix    string       // the "directory" in the bucket everything is created in. The whole bucket if empty
+	AccessKey string       // requests are not signed if empty
+	SecretKey string       //
+	Client    *http.Client // a client with timeouts if nil
+
+	SessionToken string // of temporary credentials. None if empty
+
+	PartSize int // bytes in the first 1000 parts of a multipart upload. 8 MiB if 0
+}
+
+// Parses "s3://bucket/prefix" (the prefix is optional) into the storage with such Bucket and Prefix
+func ParseS3(location string) (*S3, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("%w \"%s\": %s", ErrorInvalidS3Location, location, err)
+	}
+	if parsed.Scheme != "s3" || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return nil, fmt.Errorf("%w \"%s\" (expected s3://bucket/prefix)", ErrorInvalidS3Location, location)
+	}
+
+	prefix := strings.Trim(parsed.Path, "/")
+	if prefix != "" && !fs.ValidPath(prefix) {
+		return nil, fmt.Errorf("%w \"%s\": the prefix is not clean", ErrorInvalidS3Location, location)
+	}
+
+	return &S3{
+		Bucket: parsed.Host,
+		Prefix: prefix,
+	}, nil
+}
+
+func (s3 *S3) region() string {
+	if s3.Region == "" {
+		return "us-east-1"
+	}
+	return s3.Region
+}
+
+// Returns the name of the object of the entry at name
+func (s3 *S3) key(name string) string {
+	prefix := strings.Trim(s3.Prefix, "/")
+	if name == "." {
+		return prefix
+	}
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// Makes a signed request to the object at key (or to the bucket itself if key is empty)
+func (s3 *S3) do(method string, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	endpoint := s3.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s3.region() + ".amazonaws.com"
+	}
+
+	requestURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	objectPath := strings.TrimSuffix(requestURL.Path, "/") + "/" + s3.Bucket
+	if key != "" {
+		objectPath += "/" + key
+	}
+	requestURL.Path = objectPath
+	requestURL.RawPath = uriEncode(objectPath, false)
+	requestURL.RawQuery = canonicalQuery(query)
+
+	if body == nil || size == 0 {
+		body = http.NoBody
+	}
+
+	request, err := http.NewRequest(method, requestURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	request.ContentLength = size
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	if s3.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", s3.SessionToken)
+	}
+
+	if s3.AccessKey != "" {
+		signV4(request, payloadHash, s3.AccessKey, s3.SecretKey, s3.region(), time.Now())
+	} else {
+		request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	client := s3.Client
+	if client == nil {
+		client = defaultS3Client
+	}
+
+	return client.Do(request)
+}
+
+// Returns the error of the unsuccessful response
+func responseError(response *http.Response) error {
+	var errorResponse struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	xml.Unmarshal(body, &errorResponse)
+
+	return &S3Error{
+		StatusCode: response.StatusCode,
+		Code:       errorResponse.Code,
+		Message:    errorResponse.Message,
+	}
+}
+
+// Makes the request and fails unless the object store has responded with success. The response body is discarded
+func (s3 *S3) doOK(method string, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (http.Header, error) {
+	response, err := s3.do(method, key, query, header, body, size, payloadHash)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, responseError(response)
+	}
+
+	// copying can fail after the success has been responded
+	if header.Get("X-Amz-Copy-Source") != "" {
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Contains(body, []byte("<Error>")) {
+			response.Body = io.NopCloser(bytes.NewReader(body))
+			return nil, responseError(response)
+		}
+	}
+	io.Copy(io.Discard, response.Body)
+
+	return response.Header, nil
+}
+
+// Makes the request and decodes the XML the object store has responded with into result
+func (s3 *S3) doXML(method string, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string, result interface{}) error {
+	response, err := s3.do(method, key, query, header, body, size, payloadHash)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return responseError(response)
+	}
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	// copying and completing can fail after the success has been responded
+	if bytes.Contains(data, []byte("<Error>")) {
+		response.Body = io.NopCloser(bytes.NewReader(data))
+		return responseError(response)
+	}
+
+	return xml.Unmarshal(data, result)
+}
+
+// Returns the headers of the object at key. Nil if there is no such object
+func (s3 *S3) head(key string) (http.Header, error) {
+	response, err := s3.do(http.MethodHead, key, nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, &S3Error{StatusCode: response.StatusCode}
+	}
+
+	return response.Header, nil
+}
+
+// Tells whether there are objects which names start with prefix
+func (s3 *S3) anyWithPrefix(prefix string) (bool, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("max-keys", "1")
+	query.Set("prefix", prefix)
+
+	response, err := s3.do(http.MethodGet, "", query, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return false, responseError(response)
+	}
+
+	var listing struct {
+		Contents []struct {
+			Key string `xml:"Key"`
+		} `xml:"Contents"`
+		CommonPrefixes []struct {
+			Prefix string `xml:"Prefix"`
+		} `xml:"CommonPrefixes"`
+	}
+	err = xml.NewDecoder(response.Body).Decode(&listing)
+	if err != nil {
+		return false, err
+	}
+
+	return len(listing.Contents) != 0 || len(listing.CommonPrefixes) != 0, nil
+}
+
+// Returns the headers of the metadata
+func metadataHeader(mode os.FileMode, modTime time.Time) http.Header {
+	header := http.Header{}
+	if mode.Perm() != 0 {
+		header.Set(s3MetaMode, strconv.FormatUint(uint64(mode.Perm()), 8))
+	}
+	if !modTime.IsZero() {
+		header.Set(s3MetaMtime, modTime.UTC().Format(time.RFC3339Nano))
+	}
+
+	return header
+}
+
+// A part of a multipart upload
+type s3Part struct {
+	XMLName xml.Name `xml:"Part"`
+	Number  int      `xml:"PartNumber"`
+	ETag    string   `xml:"ETag"`
+}
+
+// Returns the size of the part with such number. Every 1000 parts are bigger than the previous ones,
+// so the 10000 parts S3 allows hold more
+func (s3 *S3) partSize(number int) int {
+	size := s3.PartSize
+	if size <= 0 {
+		size = defaultPartSize
+	}
+
+	return size * (1 + (number-1)/1000)
+}
+
+// Starts a multipart upload of the object at key with such headers. Returns the id of the upload
+func (s3 *S3) createMultipart(key string, header http.Header) (string, error) {
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err := s3.doXML(http.MethodPost, key, url.Values{"uploads": {""}}, header, nil, 0, emptyPayloadHash, &initiated)
+	if err != nil {
+		return "", err
+	}
+	if initiated.UploadID == "" {
+		return "", fmt.Errorf("no id of the multipart upload in the response")
+	}
+
+	return initiated.UploadID, nil
+}
+
+func uploadQuery(uploadID string, number int) url.Values {
+	return url.Values{
+		"partNumber": {strconv.Itoa(number)},
+		"uploadId":   {uploadID},
+	}
+}
+
+// Uploads the part with such number
+func (s3 *S3) uploadPart(key string, uploadID string, number int, data []byte) (s3Part, error) {
+	hash := sha256.Sum256(data)
+	header, err := s3.doOK(http.MethodPut, key, uploadQuery(uploadID, number), nil, bytes.NewReader(data), int64(len(data)), hex.EncodeToString(hash[:]))
+	if err != nil {
+		return s3Part{}, err
+	}
+
+	return s3Part{Number: number, ETag: header.Get("ETag")}, nil
+}
+
+// Copies bytes from first to last (inclusive) of the object at key as the part with such number
+func (s3 *S3) copyPart(key string, uploadID string, number int, first int64, last int64) (s3Part, error) {
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", uriEncode("/"+s3.Bucket+"/"+key, false))
+	header.Set("X-Amz-Copy-Source-Range", fmt.Sprintf("bytes=%d-%d", first, last))
+
+	var copied struct {
+		ETag string `xml:"ETag"`
+	}
+	err := s3.doXML(http.MethodPut, key, uploadQuery(uploadID, number), header, nil, 0, emptyPayloadHash, &copied)
+	if err != nil {
+		return s3Part{}, err
+	}
+
+	return s3Part{Number: number, ETag: copied.ETag}, nil
+}
+
+// Puts the uploaded parts together into the object
+func (s3 *S3) completeMultipart(key string, uploadID string, parts []s3Part) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(body)
+
+	var completed struct {
+		ETag string `xml:"ETag"`
+	}
+	return s3.doXML(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, bytes.NewReader(body), int64(len(body)), hex.EncodeToString(hash[:]), &completed)
+}
+
+// Discards the multipart upload along with its uploaded parts
+func (s3 *S3) abortMultipart(key string, uploadID string) error {
+	_, err := s3.doOK(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil, 0, emptyPayloadHash)
+	return err
+}
+
+// Copies the object at key of such size onto itself part by part, replacing its headers
+func (s3 *S3) copyMultipart(key string, size int64, header http.Header) error {
+	uploadID, err := s3.createMultipart(key, header)
+	if err != nil {
+		return err
+	}
+
+	var parts []s3Part
+	for first := int64(0); first < size; first += maxCopySize {
+		last := first + maxCopySize - 1
+		if last >= size {
+			last = size - 1
+		}
+
+		part, err := s3.copyPart(key, uploadID, len(parts)+1, first, last)
+		if err != nil {
+			s3.abortMultipart(key, uploadID)
+			return err
+		}
+		parts = append(parts, part)
+	}
+
+	err = s3.completeMultipart(key, uploadID, parts)
+	if err != nil {
+		s3.abortMultipart(key, uploadID)
+		return err
+	}
+
+	return nil
+}
+
+func (s3 *S3) Create(name string) (File, error) {
+	err := checkName("create", name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	}
+
+	return &s3File{
+		s3:   s3,
+		name: name,
+	}, nil
+}
+
+func (s3 *S3) Stat(name string) (fs.FileInfo, error) {
+	err := checkName("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if name == "." {
+		return &s3Info{name: ".", mode: fs.ModeDir | 0755}, nil
+	}
+
+	key := s3.key(name)
+
+	header, err := s3.head(key)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if header != nil {
+		return newS3Info(path.Base(name), header, 0), nil
+	}
+
+	// a directory, created by Mkdir or just with something in it
+	header, err = s3.head(key + "/")
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if header != nil {
+		return newS3Info(path.Base(name), header, fs.ModeDir), nil
+	}
+
+	found, err := s3.anyWithPrefix(key + "/")
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if found {
+		return &s3Info{name: path.Base(name), mode: fs.ModeDir | 0755}, nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (s3 *S3) Mkdir(name string) error {
+	err := checkName("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return nil
+	}
+
+	info, err := s3.Stat(name)
+	if err == nil && info.IsDir() {
+		return nil
+	}
+	if err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	_, err = s3.doOK(http.MethodPut, s3.key(name)+"/", nil, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+func (s3 *S3) Symlink(target string, name string) error {
+	err := checkSymlink(target, name)
+	if err != nil {
+		return err
+	}
+
+	exists, err := Exists(s3, name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return &fs.PathError{Op: "symlink", Path: name, Err: fs.ErrExist}
+	}
+
+	header := http.Header{}
+	header.Set(s3MetaSymlink, target)
+
+	_, err = s3.doOK(http.MethodPut, s3.key(name), nil, header, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return &fs.PathError{Op: "symlink", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+func (s3 *S3) SetMetadata(name string, mode os.FileMode, modTime time.Time) error {
+	err := checkName("setmetadata", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "setmetadata", Path: name, Err: fs.ErrPermission}
+	}
+
+	info, err := s3.Stat(name)
+	if err != nil {
+		return err
+	}
+	if mode.Perm() == 0 {
+		mode = info.Mode()
+	}
+	if modTime.IsZero() {
+		modTime = info.ModTime()
+	}
+	header := metadataHeader(mode, modTime)
+
+	switch {
+	case info.IsDir():
+		// the marker of the directory is empty, so it is simply created again
+		_, err = s3.doOK(http.MethodPut, s3.key(name)+"/", nil, header, nil, 0, emptyPayloadHash)
+
+	case info.Mode()&fs.ModeSymlink != 0:
+		return &fs.PathError{Op: "setmetadata", Path: name, Err: fs.ErrInvalid}
+
+	case info.Size() > maxCopySize:
+		// too big to be copied at once
+		header.Set("Content-Type", "application/octet-stream")
+		err = s3.copyMultipart(s3.key(name), info.Size(), header)
+
+	default:
+		// the metadata of an object can only be replaced by copying the object onto itself
+		header.Set("X-Amz-Copy-Source", uriEncode("/"+s3.Bucket+"/"+s3.key(name), false))
+		header.Set("X-Amz-Metadata-Directive", "REPLACE")
+		_, err = s3.doOK(http.MethodPut, s3.key(name), nil, header, nil, 0, emptyPayloadHash)
+	}
+	if err != nil {
+		return &fs.PathError{Op: "setmetadata", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// A file that is uploaded as it is written. What has not been uploaded yet is kept in buffer
+type s3File struct {
+	mutex    sync.Mutex
+	s3       *S3
+	name     string
+	buffer   []byte   // written, but not uploaded yet. Starts at uploaded
+	uploaded int64    // bytes uploaded in parts
+	uploadID string   // of the multipart upload. Empty until the first part is uploaded
+	parts    []s3Part // uploaded so far
+	finished bool     // finalized or aborted
+}
+
+func (file *s3File) WriteAt(data []byte, offset int64) (int, error) {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.finished {
+		return 0, ErrorFinished
+	}
+
+	// only what has not been uploaded can be written, and not far past what has been, so the buffer stays small
+	start := offset - file.uploaded
+	if start < 0 || start > int64(len(file.buffer)+file.s3.partSize(len(file.parts)+1)) {
+		return 0, &fs.PathError{Op: "write", Path: file.name, Err: ErrorWriteOrder}
+	}
+
+	end := start + int64(len(data))
+
+	if end > int64(len(file.buffer)) {
+		file.buffer = append(file.buffer, make([]byte, end-int64(len(file.buffer)))...)
+	}
+	copy(file.buffer[start:], data)
+
+	// upload every full part
+	for {
+		size := file.s3.partSize(len(file.parts) + 1)
+		if len(file.buffer) < size {
+			break
+		}
+
+		err := file.uploadPart(file.buffer[:size])
+		if err != nil {
+			return 0, &fs.PathError{Op: "write", Path: file.name, Err: err}
+		}
+		file.buffer = append(file.buffer[:0], file.buffer[size:]...)
+	}
+
+	return len(data), nil
+}
+
+// Uploads the next part, starting the multipart upload with the first one
+func (file *s3File) uploadPart(data []byte) error {
+	key := file.s3.key(file.name)
+
+	if file.uploadID == "" {
+		header := http.Header{}
+		header.Set("Content-Type", "application/octet-stream")
+
+		uploadID, err := file.s3.createMultipart(key, header)
+		if err != nil {
+			return err
+		}
+		file.uploadID = uploadID
+	}
+
+	part, err := file.s3.uploadPart(key, file.uploadID, len(file.parts)+1, data)
+	if err != nil {
+		return err
+	}
+	file.parts = append(file.parts, part)
+	file.uploaded += int64(len(data))
+
+	return nil
+}
+
+func (file *s3File) Finalize() error {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.finished {
+		return ErrorFinished
+	}
+	file.finished = true
+	defer func() {
+		file.buffer = nil
+	}()
+
+	key := file.s3.key(file.name)
+
+	if file.uploadID == "" {
+		// small enough for a single request
+		header := http.Header{}
+		header.Set("Content-Type", "application/octet-stream")
+
+		hash := sha256.Sum256(file.buffer)
+		_, err := file.s3.doOK(
+			http.MethodPut, key, nil, header,
+			bytes.NewReader(file.buffer), int64(len(file.buffer)), hex.EncodeToString(hash[:]),
+		)
+		if err != nil {
+			return &fs.PathError{Op: "finalize", Path: file.name, Err: err}
+		}
+
+		return nil
+	}
+
+	// the rest is the last part
+	var err error
+	if len(file.buffer) != 0 {
+		err = file.uploadPart(file.buffer)
+	}
+	if err == nil {
+		err = file.s3.completeMultipart(key, file.uploadID, file.parts)
+	}
+	if err != nil {
+		file.s3.abortMultipart(key, file.uploadID)
+		return &fs.PathError{Op: "finalize", Path: file.name, Err: err}
+	}
+
+	return nil
+}
+
+func (file *s3File) Abort() error {
+	file.mutex.Lock()
+	defer file.mutex.Unlock()
+
+	if file.finished {
+		return ErrorFinished
+	}
+	file.finished = true
+	file.buffer = nil
+
+	if file.uploadID != "" {
+		err := file.s3.abortMultipart(file.s3.key(file.name), file.uploadID)
+		if err != nil {
+			return &fs.PathError{Op: "abort", Path: file.name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// Information about an object
+type s3Info struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// Returns information about the object with such headers
+func newS3Info(name string, header http.Header, kind fs.FileMode) *s3Info {
+	info := &s3Info{
+		name: name,
+		mode: kind | 0644,
+	}
+	if kind.IsDir() {
+		info.mode = kind | 0755
+	} else {
+		info.size, _ = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	}
+
+	if header.Get(s3MetaSymlink) != "" {
+		info.mode = fs.ModeSymlink | 0777
+		info.size = int64(len(header.Get(s3MetaSymlink)))
+	} else if mode, err := strconv.ParseUint(header.Get(s3MetaMode), 8, 32); err == nil {
+		info.mode = info.mode.Type() | fs.FileMode(mode).Perm()
+	}
+
+	if modTime, err := time.Parse(time.RFC3339Nano, header.Get(s3MetaMtime)); err == nil {
+		info.modTime = modTime
+	} else if modTime, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		info.modTime = modTime
+	}
+
+	return info
+}
+
+func (info *s3Info) Name() string       { return info.name }
+func (info *s3Info) Size() int64        { return info.size }
+func (info *s3Info) Mode() fs.FileMode  { return info.mode }
+func (info *s3Info) ModTime() time.Time { return info.modTime }
+func (info *s3Info) IsDir() bool        { return info.mode.IsDir() }
+func (info *s3Info) Sys() interface{}   { return nil }
+
+// Percent-encodes everything except the unreserved characters (and "/" unless encodeSlash is set)
+// the way Signature Version 4 expects
+func uriEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, char := range []byte(value) {
+		switch {
+		case char >= 'A' && char <= 'Z', char >= 'a' && char <= 'z', char >= '0' && char <= '9',
+			char == '-', char == '_', char == '.', char == '~':
+			encoded.WriteByte(char)
+		case char == '/' && !encodeSlash:
+			encoded.WriteByte(char)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", char)
+		}
+	}
+
+	return encoded.String()
+}
+
+// Returns the query sorted by names and encoded the way Signature Version 4 expects
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Signs the request to S3 with AWS Signature Version 4. Every header of the request is signed.
+// The path and the query of its URL must already be encoded with uriEncode and canonicalQuery
+func signV4(request *http.Request, payloadHash string, accessKey string, secretKey string, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := request.Host
+	if host == "" {
+		host = request.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for name, values := range request.Header {
+		name = strings.ToLower(name)
+		if name == "authorization" || name == "user-agent" {
+			continue
+		}
+
+		trimmed := make([]string, len(values))
+		for index, value := range values {
+			trimmed[index] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[name] = strings.Join(trimmed, ",")
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature,
+	))
+}
@@ -0,0 +1,105 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Where the receiving side creates what it receives instead of the downloads folder:
+// the local filesystem, the memory or an S3-compatible object store
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+// Everything the receiving side does with what it receives. Names are slash-separated
+// paths relative to the root of the storage that pass fs.ValidPath ("." is the root itself)
+type Storage interface {
+	// Starts a new file at name. Nothing appears at name until the file is finalized,
+	// whatever is already there stays as it is until then
+	Create(name string) (File, error)
+
+	// Returns information about the entry at name itself, not following it if it is a symlink.
+	// The error is fs.ErrNotExist if there is nothing
+	Stat(name string) (fs.FileInfo, error)
+
+	// Creates a directory at name along with the missing parents. Nothing happens if it already exists
+	Mkdir(name string) error
+
+	// Creates a symlink at name pointing to target, which is relative to the directory
+	// of the symlink and must not lead outside of the storage
+	Symlink(target string, name string) error
+
+	// Sets the permission bits and the modification time of the file or directory at name.
+	// A zero mode or modTime is left as it is
+	SetMetadata(name string, mode os.FileMode, modTime time.Time) error
+}
+
+// A file that is being written into the storage
+type File interface {
+	io.WriterAt
+
+	// Puts the written file at its name, replacing what was there. The file can not be written after that
+	Finalize() error
+
+	// Discards the written file, leaving what was at its name as it is
+	Abort() error
+}
+
+var ErrorFinished error = errors.New("the file has already been finalized or aborted")
+var ErrorNotDirectory error = errors.New("not a directory")
+
+// Returns an error if the name is not a valid name of an entry in the storage
+func checkName(op string, name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	return nil
+}
+
+// Returns an error if the symlink at name can not point to target: it is not relative or leads outside of the storage
+func checkSymlink(target string, name string) error {
+	err := checkName("symlink", name)
+	if err != nil {
+		return err
+	}
+
+	if target == "" || path.IsAbs(target) || !fs.ValidPath(path.Join(path.Dir(name), target)) {
+		return &fs.PathError{Op: "symlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	return nil
+}
+
+// Tells whether anything (including a symlink) exists at name in the storage
+func Exists(storage Storage, name string) (bool, error) {
+	_, err := storage.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
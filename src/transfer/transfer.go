@@ -0,0 +1,217 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Sending and receiving files from Go programs. Functions of this package never
+// panic or exit the process, honour context cancellation and return typed errors
+package transfer
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+
+	"unbewohnte/ftu/archive"
+	"unbewohnte/ftu/limit"
+	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/progress"
+	"unbewohnte/ftu/storage"
+	"unbewohnte/ftu/transport"
+)
+
+// How the transfer has ended and what has been done
+type Result = node.Result
+
+// Errors returned when the transfer has not been successful. Check them with errors.Is.
+// Canceled transfers return the error of the context
+var (
+	ErrorRejected   error = node.ErrorRejected
+	ErrorConnection error = node.ErrorConnection
+	ErrorIntegrity  error = node.ErrorIntegrity
+	ErrorPartial    error = node.ErrorPartial
+)
+
+// Options common for both sides
+type Options struct {
+	Output         io.Writer         // where human-readable messages and progress go. Discarded if nil
+	Events         io.Writer         // if != nil - newline-delimited JSON events are written into it
+	Observer       progress.Observer // if != nil - told about every event of the transfer
+	Verbose        bool              // report every file in Output
+	Reverse        bool              // the receiver listens and the sender connects to it
+	SendLimiter    *limit.Limiter    // limits outgoing traffic if != nil
+	ReceiveLimiter *limit.Limiter    // limits incoming traffic if != nil
+}
+
+type SendOptions struct {
+	Options
+	Recursive      bool      // send the directory recursively
+	FollowSymlinks bool      // send what symlinks point to instead of symlinks themselves
+	MaxDepth       uint      // how deep to descend into the directory. 0 means no limit
+	MoreSources    []string  // other files|directories sent together with the source in the same transfer, each under its own name
+	Text           string    // if != "" - the text message is sent instead, and the source is ignored
+	Stream         io.Reader // if != nil - it is read until EOF and sent as a file named after the source, which does not have to exist
+	FS             fs.FS     // if != nil - the source is a slash-separated path in it ("." for all of it) rather than in the OS filesystem, ie: an archive.FS
+	FSName         string    // the name of the directory all of FS is sent as. "files" if empty
+}
+
+type ReceiveOptions struct {
+	Options
+	OnConflict      node.ConflictPolicy    // overwrite by default
+	OnDirConflict   node.DirConflictPolicy // merge by default
+	Resume          bool                   // continue from leftover partial files of the interrupted transfer
+	CleanPartial    bool                   // remove leftover partial files before receiving
+	Backup          node.BackupPolicy      // none by default
+	BackupKeep      uint                   // 0 means keep all
+	IgnoreFreeSpace bool                   // do not reject transfers that do not fit
+	OfferDecider    node.OfferDecider      // decides whether to accept the offer. Everything is accepted if nil
+	ConflictDecider node.ConflictDecider   // decides on conflicts when OnConflict|OnDirConflict is "ask". Files are skipped and directories rejected if nil
+	TextFile        string                 // if != "" - received text messages are appended to this file. Result.Text has the message anyway
+	Writer          io.Writer              // if != nil - the received file|stream is written into it instead of the destination. Directories are rejected
+	Archive         archive.Format         // if != "" - the received file|directory is written into Writer as an archive. Streams are rejected
+	Storage         storage.Storage        // if != nil - everything is received into it, destination being a slash-separated directory in it ("" for the root). Resume, CleanPartial and Backup are not possible
+}
+
+func (options Options) nodeOptions() *node.NodeOptions {
+	output := options.Output
+	if output == nil {
+		output = io.Discard
+	}
+
+	return &node.NodeOptions{
+		VerboseOutput:  options.Verbose,
+		Reverse:        options.Reverse,
+		Output:         output,
+		Events:         options.Events,
+		Observer:       options.Observer,
+		SendLimiter:    options.SendLimiter,
+		ReceiveLimiter: options.ReceiveLimiter,
+		SenderSide:     &node.SenderNodeOptions{},
+		ReceiverSide:   &node.ReceiverNodeOptions{},
+	}
+}
+
+func send(ctx context.Context, nodeOptions *node.NodeOptions, source string, options SendOptions) (*Result, error) {
+	nodeOptions.IsSending = true
+	nodeOptions.SenderSide.ServingPath = source
+	if len(options.MoreSources) != 0 {
+		nodeOptions.SenderSide.ServingPaths = append([]string{source}, options.MoreSources...)
+	}
+	nodeOptions.SenderSide.Recursive = options.Recursive
+	nodeOptions.SenderSide.FollowSymlinks = options.FollowSymlinks
+	nodeOptions.SenderSide.MaxDepth = options.MaxDepth
+	nodeOptions.SenderSide.Text = options.Text
+	nodeOptions.SenderSide.Stream = options.Stream
+	nodeOptions.SenderSide.FS = options.FS
+	nodeOptions.SenderSide.FSName = options.FSName
+
+	sender, err := node.NewNode(nodeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return sender.Start(ctx)
+}
+
+// Waits for the receiver on address ("[host]:port" or "unix:/path/to/socket") and sends the source file or directory
+// (and options.MoreSources) to it.
+// Connects to the receiver listening on address if options.Reverse is set
+func Send(ctx context.Context, address string, source string, options SendOptions) (*Result, error) {
+	sendTransport, err := transport.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return SendTransport(ctx, sendTransport, source, options)
+}
+
+// Waits for the receiver (or connects to it if options.Reverse is set) through the transport and sends the source file or directory to it
+func SendTransport(ctx context.Context, sendTransport transport.Transport, source string, options SendOptions) (*Result, error) {
+	nodeOptions := options.nodeOptions()
+	nodeOptions.Transport = sendTransport
+
+	return send(ctx, nodeOptions, source, options)
+}
+
+// Sends the source file or directory to the receiver on the other end of the connection.
+// The connection is closed in the end
+func SendConn(ctx context.Context, conn net.Conn, source string, options SendOptions) (*Result, error) {
+	nodeOptions := options.nodeOptions()
+	nodeOptions.Conn = conn
+
+	return send(ctx, nodeOptions, source, options)
+}
+
+func receive(ctx context.Context, nodeOptions *node.NodeOptions, destination string, options ReceiveOptions) (*Result, error) {
+	offerDecider := options.OfferDecider
+	if offerDecider == nil {
+		offerDecider = node.AcceptAll
+	}
+
+	nodeOptions.IsSending = false
+	nodeOptions.ReceiverSide.DownloadsFolderPath = destination
+	nodeOptions.ReceiverSide.OnConflict = options.OnConflict
+	nodeOptions.ReceiverSide.OnDirConflict = options.OnDirConflict
+	nodeOptions.ReceiverSide.Resume = options.Resume
+	nodeOptions.ReceiverSide.CleanPartial = options.CleanPartial
+	nodeOptions.ReceiverSide.Backup = options.Backup
+	nodeOptions.ReceiverSide.BackupKeep = options.BackupKeep
+	nodeOptions.ReceiverSide.IgnoreFreeSpace = options.IgnoreFreeSpace
+	nodeOptions.ReceiverSide.OfferDecider = offerDecider
+	nodeOptions.ReceiverSide.ConflictDecider = options.ConflictDecider
+	nodeOptions.ReceiverSide.TextFile = options.TextFile
+	nodeOptions.ReceiverSide.Writer = options.Writer
+	nodeOptions.ReceiverSide.Archive = options.Archive
+	nodeOptions.ReceiverSide.Storage = options.Storage
+
+	receiver, err := node.NewNode(nodeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return receiver.Start(ctx)
+}
+
+// Connects to the sender on address ("host:port" or "unix:/path/to/socket") and receives what it offers into destination directory.
+// Waits for the sender on address if options.Reverse is set
+func Receive(ctx context.Context, address string, destination string, options ReceiveOptions) (*Result, error) {
+	receiveTransport, err := transport.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReceiveTransport(ctx, receiveTransport, destination, options)
+}
+
+// Connects to the sender (or waits for it if options.Reverse is set) through the transport and receives what it offers into destination directory
+func ReceiveTransport(ctx context.Context, receiveTransport transport.Transport, destination string, options ReceiveOptions) (*Result, error) {
+	nodeOptions := options.nodeOptions()
+	nodeOptions.Transport = receiveTransport
+
+	return receive(ctx, nodeOptions, destination, options)
+}
+
+// Receives what the sender on the other end of the connection offers into destination directory.
+// The connection is closed in the end
+func ReceiveConn(ctx context.Context, conn net.Conn, destination string, options ReceiveOptions) (*Result, error) {
+	nodeOptions := options.nodeOptions()
+	nodeOptions.Conn = conn
+
+	return receive(ctx, nodeOptions, destination, options)
+}
@@ -0,0 +1,880 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package transfer
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"unbewohnte/ftu/archive"
+	"unbewohnte/ftu/fsys"
+	"unbewohnte/ftu/node"
+	"unbewohnte/ftu/progress"
+	"unbewohnte/ftu/storage"
+)
+
+// creates a small directory to send
+func makeSource(t *testing.T) string {
+	source := filepath.Join(t.TempDir(), "source")
+
+	files := map[string]string{
+		"a.txt":           "aaaaa",
+		"sub/b.txt":       "bbbbbbbbbb",
+		"sub/inner/c.txt": "",
+	}
+	for path, contents := range files {
+		fullPath := filepath.Join(source, path)
+		err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		err = os.WriteFile(fullPath, []byte(contents), os.ModePerm)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+
+	return source
+}
+
+// counts finished files
+type doneCounter struct {
+	progress.NopObserver
+	done   int
+	status string
+}
+
+func (counter *doneCounter) FileDone(name string) {
+	counter.done++
+}
+
+func (counter *doneCounter) Completed(status string, summary progress.Summary) {
+	counter.status = status
+}
+
+type outcome struct {
+	result *Result
+	err    error
+}
+
+func Test_SendReceiveConn(t *testing.T) {
+	source := makeSource(t)
+	destination := t.TempDir()
+
+	senderConn, receiverConn := net.Pipe()
+
+	sent := make(chan outcome)
+	go func() {
+		result, err := SendConn(context.Background(), senderConn, source, SendOptions{Recursive: true})
+		sent <- outcome{result, err}
+	}()
+
+	counter := &doneCounter{}
+	result, err := ReceiveConn(context.Background(), receiverConn, destination, ReceiveOptions{
+		Options: Options{Observer: counter},
+	})
+	if err != nil {
+		t.Fatalf("receiving failed: %s", err)
+	}
+	if counter.done != 3 || counter.status != string(node.StatusSuccess) {
+		t.Fatalf("expected the observer to see 3 files done and a success; got %d and %s", counter.done, counter.status)
+	}
+	if result.Status != node.StatusSuccess || result.FilesDone != 3 {
+		t.Fatalf("expected 3 files to be received successfully; got %s with %d files", result.Status, result.FilesDone)
+	}
+
+	sender := <-sent
+	if sender.err != nil {
+		t.Fatalf("sending failed: %s", sender.err)
+	}
+	if sender.result.Status != node.StatusSuccess || sender.result.TransferredBytes != 15 {
+		t.Fatalf("expected 15 bytes to be sent successfully; got %s with %d bytes", sender.result.Status, sender.result.TransferredBytes)
+	}
+
+	for _, path := range []string{"a.txt", "sub/b.txt", "sub/inner/c.txt"} {
+		expected, _ := os.ReadFile(filepath.Join(source, path))
+		received, err := os.ReadFile(filepath.Join(destination, "source", path))
+		if err != nil {
+			t.Fatalf("\"%s\" has not been received: %s", path, err)
+		}
+		if !bytes.Equal(expected, received) {
+			t.Fatalf("\"%s\" differs from the sent one", path)
+		}
+	}
+}
+
+func Test_SymlinkOutside(t *testing.T) {
+	source := makeSource(t)
+	outside := filepath.Join(t.TempDir(), "hostname")
+	err := os.WriteFile(outside, []byte("host"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.Symlink(outside, filepath.Join(source, "hostlink"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.Symlink(filepath.Join(source, "a.txt"), filepath.Join(source, "sub", "link"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	destination := t.TempDir()
+
+	senderConn, receiverConn := net.Pipe()
+
+	sent := make(chan outcome)
+	go func() {
+		result, err := SendConn(context.Background(), senderConn, source, SendOptions{Recursive: true})
+		sent <- outcome{result, err}
+	}()
+
+	result, err := ReceiveConn(context.Background(), receiverConn, destination, ReceiveOptions{})
+	if err != nil {
+		t.Fatalf("receiving failed: %s", err)
+	}
+	if result.Status != node.StatusSuccess || result.FilesDone != 3 {
+		t.Fatalf("expected 3 files to be received successfully; got %s with %d files", result.Status, result.FilesDone)
+	}
+	sender := <-sent
+	if sender.err != nil {
+		t.Fatalf("sending failed: %s", sender.err)
+	}
+
+	// the link that leaves the transfer is skipped, the one inside of it is not
+	_, err = os.Lstat(filepath.Join(destination, "source", "hostlink"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the symlink pointing outside to be skipped; got %v", err)
+	}
+	received, err := os.ReadFile(filepath.Join(destination, "source", "sub", "link"))
+	if err != nil || string(received) != "aaaaa" {
+		t.Fatalf("expected the symlink to \"a.txt\" to be created; got %v", err)
+	}
+}
+
+func Test_SymlinkFailure(t *testing.T) {
+	source := makeSource(t)
+	err := os.Symlink(filepath.Join(source, "a.txt"), filepath.Join(source, "sub", "link"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// something else is already where the symlink goes
+	memory := storage.NewMemory()
+	err = memory.Mkdir("inbox/source/sub/link")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	senderConn, receiverConn := net.Pipe()
+	go SendConn(context.Background(), senderConn, source, SendOptions{Recursive: true})
+
+	result, err := ReceiveConn(context.Background(), receiverConn, "inbox", ReceiveOptions{Storage: memory})
+	if !errors.Is(err, ErrorPartial) || result.Status != node.StatusPartial || result.FilesDone != 3 {
+		t.Fatalf("expected the transfer to be partial without the symlink; got %v", err)
+	}
+}
+
+// answers every conflict the same way
+type fixedConflictDecider struct {
+	file  node.ConflictPolicy
+	dir   node.DirConflictPolicy
+	asked int
+}
+
+func (decider *fixedConflictDecider) DecideFile(file *fsys.File, existing os.FileInfo) node.ConflictPolicy {
+	decider.asked++
+	return decider.file
+}
+
+func (decider *fixedConflictDecider) DecideDir(dir *fsys.Directory) node.DirConflictPolicy {
+	decider.asked++
+	return decider.dir
+}
+
+func Test_AskOnConflict(t *testing.T) {
+	source := makeSource(t)
+	destination := t.TempDir()
+
+	receive := func(options ReceiveOptions) (*Result, error) {
+		senderConn, receiverConn := net.Pipe()
+		go SendConn(context.Background(), senderConn, source, SendOptions{Recursive: true})
+		return ReceiveConn(context.Background(), receiverConn, destination, options)
+	}
+
+	_, err := receive(ReceiveOptions{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.WriteFile(filepath.Join(destination, "source", "a.txt"), []byte("changed"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// nobody to ask: the directory is rejected
+	_, err = receive(ReceiveOptions{OnDirConflict: node.DirConflictAsk})
+	if !errors.Is(err, ErrorRejected) {
+		t.Fatalf("expected the existing directory to be rejected without a decider; got %v", err)
+	}
+
+	// nobody to ask: the conflicting file is left as it is
+	result, err := receive(ReceiveOptions{OnConflict: node.ConflictAsk})
+	if err != nil || result.FilesSkipped != 3 {
+		t.Fatalf("expected every file to be skipped without a decider; got %+v (%v)", result, err)
+	}
+	received, _ := os.ReadFile(filepath.Join(destination, "source", "a.txt"))
+	if string(received) != "changed" {
+		t.Fatalf("expected the conflicting file to stay as it is; got \"%s\"", received)
+	}
+
+	// the decider is asked about the directory and the file
+	decider := &fixedConflictDecider{file: node.ConflictOverwrite, dir: node.DirConflictMerge}
+	result, err = receive(ReceiveOptions{OnConflict: node.ConflictAsk, OnDirConflict: node.DirConflictAsk, ConflictDecider: decider})
+	if err != nil || result.FilesDone != 1 || decider.asked != 2 {
+		t.Fatalf("expected the decider to be asked twice and the file to be overwritten; got %+v asked %d times (%v)", result, decider.asked, err)
+	}
+	received, _ = os.ReadFile(filepath.Join(destination, "source", "a.txt"))
+	if string(received) != "aaaaa" {
+		t.Fatalf("expected the conflicting file to be overwritten; got \"%s\"", received)
+	}
+}
+
+func Test_Rejection(t *testing.T) {
+	source := makeSource(t)
+	senderConn, receiverConn := net.Pipe()
+
+	sent := make(chan outcome)
+	go func() {
+		result, err := SendConn(context.Background(), senderConn, filepath.Join(source, "a.txt"), SendOptions{})
+		sent <- outcome{result, err}
+	}()
+
+	result, err := ReceiveConn(context.Background(), receiverConn, t.TempDir(), ReceiveOptions{
+		OfferDecider: node.OfferDeciderFunc(func(file *fsys.File, dir *fsys.Directory) bool {
+			return false
+		}),
+	})
+	if !errors.Is(err, ErrorRejected) || result.Status != node.StatusRejected {
+		t.Fatalf("expected the receiver to reject; got %v", err)
+	}
+
+	sender := <-sent
+	if !errors.Is(sender.err, ErrorRejected) {
+		t.Fatalf("expected the sender to be rejected; got %v", sender.err)
+	}
+}
+
+func Test_CancelWaitingForConnection(t *testing.T) {
+	source := makeSource(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	result, err := Send(ctx, "127.0.0.1:0", source, SendOptions{Recursive: true})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded; got %v", err)
+	}
+	if result.Status != node.StatusCanceled {
+		t.Fatalf("expected the transfer to be canceled; got %s", result.Status)
+	}
+}
+
+func Test_ConnectionFailure(t *testing.T) {
+	// find a port nobody listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	result, err := Receive(context.Background(), address, t.TempDir(), ReceiveOptions{})
+	if !errors.Is(err, ErrorConnection) || result.Status != node.StatusConnectionFailed {
+		t.Fatalf("expected a connection failure; got %v", err)
+	}
+}
+
+func Test_InvalidAddress(t *testing.T) {
+	_, err := Receive(context.Background(), "no port", t.TempDir(), ReceiveOptions{})
+	if err == nil {
+		t.Fatalf("expected an invalid address to be rejected")
+	}
+}
+
+func Test_UnixSocket(t *testing.T) {
+	source := makeSource(t)
+	destination := t.TempDir()
+	address := "unix:" + filepath.Join(t.TempDir(), "ftu.sock")
+
+	sent := make(chan outcome)
+	go func() {
+		result, err := Send(context.Background(), address, filepath.Join(source, "a.txt"), SendOptions{})
+		sent <- outcome{result, err}
+	}()
+
+	// wait for the sender to listen
+	var err error
+	for attempt := 0; attempt < 50; attempt++ {
+		_, err = Receive(context.Background(), address, destination, ReceiveOptions{})
+		if !errors.Is(err, ErrorConnection) {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		t.Fatalf("receiving failed: %s", err)
+	}
+
+	sender := <-sent
+	if sender.err != nil {
+		t.Fatalf("sending failed: %s", sender.err)
+	}
+
+	received, err := os.ReadFile(filepath.Join(destination, "a.txt"))
+	if err != nil || string(received) != "aaaaa" {
+		t.Fatalf("\"a.txt\" has not been received correctly: %q (%v)", received, err)
+	}
+}
+
+func Test_Reverse(t *testing.T) {
+	source := makeSource(t)
+	destination := t.TempDir()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	received := make(chan outcome)
+	go func() {
+		result, err := Receive(context.Background(), address, destination, ReceiveOptions{Options: Options{Reverse: true}})
+		received <- outcome{result, err}
+	}()
+
+	// wait for the receiver to listen
+	for attempt := 0; attempt < 50; attempt++ {
+		_, err = Send(context.Background(), address, filepath.Join(source, "sub"), SendOptions{Options: Options{Reverse: true}, Recursive: true})
+		if !errors.Is(err, ErrorConnection) {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		t.Fatalf("pushing failed: %s", err)
+	}
+
+	receiver := <-received
+	if receiver.err != nil {
+		t.Fatalf("receiving failed: %s", receiver.err)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(destination, "sub", "b.txt"))
+	if err != nil || string(contents) != "bbbbbbbbbb" {
+		t.Fatalf("\"sub/b.txt\" has not been received correctly: %q (%v)", contents, err)
+	}
+}
+
+func Test_SendSeveralSources(t *testing.T) {
+	source := makeSource(t)
+	destination := t.TempDir()
+
+	// "sub" is already there, so it is received next to it
+	err := os.MkdirAll(filepath.Join(destination, "sub"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	senderConn, receiverConn := net.Pipe()
+
+	sent := make(chan outcome)
+	go func() {
+		result, err := SendConn(context.Background(), senderConn, filepath.Join(source, "a.txt"), SendOptions{
+			Recursive:   true,
+			MoreSources: []string{filepath.Join(source, "sub")},
+		})
+		sent <- outcome{result, err}
+	}()
+
+	var offered *fsys.Directory
+	result, err := ReceiveConn(context.Background(), receiverConn, destination, ReceiveOptions{
+		OnDirConflict: node.DirConflictKeepBoth,
+		OfferDecider: node.OfferDeciderFunc(func(file *fsys.File, dir *fsys.Directory) bool {
+			offered = dir
+			return true
+		}),
+	})
+	if err != nil {
+		t.Fatalf("receiving failed: %s", err)
+	}
+	if result.FilesDone != 3 {
+		t.Fatalf("expected 3 files to be received; got %d", result.FilesDone)
+	}
+	if offered == nil || !offered.Bundle || offered.Size != 15 {
+		t.Fatalf("expected a bundle of 15 bytes to be offered; got %+v", offered)
+	}
+
+	sender := <-sent
+	if sender.err != nil {
+		t.Fatalf("sending failed: %s", sender.err)
+	}
+
+	for path, expected := range map[string]string{
+		"a.txt":               "aaaaa",
+		"sub (1)/b.txt":       "bbbbbbbbbb",
+		"sub (1)/inner/c.txt": "",
+	} {
+		contents, err := os.ReadFile(filepath.Join(destination, path))
+		if err != nil || string(contents) != expected {
+			t.Fatalf("\"%s\" has not been received correctly: %q (%v)", path, contents, err)
+		}
+	}
+}
+
+// remembers received text messages
+type textCatcher struct {
+	progress.NopObserver
+	texts []string
+}
+
+func (catcher *textCatcher) TextReceived(text string) {
+	catcher.texts = append(catcher.texts, text)
+}
+
+func Test_SendText(t *testing.T) {
+	destination := t.TempDir()
+	textFile := filepath.Join(t.TempDir(), "texts.txt")
+
+	for _, text := range []string{"https://example.com/?token=abc", "a paragraph\nof text\n"} {
+		senderConn, receiverConn := net.Pipe()
+
+		sent := make(chan outcome)
+		go func() {
+			result, err := SendConn(context.Background(), senderConn, "", SendOptions{Text: text})
+			sent <- outcome{result, err}
+		}()
+
+		catcher := &textCatcher{}
+		result, err := ReceiveConn(context.Background(), receiverConn, destination, ReceiveOptions{
+			Options:  Options{Observer: catcher},
+			TextFile: textFile,
+			OfferDecider: node.OfferDeciderFunc(func(file *fsys.File, dir *fsys.Directory) bool {
+				t.Errorf("nothing must be offered")
+				return false
+			}),
+		})
+		if err != nil {
+			t.Fatalf("receiving failed: %s", err)
+		}
+		if result.Status != node.StatusSuccess || result.Text != text {
+			t.Fatalf("expected %q to be received successfully; got %q with %s", text, result.Text, result.Status)
+		}
+		if len(catcher.texts) != 1 || catcher.texts[0] != text {
+			t.Fatalf("expected the observer to see %q; got %q", text, catcher.texts)
+		}
+
+		sender := <-sent
+		if sender.err != nil || sender.result.Status != node.StatusSuccess {
+			t.Fatalf("sending failed: %v", sender.err)
+		}
+	}
+
+	contents, err := os.ReadFile(textFile)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if string(contents) != "https://example.com/?token=abc\na paragraph\nof text\n" {
+		t.Fatalf("unexpected text file contents %q", contents)
+	}
+
+	entries, _ := os.ReadDir(destination)
+	if len(entries) != 0 {
+		t.Fatalf("expected no files to be created; got %d", len(entries))
+	}
+}
+
+func Test_SendStream(t *testing.T) {
+	contents := make([]byte, 1000000)
+	for index := range contents {
+		contents[index] = byte(index * 31 / 7)
+	}
+
+	// comes in uneven pieces, like from a pipe
+	stream := func() io.Reader {
+		reader, writer := io.Pipe()
+		go func() {
+			for offset := 0; offset < len(contents); offset += 12345 {
+				end := offset + 12345
+				if end > len(contents) {
+					end = len(contents)
+				}
+				writer.Write(contents[offset:end])
+			}
+			writer.Close()
+		}()
+		return reader
+	}
+
+	receive := func(source string, sendOptions SendOptions, receiveOptions ReceiveOptions) (string, *Result, error) {
+		destination := t.TempDir()
+		senderConn, receiverConn := net.Pipe()
+
+		sent := make(chan outcome)
+		go func() {
+			result, err := SendConn(context.Background(), senderConn, source, sendOptions)
+			sent <- outcome{result, err}
+		}()
+
+		result, err := ReceiveConn(context.Background(), receiverConn, destination, receiveOptions)
+		<-sent
+
+		return destination, result, err
+	}
+
+	// into the downloads folder under the given name
+	destination, result, err := receive("backup.tar", SendOptions{Stream: stream()}, ReceiveOptions{})
+	if err != nil || result.Status != node.StatusSuccess || result.FilesDone != 1 {
+		t.Fatalf("expected the stream to be received successfully; got %v", err)
+	}
+	received, err := os.ReadFile(filepath.Join(destination, "backup.tar"))
+	if err != nil || !bytes.Equal(received, contents) {
+		t.Fatalf("the received stream differs from the sent one: %v", err)
+	}
+
+	// into the writer, leaving the downloads folder alone
+	var output bytes.Buffer
+	destination, result, err = receive("stdin", SendOptions{Stream: stream()}, ReceiveOptions{Writer: &output})
+	if err != nil || result.Status != node.StatusSuccess {
+		t.Fatalf("expected the stream to be written successfully; got %v", err)
+	}
+	if !bytes.Equal(output.Bytes(), contents) {
+		t.Fatalf("written %d bytes of the stream; expected %d", output.Len(), len(contents))
+	}
+	if entries, _ := os.ReadDir(destination); len(entries) != 0 {
+		t.Fatalf("expected no files to be created; got %d", len(entries))
+	}
+
+	// an ordinary file can be written into the writer as well
+	path := filepath.Join(t.TempDir(), "file.bin")
+	err = os.WriteFile(path, contents, os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	output.Reset()
+	_, result, err = receive(path, SendOptions{}, ReceiveOptions{Writer: &output})
+	if err != nil || result.Status != node.StatusSuccess || !bytes.Equal(output.Bytes(), contents) {
+		t.Fatalf("expected the file to be written successfully; got %v", err)
+	}
+
+	// but not a directory
+	_, _, err = receive(makeSource(t), SendOptions{Recursive: true}, ReceiveOptions{Writer: &output})
+	if !errors.Is(err, ErrorRejected) {
+		t.Fatalf("expected a directory to be rejected; got %v", err)
+	}
+}
+
+func Test_ReceiveArchive(t *testing.T) {
+	source := makeSource(t)
+	err := os.MkdirAll(filepath.Join(source, "sub", "empty"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.Symlink(filepath.Join(source, "a.txt"), filepath.Join(source, "sub", "link"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.Chmod(filepath.Join(source, "a.txt"), 0600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	modTime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = os.Chtimes(filepath.Join(source, "a.txt"), modTime, modTime)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	receive := func(source string, sendOptions SendOptions, receiveOptions ReceiveOptions) (string, error) {
+		destination := t.TempDir()
+		senderConn, receiverConn := net.Pipe()
+
+		sent := make(chan outcome)
+		go func() {
+			result, err := SendConn(context.Background(), senderConn, source, sendOptions)
+			sent <- outcome{result, err}
+		}()
+
+		_, err := ReceiveConn(context.Background(), receiverConn, destination, receiveOptions)
+		<-sent
+
+		return destination, err
+	}
+
+	var archived bytes.Buffer
+	destination, err := receive(source, SendOptions{Recursive: true}, ReceiveOptions{Writer: &archived, Archive: archive.FormatTar})
+	if err != nil {
+		t.Fatalf("expected the directory to be archived successfully; got %v", err)
+	}
+	if entries, _ := os.ReadDir(destination); len(entries) != 0 {
+		t.Fatalf("expected no files to be created; got %d", len(entries))
+	}
+
+	headers := make(map[string]*tar.Header)
+	contents := make(map[string]string)
+	reader := tar.NewReader(&archived)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		headers[header.Name] = header
+		contents[header.Name] = string(data)
+	}
+
+	for _, dir := range []string{"source/", "source/sub/", "source/sub/inner/", "source/sub/empty/"} {
+		if headers[dir] == nil || headers[dir].Typeflag != tar.TypeDir {
+			t.Fatalf("expected directory \"%s\" in the archive", dir)
+		}
+	}
+	if contents["source/sub/b.txt"] != "bbbbbbbbbb" || headers["source/sub/inner/c.txt"] == nil {
+		t.Fatalf("expected files to be archived as they are; got %v", contents)
+	}
+	file := headers["source/a.txt"]
+	if file == nil || file.Mode != 0600 || !file.ModTime.Equal(modTime) {
+		t.Fatalf("expected \"a.txt\" to keep its mode and modification time; got %+v", file)
+	}
+	link := headers["source/sub/link"]
+	if link == nil || link.Typeflag != tar.TypeSymlink || link.Linkname != "../a.txt" {
+		t.Fatalf("expected a symlink to \"../a.txt\"; got %+v", link)
+	}
+
+	// empty directories are received into the downloads folder as well
+	destination, err = receive(source, SendOptions{Recursive: true}, ReceiveOptions{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stats, err := os.Stat(filepath.Join(destination, "source", "sub", "empty"))
+	if err != nil || !stats.IsDir() {
+		t.Fatalf("expected the empty directory to be received; got %v", err)
+	}
+
+	// the size of every archived file must be known
+	_, err = receive("stdin", SendOptions{Stream: bytes.NewReader([]byte("stream"))}, ReceiveOptions{Writer: &archived, Archive: archive.FormatZip})
+	if !errors.Is(err, ErrorRejected) {
+		t.Fatalf("expected a stream to be rejected; got %v", err)
+	}
+}
+
+func Test_SendFS(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "release.tar.gz")
+	archiveFile, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	writer, err := archive.NewWriter(archiveFile, archive.FormatTarGz)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	files := map[string][]byte{
+		"bin/tool":   bytes.Repeat([]byte("tool"), 100000),
+		"README":     []byte("read me"),
+		"docs/a.txt": []byte("a"),
+	}
+	for _, name := range []string{"bin/tool", "README", "docs/a.txt"} {
+		contents, err := writer.File(name, uint64(len(files[name])), 0755, time.Time{})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		contents.Write(files[name])
+	}
+	writer.Symlink("latest", "bin/tool")
+	writer.Directory("empty", time.Time{})
+	err = writer.Close()
+	archiveFile.Close()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	filesystem, err := archive.Open(archivePath)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer filesystem.Close()
+
+	receive := func(source string, sendOptions SendOptions) (string, *Result, error) {
+		destination := t.TempDir()
+		senderConn, receiverConn := net.Pipe()
+
+		sent := make(chan outcome)
+		go func() {
+			result, err := SendConn(context.Background(), senderConn, source, sendOptions)
+			sent <- outcome{result, err}
+		}()
+
+		result, err := ReceiveConn(context.Background(), receiverConn, destination, ReceiveOptions{})
+		<-sent
+
+		return destination, result, err
+	}
+
+	// all of the archive as a directory
+	destination, result, err := receive(".", SendOptions{FS: filesystem, FSName: "release", Recursive: true})
+	if err != nil || result.FilesDone != 3 {
+		t.Fatalf("expected the contents of the archive to be received; got %v", err)
+	}
+	for name, contents := range files {
+		received, err := os.ReadFile(filepath.Join(destination, "release", filepath.FromSlash(name)))
+		if err != nil || !bytes.Equal(received, contents) {
+			t.Fatalf("\"%s\" differs from the one in the archive: %v", name, err)
+		}
+	}
+	target, err := os.Readlink(filepath.Join(destination, "release", "latest"))
+	if err != nil || target != filepath.Join(destination, "release", "bin", "tool") {
+		t.Fatalf("expected \"latest\" to point to \"bin/tool\"; got \"%s\" (%v)", target, err)
+	}
+	stats, err := os.Stat(filepath.Join(destination, "release", "empty"))
+	if err != nil || !stats.IsDir() {
+		t.Fatalf("expected the empty directory to be received; got %v", err)
+	}
+
+	// a single file of it
+	destination, _, err = receive("docs/a.txt", SendOptions{FS: filesystem})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	received, err := os.ReadFile(filepath.Join(destination, "a.txt"))
+	if err != nil || string(received) != "a" {
+		t.Fatalf("expected \"a.txt\" to be received; got %v", err)
+	}
+
+	// nothing else can be sent with it
+	_, err = SendConn(context.Background(), nil, ".", SendOptions{FS: filesystem, MoreSources: []string{"README"}})
+	if !errors.Is(err, node.ErrorFSBundle) {
+		t.Fatalf("expected several paths in the filesystem to be refused; got %v", err)
+	}
+}
+
+func Test_ReceiveStorage(t *testing.T) {
+	source := makeSource(t)
+	err := os.MkdirAll(filepath.Join(source, "sub", "empty"), os.ModePerm)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.Symlink(filepath.Join(source, "a.txt"), filepath.Join(source, "sub", "link"))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	err = os.Chmod(filepath.Join(source, "a.txt"), 0600)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	modTime := time.Date(2022, 1, 2, 3, 4, 5, 6, time.UTC)
+	err = os.Chtimes(filepath.Join(source, "a.txt"), modTime, modTime)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	memory := storage.NewMemory()
+
+	receive := func(source string, sendOptions SendOptions) (*Result, error) {
+		senderConn, receiverConn := net.Pipe()
+
+		sent := make(chan outcome)
+		go func() {
+			result, err := SendConn(context.Background(), senderConn, source, sendOptions)
+			sent <- outcome{result, err}
+		}()
+
+		result, err := ReceiveConn(context.Background(), receiverConn, "inbox", ReceiveOptions{Storage: memory})
+		<-sent
+
+		return result, err
+	}
+
+	result, err := receive(source, SendOptions{Recursive: true})
+	if err != nil || result.FilesDone != 3 {
+		t.Fatalf("expected the directory to be received into the storage; got %v", err)
+	}
+
+	expected := map[string]string{
+		"inbox/source/a.txt":           "aaaaa",
+		"inbox/source/sub/b.txt":       "bbbbbbbbbb",
+		"inbox/source/sub/inner/c.txt": "",
+	}
+	for name, contents := range expected {
+		received, err := memory.ReadFile(name)
+		if err != nil || string(received) != contents {
+			t.Fatalf("expected \"%s\" to contain \"%s\"; got \"%s\" (%v)", name, contents, received, err)
+		}
+	}
+	info, err := memory.Stat("inbox/source/a.txt")
+	if err != nil || info.Mode().Perm() != 0600 || !info.ModTime().Equal(modTime) {
+		t.Fatalf("expected \"a.txt\" to keep its mode and modification time; got %v (%v)", info, err)
+	}
+	target, err := memory.ReadLink("inbox/source/sub/link")
+	if err != nil || target != "../a.txt" {
+		t.Fatalf("expected a symlink to \"../a.txt\"; got \"%s\" (%v)", target, err)
+	}
+	info, err = memory.Stat("inbox/source/sub/empty")
+	if err != nil || !info.IsDir() {
+		t.Fatalf("expected the empty directory to be received; got %v", err)
+	}
+
+	// the same files are not received again
+	result, err = receive(source, SendOptions{Recursive: true})
+	if err != nil || result.FilesSkipped != 3 {
+		t.Fatalf("expected the files already in the storage to be skipped; got %+v (%v)", result, err)
+	}
+
+	// streams are written as they come
+	result, err = receive("stream.txt", SendOptions{Stream: bytes.NewReader([]byte("streamed"))})
+	if err != nil || result.FilesDone != 1 {
+		t.Fatalf("expected the stream to be received; got %v", err)
+	}
+	received, err := memory.ReadFile("inbox/stream.txt")
+	if err != nil || string(received) != "streamed" {
+		t.Fatalf("expected \"stream.txt\" to contain \"streamed\"; got \"%s\" (%v)", received, err)
+	}
+
+	// nothing is left to resume from in a storage
+	_, err = ReceiveConn(context.Background(), nil, "", ReceiveOptions{Storage: memory, Resume: true})
+	if !errors.Is(err, node.ErrorStorageUnsupported) {
+		t.Fatalf("expected resuming into the storage to be refused; got %v", err)
+	}
+	_, err = ReceiveConn(context.Background(), nil, "", ReceiveOptions{Storage: memory, Writer: io.Discard})
+	if !errors.Is(err, node.ErrorStorageWriter) {
+		t.Fatalf("expected receiving into both the writer and the storage to be refused; got %v", err)
+	}
+}
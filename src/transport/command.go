@@ -0,0 +1,96 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package transport
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// Stdin and stdout of the started command
+type commandStream struct {
+	io.Reader
+	io.Writer
+	stdin     io.Closer
+	command   *exec.Cmd
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Closes stdin of the command and waits for it to exit
+func (stream *commandStream) Close() error {
+	stream.closeOnce.Do(func() {
+		stream.stdin.Close()
+		stream.closeErr = stream.command.Wait()
+	})
+
+	return stream.closeErr
+}
+
+// Starts the command (ie: ssh running ftu on another machine) and makes a connection
+// out of its stdin and stdout, named name. Closing the connection closes stdin of the
+// command and waits for it to exit
+func Command(command *exec.Cmd, name string) (net.Conn, error) {
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = command.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStreamConn(&commandStream{
+		Reader:  stdout,
+		Writer:  stdin,
+		stdin:   stdin,
+		command: command,
+	}, name), nil
+}
+
+// Stdin and stdout of this process
+type stdioStream struct {
+	io.Reader
+	io.Writer
+}
+
+func (stream stdioStream) Close() error {
+	os.Stdin.Close()
+	return os.Stdout.Close()
+}
+
+// Makes a connection out of stdin and stdout of this process, so the node can be
+// spawned by another one through a remote shell. Nothing else must be written to stdout
+func Stdio() net.Conn {
+	return NewStreamConn(stdioStream{
+		Reader: os.Stdin,
+		Writer: os.Stdout,
+	}, "stdio")
+}
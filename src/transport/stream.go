@@ -0,0 +1,76 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+var ErrorNoDeadlines error = errors.New("deadlines are not supported by streams")
+
+// An address of a stream, which is just its name
+type streamAddr string
+
+func (addr streamAddr) Network() string {
+	return "stream"
+}
+
+func (addr streamAddr) String() string {
+	return string(addr)
+}
+
+// A connection over an arbitrary stream, ie: pipes or stdio of a process
+type streamConn struct {
+	io.ReadWriteCloser
+	name streamAddr
+}
+
+// Makes a connection out of the stream so nodes can run over it. Name is what is
+// reported as the remote address. Deadlines are not supported
+func NewStreamConn(stream io.ReadWriteCloser, name string) net.Conn {
+	return &streamConn{
+		ReadWriteCloser: stream,
+		name:            streamAddr(name),
+	}
+}
+
+func (conn *streamConn) LocalAddr() net.Addr {
+	return conn.name
+}
+
+func (conn *streamConn) RemoteAddr() net.Addr {
+	return conn.name
+}
+
+func (conn *streamConn) SetDeadline(t time.Time) error {
+	return ErrorNoDeadlines
+}
+
+func (conn *streamConn) SetReadDeadline(t time.Time) error {
+	return ErrorNoDeadlines
+}
+
+func (conn *streamConn) SetWriteDeadline(t time.Time) error {
+	return ErrorNoDeadlines
+}
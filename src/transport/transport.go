@@ -0,0 +1,133 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Ways for nodes to reach each other: TCP, Unix domain sockets or any stream
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Prefix of addresses of Unix domain sockets, ie: unix:/tmp/ftu.sock
+const UnixPrefix string = "unix:"
+
+// How long to wait for the TCP connection to be established
+const DefaultDialTimeout time.Duration = time.Second * 5
+
+var ErrorInvalidAddress error = errors.New("invalid address")
+
+// Establishes the connection between nodes. The receiving side dials, the sending side listens
+type Transport interface {
+	Dial(ctx context.Context) (net.Conn, error)
+	Listen(ctx context.Context) (net.Listener, error)
+	String() string // where it dials or listens, suitable for printing
+}
+
+// TCP on Address ("[host]:port"). Empty host means all interfaces when listening
+type TCP struct {
+	Address     string
+	DialTimeout time.Duration // DefaultDialTimeout if 0
+}
+
+func (tcp *TCP) Dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: tcp.DialTimeout}
+	if dialer.Timeout == 0 {
+		dialer.Timeout = DefaultDialTimeout
+	}
+
+	return dialer.DialContext(ctx, "tcp", tcp.Address)
+}
+
+func (tcp *TCP) Listen(ctx context.Context) (net.Listener, error) {
+	var listenConfig net.ListenConfig
+	return listenConfig.Listen(ctx, "tcp", tcp.Address)
+}
+
+func (tcp *TCP) String() string {
+	return tcp.Address
+}
+
+// Unix domain socket at Path. The socket file is removed when the listener is closed;
+// a stale one left by a killed node is replaced
+type Unix struct {
+	Path string
+}
+
+func (unix *Unix) Dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", unix.Path)
+}
+
+func (unix *Unix) Listen(ctx context.Context) (net.Listener, error) {
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(ctx, "unix", unix.Path)
+	if err == nil || !errors.Is(err, syscall.EADDRINUSE) {
+		return listener, err
+	}
+
+	// the socket might have been left by a killed node. Replace it if nobody listens on it
+	conn, dialErr := unix.Dial(ctx)
+	if dialErr == nil {
+		conn.Close()
+		return nil, err
+	}
+
+	removeErr := os.Remove(unix.Path)
+	if removeErr != nil {
+		return nil, err
+	}
+
+	return listenConfig.Listen(ctx, "unix", unix.Path)
+}
+
+func (unix *Unix) String() string {
+	return UnixPrefix + unix.Path
+}
+
+// Parses "unix:/path/to/socket" or "[host]:port"
+func Parse(address string) (Transport, error) {
+	if strings.HasPrefix(address, UnixPrefix) {
+		path := strings.TrimPrefix(address, UnixPrefix)
+		if path == "" {
+			return nil, fmt.Errorf("%w \"%s\": no socket path", ErrorInvalidAddress, address)
+		}
+		return &Unix{Path: path}, nil
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("%w \"%s\": %s", ErrorInvalidAddress, address, err)
+	}
+
+	_, err = strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w \"%s\": invalid port", ErrorInvalidAddress, address)
+	}
+
+	return &TCP{Address: address}, nil
+}
@@ -0,0 +1,162 @@
+/*
+ftu - file transferring utility.
+Copyright (C) 2021,2022  Kasyanov Nikolay Alexeyevich (Unbewohnte)
+
+This file is a part of ftu
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Parse(t *testing.T) {
+	cases := map[string]Transport{
+		"unix:/tmp/ftu.sock": &Unix{Path: "/tmp/ftu.sock"},
+		"127.0.0.1:7270":     &TCP{Address: "127.0.0.1:7270"},
+		":7270":              &TCP{Address: ":7270"},
+		"[::1]:7270":         &TCP{Address: "[::1]:7270"},
+	}
+	for address, expected := range cases {
+		parsed, err := Parse(address)
+		if err != nil {
+			t.Fatalf("failed to parse \"%s\": %s", address, err)
+		}
+		if parsed.String() != expected.String() {
+			t.Fatalf("expected \"%s\" to be parsed as %s; got %s", address, expected, parsed)
+		}
+	}
+
+	for _, invalid := range []string{"unix:", "127.0.0.1", "host:port", "host:70000"} {
+		_, err := Parse(invalid)
+		if !errors.Is(err, ErrorInvalidAddress) {
+			t.Fatalf("expected \"%s\" to be invalid; got %v", invalid, err)
+		}
+	}
+}
+
+// accepts one connection and echoes back what has been read
+func echoOnce(t *testing.T, transport Transport) {
+	listener, err := transport.Listen(context.Background())
+	if err != nil {
+		t.Fatalf("could not listen on %s: %s", transport, err)
+	}
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+}
+
+func Test_Unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ftu.sock")
+
+	// a socket left by a killed node
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("stale socket has not been left: %s", err)
+	}
+
+	unix := &Unix{Path: path}
+	echoOnce(t, unix)
+
+	conn, err := unix.Dial(context.Background())
+	if err != nil {
+		t.Fatalf("could not dial %s: %s", unix, err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+	answer := make([]byte, 4)
+	_, err = io.ReadFull(conn, answer)
+	if err != nil || string(answer) != "ping" {
+		t.Fatalf("expected \"ping\" to be echoed; got %q (%v)", answer, err)
+	}
+
+	// the socket is in use now, it must not be replaced
+	_, err = unix.Listen(context.Background())
+	if err == nil {
+		t.Fatalf("listening on the socket in use has succeeded")
+	}
+}
+
+func Test_StreamConn(t *testing.T) {
+	reader, writer := io.Pipe()
+	conn := NewStreamConn(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{reader, writer, writer}, "pipe")
+
+	if conn.RemoteAddr().String() != "pipe" {
+		t.Fatalf("expected the remote address to be \"pipe\"; got %s", conn.RemoteAddr())
+	}
+
+	go conn.Write([]byte("data"))
+	received := make([]byte, 4)
+	_, err := io.ReadFull(conn, received)
+	if err != nil || string(received) != "data" {
+		t.Fatalf("expected \"data\" to be read; got %q (%v)", received, err)
+	}
+
+	if !errors.Is(conn.SetDeadline(time.Time{}), ErrorNoDeadlines) {
+		t.Fatalf("deadlines are expected to be unsupported")
+	}
+}
+
+func Test_Command(t *testing.T) {
+	cat, err := exec.LookPath("cat")
+	if err != nil {
+		t.Skip("no cat to talk to")
+	}
+
+	conn, err := Command(exec.Command(cat), "cat")
+	if err != nil {
+		t.Fatalf("could not start cat: %s", err)
+	}
+
+	conn.Write([]byte("hello"))
+	echoed := make([]byte, 5)
+	_, err = io.ReadFull(conn, echoed)
+	if err != nil || string(echoed) != "hello" {
+		t.Fatalf("expected \"hello\" to be echoed; got %q (%v)", echoed, err)
+	}
+
+	// cat exits once its stdin is closed
+	err = conn.Close()
+	if err != nil {
+		t.Fatalf("cat has not exited cleanly: %s", err)
+	}
+}